@@ -5,14 +5,15 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/cloudzero/cloudzero-agent/app/domain/apikey"
 	"github.com/cloudzero/cloudzero-agent/app/domain/filter"
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/pkg/errors"
@@ -23,11 +24,21 @@ const (
 	DefaultCZSendInterval           = 10 * time.Minute
 	DefaultCZSendTimeout            = 10 * time.Second
 	DefaultCZRotateInterval         = 10 * time.Minute
+	DefaultCZReplayRequestTTL       = 7 * 24 * time.Hour
 	DefaultDatabaseMaxRecords       = 1_500_000
 	DefaultDatabaseCompressionLevel = 8
 	DefaultDatabaseMaxInterval      = 10 * time.Minute
 	DefaultServerPort               = 8080
 	DefaultServerMode               = "http"
+	DefaultCoordinationLeaseName    = "cloudzero-shipper"
+	DefaultCoordinationLeaseTTL     = 15 * time.Second
+	DefaultCoordinationRenew        = 5 * time.Second
+)
+
+// Coordination backends used to serialize the shipper replicas.
+const (
+	CoordinationBackendFile  = "file"
+	CoordinationBackendLease = "lease"
 )
 
 type Settings struct {
@@ -41,8 +52,12 @@ type Settings struct {
 	Database  Database  `yaml:"database"`
 	Cloudzero Cloudzero `yaml:"cloudzero"`
 	Metrics   Metrics   `yaml:"metrics"`
+	Admin     Admin     `yaml:"admin"`
+
+	Coordination Coordination `yaml:"coordination"`
 
-	mu sync.Mutex
+	mu             sync.Mutex
+	apiKeyProvider apikey.Provider
 }
 
 type Metrics struct {
@@ -50,6 +65,10 @@ type Metrics struct {
 	Observability       []filter.FilterEntry `yaml:"observability"`
 	CostLabels          []filter.FilterEntry `yaml:"cost_labels"`
 	ObservabilityLabels []filter.FilterEntry `yaml:"observability_labels"`
+	// LabelTransforms rewrite the values of the labels whose name matches,
+	// e.g. to hash or redact sensitive values.
+	LabelTransforms []filter.TransformEntry `yaml:"label_transforms"`
+	HashSecretPath  string                  `yaml:"hash_secret_path" env:"METRICS_HASH_SECRET_PATH" env-description:"path to the secret of the hash label transforms"`
 }
 
 type Logging struct {
@@ -77,6 +96,22 @@ type Server struct {
 	Profiling bool   `yaml:"profiling" default:"false" env:"SERVER_PROFILING" env-description:"enable profiling"`
 }
 
+type Admin struct {
+	Enabled   bool   `yaml:"enabled" default:"false" env:"ADMIN_ENABLED" env-description:"enable the shipper admin API"`
+	TokenPath string `yaml:"token_path" env:"ADMIN_TOKEN_PATH" env-description:"path to the file containing the bearer token required by the admin API"`
+}
+
+// Coordination selects how shipper replicas avoid processing the same files.
+// The file backend only works when every replica runs on the node holding the
+// volume, the lease backend uses a `coordination.k8s.io/v1` Lease instead.
+type Coordination struct {
+	Backend        string        `yaml:"backend" default:"file" env:"COORDINATION_BACKEND" env-description:"how shipper replicas coordinate, either file or lease"`
+	LeaseName      string        `yaml:"lease_name" default:"cloudzero-shipper" env:"COORDINATION_LEASE_NAME" env-description:"prefix of the Lease names used by the lease backend"`
+	LeaseNamespace string        `yaml:"lease_namespace" env:"POD_NAMESPACE" env-description:"namespace of the Leases used by the lease backend"`
+	LeaseDuration  time.Duration `yaml:"lease_duration" default:"15s" env:"COORDINATION_LEASE_DURATION" env-description:"how long a Lease is valid without being renewed"`
+	RenewInterval  time.Duration `yaml:"renew_interval" default:"5s" env:"COORDINATION_RENEW_INTERVAL" env-description:"how often the holder renews its Lease"`
+}
+
 type Cloudzero struct {
 	APIKeyPath     string        `yaml:"api_key_path" env:"API_KEY_PATH" env-description:"path to the API key file"`
 	RotateInterval time.Duration `yaml:"rotate_interval" default:"10m" env:"ROTATE_INTERVAL" env-description:"interval in hours to rotate API key"`
@@ -84,7 +119,10 @@ type Cloudzero struct {
 	SendTimeout    time.Duration `yaml:"send_timeout" default:"10s" env:"SEND_TIMEOUT" env-description:"timeout in seconds to send data"`
 	Host           string        `yaml:"host" env:"HOST" default:"api.cloudzero.com" env-description:"host to send metrics to"`
 	UseHTTP        bool          `yaml:"use_http" env:"USE_HTTP" default:"false" env-description:"use http for client requests instead of https"`
+	ReplayTTL      time.Duration `yaml:"replay_ttl" default:"168h" env:"REPLAY_TTL" env-description:"how long a replay request is retried before the remaining files are abandoned"`
+	APIKeySource   apikey.Config `yaml:"api_key_source"`
 	apiKey         string        // Set after reading keypath
+	nextAPIKey     string        // the key replacing apiKey during a rotation
 
 	_host string // cached value of `Host` since it is overridden in initialization
 }
@@ -149,6 +187,14 @@ func (s *Settings) Validate() error {
 		return errors.Wrap(err, "cloudzero validation")
 	}
 
+	if err := s.Admin.Validate(); err != nil {
+		return errors.Wrap(err, "admin validation")
+	}
+
+	if err := s.Coordination.Validate(); err != nil {
+		return errors.Wrap(err, "coordination validation")
+	}
+
 	return nil
 }
 
@@ -176,6 +222,63 @@ func (s *Server) Validate() error {
 	return nil
 }
 
+func (a *Admin) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.TokenPath == "" {
+		return errors.New("admin token path is empty")
+	}
+	if _, err := os.Stat(a.TokenPath); os.IsNotExist(err) {
+		return errors.Wrap(err, "admin token path does not exist")
+	}
+	return nil
+}
+
+// GetToken reads the admin API bearer token. The file is read on every call so
+// a rotated token takes effect without a restart.
+func (a *Admin) GetToken() (string, error) {
+	data, err := os.ReadFile(a.TokenPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read admin token")
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("admin token is empty")
+	}
+	return token, nil
+}
+
+func (c *Coordination) Validate() error {
+	switch c.Backend {
+	case "":
+		c.Backend = CoordinationBackendFile
+	case CoordinationBackendFile, CoordinationBackendLease:
+	default:
+		return fmt.Errorf("unknown coordination backend: %s", c.Backend)
+	}
+	if c.Backend != CoordinationBackendLease {
+		return nil
+	}
+
+	if c.LeaseName == "" {
+		c.LeaseName = DefaultCoordinationLeaseName
+	}
+	if c.LeaseDuration <= 0 {
+		c.LeaseDuration = DefaultCoordinationLeaseTTL
+	}
+	if c.RenewInterval <= 0 {
+		c.RenewInterval = DefaultCoordinationRenew
+	}
+	if c.RenewInterval >= c.LeaseDuration {
+		return errors.New("lease renew interval must be shorter than the lease duration")
+	}
+	if c.LeaseNamespace == "" {
+		return errors.New("lease namespace is empty")
+	}
+	return nil
+}
+
 func (c *Cloudzero) Validate() error {
 	if c.Host == "" {
 		c.Host = DefaultCZHost
@@ -189,6 +292,16 @@ func (c *Cloudzero) Validate() error {
 	if c.RotateInterval <= 0 {
 		c.RotateInterval = DefaultCZRotateInterval
 	}
+	if c.ReplayTTL <= 0 {
+		c.ReplayTTL = DefaultCZReplayRequestTTL
+	}
+	if err := c.APIKeySource.Validate(); err != nil {
+		return errors.Wrap(err, "invalid API key source")
+	}
+	if c.APIKeySource.Source != "" && c.APIKeySource.Source != apikey.SourceFile {
+		// the key is not read from a file
+		return nil
+	}
 	if c.APIKeyPath == "" {
 		return errors.New("API key path is empty")
 	}
@@ -204,30 +317,69 @@ func (s *Settings) GetAPIKey() string {
 	return s.Cloudzero.apiKey
 }
 
-func (s *Settings) SetAPIKey() error {
+// GetNextAPIKey returns the key which replaces the API key during a rotation,
+// or an empty string when no rotation is in progress.
+func (s *Settings) GetNextAPIKey() string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.Cloudzero.nextAPIKey
+}
 
-	apiKeyPathLocation, err := absFilePath(s.Cloudzero.APIKeyPath)
-	if err != nil {
-		return errors.Wrap(err, "failed to get absolute path")
+// PromoteAPIKey makes the next key the active one, once it was accepted in
+// place of the active key.
+func (s *Settings) PromoteAPIKey(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key != "" && key == s.Cloudzero.nextAPIKey {
+		s.Cloudzero.apiKey = key
+		s.Cloudzero.nextAPIKey = ""
 	}
+}
 
-	if _, err = os.Stat(apiKeyPathLocation); os.IsNotExist(err) {
-		return fmt.Errorf("API key file %s not found", apiKeyPathLocation)
+// SetAPIKey reads the API keys from their source.
+func (s *Settings) SetAPIKey() error {
+	s.mu.Lock()
+	if s.apiKeyProvider == nil {
+		provider, err := apikey.NewProvider(s.Cloudzero.APIKeySource, s.Cloudzero.APIKeyPath)
+		if err != nil {
+			s.mu.Unlock()
+			return errors.Wrap(err, "invalid API key source")
+		}
+		s.setAPIKeyProvider(provider)
 	}
-	apiKey, err := os.ReadFile(s.Cloudzero.APIKeyPath)
+	provider := s.apiKeyProvider
+	s.mu.Unlock()
+
+	// the keys are read without holding the lock, as a plugin may be slow
+	keys, err := provider.Keys(context.Background())
 	if err != nil {
-		return errors.Wrap(err, "failed to read API key")
+		return err
 	}
-	s.Cloudzero.apiKey = strings.TrimSpace(string(apiKey))
 
-	if len(s.Cloudzero.apiKey) == 0 {
-		return errors.New("API key is empty")
-	}
+	s.applyAPIKeys(keys)
 	return nil
 }
 
+// setAPIKeyProvider sets the source of the API keys. The caller holds the
+// lock.
+func (s *Settings) setAPIKeyProvider(provider apikey.Provider) {
+	if notifier, ok := provider.(apikey.Notifier); ok {
+		// a rotation is applied as soon as the provider sees it
+		notifier.OnChange(s.applyAPIKeys)
+	}
+	s.apiKeyProvider = provider
+}
+
+// applyAPIKeys replaces the API keys with the keys read from their source,
+// without demoting a next key which was promoted.
+func (s *Settings) applyAPIKeys(keys apikey.Keys) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys = keys.Resolve(s.Cloudzero.apiKey)
+	s.Cloudzero.apiKey = keys.Active
+	s.Cloudzero.nextAPIKey = keys.Next
+}
+
 func (s *Settings) SetRemoteUploadAPI() error {
 	if s.Cloudzero.Host == "" {
 		return errors.New("host is empty")
@@ -287,15 +439,3 @@ func isValidURL(uri string) bool {
 	}
 	return true
 }
-
-func absFilePath(location string) (string, error) {
-	dir := filepath.Dir(filepath.Clean(location))
-	if dir == "" || strings.HasPrefix(dir, ".") {
-		wd, err := os.Getwd()
-		if err != nil {
-			return "", errors.Wrap(err, "working directory")
-		}
-		location = filepath.Clean(filepath.Join(wd, location))
-	}
-	return location, nil
-}
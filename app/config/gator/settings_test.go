@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/domain/apikey"
 )
 
 func TestCloudzeroSettings_Defaults(t *testing.T) {
@@ -28,6 +29,28 @@ func TestCloudzeroSettings_APIKey(t *testing.T) {
 	assert.Equal(t, "test-api-key", settings.GetAPIKey())
 }
 
+func TestCloudzeroSettings_PromotedAPIKey(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "old-key")
+	t.Setenv("TEST_NEXT_API_KEY", "new-key")
+	settings := config.Settings{
+		Cloudzero: config.Cloudzero{
+			APIKeySource: apikey.Config{Source: apikey.SourceEnv, Env: "TEST_API_KEY", NextEnv: "TEST_NEXT_API_KEY"},
+		},
+	}
+	assert.NoError(t, settings.SetAPIKey())
+	settings.PromoteAPIKey("new-key")
+
+	// the promoted key is kept while it is still the next key
+	assert.NoError(t, settings.SetAPIKey())
+	assert.Equal(t, "new-key", settings.GetAPIKey())
+	assert.Empty(t, settings.GetNextAPIKey())
+
+	t.Setenv("TEST_API_KEY", "new-key")
+	t.Setenv("TEST_NEXT_API_KEY", "")
+	assert.NoError(t, settings.SetAPIKey())
+	assert.Equal(t, "new-key", settings.GetAPIKey())
+}
+
 func TestCloudzeroSettings_InvalidAPIKeyPath(t *testing.T) {
 	settings := config.Settings{
 		Cloudzero: config.Cloudzero{
@@ -295,3 +318,114 @@ func TestCloudzeroSettings_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestAdmin_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   config.Admin
+		wantErr bool
+	}{
+		{
+			name:    "disabled",
+			admin:   config.Admin{},
+			wantErr: false,
+		},
+		{
+			name: "enabled with token",
+			admin: config.Admin{
+				Enabled:   true,
+				TokenPath: "testdata/api_key.txt",
+			},
+			wantErr: false,
+		},
+		{
+			name: "enabled without token path",
+			admin: config.Admin{
+				Enabled: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "enabled with invalid token path",
+			admin: config.Admin{
+				Enabled:   true,
+				TokenPath: "invalid_path",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.admin.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCoordination_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.Coordination
+		expected config.Coordination
+		wantErr  bool
+	}{
+		{
+			name:     "defaults to the file backend",
+			settings: config.Coordination{},
+			expected: config.Coordination{Backend: config.CoordinationBackendFile},
+		},
+		{
+			name: "lease defaults",
+			settings: config.Coordination{
+				Backend:        config.CoordinationBackendLease,
+				LeaseNamespace: "cloudzero",
+			},
+			expected: config.Coordination{
+				Backend:        config.CoordinationBackendLease,
+				LeaseName:      config.DefaultCoordinationLeaseName,
+				LeaseNamespace: "cloudzero",
+				LeaseDuration:  config.DefaultCoordinationLeaseTTL,
+				RenewInterval:  config.DefaultCoordinationRenew,
+			},
+		},
+		{
+			name: "lease without namespace",
+			settings: config.Coordination{
+				Backend: config.CoordinationBackendLease,
+			},
+			wantErr: true,
+		},
+		{
+			name: "lease renewed slower than it expires",
+			settings: config.Coordination{
+				Backend:        config.CoordinationBackendLease,
+				LeaseNamespace: "cloudzero",
+				LeaseDuration:  time.Second,
+				RenewInterval:  time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name:     "unknown backend",
+			settings: config.Coordination{Backend: "etcd"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, tt.settings)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DropPolicy is what happens to an admission request when the queue is full.
+type DropPolicy string
+
+const (
+	// DropNewest drops the request which does not fit in the queue.
+	DropNewest DropPolicy = "drop-newest"
+	// DropOldest drops the oldest queued request, to make room for the new
+	// one.
+	DropOldest DropPolicy = "drop-oldest"
+	// Block waits for room in the queue up to the timeout, and then drops
+	// the request.
+	Block DropPolicy = "block"
+)
+
+// Admission configures how the admission requests are processed. By default
+// the requests are queued and the webhook responds immediately, so a slow
+// database does not delay the admission of resources in the cluster. The
+// requests of an object are always processed by the same worker, in the order
+// they were received.
+type Admission struct {
+	// Synchronous processes the requests before responding, which is
+	// always the case for the mutating webhook.
+	Synchronous bool       `yaml:"synchronous" default:"false" env:"ADMISSION_SYNCHRONOUS" env-description:"process the admission requests before responding"`
+	QueueSize   int        `yaml:"queue_size" default:"10000" env:"ADMISSION_QUEUE_SIZE" env-description:"how many admission requests are queued for processing"`
+	Workers     int        `yaml:"workers" default:"2" env:"ADMISSION_WORKERS" env-description:"how many queued admission requests are processed at a time"`
+	DropPolicy  DropPolicy `yaml:"drop_policy" default:"drop-oldest" env:"ADMISSION_DROP_POLICY" env-description:"what happens when the queue is full, one of drop-newest, drop-oldest or block"`
+	// Timeout bounds how long a request waits for room in the queue with
+	// the block policy, or for its processing when it is synchronous. The
+	// request is allowed once the timeout passes, so the webhook never
+	// delays an admission for longer.
+	Timeout time.Duration  `yaml:"timeout" default:"2s" env:"ADMISSION_TIMEOUT" env-description:"how long an admission request may be delayed before it is allowed"`
+	Audit   AdmissionAudit `yaml:"audit"`
+}
+
+// AdmissionAudit configures the audit log of the admission requests, which
+// records every request the controller saw as a JSON line.
+type AdmissionAudit struct {
+	Enabled bool   `yaml:"enabled" default:"false" env:"ADMISSION_AUDIT_ENABLED" env-description:"write an audit log of the admission requests"`
+	Path    string `yaml:"path" env:"ADMISSION_AUDIT_PATH" env-description:"path of the admission audit log"`
+	// MaxSize is the size in megabytes at which the file is rotated.
+	MaxSize    int `yaml:"max_size" default:"10" env:"ADMISSION_AUDIT_MAX_SIZE" env-description:"size in megabytes at which the audit log is rotated"`
+	MaxBackups int `yaml:"max_backups" default:"3" env:"ADMISSION_AUDIT_MAX_BACKUPS" env-description:"how many rotated audit logs are kept"`
+}
+
+// Validate checks the queue and the audit log settings.
+func (a Admission) Validate() error {
+	var errs []error
+	switch a.DropPolicy {
+	case "", DropNewest, DropOldest, Block:
+	default:
+		errs = append(errs, fmt.Errorf("unknown drop policy '%s', expected %s, %s or %s", a.DropPolicy, DropNewest, DropOldest, Block))
+	}
+	if a.QueueSize < 0 {
+		errs = append(errs, errors.New("the queue size cannot be negative"))
+	}
+	if a.Workers < 0 {
+		errs = append(errs, errors.New("the number of workers cannot be negative"))
+	}
+	if a.Timeout < 0 {
+		errs = append(errs, errors.New("the timeout cannot be negative"))
+	}
+	if a.Audit.Enabled && a.Audit.Path == "" {
+		errs = append(errs, errors.New("the audit log requires a path"))
+	}
+	if a.Audit.MaxSize < 0 || a.Audit.MaxBackups < 0 {
+		errs = append(errs, errors.New("the audit log size and backups cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
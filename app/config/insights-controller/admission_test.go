@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmission_Validate(t *testing.T) {
+	assert.NoError(t, Admission{}.Validate(), "defaults")
+
+	valid := Admission{
+		QueueSize:  100,
+		Workers:    1,
+		DropPolicy: Block,
+		Timeout:    time.Second,
+		Audit:      AdmissionAudit{Enabled: true, Path: "/var/log/cloudzero/audit.log"},
+	}
+	assert.NoError(t, valid.Validate())
+
+	invalid := Admission{DropPolicy: "drop-all", QueueSize: -1, Audit: AdmissionAudit{Enabled: true}}
+	err := invalid.Validate()
+	require.Error(t, err)
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 3)
+}
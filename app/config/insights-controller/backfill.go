@@ -0,0 +1,13 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "time"
+
+// Backfill configures the periodic reconciliation of the stored resources
+// against the cluster. Every replica reconciles its own store without
+// coordinating with the others, so it is only safe with a single replica.
+type Backfill struct {
+	Interval time.Duration `yaml:"interval" default:"0s" env:"BACKFILL_INTERVAL" env-description:"how often the stored resources are reconciled against the cluster, disabled when zero"`
+}
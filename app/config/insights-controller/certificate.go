@@ -3,7 +3,60 @@
 
 package config
 
+import (
+	"errors"
+	"time"
+)
+
 type Certificate struct {
 	Key  string `yaml:"key" env:"TLS_KEY" env-description:"path to the TLS key"`
 	Cert string `yaml:"cert" env:"TLS_CERT" env-description:"path to the TLS certificate"`
+	// ExpiryWindow is how long before the certificate expires it is reported
+	// as expiring.
+	ExpiryWindow time.Duration `yaml:"expiry_window" default:"168h" env:"TLS_EXPIRY_WINDOW" env-description:"how long before the TLS certificate expires it is reported as expiring"`
+
+	// Managed replaces the key and certificate files with a certificate which
+	// the controller generates, stores in a Secret and rotates itself.
+	Managed ManagedCertificate `yaml:"managed"`
+}
+
+// ManagedCertificate configures the certificates generated by the controller.
+// A self-signed CA signs a serving certificate for the DNS names of the
+// webhook Service, and the CA is set as the caBundle of the webhook
+// configurations.
+type ManagedCertificate struct {
+	Enabled     bool          `yaml:"enabled" default:"false" env:"TLS_MANAGED" env-description:"when enabled the controller generates and rotates its own TLS certificate"`
+	SecretName  string        `yaml:"secret_name" env:"TLS_SECRET_NAME" env-description:"name of the Secret the generated certificates are stored in"`
+	Namespace   string        `yaml:"namespace" env:"TLS_NAMESPACE" env-description:"namespace of the Secret and the webhook Service"`
+	ServiceName string        `yaml:"service_name" env:"TLS_SERVICE_NAME" env-description:"name of the webhook Service, which the certificate is issued for"`
+	Validity    time.Duration `yaml:"validity" default:"8760h" env:"TLS_VALIDITY" env-description:"how long the generated certificates are valid"`
+	RenewBefore time.Duration `yaml:"renew_before" default:"720h" env:"TLS_RENEW_BEFORE" env-description:"how long before expiry the certificates are rotated"`
+	// ValidatingWebhooks and MutatingWebhooks are the names of the webhook
+	// configurations which the caBundle is patched in.
+	ValidatingWebhooks []string `yaml:"validating_webhooks"`
+	MutatingWebhooks   []string `yaml:"mutating_webhooks"`
+}
+
+// Validate checks that the managed certificate can be issued and stored.
+func (m ManagedCertificate) Validate() error {
+	if !m.Enabled {
+		return nil
+	}
+	var errs []error
+	if m.SecretName == "" {
+		errs = append(errs, errors.New("a secret name is required"))
+	}
+	if m.Namespace == "" {
+		errs = append(errs, errors.New("a namespace is required"))
+	}
+	if m.ServiceName == "" {
+		errs = append(errs, errors.New("a service name is required"))
+	}
+	if m.Validity < 0 || m.RenewBefore < 0 {
+		errs = append(errs, errors.New("the validity and renewal period can not be negative"))
+	}
+	if m.Validity > 0 && m.RenewBefore >= m.Validity {
+		errs = append(errs, errors.New("the renewal period must be shorter than the validity"))
+	}
+	return errors.Join(errs...)
 }
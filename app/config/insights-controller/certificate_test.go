@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagedCertificate_Validate(t *testing.T) {
+	assert.NoError(t, ManagedCertificate{}.Validate(), "disabled")
+
+	valid := ManagedCertificate{Enabled: true, SecretName: "webhook-tls", Namespace: "cloudzero", ServiceName: "webhook-svc"}
+	assert.NoError(t, valid.Validate())
+
+	invalid := ManagedCertificate{Enabled: true, Validity: 24 * time.Hour, RenewBefore: 48 * time.Hour}
+	err := invalid.Validate()
+	require.Error(t, err)
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 4)
+}
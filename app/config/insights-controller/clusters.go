@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// LogicalCluster assigns the resources of some namespaces to a logical
+// cluster, such as a vcluster or a Capsule tenant sharing the host cluster.
+// The records of a logical cluster are sent to its own remote write URL, with
+// its cluster name, account and region.
+//
+// A namespace belongs to the first logical cluster it matches, by name prefix
+// or label selector, and to the host cluster when it matches none.
+// Cluster-scoped resources, such as nodes, always belong to the host cluster.
+type LogicalCluster struct {
+	ClusterName string `yaml:"cluster_name"`
+	// CloudAccountID and Region default to those of the host cluster.
+	CloudAccountID string `yaml:"cloud_account_id"`
+	Region         string `yaml:"region"`
+	// NamespacePrefix matches the namespaces whose name starts with it.
+	NamespacePrefix string `yaml:"namespace_prefix"`
+	// NamespaceSelector matches the namespaces with matching labels, e.g.
+	// `capsule.clastix.io/tenant=a`.
+	NamespaceSelector string `yaml:"namespace_selector"`
+	// RemoteWriteHost is the remote write URL of the logical cluster, set by
+	// NewSettings.
+	RemoteWriteHost string `yaml:"-"`
+}
+
+// LogicalClusters are the logical clusters sharing the host cluster, matched
+// in order.
+type LogicalClusters []LogicalCluster
+
+// NeedsNamespaceLabels returns true when the labels of the namespaces must be
+// looked up to assign them to the logical clusters.
+func (l LogicalClusters) NeedsNamespaceLabels() bool {
+	for _, c := range l {
+		if c.NamespaceSelector != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that every logical cluster has a unique name and matches
+// some namespaces.
+func (l LogicalClusters) Validate(hostClusterName string) error {
+	var errs []error
+	names := map[string]bool{hostClusterName: true}
+	for i, c := range l {
+		if c.ClusterName == "" {
+			errs = append(errs, fmt.Errorf("logical cluster %d: the cluster name is required", i))
+		} else if names[c.ClusterName] {
+			errs = append(errs, fmt.Errorf("logical cluster '%s': the cluster name is already used", c.ClusterName))
+		}
+		names[c.ClusterName] = true
+		if c.NamespacePrefix == "" && c.NamespaceSelector == "" {
+			errs = append(errs, fmt.Errorf("logical cluster '%s': a namespace prefix or selector is required", c.ClusterName))
+		}
+		if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+			errs = append(errs, fmt.Errorf("logical cluster '%s': invalid namespace selector '%s': %w", c.ClusterName, c.NamespaceSelector, err))
+		}
+	}
+	return errors.Join(errs...)
+}
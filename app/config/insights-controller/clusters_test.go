@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogicalClusters_Validate(t *testing.T) {
+	assert.NoError(t, LogicalClusters{}.Validate("host"), "empty")
+
+	valid := LogicalClusters{
+		{ClusterName: "tenant-a", NamespacePrefix: "a-"},
+		{ClusterName: "tenant-b", NamespaceSelector: "capsule.clastix.io/tenant=b"},
+	}
+	assert.NoError(t, valid.Validate("host"))
+	assert.True(t, valid.NeedsNamespaceLabels())
+	assert.False(t, valid[:1].NeedsNamespaceLabels())
+
+	invalid := LogicalClusters{
+		{NamespacePrefix: "a-"},
+		{ClusterName: "host", NamespacePrefix: "b-"},
+		{ClusterName: "tenant-c"},
+		{ClusterName: "tenant-d", NamespaceSelector: "tenant in ("},
+	}
+	err := invalid.Validate("host")
+	require.Error(t, err)
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 4)
+}
+
+func TestSettings_LogicalClusterRemoteWriteURL(t *testing.T) {
+	s := &Settings{
+		CloudAccountID: "123456789012",
+		Region:         "us-west-2",
+		ClusterName:    "host",
+		Destination:    "https://api.cloudzero.com/v1/container-metrics",
+		LogicalClusters: LogicalClusters{
+			{ClusterName: "tenant-a", NamespacePrefix: "a-"},
+			{ClusterName: "tenant-b", CloudAccountID: " 210987654321 ", Region: "eu-west-1", NamespacePrefix: "b-"},
+		},
+	}
+	s.setRemoteWriteURL()
+
+	assert.Equal(t, "https://api.cloudzero.com/v1/container-metrics?cloud_account_id=123456789012&cluster_name=host&region=us-west-2", s.RemoteWrite.Host)
+	// the account and region default to those of the host cluster
+	assert.Equal(t, "https://api.cloudzero.com/v1/container-metrics?cloud_account_id=123456789012&cluster_name=tenant-a&region=us-west-2", s.LogicalClusters[0].RemoteWriteHost)
+	assert.Equal(t, "210987654321", s.LogicalClusters[1].CloudAccountID)
+	assert.Equal(t, "https://api.cloudzero.com/v1/container-metrics?cloud_account_id=210987654321&cluster_name=tenant-b&region=eu-west-1", s.LogicalClusters[1].RemoteWriteHost)
+}
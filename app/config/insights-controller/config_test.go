@@ -8,7 +8,9 @@ import (
 	"testing"
 
 	"github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/filter"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFilter(t *testing.T) {
@@ -105,3 +107,36 @@ func TestFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestFilter_Transforms(t *testing.T) {
+	transformer, err := filter.NewTransformer([]filter.TransformEntry{
+		{Pattern: "owner", Match: filter.FilterMatchTypeExact, Action: filter.TransformActionRedact},
+	}, nil)
+	require.NoError(t, err)
+	settings := &config.Settings{ValueTransformer: transformer}
+
+	actual := config.Filter(map[string]string{"owner": "jane@example.com", "app": "web"},
+		[]regexp.Regexp{*regexp.MustCompile(`.*`)}, true, settings)
+	assert.Equal(t, config.MetricLabels{"owner": filter.DefaultRedactedValue, "app": "web"}, actual)
+}
+
+func TestFilter_TransformsBeforePolicy(t *testing.T) {
+	transformer, err := filter.NewTransformer([]filter.TransformEntry{
+		{Pattern: "ticket", Match: filter.FilterMatchTypeExact, Action: filter.TransformActionHash, Length: 12},
+		{Pattern: "owner", Match: filter.FilterMatchTypeExact, Action: filter.TransformActionRedact},
+	}, []byte("s3cr3t"))
+	require.NoError(t, err)
+	settings := &config.Settings{ValueTransformer: transformer}
+
+	actual := config.Filter(map[string]string{
+		"ticket": "https://jira.example.com/browse?id=OPS-1&view=full",
+		"owner":  "Jane Doe <jane@example.com>",
+		"link":   "https://example.com/?a=1&b=2",
+	}, []regexp.Regexp{*regexp.MustCompile(`.*`)}, true, settings)
+
+	// the values rejected by the policy are kept once transformed, and the
+	// values without a transform are still checked as they are
+	assert.Len(t, actual["ticket"], 12)
+	assert.Equal(t, filter.DefaultRedactedValue, actual["owner"])
+	assert.NotContains(t, actual, "link")
+}
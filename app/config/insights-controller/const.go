@@ -15,6 +15,13 @@ const (
 	Job         ResourceType = 6
 	CronJob     ResourceType = 7
 	DaemonSet   ResourceType = 8
+
+	PersistentVolumeClaim ResourceType = 9
+	PersistentVolume      ResourceType = 10
+	Service               ResourceType = 11
+	Ingress               ResourceType = 12
+	ResourceQuota         ResourceType = 13
+	ReplicaSet            ResourceType = 14
 )
 
 var ResourceTypeToMetricName = map[ResourceType]string{
@@ -27,4 +34,11 @@ var ResourceTypeToMetricName = map[ResourceType]string{
 	Job:         "job",
 	CronJob:     "cronjob",
 	DaemonSet:   "daemonset",
+
+	PersistentVolumeClaim: "persistentvolumeclaim",
+	PersistentVolume:      "persistentvolume",
+	Service:               "service",
+	Ingress:               "ingress",
+	ResourceQuota:         "resourcequota",
+	ReplicaSet:            "replicaset",
 }
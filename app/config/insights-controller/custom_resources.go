@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// CustomResourceTypeOffset is the lowest resource type of a custom resource.
+// The resource types below it are reserved for the built-in kinds.
+const CustomResourceTypeOffset ResourceType = 1 << 16
+
+// CustomResource configures tracking the labels and annotations of a kind
+// which has no built-in handler, such as a CRD. The labels and annotations are
+// gathered whenever labels or annotations are enabled, using the same patterns
+// as the built-in kinds, and sent as `cloudzero_<kind>_labels` and
+// `cloudzero_<kind>_annotations`.
+type CustomResource struct {
+	Group   string `yaml:"group"`
+	Version string `yaml:"version"`
+	Kind    string `yaml:"kind"`
+	// Resource is the plural name of the kind in the API, e.g.
+	// `inferenceservices`. It defaults to the lowercase kind followed by `s`.
+	Resource string `yaml:"resource"`
+	// Name is used in the metric names. It defaults to the lowercase kind, and
+	// must be set when the kind clashes with another one, e.g. the `Service`
+	// of Knative.
+	Name string `yaml:"name"`
+}
+
+// Plural returns the name of the kind in the API.
+func (c CustomResource) Plural() string {
+	if c.Resource != "" {
+		return c.Resource
+	}
+	return strings.ToLower(c.Kind) + "s"
+}
+
+// MetricName returns the name of the kind in the metric names.
+func (c CustomResource) MetricName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return strings.ToLower(c.Kind)
+}
+
+// ResourceType returns the resource type the records of the kind are stored
+// with. It is derived from the group and kind, so it does not change when the
+// configuration is reordered.
+func (c CustomResource) ResourceType() ResourceType {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(c.Group) + "/" + strings.ToLower(c.Kind)))
+	return CustomResourceTypeOffset + ResourceType(h.Sum32()%(1<<30))
+}
+
+func (c CustomResource) String() string {
+	if c.Group == "" {
+		return c.Version + "/" + c.Kind
+	}
+	return c.Group + "/" + c.Version + "/" + c.Kind
+}
+
+// FindCustomResource returns the configured custom resource of a group and
+// kind.
+func (s *Settings) FindCustomResource(group, kind string) (CustomResource, bool) {
+	for _, c := range s.CustomResources {
+		if c.Group == group && c.Kind == kind {
+			return c, true
+		}
+	}
+	return CustomResource{}, false
+}
+
+var customResourcesMu sync.Mutex
+
+// RegisterCustomResources adds the metric names of the custom resources to
+// ResourceTypeToMetricName. It fails when a custom resource is incomplete, or
+// when two kinds map to the same resource type or metric name.
+func RegisterCustomResources(resources []CustomResource) error {
+	customResourcesMu.Lock()
+	defer customResourcesMu.Unlock()
+
+	var errs []error
+	for _, c := range resources {
+		if c.Version == "" || c.Kind == "" {
+			errs = append(errs, fmt.Errorf("custom resource '%s' requires a version and a kind", c))
+			continue
+		}
+		resourceType, name := c.ResourceType(), c.MetricName()
+		if existing, ok := ResourceTypeToMetricName[resourceType]; ok && existing != name {
+			errs = append(errs, fmt.Errorf("custom resource '%s' conflicts with the resource type of '%s'", c, existing))
+			continue
+		}
+		for t, existing := range ResourceTypeToMetricName {
+			if existing == name && t != resourceType {
+				errs = append(errs, fmt.Errorf("custom resource '%s' conflicts with the metric name of '%s'", c, existing))
+			}
+		}
+		ResourceTypeToMetricName[resourceType] = name
+	}
+	return errors.Join(errs...)
+}
+
+// ResourceTypeOf returns the resource type of a metric name, such as
+// `deployment`, including the registered custom resources.
+func ResourceTypeOf(name string) (ResourceType, bool) {
+	customResourcesMu.Lock()
+	defer customResourcesMu.Unlock()
+
+	for t, existing := range ResourceTypeToMetricName {
+		if existing == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// ResourceTypeOfKind returns the resource type of the kind of an admission
+// request or a listed object, preferring the registered custom resources.
+func ResourceTypeOfKind(group, kind string) (ResourceType, bool) {
+	customResourceType := CustomResource{Group: group, Kind: kind}.ResourceType()
+	customResourcesMu.Lock()
+	_, ok := ResourceTypeToMetricName[customResourceType]
+	customResourcesMu.Unlock()
+	if ok {
+		return customResourceType, true
+	}
+	return ResourceTypeOf(strings.ToLower(kind))
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomResource(t *testing.T) {
+	rollout := CustomResource{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}
+	assert.Equal(t, "rollouts", rollout.Plural())
+	assert.Equal(t, "rollout", rollout.MetricName())
+	assert.GreaterOrEqual(t, rollout.ResourceType(), CustomResourceTypeOffset)
+
+	// the resource type only depends on the group and kind
+	assert.Equal(t, rollout.ResourceType(), CustomResource{Group: "argoproj.io", Version: "v1", Kind: "Rollout"}.ResourceType())
+	assert.NotEqual(t, rollout.ResourceType(), CustomResource{Group: "example.com", Version: "v1alpha1", Kind: "Rollout"}.ResourceType())
+
+	overridden := CustomResource{Group: "serving.knative.dev", Version: "v1", Kind: "Service", Resource: "services", Name: "knative_service"}
+	assert.Equal(t, "services", overridden.Plural())
+	assert.Equal(t, "knative_service", overridden.MetricName())
+}
+
+func TestRegisterCustomResources(t *testing.T) {
+	nodePool := CustomResource{Group: "karpenter.sh", Version: "v1", Kind: "NodePool"}
+	require.NoError(t, RegisterCustomResources([]CustomResource{nodePool}))
+	assert.Equal(t, "nodepool", ResourceTypeToMetricName[nodePool.ResourceType()])
+
+	// registering the same kind again is allowed
+	require.NoError(t, RegisterCustomResources([]CustomResource{nodePool}))
+
+	assert.Error(t, RegisterCustomResources([]CustomResource{{Group: "serving.knative.dev", Version: "v1", Kind: "Service"}}))
+	assert.Error(t, RegisterCustomResources([]CustomResource{{Group: "example.com", Kind: "Widget"}}))
+}
+
+func TestResourceTypeOfKind(t *testing.T) {
+	gateway := CustomResource{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}
+	require.NoError(t, RegisterCustomResources([]CustomResource{gateway}))
+
+	resourceType, ok := ResourceTypeOfKind("gateway.networking.k8s.io", "Gateway")
+	assert.True(t, ok)
+	assert.Equal(t, gateway.ResourceType(), resourceType)
+
+	resourceType, ok = ResourceTypeOfKind("apps", "StatefulSet")
+	assert.True(t, ok)
+	assert.Equal(t, StatefulSet, resourceType)
+
+	_, ok = ResourceTypeOfKind("example.com", "Widget")
+	assert.False(t, ok)
+}
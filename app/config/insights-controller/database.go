@@ -6,9 +6,10 @@ package config
 import "time"
 
 type Database struct {
-	Enabled         bool          `yaml:"enabled" default:"false" env:"DATABASE_ENABLED" env-description:"when enabled will write to persistent storage, otherwise only in memory sqlite"`
-	StoragePath     string        `yaml:"storage_path" default:"/opt/insights" env:"DATABASE_STORAGE_PATH" env-description:"location where to write database"`
-	RetentionTime   time.Duration `yaml:"retention_time" default:"24h" env:"DATABASE_RETENTION" env-description:"how long local data should be retain before being deleted"`
-	CleanupInterval time.Duration `yaml:"cleanup_interval" default:"3h" env:"DATABASE_CLEANUP_INTERVAL" env-description:"how often to check for expired data"`
-	BatchUpdateSize int           `yaml:"batch_update_size" default:"500" env:"DATABASE_BATCH_UPDATE_SIZE" env-description:"how many records to update in a single batch"`
+	Enabled          bool          `yaml:"enabled" default:"false" env:"DATABASE_ENABLED" env-description:"when enabled will write to persistent storage, otherwise only in memory sqlite"`
+	StoragePath      string        `yaml:"storage_path" default:"/opt/insights" env:"DATABASE_STORAGE_PATH" env-description:"location where to write database"`
+	RetentionTime    time.Duration `yaml:"retention_time" default:"24h" env:"DATABASE_RETENTION" env-description:"how long local data should be retain before being deleted"`
+	CleanupInterval  time.Duration `yaml:"cleanup_interval" default:"3h" env:"DATABASE_CLEANUP_INTERVAL" env-description:"how often to check for expired data"`
+	BatchUpdateSize  int           `yaml:"batch_update_size" default:"500" env:"DATABASE_BATCH_UPDATE_SIZE" env-description:"how many records to update in a single batch"`
+	HistoryRetention time.Duration `yaml:"history_retention" default:"720h" env:"DATABASE_HISTORY_RETENTION" env-description:"how long the replaced labels of a resource are kept for lookups"`
 }
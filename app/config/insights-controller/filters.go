@@ -5,16 +5,23 @@ package config
 
 import (
 	"regexp"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/filter"
 )
 
 type Filters struct {
 	Labels      Labels      `yaml:"labels"`
 	Annotations Annotations `yaml:"annotations"`
-	Policy      bluemonday.Policy
+	// Transforms rewrite the values of the labels and annotations whose key
+	// matches, before they are stored, e.g. to hash or redact sensitive values.
+	Transforms     []filter.TransformEntry `yaml:"transforms"`
+	HashSecretPath string                  `yaml:"hash_secret_path" env:"FILTERS_HASH_SECRET_PATH" env-description:"path to the secret of the hash transforms"`
+	Policy         bluemonday.Policy
 }
 
 // MetricLabels represents any metric label that can be added to a metric; "pod", "namespace", "label_foo" etc.
@@ -23,12 +30,26 @@ type MetricLabels = map[string]string
 // MetricLabelTags represents metric labels attached to a metric that represent annotations or labels; value must be prefixed with "label_"
 type MetricLabelTags = map[string]string
 
+// MetricLabelName converts a resource name such as `requests.nvidia.com/gpu`
+// into a valid metric label name or value such as `requests_nvidia_com_gpu`.
+func MetricLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 func Filter(tags map[string]string, patterns []regexp.Regexp, enabled bool, settings *Settings) MetricLabelTags {
 	filteredTags := make(MetricLabels)
 	if !enabled {
 		return filteredTags
 	}
 	for key, value := range tags {
+		// the policy applies to the transformed value, so a value which is
+		// hashed or redacted is kept even when the original would be rejected
+		value = settings.ValueTransformer.Transform(key, value)
 		if evalTag(key, value, patterns, settings) {
 			filteredTags[key] = value
 		}
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/filter"
+)
+
+func TestSettings_SetValueTransformer(t *testing.T) {
+	s := &Settings{}
+	require.NoError(t, s.setValueTransformer())
+	assert.Nil(t, s.ValueTransformer)
+
+	s.Filters.Transforms = []filter.TransformEntry{{Pattern: "owner", Action: filter.TransformActionHash, Length: 8}}
+	assert.Error(t, s.setValueTransformer(), "the hash action requires a secret")
+
+	s.Filters.HashSecretPath = filepath.Join(t.TempDir(), "secret")
+	assert.Error(t, s.setValueTransformer(), "the secret file is missing")
+
+	require.NoError(t, os.WriteFile(s.Filters.HashSecretPath, []byte("s3cr3t"), 0o600))
+	require.NoError(t, s.setValueTransformer())
+	assert.Len(t, s.ValueTransformer.Transform("owner", "jane@example.com"), 8)
+}
+
+func TestMetricLabelName(t *testing.T) {
+	assert.Equal(t, "requests_nvidia_com_gpu", MetricLabelName("requests.nvidia.com/gpu"))
+	assert.Equal(t, "ephemeral_storage", MetricLabelName("ephemeral-storage"))
+	assert.Equal(t, "cpu", MetricLabelName("cpu"))
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// Inventory configures the periodic snapshot of every tracked resource, with
+// its labels, annotations, owner and timestamps.
+//
+// The snapshots are written to the data directory of a shipper, which uploads
+// them like the metric files.
+type Inventory struct {
+	Enabled  bool          `yaml:"enabled" default:"false" env:"INVENTORY_ENABLED" env-description:"when enabled will write periodic snapshots of the tracked resources"`
+	Interval time.Duration `yaml:"interval" default:"1h" env:"INVENTORY_INTERVAL" env-description:"interval between the snapshots"`
+	Path     string        `yaml:"path" default:"/cloudzero/data" env:"INVENTORY_PATH" env-description:"data directory of the shipper the snapshots are written to"`
+	// Retention bounds how long a snapshot which was not uploaded is kept,
+	// and MaxSnapshots how many of them are kept, so the snapshots do not
+	// pile up while the shipper is unavailable.
+	Retention    time.Duration `yaml:"retention" default:"24h" env:"INVENTORY_RETENTION" env-description:"how long the snapshots which were not uploaded are kept"`
+	MaxSnapshots int           `yaml:"max_snapshots" default:"3" env:"INVENTORY_MAX_SNAPSHOTS" env-description:"how many snapshots which were not uploaded are kept"`
+}
+
+// Validate checks the snapshot settings.
+func (i Inventory) Validate() error {
+	var errs []error
+	if i.Enabled && i.Path == "" {
+		errs = append(errs, errors.New("the snapshots require a path"))
+	}
+	if i.Interval < 0 || i.Retention < 0 {
+		errs = append(errs, errors.New("the interval and retention cannot be negative"))
+	}
+	if i.MaxSnapshots < 0 {
+		errs = append(errs, errors.New("the number of snapshots cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInventory_Validate(t *testing.T) {
+	assert.NoError(t, Inventory{}.Validate(), "disabled")
+	assert.NoError(t, Inventory{Enabled: true, Path: "/cloudzero/data", Interval: time.Hour, Retention: 24 * time.Hour, MaxSnapshots: 3}.Validate())
+	assert.Error(t, Inventory{Enabled: true}.Validate(), "missing path")
+	assert.Error(t, Inventory{Interval: -time.Second}.Validate())
+	assert.Error(t, Inventory{MaxSnapshots: -1}.Validate())
+}
@@ -3,7 +3,13 @@
 
 package config
 
+import "time"
+
 type K8sClient struct {
-	KubeConfig      string `yaml:"kube_config" env:"KUBE_CONFIG" default:"false" env-description:"path to the kubeconfig file"`
-	PaginationLimit int64  `yaml:"pagination_limit" env:"KUBE_PAGINATION_LIMIT" default:"500" env-description:"limit for pagination"`
+	KubeConfig      string        `yaml:"kube_config" env:"KUBE_CONFIG" default:"false" env-description:"path to the kubeconfig file"`
+	PaginationLimit int64         `yaml:"pagination_limit" env:"KUBE_PAGINATION_LIMIT" default:"500" env-description:"limit for pagination"`
+	QPS             float32       `yaml:"qps" env:"KUBE_QPS" default:"10" env-description:"maximum rate of list requests per second"`
+	Burst           int           `yaml:"burst" env:"KUBE_BURST" default:"20" env-description:"maximum burst of list requests"`
+	MaxRetries      int           `yaml:"max_retries" env:"KUBE_MAX_RETRIES" default:"5" env-description:"maximum number of retries of a failed list request"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff" env:"KUBE_RETRY_BACKOFF" default:"1s" env-description:"delay before the first retry, doubled for every following retry"`
 }
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RuleMode is how a label rule acts on the resources which break it.
+type RuleMode string
+
+const (
+	// RuleModeAudit only logs and counts the resources which break the rule.
+	RuleModeAudit RuleMode = "audit"
+	// RuleModeWarn adds the default label, or returns a warning to the client
+	// when there is no default.
+	RuleModeWarn RuleMode = "warn"
+	// RuleModeEnforce adds the default label, or denies the resource when
+	// there is no default.
+	RuleModeEnforce RuleMode = "enforce"
+)
+
+// Mutation configures the mutating webhook, which applies cost allocation
+// label rules to resources as they are admitted.
+type Mutation struct {
+	Enabled bool        `yaml:"enabled" default:"false" env:"MUTATION_ENABLED" env-description:"when enabled will apply the label rules to admitted resources"`
+	Rules   []LabelRule `yaml:"rules"`
+}
+
+// LabelRule requires a label on the resources in scope. When the label is
+// missing, its value is taken from the label of the namespace named in
+// NamespaceLabel, or else from Default.
+type LabelRule struct {
+	Name           string   `yaml:"name"`
+	Label          string   `yaml:"label"`
+	Mode           RuleMode `yaml:"mode"`
+	Default        string   `yaml:"default"`
+	NamespaceLabel string   `yaml:"namespace_label"`
+	// Kinds are the metric names of the kinds the rule applies to, e.g.
+	// `deployment`. All kinds are in scope when empty.
+	Kinds []string `yaml:"kinds"`
+	// Namespaces the rule applies to. All namespaces are in scope when empty.
+	Namespaces []string `yaml:"namespaces"`
+	// ExemptNamespaces are never in scope, e.g. `kube-system`.
+	ExemptNamespaces []string `yaml:"exempt_namespaces"`
+}
+
+// RuleName returns the name of the rule, which defaults to the label.
+func (r LabelRule) RuleName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Label
+}
+
+// EffectiveMode returns the mode of the rule, which defaults to audit.
+func (r LabelRule) EffectiveMode() RuleMode {
+	if r.Mode == "" {
+		return RuleModeAudit
+	}
+	return r.Mode
+}
+
+// Applies returns true when a resource of a kind in a namespace is in the
+// scope of the rule. Cluster-scoped resources have an empty namespace, and are
+// only in scope when the rule is not limited to namespaces.
+func (r LabelRule) Applies(kind, namespace string) bool {
+	if len(r.Kinds) > 0 && !slices.Contains(r.Kinds, kind) {
+		return false
+	}
+	if slices.Contains(r.ExemptNamespaces, namespace) {
+		return false
+	}
+	return len(r.Namespaces) == 0 || slices.Contains(r.Namespaces, namespace)
+}
+
+// Validate checks the label rules.
+func (m Mutation) Validate() error {
+	var errs []error
+	for i, r := range m.Rules {
+		if r.Label == "" {
+			errs = append(errs, fmt.Errorf("label rule %d requires a label", i))
+		} else if msgs := validation.IsQualifiedName(r.Label); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("label rule '%s' has an invalid label: %s", r.RuleName(), strings.Join(msgs, "; ")))
+		}
+		if msgs := validation.IsValidLabelValue(r.Default); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("label rule '%s' has an invalid default: %s", r.RuleName(), strings.Join(msgs, "; ")))
+		}
+		switch r.EffectiveMode() {
+		case RuleModeAudit, RuleModeWarn, RuleModeEnforce:
+		default:
+			errs = append(errs, fmt.Errorf("label rule '%s' has an invalid mode '%s', expected audit, warn or enforce", r.RuleName(), r.Mode))
+		}
+	}
+	return errors.Join(errs...)
+}
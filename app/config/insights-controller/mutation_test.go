@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelRule_Applies(t *testing.T) {
+	rule := LabelRule{Label: "cost-center", Kinds: []string{"deployment"}, Namespaces: []string{"team-a", "kube-system"}, ExemptNamespaces: []string{"kube-system"}}
+	assert.True(t, rule.Applies("deployment", "team-a"))
+	assert.False(t, rule.Applies("deployment", "team-b"))
+	assert.False(t, rule.Applies("deployment", "kube-system"))
+	assert.False(t, rule.Applies("pod", "team-a"))
+
+	// cluster-scoped resources are only in scope of unscoped rules
+	assert.False(t, rule.Applies("deployment", ""))
+	assert.True(t, LabelRule{Label: "cost-center"}.Applies("node", ""))
+	assert.Equal(t, RuleModeAudit, rule.EffectiveMode())
+}
+
+func TestMutation_Validate(t *testing.T) {
+	valid := Mutation{Rules: []LabelRule{{Label: "cost-center", Mode: RuleModeEnforce, Default: "unallocated"}}}
+	assert.NoError(t, valid.Validate())
+
+	invalid := Mutation{Rules: []LabelRule{
+		{Mode: RuleModeWarn},
+		{Label: "cost center"},
+		{Label: "cost-center", Mode: "block"},
+		{Label: "cost-center", Default: "not a value"},
+	}}
+	err := invalid.Validate()
+	require.Error(t, err)
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 4)
+}
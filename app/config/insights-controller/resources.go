@@ -12,4 +12,31 @@ type Resources struct {
 	StatefulSets bool `yaml:"statefulsets" default:"false"` //nolint:tagliatelle // compatibility
 	DaemonSets   bool `yaml:"daemonsets" default:"false"`   //nolint:tagliatelle // compatibility
 	Nodes        bool `yaml:"nodes" default:"false"`
+
+	PersistentVolumeClaims bool `yaml:"persistentvolumeclaims" default:"false"` //nolint:tagliatelle // compatibility
+	PersistentVolumes      bool `yaml:"persistentvolumes" default:"false"`      //nolint:tagliatelle // compatibility
+	Services               bool `yaml:"services" default:"false"`
+	Ingresses              bool `yaml:"ingresses" default:"false"`
+	ResourceQuotas         bool `yaml:"resourcequotas" default:"false"` //nolint:tagliatelle // compatibility
+	ReplicaSets            bool `yaml:"replicasets" default:"false"`    //nolint:tagliatelle // compatibility
+}
+
+// Merge returns the resource types enabled in either of the resources.
+func (r Resources) Merge(o Resources) Resources {
+	return Resources{
+		Pods:                   r.Pods || o.Pods,
+		Namespaces:             r.Namespaces || o.Namespaces,
+		Deployments:            r.Deployments || o.Deployments,
+		Jobs:                   r.Jobs || o.Jobs,
+		CronJobs:               r.CronJobs || o.CronJobs,
+		StatefulSets:           r.StatefulSets || o.StatefulSets,
+		DaemonSets:             r.DaemonSets || o.DaemonSets,
+		Nodes:                  r.Nodes || o.Nodes,
+		PersistentVolumeClaims: r.PersistentVolumeClaims || o.PersistentVolumeClaims,
+		PersistentVolumes:      r.PersistentVolumes || o.PersistentVolumes,
+		Services:               r.Services || o.Services,
+		Ingresses:              r.Ingresses || o.Ingresses,
+		ResourceQuotas:         r.ResourceQuotas || o.ResourceQuotas,
+		ReplicaSets:            r.ReplicaSets || o.ReplicaSets,
+	}
 }
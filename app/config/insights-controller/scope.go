@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Scope limits the tracked objects to some namespaces and objects. Every
+// object is tracked when it is empty.
+//
+// The namespace rules apply to the namespaced objects and to the namespaces
+// themselves. The object selectors apply to every object but the namespaces.
+// Selectors use the label selector syntax of kubectl, e.g.
+// `team in (a, b), !ephemeral`.
+type Scope struct {
+	// IncludeNamespaces only tracks the objects of these namespaces.
+	IncludeNamespaces []string `yaml:"include_namespaces"`
+	// ExcludeNamespaces never tracks the objects of these namespaces.
+	ExcludeNamespaces []string `yaml:"exclude_namespaces"`
+	// IncludeNamespaceSelector only tracks the objects of the namespaces
+	// with matching labels.
+	IncludeNamespaceSelector string `yaml:"include_namespace_selector" env:"SCOPE_INCLUDE_NAMESPACE_SELECTOR" env-description:"only track the objects of the namespaces matching this label selector"`
+	// ExcludeNamespaceSelector never tracks the objects of the namespaces
+	// with matching labels.
+	ExcludeNamespaceSelector string `yaml:"exclude_namespace_selector" env:"SCOPE_EXCLUDE_NAMESPACE_SELECTOR" env-description:"never track the objects of the namespaces matching this label selector"`
+	// IncludeObjectSelector only tracks the objects with matching labels.
+	IncludeObjectSelector string `yaml:"include_object_selector" env:"SCOPE_INCLUDE_OBJECT_SELECTOR" env-description:"only track the objects matching this label selector"`
+	// ExcludeObjectSelector never tracks the objects with matching labels.
+	ExcludeObjectSelector string `yaml:"exclude_object_selector" env:"SCOPE_EXCLUDE_OBJECT_SELECTOR" env-description:"never track the objects matching this label selector"`
+}
+
+// Active returns true when the scope limits the tracked objects.
+func (s Scope) Active() bool {
+	return len(s.IncludeNamespaces) > 0 || len(s.ExcludeNamespaces) > 0 ||
+		s.NeedsNamespaceLabels() ||
+		s.IncludeObjectSelector != "" || s.ExcludeObjectSelector != ""
+}
+
+// NeedsNamespaceLabels returns true when the labels of the namespaces must be
+// looked up to scope the objects.
+func (s Scope) NeedsNamespaceLabels() bool {
+	return s.IncludeNamespaceSelector != "" || s.ExcludeNamespaceSelector != ""
+}
+
+// Validate checks that the selectors can be parsed.
+func (s Scope) Validate() error {
+	var errs []error
+	for name, selector := range map[string]string{
+		"include namespace selector": s.IncludeNamespaceSelector,
+		"exclude namespace selector": s.ExcludeNamespaceSelector,
+		"include object selector":    s.IncludeObjectSelector,
+		"exclude object selector":    s.ExcludeObjectSelector,
+	} {
+		if _, err := labels.Parse(selector); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s '%s': %w", name, selector, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScope_Validate(t *testing.T) {
+	assert.NoError(t, Scope{}.Validate(), "empty")
+	assert.False(t, Scope{}.Active())
+
+	valid := Scope{
+		ExcludeNamespaces:        []string{"kube-system"},
+		IncludeNamespaceSelector: "tenant in (a, b)",
+		ExcludeObjectSelector:    "!team,ephemeral=true",
+	}
+	assert.NoError(t, valid.Validate())
+	assert.True(t, valid.Active())
+	assert.True(t, valid.NeedsNamespaceLabels())
+	assert.False(t, Scope{IncludeObjectSelector: "app=web"}.NeedsNamespaceLabels())
+
+	invalid := Scope{IncludeNamespaceSelector: "tenant in (", ExcludeObjectSelector: "=web"}
+	err := invalid.Validate()
+	require.Error(t, err)
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 2)
+}
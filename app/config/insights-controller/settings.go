@@ -5,11 +5,10 @@
 package config
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
@@ -19,36 +18,62 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/ilyakaznacheev/cleanenv"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/apikey"
+	"github.com/cloudzero/cloudzero-agent/app/domain/filter"
 )
 
 // Settings represents the configuration settings for the application.
 type Settings struct {
-	CloudAccountID    string      `yaml:"cloud_account_id" env:"CLOUD_ACCOUNT_ID" env-description:"CSP account ID"`
-	Region            string      `yaml:"region" env:"CSP_REGION" env-description:"cloud service provider region"`
-	ClusterName       string      `yaml:"cluster_name" env:"CLUSTER_NAME" env-description:"name of the cluster to monitor"`
-	Destination       string      `yaml:"destination" env:"DESTINATION" env-default:"https://api.cloudzero.com/v1/container-metrics" env-description:"location to send metrics to"`
-	APIKeyPath        string      `yaml:"api_key_path" env:"API_KEY_PATH" env-description:"path to the API key file"`
-	Server            Server      `yaml:"server"`
-	Certificate       Certificate `yaml:"certificate"`
-	Logging           Logging     `yaml:"logging"`
-	Database          Database    `yaml:"database"`
-	Filters           Filters     `yaml:"filters"`
-	RemoteWrite       RemoteWrite `yaml:"remote_write"`
-	K8sClient         K8sClient   `yaml:"k8s_client"`
+	CloudAccountID    string           `yaml:"cloud_account_id" env:"CLOUD_ACCOUNT_ID" env-description:"CSP account ID"`
+	Region            string           `yaml:"region" env:"CSP_REGION" env-description:"cloud service provider region"`
+	ClusterName       string           `yaml:"cluster_name" env:"CLUSTER_NAME" env-description:"name of the cluster to monitor"`
+	Destination       string           `yaml:"destination" env:"DESTINATION" env-default:"https://api.cloudzero.com/v1/container-metrics" env-description:"location to send metrics to"`
+	APIKeyPath        string           `yaml:"api_key_path" env:"API_KEY_PATH" env-description:"path to the API key file"`
+	APIKeySource      apikey.Config    `yaml:"api_key_source"`
+	Server            Server           `yaml:"server"`
+	Certificate       Certificate      `yaml:"certificate"`
+	Logging           Logging          `yaml:"logging"`
+	Database          Database         `yaml:"database"`
+	Filters           Filters          `yaml:"filters"`
+	RemoteWrite       RemoteWrite      `yaml:"remote_write"`
+	K8sClient         K8sClient        `yaml:"k8s_client"`
+	Watch             Watch            `yaml:"watch"`
+	Backfill          Backfill         `yaml:"backfill"`
+	Workloads         Workloads        `yaml:"workloads"`
+	CustomResources   []CustomResource `yaml:"custom_resources"`
+	Mutation          Mutation         `yaml:"mutation"`
+	Admission         Admission        `yaml:"admission"`
+	Scope             Scope            `yaml:"scope"`
+	Specs             Specs            `yaml:"specs"`
+	Inventory         Inventory        `yaml:"inventory"`
+	LogicalClusters   LogicalClusters  `yaml:"logical_clusters"`
 	LabelMatches      []regexp.Regexp
 	AnnotationMatches []regexp.Regexp
+	InheritMatches    []regexp.Regexp
+	ValueTransformer  *filter.Transformer
 
 	// control for dynamic reloading
-	mu sync.Mutex
+	mu             sync.Mutex
+	apiKeyProvider apikey.Provider
 }
 
+// Remote write protocol versions.
+const (
+	RemoteWriteProtocolV1 = "1.0"
+	RemoteWriteProtocolV2 = "2.0"
+)
+
 type RemoteWrite struct {
 	apiKey          string
+	nextAPIKey      string
 	Host            string
 	MaxBytesPerSend int           `yaml:"max_bytes_per_send" default:"10000000" env:"MAX_BYTES_PER_SEND" env-description:"maximum bytes to send in a single request"`
 	SendInterval    time.Duration `yaml:"send_interval" default:"60s" env:"SEND_INTERVAL" env-description:"interval in seconds to send data"`
 	SendTimeout     time.Duration `yaml:"send_timeout" default:"30s" env:"SEND_TIMEOUT" env-description:"timeout in seconds to send data"`
 	MaxRetries      int           `yaml:"max_retries" default:"3" env:"MAX_RETRIES" env-description:"maximum number of retries"`
+	Protocol        string        `yaml:"protocol" default:"1.0" env:"REMOTE_WRITE_PROTOCOL" env-description:"remote write protocol version, 1.0 or 2.0"`
+	PageSize        int           `yaml:"page_size" default:"1000" env:"REMOTE_WRITE_PAGE_SIZE" env-description:"how many unsent records are read from the database at a time"`
 }
 
 func NewSettings(configFiles ...string) (*Settings, error) {
@@ -74,6 +99,48 @@ func NewSettings(configFiles ...string) (*Settings, error) {
 
 	cfg.setCompiledFilters()
 
+	if err := cfg.setValueTransformer(); err != nil {
+		return nil, fmt.Errorf("invalid transforms: %w", err)
+	}
+
+	if err := RegisterCustomResources(cfg.CustomResources); err != nil {
+		return nil, fmt.Errorf("invalid custom resources: %w", err)
+	}
+
+	switch cfg.RemoteWrite.Protocol {
+	case "", RemoteWriteProtocolV1, RemoteWriteProtocolV2:
+	default:
+		return nil, fmt.Errorf("invalid remote write protocol '%s', expected %s or %s", cfg.RemoteWrite.Protocol, RemoteWriteProtocolV1, RemoteWriteProtocolV2)
+	}
+
+	if err := cfg.Certificate.Managed.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid managed certificate: %w", err)
+	}
+
+	if err := cfg.Mutation.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mutation rules: %w", err)
+	}
+
+	if err := cfg.Watch.Validate(cfg.Mutation); err != nil {
+		return nil, fmt.Errorf("invalid watch settings: %w", err)
+	}
+
+	if err := cfg.Admission.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid admission settings: %w", err)
+	}
+
+	if err := cfg.Scope.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scope: %w", err)
+	}
+
+	if err := cfg.Inventory.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid inventory settings: %w", err)
+	}
+
+	if err := cfg.LogicalClusters.Validate(cfg.ClusterName); err != nil {
+		return nil, fmt.Errorf("invalid logical clusters: %w", err)
+	}
+
 	if err := cfg.SetAPIKey(); err != nil {
 		return nil, fmt.Errorf("failed to get API key: %w", err)
 	}
@@ -92,50 +159,108 @@ func (s *Settings) GetAPIKey() string {
 	return s.RemoteWrite.apiKey
 }
 
-func (s *Settings) SetAPIKey() error {
+// GetNextAPIKey returns the key which replaces the API key during a rotation,
+// or an empty string when no rotation is in progress.
+func (s *Settings) GetNextAPIKey() string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.RemoteWrite.nextAPIKey
+}
 
-	apiKeyPathLocation, err := absFilePath(s.APIKeyPath)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+// PromoteAPIKey makes the next key the active one, once it was accepted in
+// place of the active key.
+func (s *Settings) PromoteAPIKey(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key != "" && key == s.RemoteWrite.nextAPIKey {
+		s.RemoteWrite.apiKey = key
+		s.RemoteWrite.nextAPIKey = ""
 	}
+}
 
-	if _, err = os.Stat(apiKeyPathLocation); os.IsNotExist(err) {
-		return fmt.Errorf("API key file %s not found: %w", apiKeyPathLocation, err)
+// SetAPIKey reads the API keys from their source.
+func (s *Settings) SetAPIKey() error {
+	s.mu.Lock()
+	if s.apiKeyProvider == nil {
+		provider, err := apikey.NewProvider(s.APIKeySource, s.APIKeyPath)
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("invalid API key source: %w", err)
+		}
+		s.setAPIKeyProvider(provider)
 	}
-	apiKey, err := os.ReadFile(s.APIKeyPath)
+	provider := s.apiKeyProvider
+	s.mu.Unlock()
+
+	// the keys are read without holding the lock, as a plugin may be slow
+	keys, err := provider.Keys(context.Background())
 	if err != nil {
-		return fmt.Errorf("failed to read API key: %w", err)
+		return err
 	}
-	s.RemoteWrite.apiKey = strings.TrimSpace(string(apiKey))
 
-	if len(s.RemoteWrite.apiKey) == 0 {
-		return errors.New("API key is empty")
-	}
+	s.applyAPIKeys(keys)
 	return nil
 }
 
+// setAPIKeyProvider sets the source of the API keys. The caller holds the
+// lock.
+func (s *Settings) setAPIKeyProvider(provider apikey.Provider) {
+	if notifier, ok := provider.(apikey.Notifier); ok {
+		// a rotation is applied as soon as the provider sees it
+		notifier.OnChange(s.applyAPIKeys)
+	}
+	s.apiKeyProvider = provider
+}
+
+// applyAPIKeys replaces the API keys with the keys read from their source,
+// without demoting a next key which was promoted.
+func (s *Settings) applyAPIKeys(keys apikey.Keys) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys = keys.Resolve(s.RemoteWrite.apiKey)
+	s.RemoteWrite.apiKey = keys.Active
+	s.RemoteWrite.nextAPIKey = keys.Next
+}
+
 func (s *Settings) setRemoteWriteURL() {
 	if s.Destination == "" {
 		s.Destination = "https://api.cloudzero.com/v1/container-metrics"
 	}
+	s.RemoteWrite.Host = s.remoteWriteURL(s.ClusterName, s.CloudAccountID, s.Region)
+
+	// the logical clusters are sent to the same destination, with their own
+	// cluster name, account and region
+	for i := range s.LogicalClusters {
+		c := &s.LogicalClusters[i]
+		c.CloudAccountID = cleanString(c.CloudAccountID)
+		if c.CloudAccountID == "" {
+			c.CloudAccountID = s.CloudAccountID
+		}
+		if c.Region == "" {
+			c.Region = s.Region
+		}
+		c.RemoteWriteHost = s.remoteWriteURL(c.ClusterName, c.CloudAccountID, c.Region)
+	}
+}
+
+// remoteWriteURL returns the URL of the destination for a cluster.
+func (s *Settings) remoteWriteURL(clusterName, cloudAccountID, region string) string {
 	baseURL, err := url.Parse(s.Destination)
 	if err != nil {
 		fmt.Println("Malformed URL: ", err.Error())
-		return
+		return ""
 	}
 	params := url.Values{}
-	params.Add("cluster_name", s.ClusterName)
-	params.Add("cloud_account_id", s.CloudAccountID)
-	params.Add("region", s.Region)
+	params.Add("cluster_name", clusterName)
+	params.Add("cloud_account_id", cloudAccountID)
+	params.Add("region", region)
 	baseURL.RawQuery = params.Encode()
 	url := baseURL.String()
 
 	if !isValidURL(url) {
 		log.Fatal().Str("url", url).Msg("URL format invalid")
 	}
-	s.RemoteWrite.Host = url
+	return url
 }
 
 func isValidURL(uri string) bool {
@@ -152,6 +277,16 @@ func (s *Settings) setPolicy() {
 func (s *Settings) setCompiledFilters() {
 	s.LabelMatches = s.compilePatterns(s.Filters.Labels.Patterns)
 	s.AnnotationMatches = s.compilePatterns(s.Filters.Annotations.Patterns)
+	s.InheritMatches = s.compilePatterns(s.Workloads.InheritPatterns)
+}
+
+func (s *Settings) setValueTransformer() error {
+	secret, err := filter.ReadSecret(s.Filters.HashSecretPath)
+	if err != nil {
+		return err
+	}
+	s.ValueTransformer, err = filter.NewTransformer(s.Filters.Transforms, secret)
+	return err
 }
 
 func (s *Settings) compilePatterns(patterns []string) []regexp.Regexp {
@@ -175,19 +310,6 @@ func (s *Settings) compilePatterns(patterns []string) []regexp.Regexp {
 	return compiledPatterns
 }
 
-func absFilePath(location string) (string, error) {
-	dir := filepath.Dir(filepath.Clean(location))
-	// validate path if not local directory
-	if dir == "" || strings.HasPrefix(dir, ".") {
-		wd, err := os.Getwd()
-		if err != nil {
-			return "", fmt.Errorf("failed to get working directory: %w", err)
-		}
-		location = filepath.Clean(filepath.Join(wd, location))
-	}
-	return location, nil
-}
-
 // Files is a custom flag type to handle multiple configuration files
 type Files []string
 
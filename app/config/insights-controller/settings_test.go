@@ -4,6 +4,7 @@
 package config
 
 import (
+	"context"
 	"os"
 	"strings"
 	"testing"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/apikey"
 )
 
 func TestNewSettings(t *testing.T) {
@@ -108,6 +111,57 @@ destination: "https://api.cloudzero.com/v1/container-metrics"
 	})
 }
 
+// notifyingProvider is a provider which sees the changes of the keys.
+type notifyingProvider struct {
+	keys     apikey.Keys
+	onChange func(apikey.Keys)
+}
+
+func (p *notifyingProvider) Keys(context.Context) (apikey.Keys, error) { return p.keys, nil }
+
+func (p *notifyingProvider) OnChange(fn func(apikey.Keys)) { p.onChange = fn }
+
+func (p *notifyingProvider) change(keys apikey.Keys) {
+	p.keys = keys
+	p.onChange(keys)
+}
+
+func TestSettings_APIKeyRotation(t *testing.T) {
+	newSettings := func(t *testing.T) (*Settings, *notifyingProvider) {
+		t.Helper()
+		provider := &notifyingProvider{keys: apikey.Keys{Active: "old-key", Next: "new-key"}}
+		settings := &Settings{}
+		settings.setAPIKeyProvider(provider)
+		require.NoError(t, settings.SetAPIKey())
+		return settings, provider
+	}
+
+	t.Run("a change is applied without reading the keys again", func(t *testing.T) {
+		settings, provider := newSettings(t)
+		provider.change(apikey.Keys{Active: "new-key", Next: "next-key"})
+		assert.Equal(t, "new-key", settings.GetAPIKey())
+		assert.Equal(t, "next-key", settings.GetNextAPIKey())
+	})
+
+	t.Run("a promoted key is kept while it is still the next key", func(t *testing.T) {
+		settings, provider := newSettings(t)
+		settings.PromoteAPIKey("new-key")
+
+		require.NoError(t, settings.SetAPIKey())
+		assert.Equal(t, "new-key", settings.GetAPIKey())
+		assert.Empty(t, settings.GetNextAPIKey())
+
+		provider.change(apikey.Keys{Active: "old-key", Next: "new-key"})
+		assert.Equal(t, "new-key", settings.GetAPIKey())
+
+		// the rotation is completed, then the key is replaced again
+		provider.change(apikey.Keys{Active: "new-key"})
+		assert.Equal(t, "new-key", settings.GetAPIKey())
+		provider.change(apikey.Keys{Active: "other-key"})
+		assert.Equal(t, "other-key", settings.GetAPIKey())
+	})
+}
+
 func TestCleanString(t *testing.T) {
 	tests := []struct {
 		name     string
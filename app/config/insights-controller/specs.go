@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+// Specs configures capturing the resource specification of pods and nodes:
+// the container requests and limits, QoS class and priority class of pods, and
+// the instance type, zone and capacity of nodes.
+//
+// The specification is sent as additional series, such as
+// `cloudzero_pod_resource_requests`, which serve as a fallback when
+// kube-state-metrics is not available.
+type Specs struct {
+	Enabled bool `yaml:"enabled" default:"false" env:"SPECS_ENABLED" env-description:"when enabled will send the resource requests, limits and capacity of pods and nodes"`
+}
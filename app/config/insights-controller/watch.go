@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "errors"
+
+// Watch configures tracking resources with shared informers, as an addition
+// to, or a replacement for, the admission webhook.
+type Watch struct {
+	Enabled    bool `yaml:"enabled" default:"false" env:"WATCH_ENABLED" env-description:"when enabled will watch resources with informers alongside the webhook"`
+	Standalone bool `yaml:"standalone" default:"false" env:"WATCH_STANDALONE" env-description:"when enabled will watch resources with informers, without serving the admission webhook"`
+}
+
+// Active returns true when resources should be watched with informers.
+func (w Watch) Active() bool {
+	return w.Enabled || w.Standalone
+}
+
+// Validate checks the watch mode against the mutation settings. The label
+// rules are applied by the mutating webhook, which is not served in
+// standalone mode.
+func (w Watch) Validate(mutation Mutation) error {
+	if w.Standalone && mutation.Enabled {
+		return errors.New("the mutating webhook cannot be enabled in standalone watch mode, which serves no admission webhook")
+	}
+	return nil
+}
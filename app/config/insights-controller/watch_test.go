@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch_Validate(t *testing.T) {
+	mutation := Mutation{Enabled: true}
+	assert.NoError(t, Watch{Enabled: true}.Validate(mutation))
+	assert.NoError(t, Watch{Standalone: true}.Validate(Mutation{}))
+
+	// the label rules would silently not be applied
+	assert.Error(t, Watch{Standalone: true}.Validate(mutation))
+}
@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "time"
+
+// Workloads configures resolving the workload which owns each pod, such as the
+// Deployment of a ReplicaSet or the CronJob of a Job.
+//
+// Pods can inherit the labels matching `InheritPatterns` from their workload
+// and namespace. When the same label is set more than once, the label of the
+// pod takes precedence over the label of the workload, which takes precedence
+// over the label of the namespace.
+type Workloads struct {
+	Enabled         bool          `yaml:"enabled" default:"false" env:"WORKLOADS_ENABLED" env-description:"when enabled will attach the workload owning each pod to its metrics"`
+	InheritPatterns []string      `yaml:"inherit_patterns" env:"WORKLOADS_INHERIT_PATTERNS" env-description:"list of label regular expressions pods inherit from their workload and namespace"`
+	CacheTTL        time.Duration `yaml:"cache_ttl" default:"5m" env:"WORKLOADS_CACHE_TTL" env-description:"how long owners looked up from the API server are cached"`
+}
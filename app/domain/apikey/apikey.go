@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package apikey provides the CloudZero API keys from a file, an environment
+// variable, a Kubernetes Secret or an exec credential plugin.
+//
+// A provider supplies an active key, and optionally the next key which
+// replaces it during a rotation. The clients send the active key, and retry a
+// request which was rejected as unauthorized once with the next key, so a
+// rotation does not fail requests until the keys are read again.
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sources of the API keys.
+const (
+	SourceFile   = "file"
+	SourceEnv    = "env"
+	SourceSecret = "secret"
+	SourceExec   = "exec"
+)
+
+const (
+	// DefaultEnv is used when the environment variable is not set.
+	DefaultEnv = "CLOUDZERO_API_KEY"
+	// DefaultSecretKey is used when the key of the Secret is not set.
+	DefaultSecretKey = "value"
+	// DefaultExecTimeout is used when the timeout of the plugin is not set.
+	DefaultExecTimeout = 10 * time.Second
+)
+
+// ErrEmpty is returned when the active key is empty.
+var ErrEmpty = errors.New("API key is empty")
+
+// Keys are the active API key, and the next key which replaces it during a
+// rotation. The next key is empty when no rotation is in progress.
+type Keys struct {
+	Active string
+	Next   string
+}
+
+// Resolve returns the keys to use once the keys were read again, given the
+// key in use. A next key which was promoted after it was accepted in place of
+// the active key stays active while the provider still lists it as the next
+// key, rather than being demoted until the rotation is completed.
+func (k Keys) Resolve(inUse string) Keys {
+	if inUse != "" && inUse == k.Next {
+		return Keys{Active: k.Next}
+	}
+	return k
+}
+
+// Provider supplies the API keys.
+type Provider interface {
+	// Keys returns the current keys.
+	Keys(ctx context.Context) (Keys, error)
+}
+
+// Notifier is implemented by the providers which see a change of the keys as
+// soon as it happens, rather than when the keys are read again.
+type Notifier interface {
+	// OnChange registers a function called with the keys after each change.
+	OnChange(fn func(Keys))
+}
+
+// Config configures the source of the API keys.
+type Config struct {
+	Source string `yaml:"source" default:"file" env:"API_KEY_SOURCE" env-description:"source of the API key, one of file, env, secret or exec"`
+	// NextPath is the file of the next key, when the source is a file.
+	NextPath string `yaml:"next_path" env:"API_KEY_NEXT_PATH" env-description:"path to the file of the next API key during a rotation"`
+	// Env and NextEnv are the environment variables of the keys, when the
+	// source is env.
+	Env     string       `yaml:"env" default:"CLOUDZERO_API_KEY" env:"API_KEY_ENV" env-description:"environment variable of the API key"`
+	NextEnv string       `yaml:"next_env" env:"API_KEY_NEXT_ENV" env-description:"environment variable of the next API key during a rotation"`
+	Secret  SecretConfig `yaml:"secret"`
+	Exec    ExecConfig   `yaml:"exec"`
+}
+
+// SecretConfig is the Kubernetes Secret the keys are read from.
+type SecretConfig struct {
+	Namespace  string `yaml:"namespace" env:"API_KEY_SECRET_NAMESPACE" env-description:"namespace of the API key secret"`
+	Name       string `yaml:"name" env:"API_KEY_SECRET_NAME" env-description:"name of the API key secret"`
+	Key        string `yaml:"key" default:"value" env:"API_KEY_SECRET_KEY" env-description:"key of the API key in the secret"`
+	NextKey    string `yaml:"next_key" env:"API_KEY_SECRET_NEXT_KEY" env-description:"key of the next API key in the secret"`
+	KubeConfig string `yaml:"kubeconfig" env:"API_KEY_SECRET_KUBECONFIG" env-description:"path to the kubeconfig, in-cluster when empty"`
+}
+
+// ExecConfig is the credential plugin the keys are read from. The plugin
+// prints either the key, or a JSON object with the `active` and `next` keys.
+type ExecConfig struct {
+	Command string        `yaml:"command" env:"API_KEY_EXEC_COMMAND" env-description:"command printing the API key"`
+	Args    []string      `yaml:"args"`
+	Timeout time.Duration `yaml:"timeout" default:"10s" env:"API_KEY_EXEC_TIMEOUT" env-description:"timeout of the API key command"`
+}
+
+// Validate checks that the source is known and configured.
+func (c Config) Validate() error {
+	switch c.Source {
+	case "", SourceFile, SourceEnv:
+		return nil
+	case SourceSecret:
+		if c.Secret.Namespace == "" || c.Secret.Name == "" {
+			return errors.New("the secret source requires a namespace and a name")
+		}
+		return nil
+	case SourceExec:
+		if c.Exec.Command == "" {
+			return errors.New("the exec source requires a command")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown API key source '%s', expected file, env, secret or exec", c.Source)
+	}
+}
+
+// NewProvider creates the provider of a source. The path is the file of the
+// active key, when the source is a file.
+func NewProvider(c Config, path string) (Provider, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Source {
+	case SourceEnv:
+		return NewEnvProvider(c.Env, c.NextEnv), nil
+	case SourceSecret:
+		return NewSecretProviderFromConfig(c.Secret)
+	case SourceExec:
+		return NewExecProvider(c.Exec), nil
+	default:
+		return NewFileProvider(path, c.NextPath), nil
+	}
+}
+
+// normalize checks the keys, and drops a next key which is already active.
+func normalize(keys Keys) (Keys, error) {
+	if keys.Active == "" {
+		return Keys{}, ErrEmpty
+	}
+	if keys.Next == keys.Active {
+		keys.Next = ""
+	}
+	return keys, nil
+}
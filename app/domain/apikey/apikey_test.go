@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package apikey_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/apikey"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  apikey.Config
+		wantErr bool
+	}{
+		{name: "default", config: apikey.Config{}},
+		{name: "file", config: apikey.Config{Source: apikey.SourceFile}},
+		{name: "env", config: apikey.Config{Source: apikey.SourceEnv}},
+		{
+			name:   "secret",
+			config: apikey.Config{Source: apikey.SourceSecret, Secret: apikey.SecretConfig{Namespace: "ns", Name: "api-key"}},
+		},
+		{name: "secret without a name", config: apikey.Config{Source: apikey.SourceSecret}, wantErr: true},
+		{name: "exec", config: apikey.Config{Source: apikey.SourceExec, Exec: apikey.ExecConfig{Command: "cat"}}},
+		{name: "exec without a command", config: apikey.Config{Source: apikey.SourceExec}, wantErr: true},
+		{name: "unknown", config: apikey.Config{Source: "vault"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestFileProvider_Keys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "value")
+	nextPath := filepath.Join(dir, "next")
+	require.NoError(t, os.WriteFile(path, []byte("old-key\n"), 0o600))
+
+	p := apikey.NewFileProvider(path, nextPath)
+	keys, err := p.Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, apikey.Keys{Active: "old-key"}, keys)
+
+	// the rotation starts
+	require.NoError(t, os.WriteFile(nextPath, []byte("new-key\n"), 0o600))
+	keys, err = p.Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, apikey.Keys{Active: "old-key", Next: "new-key"}, keys)
+
+	// the rotation ends
+	require.NoError(t, os.WriteFile(path, []byte("new-key"), 0o600))
+	keys, err = p.Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, apikey.Keys{Active: "new-key"}, keys)
+
+	_, err = apikey.NewFileProvider(filepath.Join(dir, "missing"), "").Keys(context.Background())
+	assert.ErrorContains(t, err, "not found")
+
+	require.NoError(t, os.WriteFile(path, []byte(" \n"), 0o600))
+	_, err = p.Keys(context.Background())
+	assert.ErrorIs(t, err, apikey.ErrEmpty)
+}
+
+func TestEnvProvider_Keys(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "old-key")
+	t.Setenv("TEST_NEXT_API_KEY", "new-key")
+
+	keys, err := apikey.NewEnvProvider("TEST_API_KEY", "TEST_NEXT_API_KEY").Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, apikey.Keys{Active: "old-key", Next: "new-key"}, keys)
+
+	keys, err = apikey.NewEnvProvider("TEST_API_KEY", "").Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, apikey.Keys{Active: "old-key"}, keys)
+
+	_, err = apikey.NewEnvProvider("TEST_UNSET_API_KEY", "").Keys(context.Background())
+	assert.ErrorIs(t, err, apikey.ErrEmpty)
+}
+
+func TestExecProvider_Keys(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    apikey.Keys
+		wantErr bool
+	}{
+		{name: "plain", script: "echo old-key", want: apikey.Keys{Active: "old-key"}},
+		{
+			name:   "json",
+			script: `echo '{"active": "old-key", "next": "new-key"}'`,
+			want:   apikey.Keys{Active: "old-key", Next: "new-key"},
+		},
+		{name: "invalid json", script: `echo '{"active":'`, wantErr: true},
+		{name: "failure", script: "echo denied >&2; exit 1", wantErr: true},
+		{name: "empty", script: "true", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := apikey.NewExecProvider(apikey.ExecConfig{Command: "sh", Args: []string{"-c", tt.script}})
+			keys, err := p.Keys(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys)
+		})
+	}
+}
+
+func TestSecretProvider_Keys(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cza", Name: "api-key"},
+		Data:       map[string][]byte{"value": []byte("old-key")},
+	}
+	client := fake.NewClientset(secret)
+
+	p := apikey.NewSecretProvider(client, apikey.SecretConfig{Namespace: "cza", Name: "api-key", NextKey: "next"})
+	t.Cleanup(p.Close)
+
+	keys, err := p.Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, apikey.Keys{Active: "old-key"}, keys)
+
+	// the rotation is seen through the watch, which is started in the
+	// background, so the update is repeated until it is seen
+	secret = secret.DeepCopy()
+	secret.Data["next"] = []byte("new-key")
+	assert.Eventually(t, func() bool {
+		_, err := client.CoreV1().Secrets("cza").Update(context.Background(), secret, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		keys, err := p.Keys(context.Background())
+		return err == nil && keys == apikey.Keys{Active: "old-key", Next: "new-key"}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSecretProvider_OnChange(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cza", Name: "api-key"},
+		Data:       map[string][]byte{"value": []byte("old-key")},
+	}
+	client := fake.NewClientset(secret)
+
+	p := apikey.NewSecretProvider(client, apikey.SecretConfig{Namespace: "cza", Name: "api-key", NextKey: "next"})
+	t.Cleanup(p.Close)
+	changes := make(chan apikey.Keys, 100)
+	p.OnChange(func(keys apikey.Keys) { changes <- keys })
+
+	_, err := p.Keys(context.Background())
+	require.NoError(t, err)
+
+	secret = secret.DeepCopy()
+	secret.Data["next"] = []byte("new-key")
+	assert.Eventually(t, func() bool {
+		_, err := client.CoreV1().Secrets("cza").Update(context.Background(), secret, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		select {
+		case keys := <-changes:
+			return keys == apikey.Keys{Active: "old-key", Next: "new-key"}
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestKeys_Resolve(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  apikey.Keys
+		inUse string
+		want  apikey.Keys
+	}{
+		{name: "active", keys: apikey.Keys{Active: "old-key", Next: "new-key"}, inUse: "old-key", want: apikey.Keys{Active: "old-key", Next: "new-key"}},
+		{name: "promoted", keys: apikey.Keys{Active: "old-key", Next: "new-key"}, inUse: "new-key", want: apikey.Keys{Active: "new-key"}},
+		{name: "rotated", keys: apikey.Keys{Active: "new-key"}, inUse: "new-key", want: apikey.Keys{Active: "new-key"}},
+		{name: "replaced", keys: apikey.Keys{Active: "other-key"}, inUse: "new-key", want: apikey.Keys{Active: "other-key"}},
+		{name: "first read", keys: apikey.Keys{Active: "old-key"}, want: apikey.Keys{Active: "old-key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.keys.Resolve(tt.inUse))
+		})
+	}
+}
+
+func TestSecretProvider_NotFound(t *testing.T) {
+	p := apikey.NewSecretProvider(fake.NewClientset(), apikey.SecretConfig{Namespace: "cza", Name: "api-key"})
+	t.Cleanup(p.Close)
+
+	_, err := p.Keys(context.Background())
+	assert.Error(t, err)
+}
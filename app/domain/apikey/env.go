@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package apikey
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// EnvProvider reads the keys from environment variables.
+type EnvProvider struct {
+	name     string
+	nextName string
+}
+
+// NewEnvProvider creates a provider reading the active key from an
+// environment variable, and the next key from another one when it is set.
+func NewEnvProvider(name, nextName string) *EnvProvider {
+	if name == "" {
+		name = DefaultEnv
+	}
+	return &EnvProvider{name: name, nextName: nextName}
+}
+
+// Keys implements Provider.
+func (p *EnvProvider) Keys(_ context.Context) (Keys, error) {
+	keys := Keys{Active: strings.TrimSpace(os.Getenv(p.name))}
+	if p.nextName != "" {
+		keys.Next = strings.TrimSpace(os.Getenv(p.nextName))
+	}
+	return normalize(keys)
+}
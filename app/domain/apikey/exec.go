@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ExecProvider runs a credential plugin, which prints either the key or a
+// JSON object such as:
+//
+//	{"active": "<key>", "next": "<next key>"}
+type ExecProvider struct {
+	config ExecConfig
+}
+
+// NewExecProvider creates a provider running a credential plugin.
+func NewExecProvider(c ExecConfig) *ExecProvider {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultExecTimeout
+	}
+	return &ExecProvider{config: c}
+}
+
+type execOutput struct {
+	Active string `json:"active"`
+	Next   string `json:"next"`
+}
+
+// Keys implements Provider.
+func (p *ExecProvider) Keys(ctx context.Context) (Keys, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.config.Command, p.config.Args...) //nolint:gosec // the command is configured by the operator
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Keys{}, fmt.Errorf("API key command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	if !bytes.HasPrefix(output, []byte("{")) {
+		return normalize(Keys{Active: string(output)})
+	}
+	var keys execOutput
+	if err := json.Unmarshal(output, &keys); err != nil {
+		return Keys{}, fmt.Errorf("failed to parse the output of the API key command: %w", err)
+	}
+	return normalize(Keys{Active: strings.TrimSpace(keys.Active), Next: strings.TrimSpace(keys.Next)})
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileProvider reads the keys from files, such as a mounted Secret.
+type FileProvider struct {
+	path     string
+	nextPath string
+}
+
+// NewFileProvider creates a provider reading the active key from a file, and
+// the next key from another file when the path is set. A missing next key
+// file means no rotation is in progress.
+func NewFileProvider(path, nextPath string) *FileProvider {
+	return &FileProvider{path: path, nextPath: nextPath}
+}
+
+// Keys implements Provider.
+func (p *FileProvider) Keys(_ context.Context) (Keys, error) {
+	location, err := filepath.Abs(p.path)
+	if err != nil {
+		return Keys{}, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if _, err = os.Stat(location); os.IsNotExist(err) {
+		return Keys{}, fmt.Errorf("API key file %s not found: %w", location, err)
+	}
+	active, err := os.ReadFile(location)
+	if err != nil {
+		return Keys{}, fmt.Errorf("failed to read API key: %w", err)
+	}
+
+	keys := Keys{Active: strings.TrimSpace(string(active))}
+	if p.nextPath != "" {
+		next, err := os.ReadFile(p.nextPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return Keys{}, fmt.Errorf("failed to read the next API key: %w", err)
+		default:
+			keys.Next = strings.TrimSpace(string(next))
+		}
+	}
+	return normalize(keys)
+}
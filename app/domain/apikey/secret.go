@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/k8s"
+)
+
+// secretWatchBackoff is how long a failed watch waits before it is retried.
+const secretWatchBackoff = 5 * time.Second
+
+// SecretProvider reads the keys from a Kubernetes Secret. The Secret is read
+// once and then watched, so a rotation is seen as soon as the Secret changes
+// rather than when the mounted file is refreshed.
+type SecretProvider struct {
+	client kubernetes.Interface
+	config SecretConfig
+
+	mu        sync.RWMutex
+	keys      Keys
+	loaded    bool
+	listeners []func(Keys)
+
+	once   sync.Once
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSecretProvider creates a provider reading the keys from a Secret.
+func NewSecretProvider(client kubernetes.Interface, c SecretConfig) *SecretProvider {
+	if c.Key == "" {
+		c.Key = DefaultSecretKey
+	}
+	return &SecretProvider{client: client, config: c, done: make(chan struct{})}
+}
+
+// NewSecretProviderFromConfig creates a provider reading the keys from a
+// Secret, with a client for the configured kubeconfig.
+func NewSecretProviderFromConfig(c SecretConfig) (*SecretProvider, error) {
+	client, err := k8s.NewClient(c.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the client of the API key secret: %w", err)
+	}
+	return NewSecretProvider(client, c), nil
+}
+
+// Keys implements Provider. The watch of the Secret is started on the first
+// call.
+func (p *SecretProvider) Keys(ctx context.Context) (Keys, error) {
+	p.mu.RLock()
+	keys, loaded := p.keys, p.loaded
+	p.mu.RUnlock()
+	if loaded {
+		return keys, nil
+	}
+
+	secret, err := p.client.CoreV1().Secrets(p.config.Namespace).Get(ctx, p.config.Name, metav1.GetOptions{})
+	if err != nil {
+		return Keys{}, fmt.Errorf("failed to get the API key secret: %w", err)
+	}
+	keys, err = p.update(secret)
+	if err != nil {
+		return Keys{}, err
+	}
+	p.once.Do(func() {
+		watchCtx, cancel := context.WithCancel(context.Background())
+		p.cancel = cancel
+		go p.watch(watchCtx, secret.ResourceVersion)
+	})
+	return keys, nil
+}
+
+// OnChange implements Notifier. The functions are called from the watch of the
+// Secret.
+func (p *SecretProvider) OnChange(fn func(Keys)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners = append(p.listeners, fn)
+}
+
+// Close stops the watch of the Secret.
+func (p *SecretProvider) Close() {
+	p.once.Do(func() { close(p.done) })
+	if p.cancel != nil {
+		p.cancel()
+		<-p.done
+	}
+}
+
+func (p *SecretProvider) watch(ctx context.Context, resourceVersion string) {
+	defer close(p.done)
+	secrets := p.client.CoreV1().Secrets(p.config.Namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", p.config.Name).String()
+	for {
+		w, err := secrets.Watch(ctx, metav1.ListOptions{FieldSelector: selector, ResourceVersion: resourceVersion})
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("name", p.config.Name).Msg("Failed to watch the API key secret")
+		} else {
+			resourceVersion = p.consume(ctx, w)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(secretWatchBackoff):
+		}
+	}
+}
+
+// consume applies the events of a watch until it ends, and returns the last
+// resource version seen.
+func (p *SecretProvider) consume(ctx context.Context, w watch.Interface) string {
+	defer w.Stop()
+	var resourceVersion string
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+			secret, isSecret := event.Object.(*corev1.Secret)
+			if !isSecret {
+				continue
+			}
+			resourceVersion = secret.ResourceVersion
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				keys, err := p.update(secret)
+				if err != nil {
+					log.Ctx(ctx).Warn().Err(err).Str("name", p.config.Name).Msg("Invalid API key secret")
+					continue
+				}
+				log.Ctx(ctx).Info().Str("name", p.config.Name).Msg("Read the API keys from the updated secret")
+				p.notify(keys)
+			case watch.Deleted:
+				// the last keys are kept, and the secret is read again
+				// once it is recreated
+				log.Ctx(ctx).Warn().Str("name", p.config.Name).Msg("The API key secret was deleted")
+			}
+		}
+	}
+}
+
+// notify passes the keys to the functions registered with OnChange.
+func (p *SecretProvider) notify(keys Keys) {
+	p.mu.RLock()
+	listeners := p.listeners
+	p.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(keys)
+	}
+}
+
+// update caches the keys of the Secret.
+func (p *SecretProvider) update(secret *corev1.Secret) (Keys, error) {
+	keys := Keys{Active: strings.TrimSpace(string(secret.Data[p.config.Key]))}
+	if p.config.NextKey != "" {
+		keys.Next = strings.TrimSpace(string(secret.Data[p.config.NextKey]))
+	}
+	keys, err := normalize(keys)
+	if err != nil {
+		return Keys{}, fmt.Errorf("the key %s of the secret %s/%s: %w", p.config.Key, p.config.Namespace, p.config.Name, err)
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.loaded = true
+	p.mu.Unlock()
+	return keys, nil
+}
@@ -251,10 +251,13 @@ func TestBackfiller_FakeK8s_Start(t *testing.T) {
 			defer ctlr.Finish()
 
 			mockStore := mocks.NewMockResourceStore(ctlr)
+			mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+			mockStore.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, types.ErrNotFound).AnyTimes()
+			mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
 			tc.expectations(mockStore)
 
 			clientset := fake.NewSimpleClientset(tc.setupObjects...)
-			s := backfiller.NewBackfiller(clientset, mockStore, settings)
+			s := backfiller.NewBackfiller(clientset, mockStore, &utils.Clock{}, settings)
 			s.Start(ctx)
 		})
 	}
@@ -275,7 +278,7 @@ func TestBackfiller_FakeK8s_Start(t *testing.T) {
 		k8sClient, err := k8s.NewClient(kubeconfig)
 		require.NoError(t, err)
 
-		s := backfiller.NewBackfiller(k8sClient, store, settings)
+		s := backfiller.NewBackfiller(k8sClient, store, &utils.Clock{}, settings)
 		s.Start(context.Background())
 
 		// Wait for Backfiller to process resources
@@ -3,16 +3,21 @@
 
 // Package backfiller provides functionality to backfill Kubernetes resources and store them in a specified storage.
 // This package is designed to gather data from various Kubernetes resources such as namespaces, pods, deployments,
-// statefulsets, daemonsets, jobs, cronjobs, and nodes. The gathered data is then formatted and stored using a
-// resource store interface. This business logic layer is essential for maintaining an up-to-date inventory of
-// Kubernetes resources, which can be used for monitoring, auditing, and analysis purposes.
+// statefulsets, daemonsets, jobs, cronjobs, nodes, replicasets, persistent volumes and their claims, services,
+// ingresses, and resource quotas. Custom resources, such as CRDs, are listed with the dynamic client. The gathered
+// data is then formatted and stored using a resource store interface.
+// This business logic layer is essential for maintaining an up-to-date inventory of Kubernetes resources, which can
+// be used for monitoring, auditing, and analysis purposes.
 //
 // The Backfiller struct is the main component of this package, which is initialized with a Kubernetes client,
-// resource store, and configuration settings. The Start method begins the scraping process, iterating through
-// all namespaces and collecting data from the specified resources based on the provided filters.
+// resource store, and configuration settings. The Reconcile method compares the live state of the cluster against
+// the store: records which are missing or out of date are written, and records of resources which no longer exist
+// are marked as deleted. The Start method runs a single reconciliation, while the Reconciler runs one periodically
+// to correct any drift, such as events missed by the webhook. The Walk method lists the same resources without
+// touching the store.
 //
-// The package also includes helper functions such as writeResources and writeNodes to handle the listing and
-// storing of resources in a paginated manner, ensuring efficient data retrieval and storage.
+// Every list request is paginated, rate limited, and retried with an exponential backoff, so a reconciliation does
+// not overload the API server and survives transient failures.
 //
 // This package is valuable for organizations that need to keep track of their Kubernetes resources and ensure
 // that their inventory is always up-to-date. It provides a robust and flexible solution for scraping and storing
@@ -21,222 +26,556 @@ package backfiller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
-	appsv1 "k8s.io/api/apps/v1"
-	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
 
 	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/scope"
+	"github.com/cloudzero/cloudzero-agent/app/domain/workload"
 	"github.com/cloudzero/cloudzero-agent/app/http/handler"
 	"github.com/cloudzero/cloudzero-agent/app/types"
 )
 
+// Defaults used when the k8s client settings are not set.
+const (
+	DefaultQPS          = 10
+	DefaultBurst        = 20
+	DefaultMaxRetries   = 5
+	DefaultRetryBackoff = time.Second
+)
+
+// Kinds of drift between the cluster and the store.
+const (
+	DriftMissing = "missing"
+	DriftChanged = "changed"
+	DriftDeleted = "deleted"
+)
+
+// -------------------- Prometheus Metrics --------------------
+var (
+	backfillStatsOnce sync.Once
+	// BackfillDriftRecords tracks the drift found by the last reconciliation.
+	BackfillDriftRecords = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "backfill_drift_records",
+			Help: "Number of records which differed from the cluster in the last reconciliation",
+		},
+		[]string{"resource_type", "drift"},
+	)
+
+	// BackfillDriftRecordsTotal counts the drift corrected by all reconciliations.
+	BackfillDriftRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backfill_drift_records_total",
+			Help: "Total number of records which differed from the cluster and were corrected",
+		},
+		[]string{"resource_type", "drift"},
+	)
+
+	// BackfillListFailures counts list requests which failed after every retry.
+	BackfillListFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backfill_list_failures_total",
+			Help: "Total number of list requests to the API server which failed after all retries",
+		},
+		[]string{"resource_type"},
+	)
+
+	// BackfillReconcileDuration measures the duration of reconciliations.
+	BackfillReconcileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "backfill_reconcile_duration_seconds",
+			Help:    "Histogram of the duration of reconciliations",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+		[]string{},
+	)
+)
+
+// namespacedResource describes how to list and detect an enabled namespaced resource type.
+type namespacedResource struct {
+	resourceType config.ResourceType
+	enabled      func(config.Resources) bool
+	list         func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error)
+}
+
 type Backfiller struct {
 	k8sClient kubernetes.Interface
 	settings  *config.Settings
 	store     types.ResourceStore
+	clock     types.TimeProvider
+	limiter   flowcontrol.RateLimiter
+	backoff   wait.Backoff
+	resolver  *workload.Resolver
+	dynamic   dynamic.Interface
+	scope     *scope.Scope
+}
+
+type Option func(s *Backfiller)
+
+// WithWorkloadResolver attaches the workload owning each pod to its record.
+func WithWorkloadResolver(resolver *workload.Resolver) Option {
+	return func(s *Backfiller) {
+		s.resolver = resolver
+	}
+}
+
+// WithDynamicClient lists the custom resources with the dynamic client. The
+// custom resources are skipped without it.
+func WithDynamicClient(client dynamic.Interface) Option {
+	return func(s *Backfiller) {
+		s.dynamic = client
+	}
+}
+
+// WithScope skips the objects out of scope. The namespaced resources of a
+// namespace out of scope are not listed.
+func WithScope(s *scope.Scope) Option {
+	return func(b *Backfiller) {
+		b.scope = s
+	}
 }
 
-func NewBackfiller(k8sClient kubernetes.Interface, store types.ResourceStore, settings *config.Settings) *Backfiller {
-	return &Backfiller{
+func NewBackfiller(k8sClient kubernetes.Interface, store types.ResourceStore, clock types.TimeProvider, settings *config.Settings, opts ...Option) *Backfiller {
+	backfillStatsOnce.Do(func() {
+		prometheus.MustRegister(
+			BackfillDriftRecords,
+			BackfillDriftRecordsTotal,
+			BackfillListFailures,
+			BackfillReconcileDuration,
+		)
+	})
+
+	qps, burst := settings.K8sClient.QPS, settings.K8sClient.Burst
+	if qps <= 0 {
+		qps = DefaultQPS
+	}
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	maxRetries, retryBackoff := settings.K8sClient.MaxRetries, settings.K8sClient.RetryBackoff
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	if retryBackoff <= 0 {
+		retryBackoff = DefaultRetryBackoff
+	}
+
+	s := &Backfiller{
 		k8sClient: k8sClient,
 		settings:  settings,
 		store:     store,
+		clock:     clock,
+		limiter:   flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+		backoff: wait.Backoff{
+			Duration: retryBackoff,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    maxRetries + 1, // the first attempt is a step
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
+// Start runs a single reconciliation of the existing resources.
 func (s *Backfiller) Start(ctx context.Context) {
-	var _continue string
-	allNamespaces := []corev1.Namespace{}
 	log.Info().
 		Time("current_time", time.Now().UTC()).
 		Msg("Starting backfill of existing resources")
 
-	// write all nodes in the cluster storage
-	s.writeNodes(ctx)
+	if err := s.Reconcile(ctx); err != nil {
+		log.Err(err).Msg("Backfill operation completed with errors")
+		return
+	}
+	log.Info().
+		Time("current_time", time.Now().UTC()).
+		Msg("Backfill operation completed")
+}
 
-	for {
-		// List all namespaces
-		namespaces, err := s.k8sClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
-			Limit:    s.settings.K8sClient.PaginationLimit,
-			Continue: _continue,
-		})
-		if err != nil {
-			log.Err(err).Msg("Error listing namespaces")
-			return
-		}
-		allNamespaces = append(allNamespaces, namespaces.Items...)
-
-		// For each namespace, gather all resources
-		for _, ns := range namespaces.Items {
-			log.Info().Str("namespace", ns.Name).Msg("Scraping data from namespace")
-			// write namespace record
-			nr := handler.FormatNamespaceData(&ns, s.settings)
-			if err := s.store.Create(ctx, &nr); err != nil {
-				log.Err(err).Msg("failed to write data to storage")
-			}
+// Reconcile compares the resources in the cluster against the store. Records
+// which are missing or differ are written, and records of resources which no
+// longer exist are marked as deleted. Records are only marked as deleted when
+// their resource type was listed successfully, so a failed list request never
+// causes false deletions. The records of resources which left the scope are
+// marked as deleted as well.
+func (s *Backfiller) Reconcile(ctx context.Context) error {
+	startedAt := s.clock.GetCurrentTime()
+	defer func(start time.Time) {
+		BackfillReconcileDuration.WithLabelValues().Observe(time.Since(start).Seconds())
+	}(time.Now())
 
-			// write all pods in the namespace storage
-			if s.settings.Filters.Labels.Resources.Pods || s.settings.Filters.Annotations.Resources.Pods { //nolint:dupl // code is similar, but not duplicated
-				writeResources(ctx, s.store, ns.Name, func(namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
-					return s.k8sClient.CoreV1().Pods(namespace).List(ctx, opts)
-				}, func(obj any, settings *config.Settings) (types.ResourceTags, error) {
-					data, ok := obj.(*corev1.Pod)
-					if !ok {
-						return types.ResourceTags{}, fmt.Errorf("type mismatch: wanted corev1.Pod, got %s", reflect.TypeOf(obj))
-					}
-					return handler.FormatPodData(data, settings), nil
-				}, s.settings)
+	state := newClusterState()
+	var errs []error
+	namespaces, err := s.walk(ctx, visitor{
+		object: func(ctx context.Context, resourceType config.ResourceType, item any) {
+			record, err := handler.FormatResourceData(item, s.settings)
+			if err != nil {
+				log.Err(err).Msg("Failed to format data")
+				return
 			}
-
-			// write all deployments in the namespace storage
-			if s.settings.Filters.Labels.Resources.Deployments || s.settings.Filters.Annotations.Resources.Deployments { //nolint:dupl // code is similar, but not duplicated
-				writeResources(ctx, s.store, ns.Name, func(namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
-					return s.k8sClient.AppsV1().Deployments(namespace).List(ctx, opts)
-				}, func(obj any, settings *config.Settings) (types.ResourceTags, error) {
-					data, ok := obj.(*appsv1.Deployment)
-					if !ok {
-						return types.ResourceTags{}, fmt.Errorf("type mismatch: wanted appsv1.Deployment, got %s", reflect.TypeOf(obj))
-					}
-					return handler.FormatDeploymentData(data, settings), nil
-				}, s.settings)
+			if s.scope.Skip(ctx, scope.SourceBackfill, config.ResourceTypeToMetricName[resourceType], item) {
+				state.skip(record)
+				return
 			}
+			s.resolver.Enrich(ctx, item, &record)
+			state.add(record)
+		},
+		listed:           state.markListed,
+		skippedNamespace: state.skipNamespace,
+	})
+	errs = append(errs, err)
 
-			// write all statefulsets in the namespace storage
-			if s.settings.Filters.Labels.Resources.StatefulSets || s.settings.Filters.Annotations.Resources.StatefulSets { //nolint:dupl // code is similar, but not duplicated
-				writeResources(ctx, s.store, ns.Name, func(namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
-					return s.k8sClient.AppsV1().StatefulSets(namespace).List(ctx, opts)
-				}, func(obj any, settings *config.Settings) (types.ResourceTags, error) {
-					data, ok := obj.(*appsv1.StatefulSet)
-					if !ok {
-						return types.ResourceTags{}, fmt.Errorf("type mismatch: wanted appsv1.StatefulSet, got %s", reflect.TypeOf(obj))
-					}
-					return handler.FormatStatefulsetData(data, settings), nil
-				}, s.settings)
-			}
+	// compare against every stored record, including the deleted ones
+	stored, err := s.store.FindAllBy(ctx, "1 = 1")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to read the stored records: %w", err))
+		return errors.Join(errs...)
+	}
+	drift := s.apply(ctx, state, stored, startedAt)
 
-			// write all daemonsets in the namespace storage
-			if s.settings.Filters.Labels.Resources.DaemonSets || s.settings.Filters.Annotations.Resources.DaemonSets { //nolint:dupl // code is similar, but not duplicated
-				writeResources(ctx, s.store, ns.Name, func(namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
-					return s.k8sClient.AppsV1().DaemonSets(namespace).List(ctx, opts)
-				}, func(obj any, settings *config.Settings) (types.ResourceTags, error) {
-					data, ok := obj.(*appsv1.DaemonSet)
-					if !ok {
-						return types.ResourceTags{}, fmt.Errorf("type mismatch: wanted appsv1.DaemonSet, got %s", reflect.TypeOf(obj))
-					}
-					return handler.FormatDaemonSetData(data, settings), nil
-				}, s.settings)
-			}
+	// report the drift of every enabled resource type, so it resets to zero
+	for resourceType := range state.listed {
+		name := config.ResourceTypeToMetricName[resourceType]
+		for _, kind := range []string{DriftMissing, DriftChanged, DriftDeleted} {
+			count := drift[resourceType][kind]
+			BackfillDriftRecords.WithLabelValues(name, kind).Set(float64(count))
+			BackfillDriftRecordsTotal.WithLabelValues(name, kind).Add(float64(count))
+		}
+	}
 
-			// write all jobs in the namespace storage
-			if s.settings.Filters.Labels.Resources.Jobs || s.settings.Filters.Annotations.Resources.Jobs { //nolint:dupl // code is similar, but not duplicated
-				writeResources(ctx, s.store, ns.Name, func(namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
-					return s.k8sClient.BatchV1().Jobs(namespace).List(ctx, opts)
-				}, func(obj any, settings *config.Settings) (types.ResourceTags, error) {
-					data, ok := obj.(*batchv1.Job)
-					if !ok {
-						return types.ResourceTags{}, fmt.Errorf("type mismatch: wanted batchv1.Job, got %s", reflect.TypeOf(obj))
-					}
-					return handler.FormatJobData(data, settings), nil
-				}, s.settings)
-			}
+	log.Info().
+		Int("namespaces_count", namespaces).
+		Int("resources_count", len(state.records)).
+		Interface("drift", drift).
+		Msg("Reconciled the stored resources")
 
-			// write all cronjobs in the namespace storage
-			if s.settings.Filters.Labels.Resources.CronJobs || s.settings.Filters.Annotations.Resources.CronJobs { //nolint:dupl // code is similar, but not duplicated
-				writeResources(ctx, s.store, ns.Name, func(namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
-					return s.k8sClient.BatchV1().CronJobs(namespace).List(ctx, opts)
-				}, func(obj any, settings *config.Settings) (types.ResourceTags, error) {
-					data, ok := obj.(*batchv1.CronJob)
-					if !ok {
-						return types.ResourceTags{}, fmt.Errorf("type mismatch: wanted batchv1.CronJob, got %s", reflect.TypeOf(obj))
-					}
-					return handler.FormatCronJobData(data, settings), nil
-				}, s.settings)
+	return errors.Join(errs...)
+}
+
+// Walk lists the enabled resources of the cluster through the same paginated
+// and rate limited requests as Reconcile, and calls fn with every object in
+// scope. The store is neither read nor written.
+func (s *Backfiller) Walk(ctx context.Context, fn func(item any)) error {
+	_, err := s.walk(ctx, visitor{
+		object: func(ctx context.Context, resourceType config.ResourceType, item any) {
+			if s.scope.Skip(ctx, scope.SourceBackfill, config.ResourceTypeToMetricName[resourceType], item) {
+				return
 			}
+			fn(item)
+		},
+	})
+	return err
+}
+
+// visitor receives the objects listed by a walk. listed, when set, is called
+// once every page of a resource type was listed in a namespace.
+// skippedNamespace, when set, is called with every namespace out of scope.
+type visitor struct {
+	object           func(ctx context.Context, resourceType config.ResourceType, item any)
+	listed           func(resourceType config.ResourceType, namespace string)
+	skippedNamespace func(namespace string)
+}
+
+// walk lists every enabled resource type, and returns the number of listed
+// namespaces.
+func (s *Backfiller) walk(ctx context.Context, v visitor) (int, error) {
+	var errs []error
 
+	// nodes and persistent volumes are cluster-scoped
+	if s.settings.Filters.Labels.Resources.Nodes || s.settings.Filters.Annotations.Resources.Nodes {
+		err := s.collect(ctx, v, config.Node, "", func(ctx context.Context, _ string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.CoreV1().Nodes().List(ctx, opts)
+		})
+		errs = append(errs, err)
+	}
+	if s.settings.Filters.Labels.Resources.PersistentVolumes || s.settings.Filters.Annotations.Resources.PersistentVolumes {
+		err := s.collect(ctx, v, config.PersistentVolume, "", func(ctx context.Context, _ string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.CoreV1().PersistentVolumes().List(ctx, opts)
+		})
+		errs = append(errs, err)
+	}
+
+	// namespaces are always written, and every namespaced resource is listed per namespace
+	var namespaces []corev1.Namespace
+	err := s.collect(ctx, v, config.Namespace, "", func(ctx context.Context, _ string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+		list, err := s.k8sClient.CoreV1().Namespaces().List(ctx, opts)
+		if err == nil {
+			namespaces = append(namespaces, list.Items...)
+		}
+		return list, err
+	})
+	errs = append(errs, err)
+
+	for _, ns := range namespaces {
+		if reason := s.scope.NamespaceReason(ns.Name, ns.Labels); reason != "" {
+			log.Debug().Str("namespace", ns.Name).Str("reason", reason).Msg("Skipping namespace out of scope")
+			if v.skippedNamespace != nil {
+				v.skippedNamespace(ns.Name)
+			}
+			continue
 		}
-		if namespaces.GetContinue() == "" {
-			log.Info().
-				Time("current_time", time.Now().UTC()).
-				Int("namespaces_count", len(allNamespaces)).
-				Msg("Backfill operation completed")
-			break
+		log.Debug().Str("namespace", ns.Name).Msg("Scraping data from namespace")
+		for _, r := range s.namespacedResources() {
+			if !r.enabled(s.settings.Filters.Labels.Resources) && !r.enabled(s.settings.Filters.Annotations.Resources) {
+				continue
+			}
+			errs = append(errs, s.collect(ctx, v, r.resourceType, ns.Name, r.list))
+		}
+	}
+
+	// custom resources are listed across every namespace at once, as their
+	// scope is not known
+	if s.dynamic != nil && (s.settings.Filters.Labels.Enabled || s.settings.Filters.Annotations.Enabled) {
+		for _, c := range s.settings.CustomResources {
+			gvr := schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Plural()}
+			err := s.collect(ctx, v, c.ResourceType(), allNamespaces, func(ctx context.Context, _ string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+				return s.dynamic.Resource(gvr).List(ctx, opts)
+			})
+			errs = append(errs, err)
 		}
-		_continue = namespaces.GetContinue()
+	}
+
+	return len(namespaces), errors.Join(errs...)
+}
+
+func (s *Backfiller) namespacedResources() []namespacedResource {
+	return []namespacedResource{
+		{config.Pod, func(r config.Resources) bool { return r.Pods }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.CoreV1().Pods(namespace).List(ctx, opts)
+		}},
+		{config.Deployment, func(r config.Resources) bool { return r.Deployments }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.AppsV1().Deployments(namespace).List(ctx, opts)
+		}},
+		{config.StatefulSet, func(r config.Resources) bool { return r.StatefulSets }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.AppsV1().StatefulSets(namespace).List(ctx, opts)
+		}},
+		{config.DaemonSet, func(r config.Resources) bool { return r.DaemonSets }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.AppsV1().DaemonSets(namespace).List(ctx, opts)
+		}},
+		{config.Job, func(r config.Resources) bool { return r.Jobs }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.BatchV1().Jobs(namespace).List(ctx, opts)
+		}},
+		{config.CronJob, func(r config.Resources) bool { return r.CronJobs }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.BatchV1().CronJobs(namespace).List(ctx, opts)
+		}},
+		{config.ReplicaSet, func(r config.Resources) bool { return r.ReplicaSets }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.AppsV1().ReplicaSets(namespace).List(ctx, opts)
+		}},
+		{config.PersistentVolumeClaim, func(r config.Resources) bool { return r.PersistentVolumeClaims }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
+		}},
+		{config.Service, func(r config.Resources) bool { return r.Services }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.CoreV1().Services(namespace).List(ctx, opts)
+		}},
+		{config.Ingress, func(r config.Resources) bool { return r.Ingresses }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.NetworkingV1().Ingresses(namespace).List(ctx, opts)
+		}},
+		{config.ResourceQuota, func(r config.Resources) bool { return r.ResourceQuotas }, func(ctx context.Context, namespace string, opts metav1.ListOptions) (metav1.ListInterface, error) {
+			return s.k8sClient.CoreV1().ResourceQuotas(namespace).List(ctx, opts)
+		}},
 	}
 }
 
-func writeResources[T metav1.ListInterface](
+// collect lists every page of a resource type in a namespace, and passes the
+// listed objects to the visitor. The namespace is only reported as listed when
+// every page was retrieved.
+func (s *Backfiller) collect(
 	ctx context.Context,
-	store types.ResourceStore,
+	v visitor,
+	resourceType config.ResourceType,
 	namespace string,
-	listFunc func(string, metav1.ListOptions) (T, error),
-	formatFunc func(any, *config.Settings) (types.ResourceTags, error),
-	settings *config.Settings,
-) {
+	listFunc func(context.Context, string, metav1.ListOptions) (metav1.ListInterface, error),
+) error {
 	var _continue string
 	for {
-		resources, err := listFunc(namespace, metav1.ListOptions{
-			Limit:    settings.K8sClient.PaginationLimit,
-			Continue: _continue,
+		resources, err := s.list(ctx, func() (metav1.ListInterface, error) {
+			return listFunc(ctx, namespace, metav1.ListOptions{
+				Limit:    s.settings.K8sClient.PaginationLimit,
+				Continue: _continue,
+			})
 		})
 		if err != nil {
-			log.Err(err).Str("namespace", namespace).Msg("Error listing resources")
-			break
+			BackfillListFailures.WithLabelValues(config.ResourceTypeToMetricName[resourceType]).Inc()
+			return fmt.Errorf("failed to list the %s resources in namespace '%s': %w", config.ResourceTypeToMetricName[resourceType], namespace, err)
 		}
 
 		items := reflect.ValueOf(resources).Elem().FieldByName("Items")
 		for i := range items.Len() {
-			resource := items.Index(i).Addr().Interface()
-			record, err := formatFunc(resource, settings)
-			if err != nil {
-				log.Err(err).Msg("Failed to format data")
-				continue
-			}
-			if err = store.Create(ctx, &record); err != nil {
-				log.Err(err).Msg("Failed to write data to storage")
-			}
+			v.object(ctx, resourceType, items.Index(i).Addr().Interface())
 		}
 
 		if resources.GetContinue() == "" {
-			return
+			if v.listed != nil {
+				v.listed(resourceType, namespace)
+			}
+			return nil
 		}
 		_continue = resources.GetContinue()
 	}
 }
 
-func (s *Backfiller) writeNodes(ctx context.Context) {
-	// if nodes are not enabled, skip the work
-	if !s.settings.Filters.Labels.Resources.Nodes && !s.settings.Filters.Annotations.Resources.Nodes {
-		return
+// list runs a single list request, waiting for the rate limiter and retrying
+// failures with an exponential backoff.
+func (s *Backfiller) list(ctx context.Context, fn func() (metav1.ListInterface, error)) (metav1.ListInterface, error) {
+	var (
+		res     metav1.ListInterface
+		lastErr error
+	)
+	err := wait.ExponentialBackoffWithContext(ctx, s.backoff, func(ctx context.Context) (bool, error) {
+		if err := s.limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+		res, lastErr = fn()
+		if lastErr != nil {
+			log.Warn().Err(lastErr).Msg("List request failed, retrying")
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && lastErr != nil {
+		return nil, lastErr
 	}
+	return res, err
+}
 
-	log.Info().Msg("Writing nodes to storage")
-	var _continue string
-	for {
-		nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{
-			Limit:    s.settings.K8sClient.PaginationLimit,
-			Continue: _continue,
-		})
-		if err != nil {
-			log.Printf("Error listing nodes: %v", err)
-			continue
+// apply writes the difference between the cluster state and the stored
+// records, and returns the drift counts per resource type.
+func (s *Backfiller) apply(ctx context.Context, state *clusterState, stored []*types.ResourceTags, startedAt time.Time) map[config.ResourceType]map[string]int {
+	drift := map[config.ResourceType]map[string]int{}
+	count := func(resourceType config.ResourceType, kind string) {
+		if drift[resourceType] == nil {
+			drift[resourceType] = map[string]int{}
 		}
-		for _, node := range nodes.Items {
-			record := handler.FormatNodeData(&node, s.settings)
-			if err := s.store.Create(ctx, &record); err != nil {
-				log.Err(err).Msg("failed to write node data to storage")
+		drift[resourceType][kind]++
+	}
+
+	existing := make(map[string]*types.ResourceTags, len(stored))
+	for _, record := range stored {
+		existing[recordKey(record)] = record
+	}
+
+	for key, record := range state.records {
+		found, ok := existing[key]
+		switch {
+		case !ok:
+			count(record.Type, DriftMissing)
+		case found.DeletedAt != nil:
+			// the deletion was recorded while the resources were listed
+			if found.DeletedAt.After(startedAt) {
+				continue
 			}
+			// the resource was created again after it was deleted
+			count(record.Type, DriftMissing)
+		case !handler.SameTags(found, &record):
+			count(record.Type, DriftChanged)
+		default:
+			continue
 		}
-		if nodes.Continue == "" {
-			break
+		handler.WriteDataToStorage(ctx, s.store, s.clock, record)
+	}
+
+	for key, found := range existing {
+		if _, ok := state.records[key]; ok || found.DeletedAt != nil {
+			continue
+		}
+		// skip records written while the resources were listed, as their
+		// resource may not have been listed yet
+		if found.RecordUpdated.After(startedAt) {
+			continue
+		}
+		// the resource left the scope since its record was written
+		if state.outOfScope(key, found.Namespace) {
+			scope.SkippedObjects.WithLabelValues(scope.SourceBackfill, config.ResourceTypeToMetricName[found.Type], scope.ReasonLeftScope).Inc()
+			handler.WriteDeletionToStorage(ctx, s.store, s.clock, *found)
+			continue
+		}
+		// only trust complete listings
+		if !state.isListed(found.Type, found.Namespace) {
+			continue
 		}
-		_continue = nodes.Continue
+		count(found.Type, DriftDeleted)
+		handler.WriteDeletionToStorage(ctx, s.store, s.clock, *found)
+	}
+
+	return drift
+}
+
+func recordKey(record *types.ResourceTags) string {
+	namespace := ""
+	if record.Namespace != nil {
+		namespace = *record.Namespace
+	}
+	return fmt.Sprintf("%d/%s/%s", record.Type, namespace, record.Name)
+}
+
+// clusterState holds the resources listed from the cluster.
+type clusterState struct {
+	records           map[string]types.ResourceTags
+	skipped           map[string]bool
+	skippedNamespaces map[string]bool
+	listed            map[config.ResourceType]map[string]bool
+}
+
+func newClusterState() *clusterState {
+	return &clusterState{
+		records:           map[string]types.ResourceTags{},
+		skipped:           map[string]bool{},
+		skippedNamespaces: map[string]bool{},
+		listed:            map[config.ResourceType]map[string]bool{},
+	}
+}
+
+func (c *clusterState) add(record types.ResourceTags) {
+	c.records[recordKey(&record)] = record
+}
+
+// skip marks a listed resource out of scope, so its stored record is closed.
+func (c *clusterState) skip(record types.ResourceTags) {
+	c.skipped[recordKey(&record)] = true
+}
+
+// skipNamespace marks a namespace out of scope, so the stored records of its
+// resources are closed.
+func (c *clusterState) skipNamespace(namespace string) {
+	c.skippedNamespaces[namespace] = true
+}
+
+// outOfScope returns true when a stored record belongs to a listed resource
+// out of scope, or to a namespace out of scope.
+func (c *clusterState) outOfScope(key string, namespace *string) bool {
+	return c.skipped[key] || (namespace != nil && c.skippedNamespaces[*namespace])
+}
+
+// allNamespaces marks a resource type which was listed across every namespace.
+const allNamespaces = "*"
+
+func (c *clusterState) markListed(resourceType config.ResourceType, namespace string) {
+	if c.listed[resourceType] == nil {
+		c.listed[resourceType] = map[string]bool{}
+	}
+	c.listed[resourceType][namespace] = true
+}
+
+func (c *clusterState) isListed(resourceType config.ResourceType, namespace *string) bool {
+	ns := ""
+	if namespace != nil {
+		ns = *namespace
 	}
+	return c.listed[resourceType][ns] || c.listed[resourceType][allNamespaces]
 }
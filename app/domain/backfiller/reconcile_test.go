@@ -0,0 +1,332 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package backfiller_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/backfiller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/scope"
+	"github.com/cloudzero/cloudzero-agent/app/http/handler"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/storage/sqlite"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func getReconcileSettings() *config.Settings {
+	return &config.Settings{
+		Filters: config.Filters{
+			Labels: config.Labels{
+				Enabled: true,
+				Resources: config.Resources{
+					Pods:       true,
+					Namespaces: true,
+				},
+				Patterns: []string{".*"},
+			},
+		},
+		LabelMatches: []regexp.Regexp{*regexp.MustCompile(".*")},
+		K8sClient: config.K8sClient{
+			QPS:          1000,
+			Burst:        1000,
+			MaxRetries:   2,
+			RetryBackoff: time.Millisecond,
+		},
+	}
+}
+
+// newStore creates a store which is private to the test.
+func newStore(t *testing.T, clock types.TimeProvider) types.ResourceStore {
+	t.Helper()
+	db, err := sqlite.NewSQLiteDriver(sqlite.InMemoryDSN)
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	store, err := repo.NewResourceRepository(clock, db)
+	require.NoError(t, err)
+	return store
+}
+
+func newPod(name string, labels map[string]string) *apiv1.Pod {
+	return &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels}}
+}
+
+func findPod(t *testing.T, store types.ResourceStore, name string) *types.ResourceTags {
+	t.Helper()
+	record, err := store.FindFirstBy(context.Background(), "type = ? AND name = ? AND namespace = ?", config.Pod, name, "default")
+	require.NoError(t, err)
+	return record
+}
+
+// seedStore writes the pods to the store as they were before the reconciliation.
+func seedStore(t *testing.T, store types.ResourceStore, settings *config.Settings, pods ...*apiv1.Pod) {
+	t.Helper()
+	for _, pod := range pods {
+		record := handler.FormatPodData(pod, settings)
+		require.NoError(t, store.Create(context.Background(), &record))
+	}
+}
+
+func TestBackfiller_Reconcile(t *testing.T) {
+	ctx := context.Background()
+	settings := getReconcileSettings()
+	clock := mocks.NewMockClock(time.Now().Add(-time.Hour))
+	store := newStore(t, clock)
+
+	seedStore(t, store, settings,
+		newPod("unchanged", map[string]string{"team": "a"}),
+		newPod("changed", map[string]string{"team": "a"}),
+		newPod("gone", map[string]string{"team": "a"}),
+	)
+	clock.SetCurrentTime(time.Now())
+
+	client := fake.NewClientset(
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		newPod("unchanged", map[string]string{"team": "a"}),
+		newPod("changed", map[string]string{"team": "b"}),
+		newPod("missing", map[string]string{"team": "a"}),
+	)
+
+	require.NoError(t, backfiller.NewBackfiller(client, store, clock, settings).Reconcile(ctx))
+
+	unchanged := findPod(t, store, "unchanged")
+	assert.True(t, unchanged.RecordUpdated.Equal(unchanged.RecordCreated))
+
+	changed := findPod(t, store, "changed")
+	assert.Equal(t, "b", (*changed.Labels)["team"])
+	assert.Nil(t, changed.DeletedAt)
+
+	assert.NotNil(t, findPod(t, store, "missing"))
+	assert.NotNil(t, findPod(t, store, "gone").DeletedAt)
+
+	assert.InDelta(t, 1, testutil.ToFloat64(backfiller.BackfillDriftRecords.WithLabelValues("pod", backfiller.DriftMissing)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(backfiller.BackfillDriftRecords.WithLabelValues("pod", backfiller.DriftChanged)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(backfiller.BackfillDriftRecords.WithLabelValues("pod", backfiller.DriftDeleted)), 0)
+
+	// a second reconciliation finds no drift
+	clock.AdvanceTime(time.Minute)
+	require.NoError(t, backfiller.NewBackfiller(client, store, clock, settings).Reconcile(ctx))
+	for _, drift := range []string{backfiller.DriftMissing, backfiller.DriftChanged, backfiller.DriftDeleted} {
+		assert.InDelta(t, 0, testutil.ToFloat64(backfiller.BackfillDriftRecords.WithLabelValues("pod", drift)), 0)
+	}
+}
+
+func TestBackfiller_Reconcile_Retry(t *testing.T) {
+	ctx := context.Background()
+	settings := getReconcileSettings()
+	clock := mocks.NewMockClock(time.Now())
+	store := newStore(t, clock)
+
+	client := fake.NewClientset(
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		newPod("pod", nil),
+	)
+
+	// the first pod list fails, the retry succeeds
+	calls := 0
+	client.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls == 1 {
+			return true, nil, errors.New("transient")
+		}
+		return false, nil, nil
+	})
+
+	require.NoError(t, backfiller.NewBackfiller(client, store, clock, settings).Reconcile(ctx))
+	assert.Equal(t, 2, calls)
+	assert.NotNil(t, findPod(t, store, "pod"))
+}
+
+func TestBackfiller_Reconcile_ListFailure(t *testing.T) {
+	ctx := context.Background()
+	settings := getReconcileSettings()
+	settings.Filters.Labels.Resources.Nodes = true
+	clock := mocks.NewMockClock(time.Now().Add(-time.Hour))
+	store := newStore(t, clock)
+
+	seedStore(t, store, settings, newPod("pod", nil))
+	clock.SetCurrentTime(time.Now())
+
+	client := fake.NewClientset(
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	)
+	for _, resource := range []string{"pods", "nodes"} {
+		client.PrependReactor("list", resource, func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("unavailable")
+		})
+	}
+
+	// the failures are returned once the retries are exhausted
+	done := make(chan error)
+	go func() {
+		done <- backfiller.NewBackfiller(client, store, clock, settings).Reconcile(ctx)
+	}()
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reconciliation did not finish")
+	}
+
+	// the pod was not listed, so it is not marked deleted
+	assert.Nil(t, findPod(t, store, "pod").DeletedAt)
+}
+
+func TestBackfiller_Reconcile_AdditionalResources(t *testing.T) {
+	ctx := context.Background()
+	settings := getReconcileSettings()
+	settings.Filters.Labels.Resources.PersistentVolumes = true
+	settings.Filters.Labels.Resources.Services = true
+	clock := mocks.NewMockClock(time.Now())
+	store := newStore(t, clock)
+
+	client := fake.NewClientset(
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&apiv1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1234"}},
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       apiv1.ServiceSpec{Type: apiv1.ServiceTypeLoadBalancer},
+		},
+	)
+
+	require.NoError(t, backfiller.NewBackfiller(client, store, clock, settings).Reconcile(ctx))
+
+	volume, err := store.FindFirstBy(ctx, "type = ? AND name = ?", config.PersistentVolume, "pvc-1234")
+	require.NoError(t, err)
+	assert.Nil(t, volume.Namespace)
+
+	service, err := store.FindFirstBy(ctx, "type = ? AND name = ? AND namespace = ?", config.Service, "web", "default")
+	require.NoError(t, err)
+	assert.Equal(t, "LoadBalancer", (*service.MetricLabels)["service_type"])
+}
+
+func TestBackfiller_Reconcile_CustomResources(t *testing.T) {
+	ctx := context.Background()
+	settings := getReconcileSettings()
+	rollout := config.CustomResource{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}
+	settings.CustomResources = []config.CustomResource{rollout}
+	clock := mocks.NewMockClock(time.Now().Add(-time.Hour))
+	store := newStore(t, clock)
+
+	newRollout := func(name string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetAPIVersion("argoproj.io/v1alpha1")
+		o.SetKind("Rollout")
+		o.SetName(name)
+		o.SetNamespace("default")
+		o.SetLabels(map[string]string{"team": "a"})
+		return o
+	}
+
+	// a rollout which no longer exists
+	gone := handler.FormatCustomResourceData(newRollout("gone"), rollout, settings)
+	require.NoError(t, store.Create(ctx, &gone))
+	clock.SetCurrentTime(time.Now())
+
+	client := fake.NewClientset(&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}: "RolloutList"},
+		newRollout("web"),
+	)
+
+	require.NoError(t, backfiller.NewBackfiller(client, store, clock, settings, backfiller.WithDynamicClient(dynamicClient)).Reconcile(ctx))
+
+	web, err := store.FindFirstBy(ctx, "type = ? AND name = ? AND namespace = ?", rollout.ResourceType(), "web", "default")
+	require.NoError(t, err)
+	assert.Equal(t, "a", (*web.Labels)["team"])
+	assert.Nil(t, web.DeletedAt)
+
+	found, err := store.FindFirstBy(ctx, "type = ? AND name = ? AND namespace = ?", rollout.ResourceType(), "gone", "default")
+	require.NoError(t, err)
+	assert.NotNil(t, found.DeletedAt)
+}
+
+func TestBackfiller_Reconcile_Scope(t *testing.T) {
+	ctx := context.Background()
+	settings := getReconcileSettings()
+	clock := mocks.NewMockClock(time.Now().Add(-time.Hour))
+	store := newStore(t, clock)
+
+	// the stored records of the pods which left the scope are closed
+	seedStore(t, store, settings,
+		newPod("ephemeral", map[string]string{"ephemeral": "true"}),
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: "kube-system"}},
+	)
+	clock.SetCurrentTime(time.Now())
+	leftScope := scope.SkippedObjects.WithLabelValues(scope.SourceBackfill, "pod", scope.ReasonLeftScope)
+	before := testutil.ToFloat64(leftScope)
+
+	client := fake.NewClientset(
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		newPod("web", nil),
+		newPod("ephemeral", map[string]string{"ephemeral": "true"}),
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}},
+	)
+	objectScope, err := scope.New(config.Scope{
+		ExcludeNamespaces:     []string{"kube-system"},
+		ExcludeObjectSelector: "ephemeral",
+	}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, backfiller.NewBackfiller(client, store, clock, settings, backfiller.WithScope(objectScope)).Reconcile(ctx))
+
+	assert.NotNil(t, findPod(t, store, "web"))
+	assert.NotNil(t, findPod(t, store, "ephemeral").DeletedAt)
+	proxy, err := store.FindFirstBy(ctx, "type = ? AND name = ?", config.Pod, "kube-proxy")
+	require.NoError(t, err)
+	assert.NotNil(t, proxy.DeletedAt)
+	assert.InDelta(t, before+2, testutil.ToFloat64(leftScope), 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(backfiller.BackfillDriftRecords.WithLabelValues("pod", backfiller.DriftDeleted)), 0)
+
+	_, err = store.FindFirstBy(ctx, "name = ?", "coredns")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+	_, err = store.FindFirstBy(ctx, "type = ? AND name = ?", config.Namespace, "kube-system")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestBackfiller_Walk(t *testing.T) {
+	client := fake.NewClientset(
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		newPod("web", nil),
+		newPod("ephemeral", map[string]string{"ephemeral": "true"}),
+		&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"}},
+	)
+	objectScope, err := scope.New(config.Scope{
+		ExcludeNamespaces:     []string{"kube-system"},
+		ExcludeObjectSelector: "ephemeral",
+	}, nil)
+	require.NoError(t, err)
+
+	// the objects in scope are listed without a store
+	names := []string{}
+	clock := mocks.NewMockClock(time.Now())
+	err = backfiller.NewBackfiller(client, nil, clock, getReconcileSettings(), backfiller.WithScope(objectScope)).Walk(context.Background(), func(item any) {
+		names = append(names, item.(metav1.Object).GetName())
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"default", "web"}, names)
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package backfiller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// Reconciler periodically reconciles the store against the cluster.
+type Reconciler struct {
+	backfiller  *Backfiller
+	interval    time.Duration
+	running     bool
+	originalCtx context.Context
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	done        chan struct{}
+}
+
+func NewReconciler(ctx context.Context, backfiller *Backfiller, interval time.Duration) types.Runnable {
+	newCtx, cancel := context.WithCancel(ctx)
+	return &Reconciler{
+		backfiller:  backfiller,
+		interval:    interval,
+		originalCtx: ctx,
+		ctx:         newCtx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+func (r *Reconciler) Run() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return nil
+	}
+
+	ticker := time.NewTicker(r.interval)
+	go func() {
+		defer ticker.Stop()
+		defer close(r.done)
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Info().
+					Interface("panic", rec).
+					Msg("Recovered from panic in reconciliation")
+			}
+		}()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.backfiller.Reconcile(r.ctx); err != nil {
+					log.Err(err).Msg("Failed to reconcile the stored resources")
+				}
+			}
+		}
+	}()
+	r.running = true
+	return nil
+}
+
+func (r *Reconciler) Shutdown() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.running {
+		return nil
+	}
+	r.cancel()
+	<-r.done
+	r.reset()
+	return nil
+}
+
+func (r *Reconciler) reset() {
+	r.running = false
+	ctx, cancel := context.WithCancel(r.originalCtx)
+	r.ctx = ctx
+	r.cancel = cancel
+	r.done = make(chan struct{})
+}
+
+func (r *Reconciler) IsRunning() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cluster assigns the tracked resources to the logical clusters which
+// share the host cluster, such as vclusters or Capsule tenants, so the records
+// of every logical cluster are sent to its own remote write URL.
+//
+// A namespaced resource belongs to the logical cluster of its namespace, which
+// is the first logical cluster matching the namespace by name prefix or label
+// selector. The resources of the other namespaces, and the cluster-scoped
+// resources, belong to the host cluster. The cluster is resolved when a record
+// is written, and stored with it.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// NamespaceLabeler looks up the labels of a namespace.
+type NamespaceLabeler interface {
+	NamespaceLabels(ctx context.Context, namespace string) map[string]string
+}
+
+// Cluster is a cluster the records are sent to.
+type Cluster struct {
+	Name            string
+	RemoteWriteHost string
+}
+
+// Batch is the records of a cluster.
+type Batch struct {
+	Cluster Cluster
+	Records []*types.ResourceTags
+}
+
+// Mapper assigns the records to their cluster. A nil Mapper is valid, and
+// assigns every record to the host cluster.
+type Mapper struct {
+	rules      []rule
+	namespaces NamespaceLabeler
+}
+
+type rule struct {
+	cluster  config.LogicalCluster
+	selector labels.Selector
+}
+
+// New creates the mapper of the logical clusters of the settings. It returns
+// nil when there is no logical cluster. The labeler is only used by the
+// namespace selectors.
+func New(settings *config.Settings, namespaces NamespaceLabeler) (*Mapper, error) {
+	if len(settings.LogicalClusters) == 0 {
+		return nil, nil //nolint:nilnil // a nil mapper assigns every record to the host cluster
+	}
+
+	m := &Mapper{namespaces: namespaces}
+	for _, c := range settings.LogicalClusters {
+		r := rule{cluster: c}
+		if c.NamespaceSelector != "" {
+			selector, err := labels.Parse(c.NamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace selector '%s': %w", c.NamespaceSelector, err)
+			}
+			r.selector = selector
+		}
+		m.rules = append(m.rules, r)
+	}
+	return m, nil
+}
+
+// HostCluster returns the host cluster of the settings.
+func HostCluster(settings *config.Settings) Cluster {
+	return Cluster{Name: settings.ClusterName, RemoteWriteHost: settings.RemoteWrite.Host}
+}
+
+// Namespace returns the cluster of the resources of a namespace.
+func (m *Mapper) Namespace(ctx context.Context, namespace string) (Cluster, bool) {
+	if m == nil || namespace == "" {
+		return Cluster{}, false
+	}
+	var namespaceLabels labels.Set
+	for _, r := range m.rules {
+		matched := r.cluster.NamespacePrefix != "" && strings.HasPrefix(namespace, r.cluster.NamespacePrefix)
+		if !matched && r.selector != nil {
+			if namespaceLabels == nil && m.namespaces != nil {
+				namespaceLabels = labels.Set(m.namespaces.NamespaceLabels(ctx, namespace))
+			}
+			matched = r.selector.Matches(namespaceLabels)
+		}
+		if matched {
+			return Cluster{Name: r.cluster.ClusterName, RemoteWriteHost: r.cluster.RemoteWriteHost}, true
+		}
+	}
+	return Cluster{}, false
+}
+
+// Split groups the records by cluster, keeping their order. A record is sent
+// to the cluster it was assigned to when it was written, so the final records
+// of a deleted namespace still reach its cluster. The records without a known
+// cluster, written before the clusters were stored or assigned to a cluster
+// which is no longer configured, are sent to the cluster of their namespace.
+// The batch of the host cluster comes first, followed by the batches of the
+// logical clusters in the order they are configured.
+func (m *Mapper) Split(ctx context.Context, host Cluster, records []*types.ResourceTags) []Batch {
+	if m == nil {
+		return []Batch{{Cluster: host, Records: records}}
+	}
+
+	// the namespaces are looked up once per split
+	clusters := map[string]Cluster{}
+	batches := map[string]*Batch{}
+	order := []string{host.Name}
+	batches[host.Name] = &Batch{Cluster: host}
+	for _, r := range m.rules {
+		order = append(order, r.cluster.ClusterName)
+		batches[r.cluster.ClusterName] = &Batch{Cluster: Cluster{Name: r.cluster.ClusterName, RemoteWriteHost: r.cluster.RemoteWriteHost}}
+	}
+	for _, record := range records {
+		if batch, ok := batches[record.Cluster]; ok && record.Cluster != "" {
+			batch.Records = append(batch.Records, record)
+			continue
+		}
+		namespace := recordNamespace(record)
+		c, ok := clusters[namespace]
+		if !ok {
+			if c, ok = m.Namespace(ctx, namespace); !ok {
+				c = host
+			}
+			clusters[namespace] = c
+		}
+		batch := batches[c.Name]
+		batch.Records = append(batch.Records, record)
+	}
+
+	result := []Batch{}
+	for _, name := range order {
+		if batch := batches[name]; len(batch.Records) > 0 {
+			result = append(result, *batch)
+		}
+	}
+	return result
+}
+
+// recordNamespace returns the namespace a record belongs to, which is the name
+// of a namespace record, or an empty string for a cluster-scoped resource.
+func recordNamespace(record *types.ResourceTags) string {
+	if record.Type == config.Namespace {
+		return record.Name
+	}
+	if record.Namespace != nil {
+		return *record.Namespace
+	}
+	return ""
+}
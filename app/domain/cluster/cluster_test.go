@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cluster_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/cluster"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// namespaceLabels is a NamespaceLabeler backed by a map, counting the lookups.
+type namespaceLabels struct {
+	labels  map[string]map[string]string
+	lookups int
+}
+
+func (n *namespaceLabels) NamespaceLabels(_ context.Context, namespace string) map[string]string {
+	n.lookups++
+	return n.labels[namespace]
+}
+
+func newSettings() *config.Settings {
+	return &config.Settings{
+		ClusterName: "host",
+		RemoteWrite: config.RemoteWrite{Host: "https://host"},
+		LogicalClusters: config.LogicalClusters{
+			{ClusterName: "tenant-a", NamespacePrefix: "a-", RemoteWriteHost: "https://tenant-a"},
+			{ClusterName: "tenant-b", NamespaceSelector: "capsule.clastix.io/tenant=b", RemoteWriteHost: "https://tenant-b"},
+		},
+	}
+}
+
+func record(resourceType config.ResourceType, namespace, name string) *types.ResourceTags {
+	r := &types.ResourceTags{Type: resourceType, Name: name}
+	if namespace != "" {
+		r.Namespace = &namespace
+	}
+	return r
+}
+
+func TestNew_Inactive(t *testing.T) {
+	m, err := cluster.New(&config.Settings{}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	// a nil mapper sends every record to the host cluster
+	host := cluster.Cluster{Name: "host", RemoteWriteHost: "https://host"}
+	records := []*types.ResourceTags{record(config.Pod, "a-web", "web")}
+	assert.Equal(t, []cluster.Batch{{Cluster: host, Records: records}}, m.Split(context.Background(), host, records))
+}
+
+func TestMapper_Namespace(t *testing.T) {
+	labeler := &namespaceLabels{labels: map[string]map[string]string{
+		"b-web": {"capsule.clastix.io/tenant": "b"},
+	}}
+	m, err := cluster.New(newSettings(), labeler)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	c, ok := m.Namespace(ctx, "a-web")
+	assert.True(t, ok)
+	assert.Equal(t, cluster.Cluster{Name: "tenant-a", RemoteWriteHost: "https://tenant-a"}, c)
+	// the labels are not looked up when the prefix matches
+	assert.Zero(t, labeler.lookups)
+
+	c, ok = m.Namespace(ctx, "b-web")
+	assert.True(t, ok)
+	assert.Equal(t, "tenant-b", c.Name)
+
+	_, ok = m.Namespace(ctx, "default")
+	assert.False(t, ok)
+	_, ok = m.Namespace(ctx, "")
+	assert.False(t, ok)
+}
+
+func TestMapper_Split(t *testing.T) {
+	labeler := &namespaceLabels{labels: map[string]map[string]string{
+		"b-web": {"capsule.clastix.io/tenant": "b"},
+	}}
+	settings := newSettings()
+	m, err := cluster.New(settings, labeler)
+	require.NoError(t, err)
+
+	records := []*types.ResourceTags{
+		record(config.Pod, "b-web", "web-1"),
+		record(config.Pod, "a-web", "web-1"),
+		record(config.Pod, "default", "web-1"),
+		record(config.Node, "", "node-1"),
+		record(config.Namespace, "", "a-web"),
+		record(config.Pod, "b-web", "web-2"),
+	}
+	batches := m.Split(context.Background(), cluster.HostCluster(settings), records)
+	require.Len(t, batches, 3)
+
+	assert.Equal(t, cluster.Cluster{Name: "host", RemoteWriteHost: "https://host"}, batches[0].Cluster)
+	assert.Equal(t, []*types.ResourceTags{records[2], records[3]}, batches[0].Records)
+	// a namespace belongs to the cluster of its resources
+	assert.Equal(t, "tenant-a", batches[1].Cluster.Name)
+	assert.Equal(t, []*types.ResourceTags{records[1], records[4]}, batches[1].Records)
+	assert.Equal(t, "tenant-b", batches[2].Cluster.Name)
+	assert.Equal(t, []*types.ResourceTags{records[0], records[5]}, batches[2].Records)
+
+	// every namespace is looked up once per split
+	assert.Equal(t, 2, labeler.lookups)
+}
+
+func TestMapper_Split_StoredCluster(t *testing.T) {
+	settings := newSettings()
+	m, err := cluster.New(settings, &namespaceLabels{})
+	require.NoError(t, err)
+
+	// the stored cluster is used, even when the namespace matches no cluster
+	// anymore, and an unknown cluster falls back to the namespace
+	deleted := record(config.Namespace, "", "b-web")
+	deleted.Cluster = "tenant-b"
+	removed := record(config.Pod, "a-web", "web")
+	removed.Cluster = "tenant-c"
+	host := record(config.Pod, "a-web", "moved")
+	host.Cluster = "host"
+
+	batches := m.Split(context.Background(), cluster.HostCluster(settings), []*types.ResourceTags{deleted, removed, host})
+	require.Len(t, batches, 3)
+	assert.Equal(t, []*types.ResourceTags{host}, batches[0].Records)
+	assert.Equal(t, "tenant-a", batches[1].Cluster.Name)
+	assert.Equal(t, []*types.ResourceTags{removed}, batches[1].Records)
+	assert.Equal(t, "tenant-b", batches[2].Cluster.Name)
+	assert.Equal(t, []*types.ResourceTags{deleted}, batches[2].Records)
+}
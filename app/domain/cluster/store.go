@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cluster
+
+import (
+	"context"
+
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// store assigns the records written through it to their cluster.
+type store struct {
+	types.ResourceStore
+	mapper *Mapper
+	host   Cluster
+}
+
+// historyStore is a store which also gives access to the versions of the
+// records.
+type historyStore struct {
+	*store
+	types.ResourceHistoryStore
+}
+
+// NewStore wraps a store, so every record written through it is assigned to
+// the cluster of its namespace at the time it is written. The record of a
+// deleted resource keeps the cluster it was assigned to, as the labels of its
+// namespace may be gone by then. The store is returned as it is when there is
+// no logical cluster.
+func NewStore(s types.ResourceStore, m *Mapper, host Cluster) types.ResourceStore {
+	if m == nil {
+		return s
+	}
+	wrapped := &store{ResourceStore: s, mapper: m, host: host}
+	if history, ok := s.(types.ResourceHistoryStore); ok {
+		return &historyStore{store: wrapped, ResourceHistoryStore: history}
+	}
+	return wrapped
+}
+
+func (s *store) Create(ctx context.Context, it *types.ResourceTags) error {
+	s.assign(ctx, it)
+	return s.ResourceStore.Create(ctx, it)
+}
+
+func (s *store) Update(ctx context.Context, it *types.ResourceTags) error {
+	s.assign(ctx, it)
+	return s.ResourceStore.Update(ctx, it)
+}
+
+func (s *store) assign(ctx context.Context, it *types.ResourceTags) {
+	if it.DeletedAt != nil && it.Cluster != "" {
+		return
+	}
+	c, ok := s.mapper.Namespace(ctx, recordNamespace(it))
+	if !ok {
+		c = s.host
+	}
+	it.Cluster = c.Name
+}
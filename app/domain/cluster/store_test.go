@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/cluster"
+	"github.com/cloudzero/cloudzero-agent/app/http/handler"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func TestNewStore(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewMockClock(time.Now())
+	base, err := repo.NewInMemoryResourceRepository(clock)
+	require.NoError(t, err)
+
+	// a store is kept as it is without logical clusters
+	assert.Equal(t, base, cluster.NewStore(base, nil, cluster.Cluster{Name: "host"}))
+
+	settings := newSettings()
+	labeler := &namespaceLabels{labels: map[string]map[string]string{
+		"b-web": {"capsule.clastix.io/tenant": "b"},
+	}}
+	m, err := cluster.New(settings, labeler)
+	require.NoError(t, err)
+	store := cluster.NewStore(base, m, cluster.HostCluster(settings))
+	_, ok := store.(types.ResourceHistoryStore)
+	assert.True(t, ok)
+
+	find := func(namespace string) *types.ResourceTags {
+		found, err := store.FindFirstBy(ctx, "type = ? AND name = ? AND namespace = ?", config.Pod, "store-web", namespace)
+		require.NoError(t, err)
+		return found
+	}
+
+	for _, namespace := range []string{"b-web", "default"} {
+		handler.WriteDataToStorage(ctx, store, clock, *record(config.Pod, namespace, "store-web"))
+	}
+	assert.Equal(t, "tenant-b", find("b-web").Cluster)
+	assert.Equal(t, "host", find("default").Cluster)
+
+	// the deletion keeps the cluster once the labels of the namespace are gone
+	labeler.labels = nil
+	clock.AdvanceTime(time.Minute)
+	handler.WriteDeletionToStorage(ctx, store, clock, *record(config.Pod, "b-web", "store-web"))
+	deleted := find("b-web")
+	assert.NotNil(t, deleted.DeletedAt)
+	assert.Equal(t, "tenant-b", deleted.Cluster)
+}
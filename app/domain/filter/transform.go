@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package filter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type TransformAction string
+
+const (
+	// TransformActionHash replaces the value with its HMAC-SHA256, hex encoded.
+	TransformActionHash TransformAction = "hash"
+	// TransformActionTruncate keeps the first characters of the value.
+	TransformActionTruncate TransformAction = "truncate"
+	// TransformActionMap replaces the value through a lookup table.
+	TransformActionMap TransformAction = "map"
+	// TransformActionRedact replaces the value with a constant.
+	TransformActionRedact TransformAction = "redact"
+)
+
+// DefaultRedactedValue replaces redacted values when no value is configured.
+const DefaultRedactedValue = "REDACTED"
+
+// TransformEntry rewrites the values of the tags whose key matches the
+// pattern. Only the first matching entry is applied to a value.
+type TransformEntry struct {
+	Pattern string
+	// Match is how the pattern is matched, a regular expression by default.
+	Match  FilterMatchType
+	Action TransformAction
+	// Length is the number of characters kept by `truncate`, and the length
+	// the `hash` is truncated to, when set.
+	Length int
+	// Mapping is the lookup table of `map`.
+	Mapping map[string]string
+	// Value replaces the values missing from the mapping of `map`, which are
+	// kept when it is empty, and the values redacted by `redact`.
+	Value string
+}
+
+// Transformer rewrites tag values, e.g. to hash or redact sensitive values
+// before they leave the cluster.
+type Transformer struct {
+	rules  []transformRule
+	secret []byte
+}
+
+type transformRule struct {
+	TransformEntry
+	checker *FilterChecker
+}
+
+// NewTransformer compiles the transforms. The secret keys the HMAC of the
+// `hash` action, and is required when it is used. It returns nil when there
+// is no transform.
+func NewTransformer(entries []TransformEntry, secret []byte) (*Transformer, error) {
+	if len(entries) == 0 {
+		return nil, nil //nolint:nilnil // methods handle nil properly, returning nil allows us to elide code
+	}
+
+	t := &Transformer{secret: secret}
+	var errs []error
+	for _, entry := range entries {
+		if entry.Match == "" {
+			entry.Match = FilterMatchTypeRegex
+		}
+		checker, err := NewFilterChecker([]FilterEntry{{Pattern: entry.Pattern, Match: entry.Match}})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("transform of '%s': %w", entry.Pattern, err))
+			continue
+		}
+		switch entry.Action {
+		case TransformActionHash:
+			if len(secret) == 0 {
+				errs = append(errs, fmt.Errorf("transform of '%s': the hash action requires a secret", entry.Pattern))
+			}
+		case TransformActionTruncate:
+			if entry.Length <= 0 {
+				errs = append(errs, fmt.Errorf("transform of '%s': the truncate action requires a positive length", entry.Pattern))
+			}
+		case TransformActionMap:
+			if len(entry.Mapping) == 0 {
+				errs = append(errs, fmt.Errorf("transform of '%s': the map action requires a mapping", entry.Pattern))
+			}
+		case TransformActionRedact:
+			if entry.Value == "" {
+				entry.Value = DefaultRedactedValue
+			}
+		default:
+			errs = append(errs, fmt.Errorf("transform of '%s': unknown action '%s'", entry.Pattern, entry.Action))
+		}
+		t.rules = append(t.rules, transformRule{TransformEntry: entry, checker: checker})
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Transform returns the value of a tag rewritten by the first transform
+// matching its key, or the value itself when none matches.
+func (t *Transformer) Transform(key, value string) string {
+	if t == nil {
+		return value
+	}
+	for _, rule := range t.rules {
+		if !rule.checker.Test(key) {
+			continue
+		}
+		switch rule.Action {
+		case TransformActionHash:
+			mac := hmac.New(sha256.New, t.secret)
+			mac.Write([]byte(value))
+			return truncate(hex.EncodeToString(mac.Sum(nil)), rule.Length)
+		case TransformActionTruncate:
+			return truncate(value, rule.Length)
+		case TransformActionMap:
+			if mapped, ok := rule.Mapping[value]; ok {
+				return mapped
+			}
+			if rule.Value != "" {
+				return rule.Value
+			}
+			return value
+		case TransformActionRedact:
+			return rule.Value
+		}
+	}
+	return value
+}
+
+// TransformAll returns a copy of the tags with every value transformed.
+func (t *Transformer) TransformAll(tags map[string]string) map[string]string {
+	if t == nil || tags == nil {
+		return tags
+	}
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		result[key] = t.Transform(key, value)
+	}
+	return result
+}
+
+// ReadSecret reads the secret of the `hash` action from a file, ignoring the
+// surrounding whitespace. An empty path returns no secret.
+func ReadSecret(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the hash secret: %w", err)
+	}
+	return []byte(strings.TrimSpace(string(data))), nil
+}
+
+// truncate keeps the first characters of a value, when length is positive.
+func truncate(value string, length int) string {
+	if length <= 0 {
+		return value
+	}
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+	return string(runes[:length])
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package filter_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	util "github.com/cloudzero/cloudzero-agent/app/domain/filter"
+)
+
+func hmacHex(secret, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestTransformer_Transform(t *testing.T) {
+	transformer, err := util.NewTransformer([]util.TransformEntry{
+		{Pattern: "owner", Match: util.FilterMatchTypeExact, Action: util.TransformActionHash},
+		{Pattern: "^ticket", Action: util.TransformActionTruncate, Length: 8},
+		{Pattern: "team", Match: util.FilterMatchTypeExact, Action: util.TransformActionMap, Mapping: map[string]string{"a": "alpha"}},
+		{Pattern: "env", Match: util.FilterMatchTypeExact, Action: util.TransformActionMap, Mapping: map[string]string{"prod": "production"}, Value: "other"},
+		{Pattern: "secret", Match: util.FilterMatchTypeContains, Action: util.TransformActionRedact},
+		{Pattern: "token", Match: util.FilterMatchTypePrefix, Action: util.TransformActionRedact, Value: "***"},
+		{Pattern: "short-owner", Match: util.FilterMatchTypeExact, Action: util.TransformActionHash, Length: 10},
+		// only the first matching transform applies
+		{Pattern: "owner", Match: util.FilterMatchTypeSuffix, Action: util.TransformActionRedact},
+	}, []byte("s3cr3t"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		key   string
+		value string
+		want  string
+	}{
+		{"owner", "jane@example.com", hmacHex("s3cr3t", "jane@example.com")},
+		{"short-owner", "jane@example.com", hmacHex("s3cr3t", "jane@example.com")[:10]},
+		{"ticket-url", "https://tickets.example.com/1234", "https://"},
+		{"ticket-id", "1234", "1234"},
+		{"team", "a", "alpha"},
+		{"team", "b", "b"},
+		{"env", "prod", "production"},
+		{"env", "dev", "other"},
+		{"db-secret-name", "admin", util.DefaultRedactedValue},
+		{"token-id", "abc", "***"},
+		{"other-owner", "jane", util.DefaultRedactedValue},
+		{"app", "web", "web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, transformer.Transform(tt.key, tt.value))
+		})
+	}
+}
+
+func TestTransformer_Nil(t *testing.T) {
+	transformer, err := util.NewTransformer(nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, transformer)
+	assert.Equal(t, "value", transformer.Transform("key", "value"))
+
+	tags := map[string]string{"key": "value"}
+	assert.Equal(t, tags, transformer.TransformAll(tags))
+}
+
+func TestTransformer_TransformAll(t *testing.T) {
+	transformer, err := util.NewTransformer([]util.TransformEntry{
+		{Pattern: "owner", Match: util.FilterMatchTypeExact, Action: util.TransformActionRedact},
+	}, nil)
+	require.NoError(t, err)
+
+	tags := map[string]string{"owner": "jane", "app": "web"}
+	assert.Equal(t, map[string]string{"owner": util.DefaultRedactedValue, "app": "web"}, transformer.TransformAll(tags))
+	// the tags are not modified
+	assert.Equal(t, "jane", tags["owner"])
+}
+
+func TestNewTransformer_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry util.TransformEntry
+	}{
+		{"hash without a secret", util.TransformEntry{Pattern: "owner", Action: util.TransformActionHash}},
+		{"truncate without a length", util.TransformEntry{Pattern: "owner", Action: util.TransformActionTruncate}},
+		{"map without a mapping", util.TransformEntry{Pattern: "owner", Action: util.TransformActionMap}},
+		{"unknown action", util.TransformEntry{Pattern: "owner", Action: "encrypt"}},
+		{"invalid pattern", util.TransformEntry{Pattern: "(", Action: util.TransformActionRedact}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := util.NewTransformer([]util.TransformEntry{tt.entry}, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestReadSecret(t *testing.T) {
+	secret, err := util.ReadSecret("")
+	require.NoError(t, err)
+	assert.Nil(t, secret)
+
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("s3cr3t\n"), 0o600))
+	secret, err = util.ReadSecret(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("s3cr3t"), secret)
+
+	_, err = util.ReadSecret(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
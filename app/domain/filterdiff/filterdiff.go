@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package filterdiff compares the labels and annotations kept under the current
+// configuration against the ones kept under a proposed configuration, so a
+// change of the filters which stops sending a label is found before it is
+// rolled out.
+//
+// Every live object is formatted with both configurations, and the keys which
+// would be added, removed or get another value are counted per resource kind
+// and namespace. The labels which pods inherit from their workload and
+// namespace are included. The objects are listed through the backfiller, and
+// nothing is written to the store.
+package filterdiff
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/workload"
+	"github.com/cloudzero/cloudzero-agent/app/http/handler"
+)
+
+// Sources of the keys.
+const (
+	SourceLabel      = "label"
+	SourceAnnotation = "annotation"
+)
+
+// Actions of the proposed configuration on a key.
+const (
+	ActionAdded   = "added"
+	ActionRemoved = "removed"
+	ActionChanged = "changed"
+)
+
+var actionSymbols = map[string]string{
+	ActionAdded:   "+",
+	ActionRemoved: "-",
+	ActionChanged: "~",
+}
+
+// Change is a key which the proposed configuration adds, removes or gives
+// another value, with the number of resources it applies to.
+type Change struct {
+	Source    string
+	Key       string
+	Action    string
+	Resources int
+}
+
+// Group is the changes of the resources of a kind in a namespace. The
+// namespace of the cluster-scoped resources and of the namespaces is empty.
+type Group struct {
+	Kind      string
+	Namespace string
+	// Resources is the number of compared resources, and Changed the number
+	// of resources with at least one change.
+	Resources int
+	Changed   int
+	Changes   []Change
+}
+
+type groupKey struct {
+	kind      string
+	namespace string
+}
+
+type changeKey struct {
+	source string
+	key    string
+	action string
+}
+
+type group struct {
+	resources int
+	changed   int
+	changes   map[changeKey]int
+}
+
+// Diff accumulates the changes of the objects it is given.
+type Diff struct {
+	current          *config.Settings
+	proposed         *config.Settings
+	currentResolver  *workload.Resolver
+	proposedResolver *workload.Resolver
+	groups           map[groupKey]*group
+}
+
+type Option func(d *Diff)
+
+// WithWorkloadResolvers merges the labels which pods inherit from their
+// workload and namespace, with the resolver of each configuration. The
+// resolver of a configuration without workloads is nil.
+func WithWorkloadResolvers(current, proposed *workload.Resolver) Option {
+	return func(d *Diff) {
+		d.currentResolver = current
+		d.proposedResolver = proposed
+	}
+}
+
+func New(current, proposed *config.Settings, opts ...Option) *Diff {
+	d := &Diff{
+		current:  current,
+		proposed: proposed,
+		groups:   map[groupKey]*group{},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// ListSettings returns the settings to list the objects with, which enable the
+// resource types and custom resources of both configurations. The client
+// settings are the ones of the current configuration.
+func ListSettings(current, proposed *config.Settings) *config.Settings {
+	settings := &config.Settings{
+		K8sClient:       current.K8sClient,
+		CustomResources: slices.Clone(current.CustomResources),
+	}
+	settings.Filters.Labels.Enabled = current.Filters.Labels.Enabled || proposed.Filters.Labels.Enabled
+	settings.Filters.Labels.Resources = current.Filters.Labels.Resources.Merge(proposed.Filters.Labels.Resources)
+	settings.Filters.Annotations.Enabled = current.Filters.Annotations.Enabled || proposed.Filters.Annotations.Enabled
+	settings.Filters.Annotations.Resources = current.Filters.Annotations.Resources.Merge(proposed.Filters.Annotations.Resources)
+	for _, c := range proposed.CustomResources {
+		if _, ok := current.FindCustomResource(c.Group, c.Kind); !ok {
+			settings.CustomResources = append(settings.CustomResources, c)
+		}
+	}
+	return settings
+}
+
+// Add formats an object with both configurations and counts its changes. A
+// custom resource declared by a single configuration has no labels or
+// annotations under the other one.
+func (d *Diff) Add(ctx context.Context, obj any) error {
+	before, errBefore := handler.FormatResourceData(obj, d.current)
+	after, errAfter := handler.FormatResourceData(obj, d.proposed)
+	if errBefore != nil && errAfter != nil {
+		return errBefore
+	}
+	if errBefore == nil {
+		d.currentResolver.Enrich(ctx, obj, &before)
+	}
+	if errAfter == nil {
+		d.proposedResolver.Enrich(ctx, obj, &after)
+	}
+	record := after
+	if errAfter != nil {
+		record = before
+	}
+
+	key := groupKey{kind: config.ResourceTypeToMetricName[record.Type]}
+	if record.Namespace != nil {
+		key.namespace = *record.Namespace
+	}
+	g, ok := d.groups[key]
+	if !ok {
+		g = &group{changes: map[changeKey]int{}}
+		d.groups[key] = g
+	}
+	g.resources++
+
+	changed := compare(g, SourceLabel, before.Labels, after.Labels)
+	changed = compare(g, SourceAnnotation, before.Annotations, after.Annotations) || changed
+	if changed {
+		g.changed++
+	}
+	return nil
+}
+
+// compare counts the keys which differ between the tags, and returns true
+// when any does.
+func compare(g *group, source string, before, after *config.MetricLabelTags) bool {
+	var left, right config.MetricLabelTags
+	if before != nil {
+		left = *before
+	}
+	if after != nil {
+		right = *after
+	}
+
+	changed := false
+	for key, value := range left {
+		other, ok := right[key]
+		switch {
+		case !ok:
+			g.changes[changeKey{source: source, key: key, action: ActionRemoved}]++
+		case other != value:
+			g.changes[changeKey{source: source, key: key, action: ActionChanged}]++
+		default:
+			continue
+		}
+		changed = true
+	}
+	for key := range right {
+		if _, ok := left[key]; !ok {
+			g.changes[changeKey{source: source, key: key, action: ActionAdded}]++
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Groups returns the groups with at least one change, sorted by kind and
+// namespace. The changes are sorted by source, action and key.
+func (d *Diff) Groups() []Group {
+	groups := []Group{}
+	for key, g := range d.groups {
+		if g.changed == 0 {
+			continue
+		}
+		result := Group{Kind: key.kind, Namespace: key.namespace, Resources: g.resources, Changed: g.changed}
+		for c, count := range g.changes {
+			result.Changes = append(result.Changes, Change{Source: c.source, Key: c.key, Action: c.action, Resources: count})
+		}
+		slices.SortFunc(result.Changes, func(a, b Change) int {
+			return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.Action, b.Action), cmp.Compare(a.Key, b.Key))
+		})
+		groups = append(groups, result)
+	}
+	slices.SortFunc(groups, func(a, b Group) int {
+		return cmp.Or(cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.Namespace, b.Namespace))
+	})
+	return groups
+}
+
+// Write prints the changes of every group, with `+` for the added keys, `-`
+// for the removed ones and `~` for the ones with another value.
+func (d *Diff) Write(w io.Writer) error {
+	groups := d.Groups()
+	if len(groups) == 0 {
+		_, err := fmt.Fprintln(w, "No label or annotation would be added or removed")
+		return err
+	}
+	for _, g := range groups {
+		where := g.Kind
+		if g.Namespace != "" {
+			where = fmt.Sprintf("%s in namespace '%s'", g.Kind, g.Namespace)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %d of %d resources changed\n", where, g.Changed, g.Resources); err != nil {
+			return err
+		}
+		for _, c := range g.Changes {
+			if _, err := fmt.Fprintf(w, "  %s %s %s (%d resources)\n", actionSymbols[c.Action], c.Source, c.Key, c.Resources); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
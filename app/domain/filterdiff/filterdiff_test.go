@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package filterdiff_test
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/backfiller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/filterdiff"
+	"github.com/cloudzero/cloudzero-agent/app/domain/workload"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func newSettings(labelPattern string, resources config.Resources) *config.Settings {
+	return &config.Settings{
+		Filters: config.Filters{
+			Labels: config.Labels{
+				Enabled:   true,
+				Resources: resources,
+				Patterns:  []string{labelPattern},
+			},
+		},
+		LabelMatches: []regexp.Regexp{*regexp.MustCompile(labelPattern)},
+		K8sClient: config.K8sClient{
+			QPS:          1000,
+			Burst:        1000,
+			MaxRetries:   2,
+			RetryBackoff: time.Millisecond,
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	current := newSettings(".*", config.Resources{Pods: true, Namespaces: true})
+	proposed := newSettings("^team$", config.Resources{Pods: true, Namespaces: true, Deployments: true})
+	proposed.Filters.Annotations = config.Annotations{Enabled: true, Resources: config.Resources{Pods: true}, Patterns: []string{"owner"}}
+	proposed.AnnotationMatches = []regexp.Regexp{*regexp.MustCompile("owner")}
+
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "a"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"team": "a", "env": "prod"}, Annotations: map[string]string{"owner": "me"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "default", Labels: map[string]string{"team": "a", "env": "prod"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Labels: map[string]string{"team": "b"}}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"team": "a"}}},
+	)
+
+	// the resource types of both configurations are listed, without a store
+	diff := filterdiff.New(current, proposed)
+	clock := mocks.NewMockClock(time.Now())
+	err := backfiller.NewBackfiller(client, nil, clock, filterdiff.ListSettings(current, proposed)).Walk(context.Background(), func(item any) {
+		assert.NoError(t, diff.Add(context.Background(), item))
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []filterdiff.Group{
+		{
+			Kind:      "deployment",
+			Namespace: "default",
+			Resources: 1,
+			Changed:   1,
+			Changes: []filterdiff.Change{
+				{Source: filterdiff.SourceLabel, Key: "team", Action: filterdiff.ActionAdded, Resources: 1},
+			},
+		},
+		{
+			Kind:      "pod",
+			Namespace: "default",
+			Resources: 3,
+			Changed:   2,
+			Changes: []filterdiff.Change{
+				{Source: filterdiff.SourceAnnotation, Key: "owner", Action: filterdiff.ActionAdded, Resources: 1},
+				{Source: filterdiff.SourceLabel, Key: "env", Action: filterdiff.ActionRemoved, Resources: 2},
+			},
+		},
+	}, diff.Groups())
+
+	var out bytes.Buffer
+	require.NoError(t, diff.Write(&out))
+	assert.Equal(t, `deployment in namespace 'default': 1 of 1 resources changed
+  + label team (1 resources)
+pod in namespace 'default': 2 of 3 resources changed
+  + annotation owner (1 resources)
+  - label env (2 resources)
+`, out.String())
+}
+
+func TestDiff_NoChanges(t *testing.T) {
+	settings := newSettings(".*", config.Resources{Pods: true})
+	diff := filterdiff.New(settings, settings)
+	require.NoError(t, diff.Add(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"team": "a"}}}))
+	assert.Empty(t, diff.Groups())
+
+	var out bytes.Buffer
+	require.NoError(t, diff.Write(&out))
+	assert.Equal(t, "No label or annotation would be added or removed\n", out.String())
+
+	// objects which neither configuration can format are reported
+	assert.Error(t, diff.Add(context.Background(), &corev1.ConfigMap{}))
+}
+
+func TestDiff_InheritedLabels(t *testing.T) {
+	current := newSettings(".*", config.Resources{Pods: true})
+	proposed := newSettings(".*", config.Resources{Pods: true})
+	proposed.Workloads = config.Workloads{Enabled: true, InheritPatterns: []string{"^team$"}}
+	proposed.InheritMatches = []regexp.Regexp{*regexp.MustCompile("^team$")}
+
+	controller := true
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"team": "a"}}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-5d8f7",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "web", Controller: &controller}},
+		}},
+	)
+	clock := mocks.NewMockClock(time.Now())
+
+	// the label is only inherited under the proposed configuration
+	diff := filterdiff.New(current, proposed, filterdiff.WithWorkloadResolvers(nil, workload.NewResolver(client, clock, proposed)))
+	require.NoError(t, diff.Add(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:            "web-5d8f7-abcde",
+		Namespace:       "default",
+		Labels:          map[string]string{"env": "prod"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d8f7", Controller: &controller}},
+	}}))
+
+	assert.Equal(t, []filterdiff.Group{
+		{
+			Kind:      "pod",
+			Namespace: "default",
+			Resources: 1,
+			Changed:   1,
+			Changes: []filterdiff.Change{
+				{Source: filterdiff.SourceLabel, Key: "team", Action: filterdiff.ActionAdded, Resources: 1},
+			},
+		},
+	}, diff.Groups())
+}
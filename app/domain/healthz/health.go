@@ -31,6 +31,21 @@ func Register(name string, fn HealthCheck) {
 	chkr.add(name, fn)
 }
 
+// CheckHandler returns an http.HandlerFunc which only runs the given check. It
+// serves checks which should not fail the liveness of the service, on an
+// endpoint of their own.
+func CheckHandler(name string, fn HealthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := fn(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(name + " failed: " + err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok")) // ignore return values
+	}
+}
+
 var (
 	// global protected access to health checker
 	// once to ensure singleton
@@ -49,3 +49,23 @@ func TestEndpointHandler(t *testing.T) {
 		assert.Contains(t, rr.Body.String(), "check2 failed: assert.AnError general error for testing")
 	})
 }
+
+func TestCheckHandler(t *testing.T) {
+	failing := false
+	handler := healthz.CheckHandler("certificate", func() error {
+		if failing {
+			return assert.AnError
+		}
+		return nil
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz/certificate", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	failing = true
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz/certificate", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "certificate failed: assert.AnError general error for testing")
+}
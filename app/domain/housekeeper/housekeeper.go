@@ -18,17 +18,22 @@ import (
 	"github.com/cloudzero/cloudzero-agent/app/utils"
 )
 
+// DefaultHistoryRetention is used when the history retention is not set.
+const DefaultHistoryRetention = 30 * 24 * time.Hour
+
 type HouseKeeper struct {
-	store           types.ResourceStore
-	running         bool
-	originalCtx     context.Context
-	ctx             context.Context
-	cancel          context.CancelFunc
-	mu              sync.Mutex
-	cleanupInterval time.Duration
-	retentionTime   time.Duration
-	clock           types.TimeProvider
-	done            chan struct{}
+	store            types.ResourceStore
+	history          types.ResourceHistoryStore
+	historyRetention time.Duration
+	running          bool
+	originalCtx      context.Context
+	ctx              context.Context
+	cancel           context.CancelFunc
+	mu               sync.Mutex
+	cleanupInterval  time.Duration
+	retentionTime    time.Duration
+	clock            types.TimeProvider
+	done             chan struct{}
 }
 
 func New(
@@ -37,16 +42,24 @@ func New(
 	clock types.TimeProvider,
 	settings *config.Settings,
 ) types.Runnable {
+	historyRetention := settings.Database.HistoryRetention
+	if historyRetention <= 0 {
+		historyRetention = DefaultHistoryRetention
+	}
+	// the label history is pruned when the store keeps one
+	history, _ := store.(types.ResourceHistoryStore)
 	newCtx, cancel := context.WithCancel(ctx)
 	return &HouseKeeper{
-		originalCtx:     ctx,
-		ctx:             newCtx,
-		cancel:          cancel,
-		done:            make(chan struct{}),
-		clock:           clock,
-		store:           store,
-		cleanupInterval: settings.Database.CleanupInterval,
-		retentionTime:   settings.Database.RetentionTime,
+		originalCtx:      ctx,
+		ctx:              newCtx,
+		cancel:           cancel,
+		done:             make(chan struct{}),
+		clock:            clock,
+		store:            store,
+		history:          history,
+		historyRetention: historyRetention,
+		cleanupInterval:  settings.Database.CleanupInterval,
+		retentionTime:    settings.Database.RetentionTime,
 	}
 }
 
@@ -76,6 +89,7 @@ func (h *HouseKeeper) Run() error {
 			case <-ticker.C:
 				// use the store to cleanup old data
 				currentTime := h.clock.GetCurrentTime()
+				h.pruneHistory(currentTime)
 				retentionTime := currentTime.Add(-1 * h.retentionTime)
 				log.Debug().
 					Dur("retention_time", h.retentionTime).
@@ -118,6 +132,24 @@ func (h *HouseKeeper) Run() error {
 	return nil
 }
 
+// pruneHistory removes the versions of the labels which ended before the
+// history retention.
+func (h *HouseKeeper) pruneHistory(currentTime time.Time) {
+	if h.history == nil {
+		return
+	}
+	deleted, err := h.history.DeleteVersionsBefore(h.ctx, currentTime.Add(-1*h.historyRetention))
+	if err != nil {
+		log.Err(err).Msg("Failed to delete old label history")
+		return
+	}
+	if deleted > 0 {
+		log.Debug().
+			Int64("deleted_count", deleted).
+			Msg("Deleted old label history")
+	}
+}
+
 func (h *HouseKeeper) Shutdown() error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
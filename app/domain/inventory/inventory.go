@@ -0,0 +1,340 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package inventory periodically writes a snapshot of every resource in the
+// resource store, with its labels, annotations, owner and timestamps.
+//
+// A snapshot is a metric file with its own content identifier, written to the
+// data directory of a shipper which uploads it like the other metric files.
+// Each resource is a `cloudzero_inventory` metric, and its labels, annotations
+// and timestamps are the labels of the metric. Every replica snapshots its own
+// store, so the metrics are labeled with the replica which wrote them. The
+// snapshots which were not uploaded are pruned by age and count, so they do not
+// pile up while the shipper is unavailable.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/store"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// MetricName is the name of the metric of each resource in a snapshot.
+const MetricName = "cloudzero_inventory"
+
+// Labels of the metric of a resource, besides its metric labels.
+const (
+	LabelResourceType  = "resource_type"
+	LabelResourceName  = "resource_name"
+	LabelNamespace     = "namespace"
+	LabelRecordCreated = "record_created"
+	LabelRecordUpdated = "record_updated"
+	LabelDeletedAt     = "deleted_at"
+	LabelReplica       = "replica"
+	LabelPrefix        = "label_"
+	AnnotationPrefix   = "annotation_"
+)
+
+// Defaults used when the settings are not set.
+const (
+	DefaultInterval     = time.Hour
+	DefaultRetention    = 24 * time.Hour
+	DefaultMaxSnapshots = 3
+)
+
+// pageSize is how many records are read from the store at a time.
+const pageSize = 1000
+
+var (
+	inventoryStatsOnce sync.Once
+	// Snapshots counts the snapshots, labeled by result.
+	Snapshots = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "inventory_snapshots_total",
+			Help: "Total number of resource inventory snapshots, labeled by result",
+		},
+		[]string{"result"},
+	)
+	// SnapshotResources is the number of resources in the last snapshot.
+	SnapshotResources = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "inventory_snapshot_resources",
+			Help: "Number of resources in the last resource inventory snapshot",
+		},
+	)
+	// SnapshotDuration is how long the snapshots take to write.
+	SnapshotDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "inventory_snapshot_duration_seconds",
+			Help:    "Duration of the resource inventory snapshots",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	// PrunedSnapshots counts the snapshots which were deleted before they
+	// were uploaded.
+	PrunedSnapshots = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "inventory_pruned_snapshots_total",
+			Help: "Total number of resource inventory snapshots deleted before they were uploaded",
+		},
+	)
+)
+
+type Snapshotter struct {
+	store        types.ResourceStore
+	clock        types.TimeProvider
+	settings     *config.Settings
+	replica      string
+	path         string
+	interval     time.Duration
+	retention    time.Duration
+	maxSnapshots int
+	running      bool
+	originalCtx  context.Context
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mu           sync.Mutex
+	done         chan struct{}
+}
+
+func New(
+	ctx context.Context,
+	store types.ResourceStore,
+	clock types.TimeProvider,
+	settings *config.Settings,
+) *Snapshotter {
+	inventoryStatsOnce.Do(func() {
+		prometheus.MustRegister(Snapshots, SnapshotResources, SnapshotDuration, PrunedSnapshots)
+	})
+	c := settings.Inventory
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.Retention <= 0 {
+		c.Retention = DefaultRetention
+	}
+	if c.MaxSnapshots <= 0 {
+		c.MaxSnapshots = DefaultMaxSnapshots
+	}
+	// the hostname of a pod is its name
+	replica, _ := os.Hostname()
+	newCtx, cancel := context.WithCancel(ctx)
+	return &Snapshotter{
+		store:        store,
+		clock:        clock,
+		settings:     settings,
+		replica:      replica,
+		path:         c.Path,
+		interval:     c.Interval,
+		retention:    c.Retention,
+		maxSnapshots: c.MaxSnapshots,
+		originalCtx:  ctx,
+		ctx:          newCtx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+}
+
+// Run writes a snapshot at every interval, the first one after the first
+// interval so the store is populated by then.
+func (s *Snapshotter) Run() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return nil
+	}
+
+	ctx, done := s.ctx, s.done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.Snapshot(ctx); err != nil {
+					log.Ctx(ctx).Err(err).Msg("Failed to write the resource inventory snapshot")
+				}
+				if err := s.Prune(); err != nil {
+					log.Ctx(ctx).Err(err).Msg("Failed to prune the resource inventory snapshots")
+				}
+			}
+		}
+	}()
+	s.running = true
+	return nil
+}
+
+func (s *Snapshotter) Shutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return nil
+	}
+	s.cancel()
+	<-s.done
+	s.reset()
+	return nil
+}
+
+func (s *Snapshotter) reset() {
+	s.running = false
+	ctx, cancel := context.WithCancel(s.originalCtx)
+	s.ctx = ctx
+	s.cancel = cancel
+	s.done = make(chan struct{})
+}
+
+func (s *Snapshotter) IsRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// Snapshot writes every record of the store, including the records of the
+// deleted resources which were not removed yet, to a new snapshot. It returns
+// the path of the snapshot.
+func (s *Snapshotter) Snapshot(ctx context.Context) (string, error) {
+	start := s.clock.GetCurrentTime()
+	metrics := []types.Metric{}
+	after := ""
+	for {
+		page, err := s.store.FindPageBy(ctx, after, pageSize)
+		if err != nil {
+			Snapshots.WithLabelValues("failure").Inc()
+			return "", fmt.Errorf("failed to read the resources: %w", err)
+		}
+		for _, record := range page {
+			metrics = append(metrics, s.formatRecord(record, start))
+		}
+		if len(page) < pageSize {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+
+	stop := s.clock.GetCurrentTime()
+	path, err := store.WriteCompressedJSONFile(s.path, store.InventoryContentIdentifier, start, stop, metrics)
+	if err != nil {
+		Snapshots.WithLabelValues("failure").Inc()
+		return "", fmt.Errorf("failed to write the snapshot: %w", err)
+	}
+
+	Snapshots.WithLabelValues("success").Inc()
+	SnapshotResources.Set(float64(len(metrics)))
+	SnapshotDuration.Observe(stop.Sub(start).Seconds())
+	log.Ctx(ctx).Debug().
+		Int("resources", len(metrics)).
+		Str("path", path).
+		Msg("Wrote the resource inventory snapshot")
+	return path, nil
+}
+
+// Prune deletes the snapshots which were not uploaded yet and are older than
+// the retention, or beyond the number of snapshots kept, the oldest first.
+// The uploaded snapshots are left to the shipper.
+func (s *Snapshotter) Prune() error {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list the snapshots: %w", err)
+	}
+
+	type snapshot struct {
+		path string
+		stop time.Time
+	}
+	snapshots := []snapshot{}
+	for _, entry := range entries {
+		if entry.IsDir() || store.ParseFileContentIdentifier(entry.Name()) != store.InventoryContentIdentifier {
+			continue
+		}
+		_, stop, ok := store.ParseFileTimeRange(entry.Name())
+		if !ok {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{path: filepath.Join(s.path, entry.Name()), stop: stop})
+	}
+	// newest first
+	slices.SortFunc(snapshots, func(a, b snapshot) int {
+		return b.stop.Compare(a.stop)
+	})
+
+	cutoff := s.clock.GetCurrentTime().Add(-1 * s.retention)
+	for i, snap := range snapshots {
+		if i < s.maxSnapshots && !snap.stop.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(snap.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete the snapshot: %w", err)
+		}
+		PrunedSnapshots.Inc()
+		log.Debug().Str("path", snap.path).Msg("Deleted a resource inventory snapshot which was not uploaded")
+	}
+	return nil
+}
+
+// formatRecord returns the metric of a record, labeled with its metric labels,
+// which carry its owner, and its labels, annotations and timestamps.
+func (s *Snapshotter) formatRecord(record *types.ResourceTags, now time.Time) types.Metric {
+	labels := map[string]string{}
+	if record.MetricLabels != nil {
+		maps.Copy(labels, *record.MetricLabels)
+	}
+	labels[LabelResourceType] = config.ResourceTypeToMetricName[record.Type]
+	labels[LabelResourceName] = record.Name
+	if record.Namespace != nil {
+		labels[LabelNamespace] = *record.Namespace
+	}
+	if record.Labels != nil {
+		for key, value := range *record.Labels {
+			labels[LabelPrefix+key] = value
+		}
+	}
+	if record.Annotations != nil {
+		for key, value := range *record.Annotations {
+			labels[AnnotationPrefix+key] = value
+		}
+	}
+	labels[LabelRecordCreated] = formatTime(record.RecordCreated)
+	labels[LabelRecordUpdated] = formatTime(record.RecordUpdated)
+	if record.DeletedAt != nil {
+		labels[LabelDeletedAt] = formatTime(*record.DeletedAt)
+	}
+	if s.replica != "" {
+		labels[LabelReplica] = s.replica
+	}
+
+	metric := types.Metric{
+		ID:             uuid.New(),
+		ClusterName:    s.settings.ClusterName,
+		CloudAccountID: s.settings.CloudAccountID,
+		MetricName:     MetricName,
+		CreatedAt:      now,
+		TimeStamp:      now,
+		Value:          "1",
+	}
+	metric.ImportLabels(labels)
+	return metric
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
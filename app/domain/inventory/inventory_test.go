@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package inventory_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/inventory"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/store"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func newSettings(path string) *config.Settings {
+	return &config.Settings{
+		ClusterName:    "cluster",
+		CloudAccountID: "account",
+		Inventory: config.Inventory{
+			Enabled:      true,
+			Path:         path,
+			Interval:     10 * time.Millisecond,
+			Retention:    time.Hour,
+			MaxSnapshots: 2,
+		},
+	}
+}
+
+func TestSnapshotter_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := mocks.NewMockClock(now)
+	resources, err := repo.NewInMemoryResourceRepository(clock)
+	require.NoError(t, err)
+
+	namespace := "default"
+	deletedAt := now.Add(-time.Minute)
+	require.NoError(t, resources.Create(ctx, &types.ResourceTags{
+		Type:         config.Pod,
+		Name:         "web-1",
+		Namespace:    &namespace,
+		MetricLabels: &config.MetricLabels{"namespace": namespace, "pod": "web-1", "workload_kind": "Deployment", "workload_name": "web"},
+		Labels:       &config.MetricLabelTags{"team": "a"},
+		Annotations:  &config.MetricLabelTags{"owner": "me"},
+		DeletedAt:    &deletedAt,
+	}))
+	require.NoError(t, resources.Create(ctx, &types.ResourceTags{
+		Type:         config.Node,
+		Name:         "node-1",
+		MetricLabels: &config.MetricLabels{"node": "node-1"},
+		Labels:       &config.MetricLabelTags{"zone": "a"},
+	}))
+
+	dir := t.TempDir()
+	s := inventory.New(ctx, resources, clock, newSettings(dir))
+	path, err := s.Snapshot(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, dir, filepath.Dir(path))
+	assert.Equal(t, store.InventoryContentIdentifier, store.ParseFileContentIdentifier(path))
+
+	// the snapshot is a valid metric file
+	file, err := store.NewMetricFile(path)
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, file.Verify())
+
+	metrics, err := store.ReadCompressedJSONFile(path)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	byName := map[string]types.Metric{}
+	for _, metric := range metrics {
+		assert.Equal(t, inventory.MetricName, metric.MetricName)
+		assert.Equal(t, "cluster", metric.ClusterName)
+		assert.Equal(t, "account", metric.CloudAccountID)
+		assert.Equal(t, now, metric.TimeStamp)
+		byName[metric.Labels[inventory.LabelResourceName]] = metric
+	}
+
+	pod := byName["web-1"]
+	assert.Equal(t, "pod", pod.Labels[inventory.LabelResourceType])
+	assert.Equal(t, "default", pod.Labels[inventory.LabelNamespace])
+	assert.Equal(t, "Deployment", pod.Labels["workload_kind"])
+	assert.Equal(t, "web", pod.Labels["workload_name"])
+	assert.Equal(t, "a", pod.Labels["label_team"])
+	assert.Equal(t, "me", pod.Labels["annotation_owner"])
+	assert.Equal(t, deletedAt.Format(time.RFC3339Nano), pod.Labels[inventory.LabelDeletedAt])
+	assert.NotEmpty(t, pod.Labels[inventory.LabelRecordCreated])
+	// the snapshot of every replica is told apart
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	assert.Equal(t, hostname, pod.Labels[inventory.LabelReplica])
+
+	node := byName["node-1"]
+	assert.Equal(t, "node", node.Labels[inventory.LabelResourceType])
+	assert.Equal(t, "node-1", node.NodeName)
+	assert.NotContains(t, node.Labels, inventory.LabelNamespace)
+	assert.NotContains(t, node.Labels, inventory.LabelDeletedAt)
+}
+
+func TestSnapshotter_Prune(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := mocks.NewMockClock(now)
+	dir := t.TempDir()
+
+	write := func(stop time.Time) string {
+		path, err := store.WriteCompressedJSONFile(dir, store.InventoryContentIdentifier, stop.Add(-time.Second), stop, nil)
+		require.NoError(t, err)
+		return path
+	}
+	expired := write(now.Add(-2 * time.Hour))
+	oldest := write(now.Add(-30 * time.Minute))
+	older := write(now.Add(-20 * time.Minute))
+	newest := write(now.Add(-10 * time.Minute))
+	// the files of other content and the uploaded snapshots are kept
+	metricsFile, err := store.WriteCompressedJSONFile(dir, store.CostContentIdentifier, now.Add(-3*time.Hour), now.Add(-2*time.Hour), nil)
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "uploaded"), 0o755))
+	uploaded := filepath.Join(dir, "uploaded", filepath.Base(expired))
+	require.NoError(t, os.WriteFile(uploaded, nil, 0o600))
+
+	s := inventory.New(context.Background(), nil, clock, newSettings(dir))
+	require.NoError(t, s.Prune())
+
+	for _, path := range []string{older, newest, metricsFile, uploaded} {
+		assert.FileExists(t, path)
+	}
+	for _, path := range []string{expired, oldest} {
+		assert.NoFileExists(t, path)
+	}
+
+	// a missing directory has nothing to prune
+	require.NoError(t, inventory.New(context.Background(), nil, clock, newSettings(filepath.Join(dir, "missing"))).Prune())
+}
+
+func TestSnapshotter_Run(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	resources, err := repo.NewInMemoryResourceRepository(clock)
+	require.NoError(t, err)
+	require.NoError(t, resources.Create(ctx, &types.ResourceTags{Type: config.Node, Name: "node-1"}))
+
+	dir := t.TempDir()
+	s := inventory.New(ctx, resources, clock, newSettings(dir))
+	require.NoError(t, s.Run())
+	assert.True(t, s.IsRunning())
+
+	assert.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, store.InventoryContentIdentifier+"_*"))
+		return len(files) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Shutdown())
+	assert.False(t, s.IsRunning())
+}
@@ -8,6 +8,7 @@ package k8s
 import (
 	"fmt"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 )
@@ -36,3 +37,19 @@ func NewClient(kubeconfigPath string) (kubernetes.Interface, error) {
 	}
 	return clientset, nil
 }
+
+// NewDynamicClient creates a new dynamic Kubernetes client using the provided kubeconfig file path.
+// The dynamic client works with any kind as unstructured objects, such as the custom resources which have no typed client.
+func NewDynamicClient(kubeconfigPath string) (dynamic.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubeconfig: %w", err)
+	}
+	config.QPS = queriesPerSecond
+	config.Burst = maxBurst
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build dynamic client: %w", err)
+	}
+	return client, nil
+}
@@ -18,6 +18,7 @@ type MetricFilter struct {
 	observability       *filter.FilterChecker
 	costLabels          *filter.FilterChecker
 	observabilityLabels *filter.FilterChecker
+	transformer         *filter.Transformer
 }
 
 // NewMetricFilter creates a new MetricFilter for the given configuration.
@@ -58,6 +59,18 @@ func NewMetricFilter(cfg *config.Metrics) (*MetricFilter, error) {
 		haveFilter = true
 	}
 
+	if len(cfg.LabelTransforms) != 0 {
+		secret, err := filter.ReadSecret(cfg.HashSecretPath)
+		if err != nil {
+			return nil, err
+		}
+		mf.transformer, err = filter.NewTransformer(cfg.LabelTransforms, secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile label transforms: %w", err)
+		}
+		haveFilter = true
+	}
+
 	if !haveFilter {
 		return nil, nil //nolint:nilnil // methods handle nil properly, returning nil allows us to elide code
 	}
@@ -68,7 +81,7 @@ func NewMetricFilter(cfg *config.Metrics) (*MetricFilter, error) {
 // Filter processes the supplied metrics through the filter. It returns two
 // slices, the first being the list of cost metrics, the second being the list
 // of observability metrics, both of which have also had the labels filtered
-// to only include those that match the filter.
+// to only include those that match the filter, and their values transformed.
 func (mf *MetricFilter) Filter(metrics []types.Metric) (costMetrics []types.Metric, observabilityMetrics []types.Metric) {
 	if mf == nil {
 		return metrics, metrics
@@ -87,6 +100,7 @@ func (mf *MetricFilter) Filter(metrics []types.Metric) (costMetrics []types.Metr
 				}
 			}
 
+			costMetric.Labels = mf.transformer.TransformAll(costMetric.Labels)
 			costMetrics = append(costMetrics, costMetric)
 		}
 
@@ -102,6 +116,7 @@ func (mf *MetricFilter) Filter(metrics []types.Metric) (costMetrics []types.Metr
 				}
 			}
 
+			observabilityMetric.Labels = mf.transformer.TransformAll(observabilityMetric.Labels)
 			observabilityMetrics = append(observabilityMetrics, observabilityMetric)
 		}
 	}
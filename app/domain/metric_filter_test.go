@@ -1,6 +1,7 @@
 package domain_test
 
 import (
+	"maps"
 	"testing"
 	"time"
 
@@ -31,6 +32,14 @@ var defaultTestMetric types.Metric = types.Metric{
 	Value: "990",
 }
 
+var transformedTestMetric = func() types.Metric {
+	metric := defaultTestMetric
+	metric.Labels = maps.Clone(defaultTestMetric.Labels)
+	metric.Labels["namespace"] = filter.DefaultRedactedValue
+	metric.Labels["image"] = "602401143452"
+	return metric
+}()
+
 func TestMetricFilter_Filter(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -217,6 +226,33 @@ func TestMetricFilter_Filter(t *testing.T) {
 				defaultTestMetric,
 			},
 		},
+		{
+			name: "label-transforms",
+			cfg: config.Metrics{
+				LabelTransforms: []filter.TransformEntry{
+					{Pattern: "namespace", Match: filter.FilterMatchTypeExact, Action: filter.TransformActionRedact},
+					{Pattern: "^image$", Action: filter.TransformActionTruncate, Length: 12},
+				},
+			},
+			metrics: []types.Metric{
+				defaultTestMetric,
+			},
+			cost: []types.Metric{
+				transformedTestMetric,
+			},
+			observability: []types.Metric{
+				transformedTestMetric,
+			},
+		},
+		{
+			name: "label-transforms-hash-without-secret",
+			cfg: config.Metrics{
+				LabelTransforms: []filter.TransformEntry{
+					{Pattern: "namespace", Action: filter.TransformActionHash},
+				},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
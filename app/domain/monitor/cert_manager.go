@@ -0,0 +1,549 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+const (
+	// DefaultCertificateValidity is used when the validity is not set.
+	DefaultCertificateValidity = 365 * 24 * time.Hour
+	// DefaultCertificateRenewBefore is used when the renewal period is not set.
+	DefaultCertificateRenewBefore = 30 * 24 * time.Hour
+	// DefaultCertificateCheckInterval is how often the Secret and the webhook
+	// configurations are reconciled.
+	DefaultCertificateCheckInterval = time.Minute
+)
+
+// Keys of the Secret the certificates are stored in. The CA bundle holds the
+// current CA first, followed by the previous CA until it expires, so the
+// requests of the API server are trusted while the serving certificate is
+// rotated to a new CA.
+const (
+	secretCABundle = "ca.crt"
+	secretCAKey    = "ca.key"
+)
+
+// certificate skew tolerates clocks which are behind the one of the controller.
+const certificateSkew = 5 * time.Minute
+
+// CertificateManager generates the TLS certificate of the webhook server. A
+// self-signed CA and a serving certificate for the DNS names of the webhook
+// Service are stored in a Secret, so all replicas share them, and the CA is
+// set as the caBundle of the webhook configurations. The certificates are
+// rotated before they expire.
+//
+// It implements TLSProvider, and the rotations are picked up with NeedsReload:
+//
+//	tlsConfig := TLSConfig(WithProvider(m), WithReloadFunc(m.NeedsReload))
+type CertificateManager struct {
+	client      kubernetes.Interface
+	clock       types.TimeProvider
+	settings    config.ManagedCertificate
+	validity    time.Duration
+	renewBefore time.Duration
+
+	// current certificate
+	certMu  sync.RWMutex
+	cert    *tls.Certificate
+	ca      *x509.Certificate
+	rotated atomic.Bool
+
+	// flow control
+	originalCtx context.Context
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	running     bool
+	done        chan struct{}
+}
+
+// NewCertificateManager creates a certificate manager. No certificate is
+// issued until it is run or reconciled.
+func NewCertificateManager(ctx context.Context, client kubernetes.Interface, clock types.TimeProvider, settings *config.Settings) *CertificateManager {
+	managed := settings.Certificate.Managed
+	validity := managed.Validity
+	if validity <= 0 {
+		validity = DefaultCertificateValidity
+	}
+	renewBefore := managed.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = DefaultCertificateRenewBefore
+	}
+	newCtx, cancel := context.WithCancel(ctx)
+	return &CertificateManager{
+		client:      client,
+		clock:       clock,
+		settings:    managed,
+		validity:    validity,
+		renewBefore: renewBefore,
+		originalCtx: ctx,
+		ctx:         newCtx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// Certificates implements TLSProvider.
+func (m *CertificateManager) Certificates() (*tls.Certificate, []*x509.Certificate, error) {
+	m.certMu.RLock()
+	defer m.certMu.RUnlock()
+	if m.cert == nil {
+		return nil, nil, errors.New("no certificate was issued yet")
+	}
+	return m.cert, []*x509.Certificate{m.ca}, nil
+}
+
+// NeedsReload returns true once after the certificate changed.
+func (m *CertificateManager) NeedsReload() bool {
+	return m.rotated.Swap(false)
+}
+
+// Run reconciles the certificate once, so the server can start with it, and
+// then periodically in the background.
+func (m *CertificateManager) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		return nil
+	}
+	if err := m.Reconcile(m.ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(DefaultCertificateCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		defer close(m.done)
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Reconcile(m.ctx); err != nil {
+					log.Ctx(m.ctx).Err(err).Msg("failed to reconcile the webhook certificate")
+				}
+			}
+		}
+	}()
+	m.running = true
+	return nil
+}
+
+// Shutdown implements types.Runnable.
+func (m *CertificateManager) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.running {
+		return nil
+	}
+	m.cancel()
+	<-m.done
+	m.reset()
+	return nil
+}
+
+func (m *CertificateManager) reset() {
+	m.running = false
+	ctx, cancel := context.WithCancel(m.originalCtx)
+	m.ctx = ctx
+	m.cancel = cancel
+	m.done = make(chan struct{})
+}
+
+func (m *CertificateManager) IsRunning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running
+}
+
+// Reconcile issues the certificates which are missing or close to expiry,
+// stores them in the Secret, patches the caBundle of the webhook
+// configurations and loads the serving certificate.
+func (m *CertificateManager) Reconcile(ctx context.Context) error {
+	var (
+		secret *corev1.Secret
+		err    error
+	)
+	// replicas may issue a certificate at the same time, in which case the one
+	// which lost the race reads the certificate of the other
+	for attempt := 0; attempt < 3; attempt++ {
+		secret, err = m.reconcileSecret(ctx)
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.patchWebhooks(ctx, secret.Data[secretCABundle]); err != nil {
+		return err
+	}
+	return m.load(secret)
+}
+
+// reconcileSecret returns the Secret, after storing new certificates in it
+// when they need to be issued.
+func (m *CertificateManager) reconcileSecret(ctx context.Context) (*corev1.Secret, error) {
+	secrets := m.client.CoreV1().Secrets(m.settings.Namespace)
+	secret, err := secrets.Get(ctx, m.settings.SecretName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get the certificate secret: %w", err)
+	}
+
+	var data map[string][]byte
+	if secret != nil {
+		data = secret.Data
+	}
+	issued, err := m.issue(data)
+	if err != nil {
+		return nil, err
+	}
+	if issued == nil {
+		return secret, nil
+	}
+
+	if secret == nil {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: m.settings.SecretName, Namespace: m.settings.Namespace},
+			Type:       corev1.SecretTypeTLS,
+			Data:       issued,
+		}
+		return secrets.Create(ctx, secret, metav1.CreateOptions{})
+	}
+	secret = secret.DeepCopy()
+	secret.Data = issued
+	return secrets.Update(ctx, secret, metav1.UpdateOptions{})
+}
+
+// issue returns the data of the Secret with new certificates, or nil when the
+// certificates in it are still valid.
+func (m *CertificateManager) issue(data map[string][]byte) (map[string][]byte, error) {
+	now := m.clock.GetCurrentTime()
+	renewAt := now.Add(m.renewBefore)
+
+	ca, caKey, previous := parseCA(data)
+	bundle := data[secretCABundle]
+	newCA := ca == nil || renewAt.After(ca.NotAfter)
+	if newCA {
+		var err error
+		ca, caKey, err = m.newCA(now)
+		if err != nil {
+			return nil, err
+		}
+		// the previous CA is trusted until it expires
+		bundle = encodeCertificate(ca.Raw)
+		for _, cert := range previous {
+			if now.Before(cert.NotAfter) {
+				bundle = append(bundle, encodeCertificate(cert.Raw)...)
+			}
+		}
+	}
+
+	if !newCA && m.validServing(data, ca, renewAt) {
+		return nil, nil
+	}
+
+	certPEM, keyPEM, err := m.newServing(now, ca, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caKeyPEM, err := encodeKey(caKey)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().
+		Bool("new_ca", newCA).
+		Str("secret", m.settings.SecretName).
+		Msg("Issued a new webhook certificate")
+	return map[string][]byte{
+		secretCABundle:          bundle,
+		secretCAKey:             caKeyPEM,
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+	}, nil
+}
+
+// validServing returns true when the serving certificate in the Secret was
+// signed by the CA, is issued for the DNS names of the Service, and is not
+// close to expiry.
+func (m *CertificateManager) validServing(data map[string][]byte, ca *x509.Certificate, renewAt time.Time) bool {
+	pair, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	if err != nil || pair.Leaf == nil {
+		return false
+	}
+	leaf := pair.Leaf
+	if renewAt.After(leaf.NotAfter) || leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	for _, name := range m.dnsNames() {
+		if !slices.Contains(leaf.DNSNames, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// dnsNames returns the names the API server may reach the Service with.
+func (m *CertificateManager) dnsNames() []string {
+	service := m.settings.ServiceName
+	namespace := m.settings.Namespace
+	return []string{
+		service,
+		service + "." + namespace,
+		service + "." + namespace + ".svc",
+		service + "." + namespace + ".svc.cluster.local",
+	}
+}
+
+func (m *CertificateManager) newCA(now time.Time) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate the CA key: %w", err)
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: m.settings.ServiceName + "-ca"},
+		NotBefore:             now.Add(-certificateSkew),
+		NotAfter:              now.Add(m.validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the CA certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ca, key, nil
+}
+
+func (m *CertificateManager) newServing(now time.Time, ca *x509.Certificate, caKey crypto.Signer) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate the serving key: %w", err)
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	names := m.dnsNames()
+	// the serving certificate does not outlive its CA
+	notAfter := now.Add(m.validity)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[2]},
+		DNSNames:     names,
+		NotBefore:    now.Add(-certificateSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the serving certificate: %w", err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCertificate(der), keyPEM, nil
+}
+
+// patchWebhooks sets the CA bundle in the webhook configurations which do not
+// have it yet. A configuration which does not exist is skipped, as the webhook
+// may be disabled.
+func (m *CertificateManager) patchWebhooks(ctx context.Context, bundle []byte) error {
+	var errs []error
+	validating := m.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	for _, name := range m.settings.ValidatingWebhooks {
+		cfg, err := validating.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			log.Ctx(ctx).Debug().Str("name", name).Msg("Validating webhook configuration not found")
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get the validating webhook configuration %s: %w", name, err))
+			continue
+		}
+		clientConfigs := make([]*admissionregistrationv1.WebhookClientConfig, 0, len(cfg.Webhooks))
+		for i := range cfg.Webhooks {
+			clientConfigs = append(clientConfigs, &cfg.Webhooks[i].ClientConfig)
+		}
+		if !setCABundle(clientConfigs, bundle) {
+			continue
+		}
+		if _, err := validating.Update(ctx, cfg, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to patch the caBundle of the validating webhook configuration %s: %w", name, err))
+			continue
+		}
+		log.Ctx(ctx).Info().Str("name", name).Msg("Patched the caBundle of the validating webhook configuration")
+	}
+
+	mutating := m.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	for _, name := range m.settings.MutatingWebhooks {
+		cfg, err := mutating.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			log.Ctx(ctx).Debug().Str("name", name).Msg("Mutating webhook configuration not found")
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get the mutating webhook configuration %s: %w", name, err))
+			continue
+		}
+		clientConfigs := make([]*admissionregistrationv1.WebhookClientConfig, 0, len(cfg.Webhooks))
+		for i := range cfg.Webhooks {
+			clientConfigs = append(clientConfigs, &cfg.Webhooks[i].ClientConfig)
+		}
+		if !setCABundle(clientConfigs, bundle) {
+			continue
+		}
+		if _, err := mutating.Update(ctx, cfg, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to patch the caBundle of the mutating webhook configuration %s: %w", name, err))
+			continue
+		}
+		log.Ctx(ctx).Info().Str("name", name).Msg("Patched the caBundle of the mutating webhook configuration")
+	}
+	return errors.Join(errs...)
+}
+
+// setCABundle sets the CA bundle of the client configurations, and returns
+// true when any of them changed.
+func setCABundle(clientConfigs []*admissionregistrationv1.WebhookClientConfig, bundle []byte) bool {
+	changed := false
+	for _, clientConfig := range clientConfigs {
+		if !bytes.Equal(clientConfig.CABundle, bundle) {
+			clientConfig.CABundle = bundle
+			changed = true
+		}
+	}
+	return changed
+}
+
+// load uses the serving certificate of the Secret, when it changed.
+func (m *CertificateManager) load(secret *corev1.Secret) error {
+	certPEM := secret.Data[corev1.TLSCertKey]
+	m.certMu.RLock()
+	same := m.cert != nil && bytes.Equal(m.cert.Certificate[0], decodeCertificate(certPEM))
+	m.certMu.RUnlock()
+	if same {
+		return nil
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("failed to load the serving certificate: %w", err)
+	}
+	ca, _, _ := parseCA(secret.Data)
+	if ca == nil {
+		return errors.New("failed to load the CA certificate")
+	}
+
+	m.certMu.Lock()
+	m.cert = &pair
+	m.ca = ca
+	m.certMu.Unlock()
+	m.rotated.Store(true)
+	return nil
+}
+
+// parseCA returns the current CA and its key, along with the certificates of
+// the bundle. The CA is nil when the Secret has no valid CA.
+func parseCA(data map[string][]byte) (*x509.Certificate, crypto.Signer, []*x509.Certificate) {
+	var bundle []*x509.Certificate
+	rest := data[secretCABundle]
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			bundle = append(bundle, cert)
+		}
+	}
+
+	block, _ := pem.Decode(data[secretCAKey])
+	if len(bundle) == 0 || block == nil {
+		return nil, nil, bundle
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, bundle
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !bundle[0].IsCA {
+		return nil, nil, bundle
+	}
+	return bundle[0], signer, bundle
+}
+
+func serialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a serial number: %w", err)
+	}
+	return serial, nil
+}
+
+func encodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func decodeCertificate(data []byte) []byte {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil
+	}
+	return block.Bytes
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
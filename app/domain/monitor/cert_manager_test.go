@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package monitor
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func newManagedSettings() *config.Settings {
+	return &config.Settings{
+		Certificate: config.Certificate{
+			Managed: config.ManagedCertificate{
+				Enabled:            true,
+				SecretName:         "webhook-tls",
+				Namespace:          "cloudzero",
+				ServiceName:        "webhook-svc",
+				Validity:           90 * 24 * time.Hour,
+				RenewBefore:        10 * 24 * time.Hour,
+				ValidatingWebhooks: []string{"webhook-pods", "webhook-missing"},
+				MutatingWebhooks:   []string{"webhook-mutate"},
+			},
+		},
+	}
+}
+
+// verifyServing checks that the serving certificate of the manager is trusted
+// by the CA bundle of a webhook, for the DNS name of the Service.
+func verifyServing(t *testing.T, m *CertificateManager, bundle []byte, at time.Time) {
+	t.Helper()
+	cert, roots, err := m.Certificates()
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(bundle))
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:     "webhook-svc.cloudzero.svc",
+		Roots:       pool,
+		CurrentTime: at,
+	})
+	require.NoError(t, err)
+}
+
+func countCertificates(data []byte) int {
+	n := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return n
+		}
+		n++
+	}
+}
+
+func TestCertificateManager_Reconcile(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := mocks.NewMockClock(now)
+	client := fake.NewClientset(
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-pods"},
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "pods.cloudzero.com"}},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-mutate"},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "mutate.cloudzero.com"}},
+		},
+	)
+	settings := newManagedSettings()
+	m := NewCertificateManager(ctx, client, clock, settings)
+
+	bundles := func() ([]byte, []byte) {
+		validating, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "webhook-pods", metav1.GetOptions{})
+		require.NoError(t, err)
+		mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "webhook-mutate", metav1.GetOptions{})
+		require.NoError(t, err)
+		return validating.Webhooks[0].ClientConfig.CABundle, mutating.Webhooks[0].ClientConfig.CABundle
+	}
+	secret := func() *corev1.Secret {
+		s, err := client.CoreV1().Secrets("cloudzero").Get(ctx, "webhook-tls", metav1.GetOptions{})
+		require.NoError(t, err)
+		return s
+	}
+
+	_, _, err := m.Certificates()
+	require.Error(t, err, "no certificate before the first reconcile")
+
+	// the certificates are issued, stored and trusted by the webhooks
+	require.NoError(t, m.Reconcile(ctx))
+	issued := secret()
+	validating, mutating := bundles()
+	assert.Equal(t, issued.Data["ca.crt"], validating)
+	assert.Equal(t, issued.Data["ca.crt"], mutating)
+	verifyServing(t, m, validating, now)
+	assert.True(t, m.NeedsReload())
+	assert.False(t, m.NeedsReload())
+
+	// nothing changes while the certificates are valid
+	clock.SetCurrentTime(now.Add(24 * time.Hour))
+	require.NoError(t, m.Reconcile(ctx))
+	assert.Equal(t, issued.Data, secret().Data)
+	assert.False(t, m.NeedsReload())
+
+	// another replica uses the stored certificates
+	other := NewCertificateManager(ctx, client, clock, settings)
+	require.NoError(t, other.Reconcile(ctx))
+	assert.Equal(t, issued.Data, secret().Data)
+	otherCert, _, err := other.Certificates()
+	require.NoError(t, err)
+	cert, _, err := m.Certificates()
+	require.NoError(t, err)
+	assert.Equal(t, cert.Certificate, otherCert.Certificate)
+
+	// the certificates are rotated before they expire, and the previous CA is
+	// trusted until it expires
+	rotateAt := now.Add(85 * 24 * time.Hour)
+	clock.SetCurrentTime(rotateAt)
+	require.NoError(t, m.Reconcile(ctx))
+	rotated := secret()
+	assert.NotEqual(t, issued.Data["tls.crt"], rotated.Data["tls.crt"])
+	assert.Equal(t, 2, countCertificates(rotated.Data["ca.crt"]))
+	validating, mutating = bundles()
+	assert.Equal(t, rotated.Data["ca.crt"], validating)
+	assert.Equal(t, rotated.Data["ca.crt"], mutating)
+	verifyServing(t, m, validating, rotateAt)
+	assert.True(t, m.NeedsReload())
+}
+
+func TestCertificateManager_ReissuesForService(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	client := fake.NewClientset()
+	settings := newManagedSettings()
+
+	m := NewCertificateManager(ctx, client, clock, settings)
+	require.NoError(t, m.Reconcile(ctx))
+	before, err := client.CoreV1().Secrets("cloudzero").Get(ctx, "webhook-tls", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	// the serving certificate is reissued by the same CA for a renamed service
+	settings.Certificate.Managed.ServiceName = "renamed-svc"
+	m = NewCertificateManager(ctx, client, clock, settings)
+	require.NoError(t, m.Reconcile(ctx))
+	after, err := client.CoreV1().Secrets("cloudzero").Get(ctx, "webhook-tls", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, before.Data["ca.crt"], after.Data["ca.crt"])
+	assert.NotEqual(t, before.Data["tls.crt"], after.Data["tls.crt"])
+
+	cert, _, err := m.Certificates()
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Contains(t, leaf.DNSNames, "renamed-svc.cloudzero.svc")
+}
+
+func TestCertificateManager_TLSConfig(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewMockClock(time.Now())
+	m := NewCertificateManager(ctx, fake.NewClientset(), clock, newManagedSettings())
+	require.NoError(t, m.Run())
+	defer func() { require.NoError(t, m.Shutdown()) }()
+	assert.True(t, m.IsRunning())
+
+	// the server presents the issued certificate
+	cfg := TLSConfig(WithProvider(m), WithReloadFunc(m.NeedsReload))
+	cert, err := cfg.GetCertificate(nil)
+	require.NoError(t, err)
+	issued, _, err := m.Certificates()
+	require.NoError(t, err)
+	assert.Equal(t, issued, cert)
+}
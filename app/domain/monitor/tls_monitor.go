@@ -367,6 +367,9 @@ type reconciler struct {
 	// onReload is a callback function invoked after a successful certificate reload.
 	// It can be used for additional actions like rotating session tickets or logging.
 	onReload func(*tls.Config)
+
+	// status records the result of each reload, when set with WithStatus.
+	status *CertificateStatus
 }
 
 // getCertificate retrieves the current TLS certificate for server-side TLS configurations.
@@ -471,7 +474,15 @@ func (r *reconciler) certificates() (cert *tls.Certificate, pool *x509.CertPool,
 		_, err, _ = r.flight.Do("reconciler", func() (interface{}, error) {
 			// Retrieve certificates from the provider.
 			latestCert, roots, certErr := r.p.Certificates()
+			// Record the result of the reload, when tracked.
+			if r.status != nil {
+				r.status.observe(latestCert, certErr)
+			}
 			if certErr != nil {
+				// Indicate that the reload has completed, so the previous
+				// certificate is still served.
+				atomic.StoreUint32(&r.reloading, 0)
+				r.cond.Broadcast()
 				return nil, certErr
 			}
 
@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package monitor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultCertificateExpiryWindow is used when the expiry window is not set.
+const DefaultCertificateExpiryWindow = 7 * 24 * time.Hour
+
+var (
+	certificateStatsOnce sync.Once
+	// CertificateNotBefore is the time the served certificate is valid from.
+	CertificateNotBefore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_not_before_timestamp_seconds",
+			Help: "Time the served TLS certificate is valid from, in seconds since the epoch.",
+		},
+		[]string{"subject", "issuer"},
+	)
+	// CertificateNotAfter is the time the served certificate expires.
+	CertificateNotAfter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_not_after_timestamp_seconds",
+			Help: "Time the served TLS certificate expires, in seconds since the epoch.",
+		},
+		[]string{"subject", "issuer"},
+	)
+	// CertificateInfo describes the served certificate.
+	CertificateInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_info",
+			Help: "Information about the served TLS certificate, always 1.",
+		},
+		[]string{"subject", "issuer", "sans", "serial"},
+	)
+	// CertificateLastReload is the time the certificate was last reloaded.
+	CertificateLastReload = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_last_reload_timestamp_seconds",
+			Help: "Time the TLS certificate was last reloaded, in seconds since the epoch.",
+		},
+	)
+	// CertificateLastReloadSuccess is whether the last reload succeeded.
+	CertificateLastReloadSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_last_reload_success",
+			Help: "Whether the last reload of the TLS certificate succeeded, 1 or 0.",
+		},
+	)
+	// CertificateExpiring is whether the served certificate expires within
+	// the expiry window.
+	CertificateExpiring = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_expiring",
+			Help: "Whether the served TLS certificate expires within the expiry window, 1 or 0.",
+		},
+	)
+	// CertificateDiskMismatch is whether the served certificate differs from
+	// the one on disk.
+	CertificateDiskMismatch = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tls_certificate_disk_mismatch",
+			Help: "Whether the served TLS certificate differs from the certificate file on disk, 1 or 0.",
+		},
+	)
+)
+
+// CertificateStatus tracks the certificate served by a reconciler. It reports
+// the certificate as metrics, and its Check warns when the certificate is close
+// to expiry. An expiring certificate does not fail the Check, as restarting the
+// pods does not renew it, but fails the ExpiryCheck, which is served on an
+// endpoint of its own.
+//
+// Example:
+//
+//	status := NewCertificateStatus(7*24*time.Hour, "/path/to/cert.pem")
+//	tlsConfig := TLSConfig(WithCertificatesPaths("/path/to/cert.pem", "/path/to/key.pem", ""), WithStatus(status))
+//	healthz.Register("tls-certificate", status.Check)
+//	mux.Handle("/healthz/certificate", healthz.CheckHandler("tls-certificate-expiry", status.ExpiryCheck))
+type CertificateStatus struct {
+	expiryWindow time.Duration
+	certPath     string
+	now          func() time.Time
+
+	mu         sync.Mutex
+	leaf       *x509.Certificate
+	lastErr    error
+	lastReload time.Time
+	mismatch   bool
+	expiring   bool
+}
+
+// NewCertificateStatus creates the status of a served certificate, which is
+// reported as expiring within the expiry window before the certificate expires. The served
+// certificate is compared to the certificate file, unless the path is empty.
+func NewCertificateStatus(expiryWindow time.Duration, certPath string) *CertificateStatus {
+	certificateStatsOnce.Do(func() {
+		prometheus.MustRegister(
+			CertificateNotBefore,
+			CertificateNotAfter,
+			CertificateInfo,
+			CertificateLastReload,
+			CertificateLastReloadSuccess,
+			CertificateExpiring,
+			CertificateDiskMismatch,
+		)
+	})
+	if expiryWindow <= 0 {
+		expiryWindow = DefaultCertificateExpiryWindow
+	}
+	return &CertificateStatus{
+		expiryWindow: expiryWindow,
+		certPath:     certPath,
+		now:          time.Now,
+	}
+}
+
+// WithStatus records the certificates loaded by the reconciler in the status.
+//
+// Example:
+//
+//	tlsConfig := TLSConfig(WithStatus(NewCertificateStatus(0, "")))
+func WithStatus(s *CertificateStatus) Option {
+	return optionFunc(func(r *reconciler) {
+		r.status = s
+	})
+}
+
+// observe records the result of a reload.
+func (s *CertificateStatus) observe(cert *tls.Certificate, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastReload = s.now()
+	s.lastErr = err
+	CertificateLastReload.Set(float64(s.lastReload.Unix()))
+	if err != nil {
+		// the previous certificate is still served
+		CertificateLastReloadSuccess.Set(0)
+		log.Warn().Err(err).Msg("Failed to reload the TLS certificate")
+		return
+	}
+	CertificateLastReloadSuccess.Set(1)
+
+	leaf, err := leafOf(cert)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to parse the TLS certificate")
+		return
+	}
+	s.leaf = leaf
+
+	// only the served certificate is reported
+	CertificateNotBefore.Reset()
+	CertificateNotAfter.Reset()
+	CertificateInfo.Reset()
+	subject, issuer := leaf.Subject.String(), leaf.Issuer.String()
+	CertificateNotBefore.WithLabelValues(subject, issuer).Set(float64(leaf.NotBefore.Unix()))
+	CertificateNotAfter.WithLabelValues(subject, issuer).Set(float64(leaf.NotAfter.Unix()))
+	CertificateInfo.WithLabelValues(subject, issuer, strings.Join(leaf.DNSNames, ","), leaf.SerialNumber.String()).Set(1)
+	log.Info().
+		Str("subject", subject).
+		Str("issuer", issuer).
+		Strs("sans", leaf.DNSNames).
+		Time("not_before", leaf.NotBefore).
+		Time("not_after", leaf.NotAfter).
+		Msg("Loaded the TLS certificate")
+}
+
+// Check is a health check, which only fails when no certificate could be
+// loaded. A served certificate which expires within the expiry window, or which
+// differs from the file on disk, is reported by the metrics and warned about,
+// as it is still valid and a restart would not renew it.
+func (s *CertificateStatus) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leaf == nil {
+		if s.lastErr != nil {
+			return fmt.Errorf("no TLS certificate was loaded: %w", s.lastErr)
+		}
+		// nothing was served yet
+		return nil
+	}
+
+	s.checkDisk()
+	s.checkExpiry()
+	return nil
+}
+
+// ExpiryCheck is a health check, which fails when the served certificate
+// expires within the expiry window, or when no certificate could be loaded.
+func (s *CertificateStatus) ExpiryCheck() error {
+	if err := s.Check(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leaf != nil && s.expiring {
+		return fmt.Errorf("the TLS certificate expires within %s, at %s", s.expiryWindow, s.leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkExpiry warns when the served certificate enters the expiry window.
+func (s *CertificateStatus) checkExpiry() {
+	expiresIn := s.leaf.NotAfter.Sub(s.now())
+	expiring := expiresIn < s.expiryWindow
+	if expiring && !s.expiring {
+		log.Warn().
+			Time("not_after", s.leaf.NotAfter).
+			Dur("expires_in", expiresIn.Truncate(time.Second)).
+			Msg("The served TLS certificate expires soon; renew it")
+	}
+	s.expiring = expiring
+	if expiring {
+		CertificateExpiring.Set(1)
+	} else {
+		CertificateExpiring.Set(0)
+	}
+}
+
+// checkDisk compares the served certificate to the certificate file, and warns
+// when the served certificate changes from matching to not matching it.
+func (s *CertificateStatus) checkDisk() {
+	if s.certPath == "" {
+		return
+	}
+	mismatch, err := s.differsFromDisk()
+	if err != nil {
+		log.Debug().Err(err).Str("path", s.certPath).Msg("Failed to read the TLS certificate file")
+		return
+	}
+	if mismatch && !s.mismatch {
+		log.Warn().Str("path", s.certPath).Msg("The served TLS certificate does not match the certificate file; send SIGHUP to reload it")
+	}
+	s.mismatch = mismatch
+	if mismatch {
+		CertificateDiskMismatch.Set(1)
+	} else {
+		CertificateDiskMismatch.Set(0)
+	}
+}
+
+func (s *CertificateStatus) differsFromDisk() (bool, error) {
+	data, err := os.ReadFile(s.certPath)
+	if err != nil {
+		return false, err
+	}
+	der := decodeCertificate(data)
+	if der == nil {
+		return false, errors.New("no certificate in the file")
+	}
+	return !bytes.Equal(der, s.leaf.Raw), nil
+}
+
+func leafOf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
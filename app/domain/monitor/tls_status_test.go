@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned returns a certificate for a name, which expires at the given time.
+func selfSigned(t *testing.T, name string, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type staticProvider struct {
+	cert *tls.Certificate
+	err  error
+}
+
+func (p *staticProvider) Certificates() (*tls.Certificate, []*x509.Certificate, error) {
+	return p.cert, nil, p.err
+}
+
+func TestCertificateStatus_Check(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	notAfter := now.Add(30 * 24 * time.Hour)
+	status := NewCertificateStatus(7*24*time.Hour, "")
+	status.now = func() time.Time { return now }
+
+	// nothing was served yet
+	require.NoError(t, status.Check())
+
+	status.observe(selfSigned(t, "webhook.svc", notAfter), nil)
+	require.NoError(t, status.Check())
+	assert.Equal(t, 1.0, testutil.ToFloat64(CertificateLastReloadSuccess))
+	assert.Equal(t, float64(now.Unix()), testutil.ToFloat64(CertificateLastReload))
+	assert.Equal(t, float64(notAfter.Unix()), testutil.ToFloat64(CertificateNotAfter.WithLabelValues("CN=webhook.svc", "CN=webhook.svc")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(CertificateInfo.WithLabelValues("CN=webhook.svc", "CN=webhook.svc", "webhook.svc", "1")))
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(CertificateExpiring))
+
+	require.NoError(t, status.ExpiryCheck())
+
+	// an expiring certificate is reported without failing the check, and
+	// fails the expiry check
+	now = notAfter.Add(-24 * time.Hour)
+	require.NoError(t, status.Check())
+	assert.Equal(t, 1.0, testutil.ToFloat64(CertificateExpiring))
+	require.Error(t, status.ExpiryCheck())
+
+	// a failed reload keeps the previous certificate
+	status.observe(nil, assert.AnError)
+	assert.Equal(t, 0.0, testutil.ToFloat64(CertificateLastReloadSuccess))
+	assert.Equal(t, 1, testutil.CollectAndCount(CertificateNotAfter))
+
+	// no certificate could be loaded at all
+	status = NewCertificateStatus(0, "")
+	status.observe(nil, assert.AnError)
+	require.ErrorIs(t, status.Check(), assert.AnError)
+	require.ErrorIs(t, status.ExpiryCheck(), assert.AnError)
+}
+
+func TestCertificateStatus_DiskMismatch(t *testing.T) {
+	notAfter := time.Now().Add(30 * 24 * time.Hour)
+	served := selfSigned(t, "webhook.svc", notAfter)
+	path := filepath.Join(t.TempDir(), "tls.crt")
+	require.NoError(t, os.WriteFile(path, encodeCertificate(served.Certificate[0]), 0o600))
+
+	status := NewCertificateStatus(0, path)
+	status.observe(served, nil)
+	require.NoError(t, status.Check())
+	assert.Equal(t, 0.0, testutil.ToFloat64(CertificateDiskMismatch))
+
+	// the file was rotated, but not reloaded
+	rotated := selfSigned(t, "webhook.svc", notAfter.Add(time.Hour))
+	require.NoError(t, os.WriteFile(path, encodeCertificate(rotated.Certificate[0]), 0o600))
+	require.NoError(t, status.Check(), "a mismatch does not fail the check")
+	assert.Equal(t, 1.0, testutil.ToFloat64(CertificateDiskMismatch))
+}
+
+func TestWithStatus(t *testing.T) {
+	provider := &staticProvider{err: assert.AnError}
+	status := NewCertificateStatus(0, "")
+	reloads := 0
+	cfg := TLSConfig(WithProvider(provider), WithStatus(status), WithReloadFunc(func() bool {
+		reloads++
+		return true
+	}))
+
+	// a failed reload is reported, and does not block the next one
+	_, err := cfg.GetCertificate(nil)
+	require.ErrorIs(t, err, assert.AnError)
+	require.Error(t, status.Check())
+
+	provider.cert, provider.err = selfSigned(t, "webhook.svc", time.Now().Add(30*24*time.Hour)), nil
+	cert, err := cfg.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, provider.cert, cert)
+	require.NoError(t, status.Check())
+	assert.Equal(t, 1, reloads)
+}
@@ -12,21 +12,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/snappy"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/exp/rand"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/protoadapt"
 
 	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/cluster"
 	"github.com/cloudzero/cloudzero-agent/app/types"
 )
 
@@ -108,18 +115,40 @@ var (
 	)
 )
 
+const (
+	// DefaultBatchUpdateSize is used when the batch update size is not set.
+	DefaultBatchUpdateSize = 500
+	// DefaultPageSize is used when the page size is not set.
+	DefaultPageSize = 1000
+)
+
+const (
+	contentTypeV1            = "application/x-protobuf"
+	contentTypeV2            = "application/x-protobuf;proto=io.prometheus.write.v2.Request"
+	remoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+)
+
 // MetricsPusher is a runnable that periodically flushes metrics to a remote write endpoint.
 type MetricsPusher struct {
 	// interfaces
-	clock types.TimeProvider
-	store types.ResourceStore
+	clock   types.TimeProvider
+	store   types.ResourceStore
+	history types.ResourceHistoryStore
 
 	// settings
-	sendTimeout  time.Duration
-	sendInterval time.Duration
-	sentMaxBytes int
-	maxRetries   int
-	settings     *config.Settings
+	sendTimeout     time.Duration
+	sendInterval    time.Duration
+	sentMaxBytes    int
+	maxRetries      int
+	batchUpdateSize int
+	pageSize        int
+	settings        *config.Settings
+	clusters        *cluster.Mapper
+
+	// remote write
+	client     *http.Client
+	protocolMu sync.Mutex
+	protocol   string
 
 	// flow controle
 	originalCtx context.Context
@@ -130,11 +159,22 @@ type MetricsPusher struct {
 	done        chan struct{}
 }
 
+type Option func(h *MetricsPusher)
+
+// WithClusterMapper sends the records of the logical clusters to their own
+// remote write URL.
+func WithClusterMapper(clusters *cluster.Mapper) Option {
+	return func(h *MetricsPusher) {
+		h.clusters = clusters
+	}
+}
+
 func New(
 	ctx context.Context,
 	store types.ResourceStore,
 	clock types.TimeProvider,
 	settings *config.Settings,
+	opts ...Option,
 ) types.Runnable {
 	remoteWriteStatsOnce.Do(func() {
 		prometheus.MustRegister(
@@ -148,20 +188,43 @@ func New(
 			RemoteWriteDBFailures,
 		)
 	})
+	batchUpdateSize := settings.Database.BatchUpdateSize
+	if batchUpdateSize <= 0 {
+		batchUpdateSize = DefaultBatchUpdateSize
+	}
+	pageSize := settings.RemoteWrite.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	protocol := settings.RemoteWrite.Protocol
+	if protocol == "" {
+		protocol = config.RemoteWriteProtocolV1
+	}
+	// the label history is sent when the store keeps one
+	history, _ := store.(types.ResourceHistoryStore)
 	newCtx, cancel := context.WithCancel(ctx)
-	return &MetricsPusher{
-		settings:     settings,
-		originalCtx:  ctx,
-		ctx:          newCtx,
-		cancel:       cancel,
-		done:         make(chan struct{}),
-		clock:        clock,
-		store:        store,
-		sendTimeout:  settings.RemoteWrite.SendTimeout,
-		sendInterval: settings.RemoteWrite.SendInterval,
-		sentMaxBytes: settings.RemoteWrite.MaxBytesPerSend,
-		maxRetries:   settings.RemoteWrite.MaxRetries,
+	h := &MetricsPusher{
+		settings:        settings,
+		originalCtx:     ctx,
+		ctx:             newCtx,
+		cancel:          cancel,
+		done:            make(chan struct{}),
+		clock:           clock,
+		store:           store,
+		history:         history,
+		sendTimeout:     settings.RemoteWrite.SendTimeout,
+		sendInterval:    settings.RemoteWrite.SendInterval,
+		sentMaxBytes:    settings.RemoteWrite.MaxBytesPerSend,
+		maxRetries:      settings.RemoteWrite.MaxRetries,
+		batchUpdateSize: batchUpdateSize,
+		pageSize:        pageSize,
+		client:          newHTTPClient(),
+		protocol:        protocol,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *MetricsPusher) ResetStats() {
@@ -236,97 +299,130 @@ func (h *MetricsPusher) IsRunning() bool {
 	return h.running
 }
 
-func (h *MetricsPusher) sendBatch(batch []*types.ResourceTags) error {
+// sendBatch sends a batch, split by cluster, and returns the records which
+// were sent. The batch of every cluster is sent even when the batch of another
+// cluster fails. The unsent versions of the records are given by record ID.
+func (h *MetricsPusher) sendBatch(batch []*types.ResourceTags, versions map[string][]*types.ResourceTagsVersion) ([]*types.ResourceTags, error) {
 	if len(batch) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	endpoint := h.settings.RemoteWrite.Host
 	apiToken := h.settings.GetAPIKey()
 	if apiToken == "" {
-		RemoteWriteFailures.WithLabelValues(endpoint).Inc()
-		return errors.New("API key is empty")
+		RemoteWriteFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
+		return nil, errors.New("API key is empty")
 	}
 
-	ts := h.formatMetrics(batch)
-	log.Ctx(h.ctx).Debug().
-		Int("record_count", len(ts)).
-		Msg("Pushing records to remote write endpoint")
+	sent := []*types.ResourceTags{}
+	var errs []error
+	for _, clusterBatch := range h.clusters.Split(h.ctx, cluster.HostCluster(h.settings), batch) {
+		endpoint := clusterBatch.Cluster.RemoteWriteHost
+		ts := h.formatMetrics(clusterBatch.Records, versions)
+		log.Ctx(h.ctx).Debug().
+			Str("cluster_name", clusterBatch.Cluster.Name).
+			Int("record_count", len(ts)).
+			Msg("Pushing records to remote write endpoint")
+
+		if err := h.pushMetrics(endpoint, apiToken, ts); err != nil {
+			RemoteWriteFailures.WithLabelValues(endpoint).Inc()
+			errs = append(errs, fmt.Errorf("failed to push metrics of cluster '%s' to remote write: %v", clusterBatch.Cluster.Name, err))
+			continue
+		}
 
-	if err := h.pushMetrics(h.settings.RemoteWrite.Host, apiToken, ts); err != nil {
-		RemoteWriteFailures.WithLabelValues(endpoint).Inc()
-		return fmt.Errorf("failed to push metrics to remote write: %v", err)
+		RemoteWriteRecordsProcessed.WithLabelValues(endpoint).Add(float64(len(clusterBatch.Records)))
+		RemoteWriteTimeseriesSent.WithLabelValues(endpoint).Add(float64(len(ts)))
+		sent = append(sent, clusterBatch.Records...)
 	}
-
-	RemoteWriteRecordsProcessed.WithLabelValues(endpoint).Add(float64(len(batch)))
-	RemoteWriteTimeseriesSent.WithLabelValues(endpoint).Add(float64(len(ts)))
-	return nil
+	return sent, errors.Join(errs...)
 }
 
+// Flush sends the unsent records. The records are read a page at a time, with
+// the unsent versions of the page, and marked as sent after each batch, so a
+// failed batch does not cause the earlier batches to be sent again.
 func (h *MetricsPusher) Flush() error {
 	log.Ctx(h.ctx).Debug().Msg("Starting flush operation")
 	ctx := context.Background()
 	currentTime := h.clock.GetCurrentTime()
 	whereClause := "sent_at IS NULL"
-	found, err := h.store.FindAllBy(ctx, whereClause)
-	if err != nil {
-		RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
-		log.Ctx(h.ctx).Err(err).Msg("Failed to find records to send")
-		return fmt.Errorf("failed to find records to send: %v", err)
-	}
-	log.Ctx(h.ctx).Debug().Int("count", len(found)).Msg("Found records to send")
 	totalSize := 0
 	batch := []*types.ResourceTags{}
-	completed := []*types.ResourceTags{}
-	for len(found) > 0 {
-		next := found[0]
-		found = found[1:] // pop
-		namespace := ""
-		if next.Namespace != nil {
-			namespace = *next.Namespace
+	versions := map[string][]*types.ResourceTagsVersion{}
+	after := ""
+	for {
+		page, err := h.store.FindPageBy(ctx, after, h.pageSize, whereClause)
+		if err != nil {
+			RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
+			log.Ctx(h.ctx).Err(err).Msg("Failed to find records to send")
+			return fmt.Errorf("failed to find records to send: %v", err)
 		}
-		log.Ctx(h.ctx).Debug().Str("namespace", namespace).Str("name", next.Name).Str("resource_type", config.ResourceTypeToMetricName[next.Type]).Msg("Sending record for namespace")
-		RemoteWriteBacklog.WithLabelValues(h.settings.RemoteWrite.Host).Set(float64(len(found)))
-
-		if next.Size+totalSize > h.sentMaxBytes && len(batch) > 0 {
-			// Send the current batch
-			if err := h.sendBatch(batch); err != nil {
-				log.Ctx(h.ctx).Err(err).Msg("Failed to send batch")
-				return err
+		log.Ctx(h.ctx).Debug().Int("count", len(page)).Msg("Found records to send")
+		maps.Copy(versions, h.unsentVersions(page))
+
+		for i, next := range page {
+			namespace := ""
+			if next.Namespace != nil {
+				namespace = *next.Namespace
+			}
+			log.Ctx(h.ctx).Debug().Str("namespace", namespace).Str("name", next.Name).Str("resource_type", config.ResourceTypeToMetricName[next.Type]).Msg("Sending record for namespace")
+			// the records left in the page, as the total is not known
+			RemoteWriteBacklog.WithLabelValues(h.settings.RemoteWrite.Host).Set(float64(len(page) - i - 1))
+
+			if next.Size+totalSize > h.sentMaxBytes && len(batch) > 0 {
+				// Send the current batch, and record the progress, so the sent
+				// batches are not sent again when a later batch fails or the
+				// process restarts
+				sent, err := h.sendBatch(batch, versions)
+				for _, record := range batch {
+					delete(versions, record.ID)
+				}
+				if markErr := h.markSent(sent, currentTime); markErr != nil {
+					return markErr
+				}
+				if err != nil {
+					log.Ctx(h.ctx).Err(err).Msg("Failed to send batch")
+					return err
+				}
+
+				// Reset totalSize and batch
+				batch = []*types.ResourceTags{}
+				totalSize = 0
 			}
 
-			// Reset totalSize and batch
-			batch = []*types.ResourceTags{}
-			totalSize = 0
+			batch = append(batch, next)
+			totalSize += next.Size
 		}
 
-		completed = append(completed, next)
-		batch = append(batch, next)
-		totalSize += next.Size
+		if len(page) < h.pageSize {
+			break
+		}
+		after = page[len(page)-1].ID
 	}
 
 	// Send the last batch if it exists
+	sent, err := h.sendBatch(batch, versions)
+	if markErr := h.markSent(sent, currentTime); markErr != nil {
+		return markErr
+	}
+	if err != nil {
+		log.Ctx(h.ctx).Err(err).Msg("Failed to send partial batch")
+		return err
+	}
 	if len(batch) > 0 {
-		if err := h.sendBatch(batch); err != nil {
-			log.Ctx(h.ctx).Err(err).Msg("Failed to send partial batch")
-			return err
-		}
 		log.Ctx(h.ctx).Debug().Int("count", len(batch)).Msg("Sent last batch")
 	}
+	return nil
+}
 
-	if len(completed) > 0 {
-		subCtx, cancel := context.WithTimeout(context.Background(), h.sendTimeout)
-		defer cancel()
-		if err := h.store.Tx(subCtx, func(txCtx context.Context) error {
-			for _, record := range completed {
-				record.SentAt = &currentTime
-				if err := h.store.Update(txCtx, record); err != nil {
-					RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
-					return fmt.Errorf("failed to update sent_at for record: %v", err)
-				}
-			}
-			return nil
-		}); err != nil {
+// markSent records that the records were sent, and removes the records of the
+// deleted resources. The records are updated in batches of BatchUpdateSize,
+// each in its own transaction, so a large backlog does not hold a single long
+// transaction.
+func (h *MetricsPusher) markSent(completed []*types.ResourceTags, currentTime time.Time) error {
+	for len(completed) > 0 {
+		batch := completed[:min(h.batchUpdateSize, len(completed))]
+		completed = completed[len(batch):]
+
+		if err := h.markSentBatch(batch, currentTime); err != nil {
 			log.Ctx(h.ctx).Err(err).Msg("Failed to update sent_at for records")
 			RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
 			return fmt.Errorf("failed to update sent_at for records: %v", err)
@@ -335,20 +431,197 @@ func (h *MetricsPusher) Flush() error {
 	return nil
 }
 
-func (h *MetricsPusher) formatMetrics(records []*types.ResourceTags) []prompb.TimeSeries {
+func (h *MetricsPusher) markSentBatch(batch []*types.ResourceTags, currentTime time.Time) error {
+	subCtx, cancel := context.WithTimeout(context.Background(), h.sendTimeout)
+	defer cancel()
+	return h.store.Tx(subCtx, func(txCtx context.Context) error {
+		for _, record := range batch {
+			// the end of life was sent, nothing is left to track unless the
+			// resource was created again since the record was read
+			if record.DeletedAt != nil {
+				if err := h.store.DeleteSent(txCtx, record.ID, record.RecordUpdated); err != nil {
+					RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
+					return fmt.Errorf("failed to delete the record of a deleted resource: %v", err)
+				}
+				continue
+			}
+
+			// the changes written since the record was read are kept, and
+			// sent with the next flush
+			if err := h.store.MarkSent(txCtx, record.ID, record.RecordUpdated, currentTime); err != nil {
+				RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
+				return fmt.Errorf("failed to update sent_at for record: %v", err)
+			}
+		}
+		if h.history == nil {
+			return nil
+		}
+		for _, record := range batch {
+			if err := h.history.MarkVersionsSent(txCtx, record.Type, record.Namespace, record.Name, currentTime); err != nil {
+				RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
+				return fmt.Errorf("failed to update sent_at for the versions of a record: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (h *MetricsPusher) formatMetrics(records []*types.ResourceTags, versions map[string][]*types.ResourceTagsVersion) []prompb.TimeSeries {
 	timeSeries := []prompb.TimeSeries{}
 	for _, record := range records {
+		timeSeries = append(timeSeries, h.formatSpec(record)...)
+		if versions := versions[record.ID]; len(versions) > 0 {
+			timeSeries = append(timeSeries, h.formatVersions(record, versions)...)
+			continue
+		}
+		samples := h.createSamples(record)
 		metricName := h.constructMetricTagName(record, "labels")
-		recordCreatedOrUpdated := h.maxTime(record.RecordUpdated, record.RecordCreated)
-		timeSeries = append(timeSeries, h.createTimeseries(metricName, *record.Labels, *record.MetricLabels, recordCreatedOrUpdated))
+		timeSeries = append(timeSeries, h.createTimeseries(metricName, *record.Labels, *record.MetricLabels, samples))
 		if record.Annotations != nil {
 			metricName := h.constructMetricTagName(record, "annotations")
-			timeSeries = append(timeSeries, h.createTimeseries(metricName, *record.Annotations, *record.MetricLabels, recordCreatedOrUpdated))
+			timeSeries = append(timeSeries, h.createTimeseries(metricName, *record.Annotations, *record.MetricLabels, samples))
 		}
 	}
 	return timeSeries
 }
 
+// unsentVersions returns the versions of the records of a page which were not
+// sent yet by record ID, or nothing when the store keeps no history, in which
+// case only the current labels of the records are sent.
+func (h *MetricsPusher) unsentVersions(page []*types.ResourceTags) map[string][]*types.ResourceTagsVersion {
+	if h.history == nil || len(page) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.sendTimeout)
+	defer cancel()
+	found, err := h.history.FindUnsentVersions(ctx, page)
+	if err != nil {
+		RemoteWriteDBFailures.WithLabelValues(h.settings.RemoteWrite.Host).Inc()
+		log.Ctx(h.ctx).Err(err).Int("count", len(page)).Msg("Failed to find the label history of the records, sending the current labels")
+		return nil
+	}
+
+	ids := make(map[string]string, len(page))
+	for _, record := range page {
+		ids[resourceKey(record.Type, record.Namespace, record.Name)] = record.ID
+	}
+	versions := map[string][]*types.ResourceTagsVersion{}
+	for _, version := range found {
+		id := ids[resourceKey(version.Type, version.Namespace, version.Name)]
+		versions[id] = append(versions[id], version)
+	}
+	return versions
+}
+
+func resourceKey(resourceType config.ResourceType, namespace *string, name string) string {
+	if namespace == nil {
+		return fmt.Sprintf("%d//%s", resourceType, name)
+	}
+	return fmt.Sprintf("%d/%s/%s", resourceType, *namespace, name)
+}
+
+// formatVersions returns the series of the versions of a record. Every label
+// set starts with a sample at the time it was first seen, and a label set
+// which was replaced, or whose resource was deleted, ends with a staleness
+// marker, so the labels are attributed to the interval they were valid in. The
+// start of a closed version is not sent again, as it would be older than the
+// samples sent since.
+func (h *MetricsPusher) formatVersions(record *types.ResourceTags, versions []*types.ResourceTagsVersion) []prompb.TimeSeries {
+	timeSeries := h.versionSeries(h.constructMetricTagName(record, "labels"), versions,
+		func(v *types.ResourceTagsVersion) *config.MetricLabelTags { return v.Labels })
+	return append(timeSeries, h.versionSeries(h.constructMetricTagName(record, "annotations"), versions,
+		func(v *types.ResourceTagsVersion) *config.MetricLabelTags { return v.Annotations })...)
+}
+
+// versionSeries returns a series per label set of the versions. Consecutive
+// versions with the same label set, e.g. when only the annotations changed,
+// are sent as a single interval.
+func (h *MetricsPusher) versionSeries(
+	metricName string,
+	versions []*types.ResourceTagsVersion,
+	tags func(*types.ResourceTagsVersion) *config.MetricLabelTags,
+) []prompb.TimeSeries {
+	timeSeries := []prompb.TimeSeries{}
+	for i := 0; i < len(versions); {
+		first, last := versions[i], versions[i]
+		for i++; i < len(versions) && sameSeries(last, versions[i], tags); i++ {
+			last = versions[i]
+		}
+		if tags(first) == nil {
+			continue
+		}
+
+		samples := []prompb.Sample{}
+		if !first.StartSent && (last.ValidTo == nil || first.ValidFrom.UnixMilli() < last.ValidTo.UnixMilli()) {
+			samples = append(samples, prompb.Sample{
+				Value:     1,
+				Timestamp: first.ValidFrom.UnixMilli(),
+			})
+		}
+		if last.ValidTo != nil {
+			samples = append(samples, prompb.Sample{
+				Value:     math.Float64frombits(value.StaleNaN),
+				Timestamp: last.ValidTo.UnixMilli(),
+			})
+		}
+		if len(samples) == 0 {
+			continue
+		}
+
+		var metricLabels config.MetricLabels
+		if first.MetricLabels != nil {
+			metricLabels = *first.MetricLabels
+		}
+		timeSeries = append(timeSeries, h.createTimeseries(metricName, *tags(first), metricLabels, samples))
+	}
+	return timeSeries
+}
+
+// sameSeries returns true when the next version continues the series of the
+// previous one without a gap.
+func sameSeries(prev, next *types.ResourceTagsVersion, tags func(*types.ResourceTagsVersion) *config.MetricLabelTags) bool {
+	return prev.ValidTo != nil && prev.ValidTo.Equal(next.ValidFrom) &&
+		equalTags(prev.MetricLabels, next.MetricLabels) &&
+		equalTags(tags(prev), tags(next))
+}
+
+func equalTags(a, b *map[string]string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return maps.Equal(*a, *b)
+}
+
+// createSamples returns the samples sent for a record. The series of a deleted
+// resource ends with a staleness marker at the deletion time, so its labels
+// are not attributed past the end of its lifetime. The start of the series is
+// only sent with it when no sample of the record was sent before, as a sample
+// older than the ones already sent would be rejected.
+func (h *MetricsPusher) createSamples(record *types.ResourceTags) []prompb.Sample {
+	if record.DeletedAt == nil {
+		recordCreatedOrUpdated := h.maxTime(record.RecordUpdated, record.RecordCreated)
+		return []prompb.Sample{
+			{
+				Value:     1,
+				Timestamp: recordCreatedOrUpdated.UnixMilli(),
+			},
+		}
+	}
+
+	samples := []prompb.Sample{}
+	if !record.StartSent && record.RecordCreated.UnixMilli() < record.DeletedAt.UnixMilli() {
+		// the resource was created and deleted between two flushes
+		samples = append(samples, prompb.Sample{
+			Value:     1,
+			Timestamp: record.RecordCreated.UnixMilli(),
+		})
+	}
+	return append(samples, prompb.Sample{
+		Value:     math.Float64frombits(value.StaleNaN),
+		Timestamp: record.DeletedAt.UnixMilli(),
+	})
+}
+
 func (h *MetricsPusher) constructMetricTagName(record *types.ResourceTags, metricType string) string {
 	return fmt.Sprintf("cloudzero_%s_%s", config.ResourceTypeToMetricName[record.Type], metricType)
 }
@@ -356,7 +629,7 @@ func (h *MetricsPusher) constructMetricTagName(record *types.ResourceTags, metri
 func (h *MetricsPusher) createTimeseries(
 	metricName string, metricTags config.MetricLabelTags,
 	additionalMetricLabels config.MetricLabels,
-	recordCreatedOrUpdated time.Time,
+	samples []prompb.Sample,
 ) prompb.TimeSeries {
 	ts := prompb.TimeSeries{
 		Labels: []prompb.Label{
@@ -365,12 +638,7 @@ func (h *MetricsPusher) createTimeseries(
 				Value: metricName,
 			},
 		},
-		Samples: []prompb.Sample{
-			{
-				Value:     1,
-				Timestamp: recordCreatedOrUpdated.UnixNano() / int64(time.Millisecond),
-			},
-		},
+		Samples: samples,
 	}
 	for labelKey, labelValue := range additionalMetricLabels {
 		ts.Labels = append(ts.Labels, prompb.Label{
@@ -384,18 +652,19 @@ func (h *MetricsPusher) createTimeseries(
 			Value: labelValue,
 		})
 	}
+	// remote write requires the labels of a series sorted by name
+	slices.SortFunc(ts.Labels, func(a, b prompb.Label) int {
+		return strings.Compare(a.Name, b.Name)
+	})
 
 	return ts
 }
 
 func (h *MetricsPusher) pushMetrics(remoteWriteURL string, apiKey string, timeSeries []prompb.TimeSeries) error {
-	writeRequest := &prompb.WriteRequest{
-		Timeseries: timeSeries,
-	}
-
-	data, err := proto.Marshal(protoadapt.MessageV2Of(writeRequest))
+	protocol := h.currentProtocol()
+	data, err := encodeRequest(protocol, timeSeries)
 	if err != nil {
-		return fmt.Errorf("error marshaling WriteRequest: %v", err)
+		return err
 	}
 
 	compressed := snappy.Encode(nil, data)
@@ -406,54 +675,165 @@ func (h *MetricsPusher) pushMetrics(remoteWriteURL string, apiKey string, timeSe
 	// Instrument: Observe payload size
 	RemoteWritePayloadSizeBytes.WithLabelValues(endpoint).Observe(float64(len(compressed)))
 
-	var resp *http.Response
-	var req *http.Request
-
 	for attempt := range h.maxRetries {
-		ctx, cancel := context.WithTimeout(context.Background(), h.sendTimeout)
-		defer cancel()
-
-		req, err = http.NewRequestWithContext(ctx, "POST", remoteWriteURL, bytes.NewBuffer(compressed))
-		if err != nil {
-			return fmt.Errorf("error creating HTTP request: %v", err)
-		}
-
-		req.Header.Set("Content-Type", "application/x-protobuf")
-		req.Header.Set("Content-Encoding", "snappy")
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-
-		client := &http.Client{}
-		resp, err = client.Do(req)
+		statusCode, err := h.doRequest(remoteWriteURL, apiKey, protocol, compressed)
 
 		// Instrument: measure duration after each attempt
 		duration := time.Since(start).Seconds()
 		RemoteWriteRequestDuration.WithLabelValues(endpoint).Observe(duration)
 
-		if err == nil && (resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices) {
-			defer resp.Body.Close()
+		switch {
+		case err == nil && statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices:
 			// Instrument: response code 200
 			RemoteWriteResponseCodes.WithLabelValues(endpoint, "2xx").Inc()
 			return nil
-		}
-
-		if resp != nil {
-			statusCode := strconv.Itoa(resp.StatusCode)
-			RemoteWriteResponseCodes.WithLabelValues(endpoint, statusCode).Inc()
-			resp.Body.Close()
+		case err == nil && statusCode == http.StatusUnsupportedMediaType && protocol == config.RemoteWriteProtocolV2:
+			// the endpoint does not support 2.0, which is not retried
+			RemoteWriteResponseCodes.WithLabelValues(endpoint, strconv.Itoa(statusCode)).Inc()
+			log.Ctx(h.ctx).Warn().Msg("The remote write endpoint does not support the 2.0 protocol, falling back to 1.0")
+			h.setProtocol(config.RemoteWriteProtocolV1)
+			return h.pushMetrics(remoteWriteURL, apiKey, timeSeries)
+		case err == nil && (statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden) && h.retryWithNextAPIKey(remoteWriteURL, apiKey, protocol, compressed):
+			// the API key was rotated, and the next key was accepted
+			RemoteWriteResponseCodes.WithLabelValues(endpoint, strconv.Itoa(statusCode)).Inc()
+			RemoteWriteResponseCodes.WithLabelValues(endpoint, "2xx").Inc()
+			return nil
+		case err == nil:
+			RemoteWriteResponseCodes.WithLabelValues(endpoint, strconv.Itoa(statusCode)).Inc()
 			log.Ctx(h.ctx).Error().
-				Int("status_code", resp.StatusCode).
-				Str("status_text", resp.Status).
+				Int("status_code", statusCode).
+				Str("status_text", http.StatusText(statusCode)).
 				Msg("Received non-2xx response, retrying...")
-		} else {
-			// If resp is nil, we can track it as a failure as well
+			err = fmt.Errorf("status code %d", statusCode)
+		default:
+			// If there is no response, we can track it as a failure as well
 			RemoteWriteResponseCodes.WithLabelValues(endpoint, "no_response").Inc()
 		}
+
+		if attempt == h.maxRetries-1 {
+			return fmt.Errorf("received non-2xx response: %v after %d retries", err, h.maxRetries)
+		}
 		backoff := time.Duration(math.Pow(2, float64(attempt))) * time.Second
 		jitter := time.Duration(rand.Int63n(int64(time.Second)))
 		time.Sleep(backoff + jitter)
 	}
 
-	return fmt.Errorf("received non-2xx response: %v after %d retries", err, h.maxRetries)
+	return fmt.Errorf("received non-2xx response after %d retries", h.maxRetries)
+}
+
+// retryWithNextAPIKey sends a request rejected as unauthorized again with the
+// next API key, and promotes the next key when it is accepted.
+func (h *MetricsPusher) retryWithNextAPIKey(remoteWriteURL, apiKey, protocol string, compressed []byte) bool {
+	next := h.settings.GetNextAPIKey()
+	if next == "" || next == apiKey {
+		return false
+	}
+
+	log.Ctx(h.ctx).Info().Msg("Retrying the remote write with the next API key")
+	statusCode, err := h.doRequest(remoteWriteURL, next, protocol, compressed)
+	if err != nil || statusCode < http.StatusOK || statusCode >= http.StatusMultipleChoices {
+		log.Ctx(h.ctx).Warn().Err(err).Int("status_code", statusCode).Msg("The next API key was not accepted")
+		return false
+	}
+	h.settings.PromoteAPIKey(next)
+	log.Ctx(h.ctx).Info().Msg("The next API key was accepted and is now the active key")
+	return true
+}
+
+// doRequest sends a compressed request with the shared client, and returns
+// the status code. The response body is drained, so the connection is kept
+// alive for the next request.
+func (h *MetricsPusher) doRequest(remoteWriteURL, apiKey, protocol string, compressed []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", remoteWriteURL, bytes.NewReader(compressed))
+	if err != nil {
+		return 0, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if protocol == config.RemoteWriteProtocolV2 {
+		req.Header.Set("Content-Type", contentTypeV2)
+		req.Header.Set(remoteWriteVersionHeader, "2.0.0")
+	} else {
+		req.Header.Set("Content-Type", contentTypeV1)
+		req.Header.Set(remoteWriteVersionHeader, "0.1.0")
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func (h *MetricsPusher) currentProtocol() string {
+	h.protocolMu.Lock()
+	defer h.protocolMu.Unlock()
+	return h.protocol
+}
+
+func (h *MetricsPusher) setProtocol(protocol string) {
+	h.protocolMu.Lock()
+	defer h.protocolMu.Unlock()
+	h.protocol = protocol
+}
+
+// encodeRequest encodes the series with a remote write protocol.
+func encodeRequest(protocol string, timeSeries []prompb.TimeSeries) ([]byte, error) {
+	if protocol == config.RemoteWriteProtocolV2 {
+		data, err := toWriteV2(timeSeries).Marshal()
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling Request: %v", err)
+		}
+		return data, nil
+	}
+
+	writeRequest := &prompb.WriteRequest{
+		Timeseries: timeSeries,
+	}
+	data, err := proto.Marshal(protoadapt.MessageV2Of(writeRequest))
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling WriteRequest: %v", err)
+	}
+	return data, nil
+}
+
+// toWriteV2 converts series to a remote write 2.0 request. Every label name
+// and value is sent once in the symbol table, and the series reference them
+// by index, which is much smaller than 1.0 as the same labels are repeated
+// across the series of a resource.
+func toWriteV2(timeSeries []prompb.TimeSeries) *writev2.Request {
+	symbols := writev2.NewSymbolTable()
+	series := make([]writev2.TimeSeries, 0, len(timeSeries))
+	for _, ts := range timeSeries {
+		refs := make([]uint32, 0, 2*len(ts.Labels))
+		for _, label := range ts.Labels {
+			refs = append(refs, symbols.Symbolize(label.Name), symbols.Symbolize(label.Value))
+		}
+		samples := make([]writev2.Sample, 0, len(ts.Samples))
+		for _, sample := range ts.Samples {
+			samples = append(samples, writev2.Sample{Value: sample.Value, Timestamp: sample.Timestamp})
+		}
+		series = append(series, writev2.TimeSeries{
+			LabelsRefs: refs,
+			Samples:    samples,
+			Metadata:   writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_GAUGE},
+		})
+	}
+	return &writev2.Request{Symbols: symbols.Symbols(), Timeseries: series}
+}
+
+// newHTTPClient returns the client shared by the requests of a pusher. The
+// connections to the endpoint are kept alive between the flushes.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	return &http.Client{Transport: transport}
 }
 
 func (h *MetricsPusher) maxTime(t1, t2 time.Time) time.Time {
@@ -7,19 +7,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang/snappy"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 
 	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/apikey"
+	"github.com/cloudzero/cloudzero-agent/app/domain/cluster"
 	"github.com/cloudzero/cloudzero-agent/app/domain/pusher"
+	"github.com/cloudzero/cloudzero-agent/app/http/handler"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/storage/sqlite"
 	"github.com/cloudzero/cloudzero-agent/app/types"
 	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
 )
@@ -103,7 +115,7 @@ func Test_SupportsRunnableInterface(t *testing.T) {
 	p, _ := setupTest(t, mockClock, mockStore, func(w http.ResponseWriter, r *http.Request) {}, "")
 	assert.False(t, p.IsRunning())
 
-	mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return([]*types.ResourceTags{}, nil).AnyTimes()
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return([]*types.ResourceTags{}, nil).AnyTimes()
 
 	err := p.Run()
 	assert.NoError(t, err)
@@ -124,17 +136,16 @@ func Test_FlushMany(t *testing.T) {
 	mockStore := mocks.NewMockResourceStore(ctrl)
 
 	records := mkRecords(currentTime, 5)
-	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).Times(1)
-	mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return(records, nil).AnyTimes()
+	// the records are marked as sent after each of the 3 batches
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil).AnyTimes()
 
 	// Fake the time it was sent
 	sentAt := currentTime.Add(2 * time.Minute)
 	mockClock.SetCurrentTime(sentAt)
 
 	// Make sure the updates have the expected sentAt time
-	mockStore.EXPECT().Update(gomock.Any(), gomock.Cond(func(m *types.ResourceTags) bool {
-		return *m.SentAt == sentAt
-	})).Return(nil).AnyTimes()
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), currentTime, sentAt).Return(nil).AnyTimes()
 
 	// Capture the records that were sent
 	apiKeyContent := "apiKeyContent"
@@ -188,7 +199,7 @@ func Test_Flush_FindAll_ReturnsNothing(t *testing.T) {
 	mockStore := mocks.NewMockResourceStore(ctrl)
 
 	emptyList := mkRecords(currentTime, 0)
-	mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return(emptyList, nil).Times(1)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(emptyList, nil).Times(1)
 
 	p, host := setupTest(t, mockClock, mockStore, func(w http.ResponseWriter, r *http.Request) {}, "apiKeyContent")
 
@@ -217,7 +228,7 @@ func Test_Flush_Handles_FindAll_Error_Gracefully(t *testing.T) {
 	mockStore := mocks.NewMockResourceStore(ctrl)
 
 	expectedError := errors.New("find error")
-	mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return(nil, expectedError).Times(1)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(nil, expectedError).Times(1)
 
 	p, host := setupTest(t, mockClock, mockStore, func(w http.ResponseWriter, r *http.Request) {}, "apiKeyContent")
 
@@ -246,7 +257,7 @@ func Test_Flush_Handles_Tx_Error_Gracefully(t *testing.T) {
 	mockStore := mocks.NewMockResourceStore(ctrl)
 
 	list := mkRecords(currentTime, 1)
-	mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return(list, nil).Times(1)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(list, nil).Times(1)
 
 	// Setup the transaction error
 	expectedError := errors.New("find error")
@@ -279,12 +290,12 @@ func Test_Flush_Handles_Update_Error_Gracefully(t *testing.T) {
 	mockStore := mocks.NewMockResourceStore(ctrl)
 
 	list := mkRecords(currentTime, 1)
-	mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return(list, nil).Times(1)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(list, nil).Times(1)
 	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).Times(1)
 
 	// Setup the transaction error
 	expectedError := errors.New("find error")
-	mockStore.EXPECT().Update(gomock.Any(), gomock.Any()).Return(expectedError).Times(1)
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(expectedError).Times(1)
 
 	p, host := setupTest(t, mockClock, mockStore, func(w http.ResponseWriter, r *http.Request) {}, "apiKeyContent")
 
@@ -313,7 +324,7 @@ func Test_Flush_Handles_SendFailure(t *testing.T) {
 	mockStore := mocks.NewMockResourceStore(ctrl)
 
 	records := mkRecords(currentTime, 1)
-	mockStore.EXPECT().FindAllBy(gomock.Any(), gomock.Any()).Return(records, nil).AnyTimes()
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil).AnyTimes()
 
 	// Capture the records that were sent
 	apiKeyContent := "apiKeyContent"
@@ -341,3 +352,725 @@ func Test_Flush_Handles_SendFailure(t *testing.T) {
 	got = testutil.ToFloat64(pusher.RemoteWriteFailures.WithLabelValues(host))
 	require.Equal(t, 1.0, got, "RemoteWriteFailures metric should be 1")
 }
+
+func Test_Flush_DeletedRecord(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	// Initialize the mock store
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	// a resource created and deleted between two flushes, and one whose
+	// start was sent with an earlier flush
+	deletedAt := currentTime.Add(time.Minute)
+	records := mkRecords(currentTime, 2)
+	for i, id := range []string{"deleted", "sent"} {
+		records[i].ID = id
+		records[i].RecordUpdated = deletedAt
+		records[i].DeletedAt = &deletedAt
+	}
+	records[1].StartSent = true
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+
+	// the records are removed once their end of life was sent
+	mockStore.EXPECT().DeleteSent(gomock.Any(), "deleted", deletedAt).Return(nil)
+	mockStore.EXPECT().DeleteSent(gomock.Any(), "sent", deletedAt).Return(nil)
+
+	var received []prompb.TimeSeries
+	p, _ := setupTest(t, mockClock, mockStore,
+		func(w http.ResponseWriter, r *http.Request) {
+			compressed, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			data, err := snappy.Decode(nil, compressed)
+			require.NoError(t, err)
+			var req prompb.WriteRequest
+			require.NoError(t, req.Unmarshal(data))
+			received = append(received, req.Timeseries...)
+			w.WriteHeader(http.StatusOK)
+		},
+		"apiKeyContent",
+	)
+
+	require.NoError(t, p.Flush())
+
+	// each series covers the lifetime and ends with a staleness marker, and
+	// the series whose start was sent only get the staleness marker, which is
+	// never older than the samples sent before
+	require.Len(t, received, 4)
+	for _, ts := range received {
+		samples := ts.Samples
+		started := !slices.ContainsFunc(ts.Labels, func(l prompb.Label) bool { return l.Value == "metric-label-1" })
+		if started {
+			require.Len(t, samples, 2)
+			assert.Equal(t, 1.0, samples[0].Value)
+			assert.Equal(t, currentTime.UnixMilli(), samples[0].Timestamp)
+			samples = samples[1:]
+		}
+		require.Len(t, samples, 1)
+		assert.True(t, value.IsStaleNaN(samples[0].Value))
+		assert.Equal(t, deletedAt.UnixMilli(), samples[0].Timestamp)
+	}
+}
+
+func Test_Flush_DeletedRecord_CreatedAgain(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+	store, err := repo.NewInMemoryResourceRepository(mockClock)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// a job deleted before the flush
+	namespace := "default"
+	job := types.ResourceTags{
+		Type:         config.Job,
+		Name:         "nightly",
+		Namespace:    &namespace,
+		Labels:       &config.MetricLabelTags{"run": "1"},
+		MetricLabels: &config.MetricLabels{"job": "nightly"},
+	}
+	handler.WriteDataToStorage(ctx, store, mockClock, job)
+	mockClock.AdvanceTime(time.Minute)
+	handler.WriteDeletionToStorage(ctx, store, mockClock, job)
+
+	// the job is run again under the same name while the flush is sent
+	var once sync.Once
+	p, _ := setupTest(t, mockClock, store,
+		func(w http.ResponseWriter, r *http.Request) {
+			once.Do(func() {
+				mockClock.AdvanceTime(time.Minute)
+				again := job
+				again.Labels = &config.MetricLabelTags{"run": "2"}
+				handler.WriteDataToStorage(ctx, store, mockClock, again)
+			})
+			w.WriteHeader(http.StatusOK)
+		},
+		"apiKeyContent",
+	)
+	require.NoError(t, p.Flush())
+
+	// the record of the new job is kept, and left unsent for the next flush
+	found, err := store.FindAllBy(ctx, "name = ?", job.Name)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Nil(t, found[0].DeletedAt)
+	assert.Nil(t, found[0].SentAt)
+	assert.Equal(t, config.MetricLabelTags{"run": "2"}, *found[0].Labels)
+}
+
+func Test_Flush_CustomResource(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	rollout := config.CustomResource{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}
+	require.NoError(t, config.RegisterCustomResources([]config.CustomResource{rollout}))
+	records := mkRecords(currentTime, 1)
+	records[0].Type = rollout.ResourceType()
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+	var names []string
+	p, _ := setupTest(t, mockClock, mockStore,
+		func(w http.ResponseWriter, r *http.Request) {
+			compressed, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			data, err := snappy.Decode(nil, compressed)
+			require.NoError(t, err)
+			var req prompb.WriteRequest
+			require.NoError(t, req.Unmarshal(data))
+			for _, ts := range req.Timeseries {
+				for _, label := range ts.Labels {
+					if label.Name == "__name__" {
+						names = append(names, label.Value)
+					}
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+		},
+		"apiKeyContent",
+	)
+
+	require.NoError(t, p.Flush())
+	assert.ElementsMatch(t, []string{"cloudzero_rollout_labels", "cloudzero_rollout_annotations"}, names)
+}
+
+func Test_Flush_BatchUpdateSize(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	// a single request, with the records marked as sent 2 at a time
+	records := mkRecords(currentTime, 5)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(5)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	settings := &config.Settings{
+		APIKeyPath: createAPIKeyFile(t, "apiKeyContent"),
+		RemoteWrite: config.RemoteWrite{
+			Host:            server.URL,
+			MaxBytesPerSend: 10000,
+			SendInterval:    time.Second,
+			SendTimeout:     time.Second,
+			MaxRetries:      3,
+		},
+		Database: config.Database{BatchUpdateSize: 2},
+	}
+	require.NoError(t, settings.SetAPIKey())
+
+	p := pusher.New(context.Background(), mockStore, mockClock, settings).(*pusher.MetricsPusher)
+	require.NoError(t, p.Flush())
+}
+
+// historyStore is a resource store which keeps the label history.
+type historyStore struct {
+	*mocks.MockResourceStore
+	*mocks.MockResourceHistoryStore
+}
+
+func Test_Flush_LabelHistory(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := historyStore{mocks.NewMockResourceStore(ctrl), mocks.NewMockResourceHistoryStore(ctrl)}
+
+	// the deployment was labeled team=a until changedAt, and team=b afterwards
+	createdAt, changedAt := currentTime.Add(-2*time.Hour), currentTime.Add(-time.Hour)
+	records := mkRecords(changedAt, 1)
+	records[0].Labels = &config.MetricLabelTags{"team": "b"}
+	annotations := &config.MetricLabelTags{"owner": "x"}
+	versions := []*types.ResourceTagsVersion{
+		{Type: config.Deployment, Name: records[0].Name, MetricLabels: records[0].MetricLabels, Labels: &config.MetricLabelTags{"team": "a"}, Annotations: annotations, ValidFrom: createdAt, ValidTo: &changedAt},
+		{Type: config.Deployment, Name: records[0].Name, MetricLabels: records[0].MetricLabels, Labels: records[0].Labels, Annotations: annotations, ValidFrom: changedAt},
+	}
+	store.MockResourceStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil)
+	store.MockResourceHistoryStore.EXPECT().FindUnsentVersions(gomock.Any(), records).Return(versions, nil)
+	store.MockResourceStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+	store.MockResourceStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	store.MockResourceHistoryStore.EXPECT().MarkVersionsSent(gomock.Any(), config.Deployment, nil, records[0].Name, currentTime).Return(nil)
+
+	received := map[string][]prompb.Sample{}
+	p, _ := setupTest(t, mockClock, store, receiveHistory(t, received), "apiKeyContent")
+
+	require.NoError(t, p.Flush())
+	require.Len(t, received, 3)
+
+	// the replaced labels end when they were changed
+	old := received["cloudzero_deployment_labels/a"]
+	require.Len(t, old, 2)
+	assert.Equal(t, prompb.Sample{Value: 1, Timestamp: createdAt.UnixMilli()}, old[0])
+	assert.True(t, value.IsStaleNaN(old[1].Value))
+	assert.Equal(t, changedAt.UnixMilli(), old[1].Timestamp)
+
+	// the current labels start when they were changed
+	assert.Equal(t, []prompb.Sample{{Value: 1, Timestamp: changedAt.UnixMilli()}}, received["cloudzero_deployment_labels/b"])
+
+	// the unchanged annotations are sent as a single interval
+	assert.Equal(t, []prompb.Sample{{Value: 1, Timestamp: createdAt.UnixMilli()}}, received["cloudzero_deployment_annotations/x"])
+}
+
+func Test_Flush_LabelHistory_ClosedVersion(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := historyStore{mocks.NewMockResourceStore(ctrl), mocks.NewMockResourceHistoryStore(ctrl)}
+
+	// the start of team=a was sent with an earlier flush, before the labels
+	// were changed to team=b
+	createdAt, changedAt := currentTime.Add(-2*time.Hour), currentTime.Add(-time.Hour)
+	records := mkRecords(changedAt, 1)
+	records[0].Labels = &config.MetricLabelTags{"team": "b"}
+	annotations := &config.MetricLabelTags{"owner": "x"}
+	versions := []*types.ResourceTagsVersion{
+		{Type: config.Deployment, Name: records[0].Name, MetricLabels: records[0].MetricLabels, Labels: &config.MetricLabelTags{"team": "a"}, Annotations: annotations, ValidFrom: createdAt, ValidTo: &changedAt, StartSent: true},
+		{Type: config.Deployment, Name: records[0].Name, MetricLabels: records[0].MetricLabels, Labels: records[0].Labels, Annotations: annotations, ValidFrom: changedAt},
+	}
+	store.MockResourceStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil)
+	store.MockResourceHistoryStore.EXPECT().FindUnsentVersions(gomock.Any(), records).Return(versions, nil)
+	store.MockResourceStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+	store.MockResourceStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	store.MockResourceHistoryStore.EXPECT().MarkVersionsSent(gomock.Any(), config.Deployment, nil, records[0].Name, currentTime).Return(nil)
+
+	received := map[string][]prompb.Sample{}
+	p, _ := setupTest(t, mockClock, store, receiveHistory(t, received), "apiKeyContent")
+
+	require.NoError(t, p.Flush())
+
+	// only the end of the replaced labels is sent
+	old := received["cloudzero_deployment_labels/a"]
+	require.Len(t, old, 1)
+	assert.True(t, value.IsStaleNaN(old[0].Value))
+	assert.Equal(t, changedAt.UnixMilli(), old[0].Timestamp)
+	assert.Equal(t, []prompb.Sample{{Value: 1, Timestamp: changedAt.UnixMilli()}}, received["cloudzero_deployment_labels/b"])
+
+	// the start of the unchanged annotations was sent already
+	assert.Len(t, received, 2)
+}
+
+// receiveHistory records the samples received per metric name and tag value.
+func receiveHistory(t *testing.T, received map[string][]prompb.Sample) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		var req prompb.WriteRequest
+		require.NoError(t, req.Unmarshal(data))
+		for _, ts := range req.Timeseries {
+			var name, tag string
+			for _, label := range ts.Labels {
+				switch label.Name {
+				case "__name__":
+					name = label.Value
+				case "label_team", "label_owner":
+					tag = label.Value
+				}
+			}
+			received[name+"/"+tag] = ts.Samples
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func Test_Flush_Pages(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	// the records are read 2 at a time, after the last id of the previous page
+	records := mkRecords(currentTime, 5)
+	for i, record := range records {
+		record.ID = fmt.Sprintf("id-%d", i)
+	}
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", 2, "sent_at IS NULL").Return(records[:2], nil)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "id-1", 2, "sent_at IS NULL").Return(records[2:4], nil)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "id-3", 2, "sent_at IS NULL").Return(records[4:], nil)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(5)
+
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		sent++
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	settings := &config.Settings{
+		APIKeyPath: createAPIKeyFile(t, "apiKeyContent"),
+		RemoteWrite: config.RemoteWrite{
+			Host:            server.URL,
+			MaxBytesPerSend: 10000,
+			SendInterval:    time.Second,
+			SendTimeout:     time.Second,
+			MaxRetries:      3,
+			PageSize:        2,
+		},
+	}
+	require.NoError(t, settings.SetAPIKey())
+
+	p := pusher.New(context.Background(), mockStore, mockClock, settings).(*pusher.MetricsPusher)
+	require.NoError(t, p.Flush())
+	// the batches span the pages
+	assert.Equal(t, 1, sent)
+}
+
+func Test_Flush_RemoteWriteV2(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	records := mkRecords(currentTime, 2)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil).Times(2)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	var (
+		received    writev2.Request
+		unsupported = true
+		versions    []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions = append(versions, r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		if r.Header.Get("Content-Type") != "application/x-protobuf;proto=io.prometheus.write.v2.Request" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		require.NoError(t, received.Unmarshal(data))
+		if unsupported {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	settings := &config.Settings{
+		APIKeyPath: createAPIKeyFile(t, "apiKeyContent"),
+		RemoteWrite: config.RemoteWrite{
+			Host:            server.URL,
+			MaxBytesPerSend: 10000,
+			SendInterval:    time.Second,
+			SendTimeout:     time.Second,
+			MaxRetries:      3,
+			Protocol:        config.RemoteWriteProtocolV2,
+		},
+	}
+	require.NoError(t, settings.SetAPIKey())
+
+	// the labels are sent once in the symbol table, and referenced by the series
+	unsupported = false
+	p := pusher.New(context.Background(), mockStore, mockClock, settings).(*pusher.MetricsPusher)
+	require.NoError(t, p.Flush())
+	assert.Equal(t, []string{"2.0.0"}, versions)
+	require.Len(t, received.Timeseries, 4)
+	assert.Equal(t, "", received.Symbols[0])
+	for _, ts := range received.Timeseries {
+		labels := map[string]string{}
+		for i := 0; i < len(ts.LabelsRefs); i += 2 {
+			labels[received.Symbols[ts.LabelsRefs[i]]] = received.Symbols[ts.LabelsRefs[i+1]]
+		}
+		assert.Contains(t, []string{"cloudzero_deployment_labels", "cloudzero_deployment_annotations"}, labels["__name__"])
+		assert.NotEmpty(t, labels["label_label"])
+		assert.Equal(t, []writev2.Sample{{Value: 1, Timestamp: currentTime.UnixMilli()}}, ts.Samples)
+	}
+
+	// an endpoint without 2.0 support is sent 1.0 instead
+	versions = nil
+	unsupported = true
+	p = pusher.New(context.Background(), mockStore, mockClock, settings).(*pusher.MetricsPusher)
+	require.NoError(t, p.Flush())
+	assert.Equal(t, []string{"2.0.0", "0.1.0"}, versions)
+}
+
+func Test_Flush_NextAPIKey(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	records := mkRecords(currentTime, 2)
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil).Times(2)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	// the old key was revoked
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer new-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	settings := &config.Settings{
+		APIKeyPath: createAPIKeyFile(t, "old-key"),
+		APIKeySource: apikey.Config{
+			NextPath: createAPIKeyFile(t, "new-key"),
+		},
+		RemoteWrite: config.RemoteWrite{
+			Host:            server.URL,
+			MaxBytesPerSend: 10000,
+			SendInterval:    time.Second,
+			SendTimeout:     time.Second,
+			MaxRetries:      1,
+		},
+	}
+	require.NoError(t, settings.SetAPIKey())
+	require.Equal(t, "new-key", settings.GetNextAPIKey())
+
+	p := pusher.New(context.Background(), mockStore, mockClock, settings).(*pusher.MetricsPusher)
+	require.NoError(t, p.Flush())
+	assert.Equal(t, []string{"Bearer old-key", "Bearer new-key"}, keys)
+	assert.Equal(t, "new-key", settings.GetAPIKey())
+	assert.Empty(t, settings.GetNextAPIKey())
+
+	// the promoted key is sent without a retry
+	keys = nil
+	require.NoError(t, p.Flush())
+	assert.Equal(t, []string{"Bearer new-key"}, keys)
+}
+
+func Test_Flush_Spec(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	records := mkRecords(currentTime, 1)
+	records[0].Type = config.Pod
+	records[0].Name = "web"
+	records[0].MetricLabels = &config.MetricLabels{"pod": "web", "namespace": "default"}
+	records[0].Spec = &types.ResourceSpec{
+		Containers: []types.ContainerResources{{
+			Name:     "app",
+			Requests: types.ResourceQuantities{"cpu": 0.25, "nvidia.com/gpu": 1},
+			Limits:   types.ResourceQuantities{"memory": 1024},
+		}},
+		QOSClass: "Burstable",
+	}
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	series := map[string]prompb.TimeSeries{}
+	p, _ := setupTest(t, mockClock, mockStore,
+		func(w http.ResponseWriter, r *http.Request) {
+			compressed, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			data, err := snappy.Decode(nil, compressed)
+			require.NoError(t, err)
+			var req prompb.WriteRequest
+			require.NoError(t, req.Unmarshal(data))
+			for _, ts := range req.Timeseries {
+				key := ""
+				for _, label := range ts.Labels {
+					if label.Name == "__name__" || label.Name == "resource" {
+						key += label.Value + "/"
+					}
+				}
+				series[key] = ts
+			}
+			w.WriteHeader(http.StatusOK)
+		},
+		"apiKeyContent",
+	)
+
+	require.NoError(t, p.Flush())
+
+	labels := func(ts prompb.TimeSeries) map[string]string {
+		result := map[string]string{}
+		for _, label := range ts.Labels {
+			result[label.Name] = label.Value
+		}
+		return result
+	}
+
+	require.Contains(t, series, "cloudzero_pod_info/")
+	assert.Equal(t, map[string]string{
+		"__name__":  "cloudzero_pod_info",
+		"pod":       "web",
+		"namespace": "default",
+		"qos_class": "Burstable",
+	}, labels(series["cloudzero_pod_info/"]))
+
+	require.Contains(t, series, "cloudzero_pod_resource_requests/cpu/")
+	cpu := series["cloudzero_pod_resource_requests/cpu/"]
+	assert.Equal(t, "core", labels(cpu)["unit"])
+	assert.Equal(t, "app", labels(cpu)["container"])
+	require.Len(t, cpu.Samples, 1)
+	assert.Equal(t, 0.25, cpu.Samples[0].Value)
+	assert.Equal(t, currentTime.UnixMilli(), cpu.Samples[0].Timestamp)
+
+	require.Contains(t, series, "cloudzero_pod_resource_requests/nvidia_com_gpu/")
+	assert.Equal(t, "integer", labels(series["cloudzero_pod_resource_requests/nvidia_com_gpu/"])["unit"])
+
+	require.Contains(t, series, "cloudzero_pod_resource_limits/memory/")
+	assert.Equal(t, "byte", labels(series["cloudzero_pod_resource_limits/memory/"])["unit"])
+	assert.Equal(t, 1024.0, series["cloudzero_pod_resource_limits/memory/"].Samples[0].Value)
+}
+
+func Test_Flush_LogicalClusters(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStore := mocks.NewMockResourceStore(ctrl)
+
+	records := mkRecords(currentTime, 4)
+	for _, record := range records {
+		record.ID = record.Name
+	}
+	for i, namespace := range []string{"default", "a-web", "b-web"} {
+		records[i].Namespace = &namespace
+	}
+	// the last record is cluster-scoped
+	mockStore.EXPECT().FindPageBy(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(records, nil)
+	mockStore.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+	// the records of the failed cluster are not marked as sent
+	var updated []string
+	mockStore.EXPECT().MarkSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, id string, _, _ time.Time) error {
+		updated = append(updated, id)
+		return nil
+	}).AnyTimes()
+
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clusterName := r.URL.Query().Get("cluster_name")
+		if clusterName == "tenant-b" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		var req prompb.WriteRequest
+		require.NoError(t, req.Unmarshal(data))
+		received[clusterName] += len(req.Timeseries)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	settings := &config.Settings{
+		APIKeyPath:  createAPIKeyFile(t, "apiKeyContent"),
+		ClusterName: "host",
+		Destination: server.URL,
+		LogicalClusters: config.LogicalClusters{
+			{ClusterName: "tenant-a", NamespacePrefix: "a-"},
+			{ClusterName: "tenant-b", NamespaceSelector: "tenant=b"},
+		},
+		RemoteWrite: config.RemoteWrite{
+			MaxBytesPerSend: 10000,
+			SendInterval:    time.Second,
+			SendTimeout:     time.Second,
+			MaxRetries:      1,
+		},
+	}
+	require.NoError(t, settings.SetAPIKey())
+	settings.RemoteWrite.Host = server.URL + "?cluster_name=host"
+	settings.LogicalClusters[0].RemoteWriteHost = server.URL + "?cluster_name=tenant-a"
+	settings.LogicalClusters[1].RemoteWriteHost = server.URL + "?cluster_name=tenant-b"
+
+	mapper, err := cluster.New(settings, namespaceLabels{"b-web": {"tenant": "b"}})
+	require.NoError(t, err)
+	p := pusher.New(context.Background(), mockStore, mockClock, settings, pusher.WithClusterMapper(mapper)).(*pusher.MetricsPusher)
+	p.ResetStats()
+
+	require.Error(t, p.Flush())
+
+	// the labels and annotations of every record
+	assert.Equal(t, map[string]int{"host": 4, "tenant-a": 2}, received)
+	assert.ElementsMatch(t, []string{"test-deployment-0", "test-deployment-1", "test-deployment-3"}, updated)
+	assert.InDelta(t, 1.0, testutil.ToFloat64(pusher.RemoteWriteFailures.WithLabelValues(settings.LogicalClusters[1].RemoteWriteHost)), 0)
+	assert.InDelta(t, 1.0, testutil.ToFloat64(pusher.RemoteWriteRecordsProcessed.WithLabelValues(settings.LogicalClusters[0].RemoteWriteHost)), 0)
+}
+
+func Test_Flush_LogicalClusters_NamespaceDeleted(t *testing.T) {
+	currentTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	mockClock := mocks.NewMockClock(currentTime)
+	ctx := context.Background()
+
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		var req prompb.WriteRequest
+		require.NoError(t, req.Unmarshal(data))
+		received[r.URL.Query().Get("cluster_name")] += len(req.Timeseries)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	settings := &config.Settings{
+		APIKeyPath:  createAPIKeyFile(t, "apiKeyContent"),
+		ClusterName: "host",
+		Destination: server.URL,
+		LogicalClusters: config.LogicalClusters{
+			{ClusterName: "tenant-b", NamespaceSelector: "tenant=b"},
+		},
+		RemoteWrite: config.RemoteWrite{
+			MaxBytesPerSend: 10000,
+			SendInterval:    time.Second,
+			SendTimeout:     time.Second,
+			MaxRetries:      1,
+		},
+	}
+	require.NoError(t, settings.SetAPIKey())
+	settings.RemoteWrite.Host = server.URL + "?cluster_name=host"
+	settings.LogicalClusters[0].RemoteWriteHost = server.URL + "?cluster_name=tenant-b"
+
+	labeler := namespaceLabels{"b-web": {"tenant": "b"}}
+	mapper, err := cluster.New(settings, labeler)
+	require.NoError(t, err)
+	// a store private to the test, so only its records are sent
+	db, err := sqlite.NewSQLiteDriver(sqlite.InMemoryDSN)
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	base, err := repo.NewResourceRepository(mockClock, db)
+	require.NoError(t, err)
+	store := cluster.NewStore(base, mapper, cluster.HostCluster(settings))
+
+	// the namespace and its pod are written while the namespace exists
+	namespace := "b-web"
+	records := []types.ResourceTags{
+		{
+			Type:         config.Namespace,
+			Name:         namespace,
+			Labels:       &config.MetricLabelTags{"tenant": "b"},
+			MetricLabels: &config.MetricLabels{"namespace": namespace},
+		},
+		{
+			Type:         config.Pod,
+			Name:         "web",
+			Namespace:    &namespace,
+			Labels:       &config.MetricLabelTags{"app": "web"},
+			MetricLabels: &config.MetricLabels{"namespace": namespace, "pod": "web"},
+		},
+	}
+	for _, record := range records {
+		handler.WriteDataToStorage(ctx, store, mockClock, record)
+	}
+
+	// the namespace is deleted before the flush, so its labels are gone
+	delete(labeler, namespace)
+	mockClock.AdvanceTime(time.Minute)
+	for _, record := range records {
+		handler.WriteDeletionToStorage(ctx, store, mockClock, record)
+	}
+
+	p := pusher.New(ctx, store, mockClock, settings, pusher.WithClusterMapper(mapper)).(*pusher.MetricsPusher)
+	require.NoError(t, p.Flush())
+
+	// the final records are sent to the cluster of the deleted namespace
+	assert.NotContains(t, received, "host")
+	assert.Positive(t, received["tenant-b"])
+}
+
+// namespaceLabels is a NamespaceLabeler backed by a map.
+type namespaceLabels map[string]map[string]string
+
+func (n namespaceLabels) NamespaceLabels(_ context.Context, namespace string) map[string]string {
+	return n[namespace]
+}
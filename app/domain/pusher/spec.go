@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package pusher
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// formatSpec returns the series of the resource specification of a record,
+// which start and end with its label series. The requests, limits and
+// capacity are sent per resource, with the same resource and unit labels as
+// kube-state-metrics.
+func (h *MetricsPusher) formatSpec(record *types.ResourceTags) []prompb.TimeSeries {
+	spec := record.Spec
+	if spec == nil {
+		return nil
+	}
+	var base config.MetricLabels
+	if record.MetricLabels != nil {
+		base = *record.MetricLabels
+	}
+	samples := h.createSamples(record)
+
+	timeSeries := []prompb.TimeSeries{}
+	add := func(metricType string, labels map[string]string, v float64) {
+		metricLabels := maps.Clone(base)
+		if metricLabels == nil {
+			metricLabels = config.MetricLabels{}
+		}
+		for key, val := range labels {
+			if val != "" {
+				metricLabels[key] = val
+			}
+		}
+		timeSeries = append(timeSeries, h.createTimeseries(
+			h.constructMetricTagName(record, metricType), nil, metricLabels, withValue(samples, v)))
+	}
+	addQuantities := func(metricType string, labels map[string]string, quantities types.ResourceQuantities) {
+		for _, name := range slices.Sorted(maps.Keys(quantities)) {
+			resourceLabels := maps.Clone(labels)
+			if resourceLabels == nil {
+				resourceLabels = map[string]string{}
+			}
+			resourceLabels["resource"] = config.MetricLabelName(name)
+			resourceLabels["unit"] = resourceUnit(name)
+			add(metricType, resourceLabels, quantities[name])
+		}
+	}
+
+	switch record.Type {
+	case config.Pod:
+		add("info", map[string]string{
+			"qos_class":      spec.QOSClass,
+			"priority_class": spec.PriorityClass,
+			"node":           spec.NodeName,
+		}, 1)
+		for _, c := range spec.Containers {
+			addQuantities("resource_requests", map[string]string{"container": c.Name}, c.Requests)
+			addQuantities("resource_limits", map[string]string{"container": c.Name}, c.Limits)
+		}
+	case config.Node:
+		add("info", map[string]string{
+			"instance_type": spec.InstanceType,
+			"zone":          spec.Zone,
+			"region":        spec.Region,
+		}, 1)
+		addQuantities("resource_capacity", nil, spec.Capacity)
+		addQuantities("resource_allocatable", nil, spec.Allocatable)
+	}
+	return timeSeries
+}
+
+// withValue returns a copy of the samples with the value set, keeping the
+// staleness markers.
+func withValue(samples []prompb.Sample, v float64) []prompb.Sample {
+	result := make([]prompb.Sample, len(samples))
+	for i, sample := range samples {
+		result[i] = sample
+		if !value.IsStaleNaN(sample.Value) {
+			result[i].Value = v
+		}
+	}
+	return result
+}
+
+// resourceUnit returns the unit a resource quantity is sent in.
+func resourceUnit(name string) string {
+	switch name {
+	case "cpu":
+		return "core"
+	case "memory", "ephemeral-storage", "storage":
+		return "byte"
+	}
+	if strings.HasPrefix(name, "hugepages-") {
+		return "byte"
+	}
+	return "integer"
+}
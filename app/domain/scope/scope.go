@@ -0,0 +1,285 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package scope limits the objects tracked by the insights controller to some
+// namespaces and objects, by namespace name, namespace label selector and
+// object label selector.
+//
+// The same scope is applied to the admission requests, the backfill and the
+// watch, and every skipped object is counted with the reason it was skipped.
+// The stored record of an object which left the scope is closed, so its
+// lifetime ends instead of staying open forever.
+package scope
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	admission "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+)
+
+// Sources of the objects.
+const (
+	SourceAdmission = "admission"
+	SourceBackfill  = "backfill"
+	SourceWatch     = "watch"
+)
+
+// Reasons an object is out of scope.
+const (
+	ReasonNamespaceExcluded    = "namespace_excluded"
+	ReasonNamespaceNotIncluded = "namespace_not_included"
+	ReasonNamespaceSelector    = "namespace_selector"
+	ReasonObjectSelector       = "object_selector"
+	// ReasonLeftScope counts the objects out of scope whose stored record was
+	// closed, such as an object updated out of scope or a namespace excluded
+	// by a change of the configuration.
+	ReasonLeftScope = "left_scope"
+)
+
+var (
+	scopeStatsOnce sync.Once
+	// SkippedObjects counts the objects which were not tracked.
+	SkippedObjects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scope_skipped_objects_total",
+			Help: "Total number of objects which were not tracked as they are out of scope, labeled by source, kind and reason",
+		},
+		[]string{"source", "kind", "reason"},
+	)
+)
+
+// RecordCloser closes the live stored record of an object, and returns true
+// when a record was closed. The namespace is empty for cluster-scoped objects.
+type RecordCloser interface {
+	CloseRecord(ctx context.Context, resourceType config.ResourceType, namespace, name string) bool
+}
+
+// NamespaceLabeler looks up the labels of a namespace.
+type NamespaceLabeler interface {
+	NamespaceLabels(ctx context.Context, namespace string) map[string]string
+}
+
+// Scope decides which objects are tracked. A nil Scope is valid, and tracks
+// every object.
+type Scope struct {
+	includeNamespaces        map[string]bool
+	excludeNamespaces        map[string]bool
+	includeNamespaceSelector labels.Selector
+	excludeNamespaceSelector labels.Selector
+	includeObjectSelector    labels.Selector
+	excludeObjectSelector    labels.Selector
+	namespaces               NamespaceLabeler
+}
+
+// New creates the scope of the settings. It returns nil when the settings do
+// not limit the tracked objects. The labeler is only used by the namespace
+// selectors.
+func New(c config.Scope, namespaces NamespaceLabeler) (*Scope, error) {
+	scopeStatsOnce.Do(func() {
+		prometheus.MustRegister(SkippedObjects)
+	})
+	if !c.Active() {
+		return nil, nil //nolint:nilnil // a nil scope tracks every object
+	}
+
+	s := &Scope{
+		includeNamespaces: toSet(c.IncludeNamespaces),
+		excludeNamespaces: toSet(c.ExcludeNamespaces),
+		namespaces:        namespaces,
+	}
+	for _, selector := range []struct {
+		value  string
+		target *labels.Selector
+	}{
+		{c.IncludeNamespaceSelector, &s.includeNamespaceSelector},
+		{c.ExcludeNamespaceSelector, &s.excludeNamespaceSelector},
+		{c.IncludeObjectSelector, &s.includeObjectSelector},
+		{c.ExcludeObjectSelector, &s.excludeObjectSelector},
+	} {
+		if selector.value == "" {
+			continue
+		}
+		parsed, err := labels.Parse(selector.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector '%s': %w", selector.value, err)
+		}
+		*selector.target = parsed
+	}
+	return s, nil
+}
+
+// NamespaceReason returns why the objects of a namespace are out of scope, or
+// an empty string when they are in scope.
+func (s *Scope) NamespaceReason(name string, namespaceLabels map[string]string) string {
+	if s == nil {
+		return ""
+	}
+	switch {
+	case s.excludeNamespaces[name]:
+		return ReasonNamespaceExcluded
+	case len(s.includeNamespaces) > 0 && !s.includeNamespaces[name]:
+		return ReasonNamespaceNotIncluded
+	}
+	set := labels.Set(namespaceLabels)
+	if s.excludeNamespaceSelector != nil && s.excludeNamespaceSelector.Matches(set) {
+		return ReasonNamespaceSelector
+	}
+	if s.includeNamespaceSelector != nil && !s.includeNamespaceSelector.Matches(set) {
+		return ReasonNamespaceSelector
+	}
+	return ""
+}
+
+// Reason returns why an object is out of scope, or an empty string when it is
+// in scope.
+func (s *Scope) Reason(ctx context.Context, obj metav1.Object) string {
+	if s == nil {
+		return ""
+	}
+	if _, ok := obj.(*corev1.Namespace); ok {
+		return s.NamespaceReason(obj.GetName(), obj.GetLabels())
+	}
+	if namespace := obj.GetNamespace(); namespace != "" {
+		if reason := s.NamespaceReason(namespace, s.namespaceLabels(ctx, namespace)); reason != "" {
+			return reason
+		}
+	}
+	set := labels.Set(obj.GetLabels())
+	if s.excludeObjectSelector != nil && s.excludeObjectSelector.Matches(set) {
+		return ReasonObjectSelector
+	}
+	if s.includeObjectSelector != nil && !s.includeObjectSelector.Matches(set) {
+		return ReasonObjectSelector
+	}
+	return ""
+}
+
+// Skip returns true when an object is out of scope, and counts it. Objects
+// without metadata are always in scope.
+func (s *Scope) Skip(ctx context.Context, source, kind string, obj any) bool {
+	if s == nil {
+		return false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	reason := s.Reason(ctx, accessor)
+	if reason == "" {
+		return false
+	}
+	SkippedObjects.WithLabelValues(source, kind, reason).Inc()
+	log.Ctx(ctx).Debug().
+		Str("source", source).
+		Str("kind", kind).
+		Str("namespace", accessor.GetNamespace()).
+		Str("name", accessor.GetName()).
+		Str("reason", reason).
+		Msg("Skipped an object out of scope")
+	return true
+}
+
+// Hook skips the admission requests of the objects out of scope, which are
+// allowed without being processed. The stored record of an object out of
+// scope, which was tracked before it left the scope, is closed with the
+// closer.
+func (s *Scope) Hook(h hook.Handler, closer RecordCloser) hook.Handler {
+	if s == nil {
+		return h
+	}
+	wrap := func(fn hook.AdmitFunc) hook.AdmitFunc {
+		if fn == nil {
+			return nil
+		}
+		return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+			if s.skipRequest(ctx, r, closer) {
+				return &hook.Result{Allowed: true}, nil
+			}
+			return fn(ctx, r)
+		}
+	}
+	h.Create = wrap(h.Create)
+	h.Update = wrap(h.Update)
+	h.Delete = wrap(h.Delete)
+	h.Connect = wrap(h.Connect)
+	return h
+}
+
+// skipRequest scopes the object of an admission request, which is the old
+// object of a deletion.
+func (s *Scope) skipRequest(ctx context.Context, r *hook.Request, closer RecordCloser) bool {
+	raw := r.Object.Raw
+	if r.Operation == admission.Delete {
+		raw = r.OldObject.Raw
+	}
+	if len(raw) == 0 {
+		return false
+	}
+
+	var obj metav1.Object
+	if r.Kind.Group == "" && r.Kind.Kind == "Namespace" {
+		var ns corev1.Namespace
+		if err := json.Unmarshal(raw, &ns); err != nil {
+			return false
+		}
+		obj = &ns
+	} else {
+		var partial metav1.PartialObjectMetadata
+		if err := json.Unmarshal(raw, &partial); err != nil {
+			return false
+		}
+		if partial.Namespace == "" {
+			// the namespace is not set in the object of a creation
+			partial.Namespace = r.Namespace
+		}
+		obj = &partial
+	}
+	kind := strings.ToLower(r.Kind.Kind)
+	if !s.Skip(ctx, SourceAdmission, kind, obj) {
+		return false
+	}
+	if closer == nil {
+		return true
+	}
+	resourceType, ok := config.ResourceTypeOfKind(r.Kind.Group, r.Kind.Kind)
+	if !ok {
+		return true
+	}
+	if closer.CloseRecord(ctx, resourceType, obj.GetNamespace(), obj.GetName()) {
+		SkippedObjects.WithLabelValues(SourceAdmission, kind, ReasonLeftScope).Inc()
+		log.Ctx(ctx).Debug().
+			Str("kind", kind).
+			Str("namespace", obj.GetNamespace()).
+			Str("name", obj.GetName()).
+			Msg("Closed the record of an object which left the scope")
+	}
+	return true
+}
+
+func (s *Scope) namespaceLabels(ctx context.Context, namespace string) map[string]string {
+	if s.namespaces == nil || (s.includeNamespaceSelector == nil && s.excludeNamespaceSelector == nil) {
+		return nil
+	}
+	return s.namespaces.NamespaceLabels(ctx, namespace)
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
@@ -0,0 +1,317 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package scope_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/scope"
+	"github.com/cloudzero/cloudzero-agent/app/http/handler"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+// namespaceLabels is a NamespaceLabeler backed by a map.
+type namespaceLabels map[string]map[string]string
+
+func (n namespaceLabels) NamespaceLabels(_ context.Context, namespace string) map[string]string {
+	return n[namespace]
+}
+
+func newPod(namespace string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace, Labels: labels}}
+}
+
+func TestNew_Inactive(t *testing.T) {
+	s, err := scope.New(config.Scope{}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	// a nil scope tracks every object
+	assert.Empty(t, s.Reason(context.Background(), newPod("default", nil)))
+	assert.False(t, s.Skip(context.Background(), scope.SourceWatch, "pod", newPod("default", nil)))
+}
+
+func TestNew_InvalidSelector(t *testing.T) {
+	_, err := scope.New(config.Scope{IncludeObjectSelector: "team in ("}, nil)
+	assert.Error(t, err)
+}
+
+func TestScope_Reason(t *testing.T) {
+	ctx := context.Background()
+	labeler := namespaceLabels{
+		"prod":    {"env": "prod"},
+		"sandbox": {"env": "sandbox"},
+	}
+
+	tests := []struct {
+		name   string
+		config config.Scope
+		obj    metav1.Object
+		want   string
+	}{
+		{
+			name:   "excluded namespace",
+			config: config.Scope{ExcludeNamespaces: []string{"kube-system"}},
+			obj:    newPod("kube-system", nil),
+			want:   scope.ReasonNamespaceExcluded,
+		},
+		{
+			name:   "namespace not included",
+			config: config.Scope{IncludeNamespaces: []string{"prod"}},
+			obj:    newPod("sandbox", nil),
+			want:   scope.ReasonNamespaceNotIncluded,
+		},
+		{
+			name:   "included namespace",
+			config: config.Scope{IncludeNamespaces: []string{"prod"}},
+			obj:    newPod("prod", nil),
+		},
+		{
+			name:   "namespace selector not matching",
+			config: config.Scope{IncludeNamespaceSelector: "env=prod"},
+			obj:    newPod("sandbox", nil),
+			want:   scope.ReasonNamespaceSelector,
+		},
+		{
+			name:   "namespace selector excluding",
+			config: config.Scope{ExcludeNamespaceSelector: "env=sandbox"},
+			obj:    newPod("sandbox", nil),
+			want:   scope.ReasonNamespaceSelector,
+		},
+		{
+			name:   "namespace selector matching",
+			config: config.Scope{IncludeNamespaceSelector: "env=prod"},
+			obj:    newPod("prod", nil),
+		},
+		{
+			name:   "object selector not matching",
+			config: config.Scope{IncludeObjectSelector: "team in (a, b)"},
+			obj:    newPod("prod", map[string]string{"team": "c"}),
+			want:   scope.ReasonObjectSelector,
+		},
+		{
+			name:   "object selector excluding",
+			config: config.Scope{ExcludeObjectSelector: "ephemeral"},
+			obj:    newPod("prod", map[string]string{"ephemeral": "true"}),
+			want:   scope.ReasonObjectSelector,
+		},
+		{
+			name:   "cluster-scoped object",
+			config: config.Scope{IncludeNamespaces: []string{"prod"}},
+			obj:    &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		},
+		{
+			name:   "namespace uses the namespace rules",
+			config: config.Scope{IncludeNamespaces: []string{"prod"}},
+			obj:    &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sandbox"}},
+			want:   scope.ReasonNamespaceNotIncluded,
+		},
+		{
+			name:   "namespace ignores the object selectors",
+			config: config.Scope{IncludeObjectSelector: "team"},
+			obj:    &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := scope.New(tt.config, labeler)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.Reason(ctx, tt.obj))
+		})
+	}
+}
+
+func TestScope_Skip(t *testing.T) {
+	s, err := scope.New(config.Scope{ExcludeNamespaces: []string{"kube-system"}}, nil)
+	require.NoError(t, err)
+
+	counter := scope.SkippedObjects.WithLabelValues(scope.SourceWatch, "pod", scope.ReasonNamespaceExcluded)
+	before := testutil.ToFloat64(counter)
+
+	assert.True(t, s.Skip(context.Background(), scope.SourceWatch, "pod", newPod("kube-system", nil)))
+	assert.False(t, s.Skip(context.Background(), scope.SourceWatch, "pod", newPod("default", nil)))
+	assert.InDelta(t, before+1, testutil.ToFloat64(counter), 0)
+}
+
+func TestScope_Hook(t *testing.T) {
+	s, err := scope.New(config.Scope{
+		IncludeNamespaces:     []string{"prod"},
+		ExcludeObjectSelector: "ephemeral",
+	}, nil)
+	require.NoError(t, err)
+
+	var calls int
+	h := s.Hook(hook.Handler{
+		Create: func(context.Context, *hook.Request) (*hook.Result, error) {
+			calls++
+			return &hook.Result{Allowed: true}, nil
+		},
+		Delete: func(context.Context, *hook.Request) (*hook.Result, error) {
+			calls++
+			return &hook.Result{Allowed: true}, nil
+		},
+	}, nil)
+	assert.Nil(t, h.Update)
+
+	raw := func(obj any) runtime.RawExtension {
+		data, err := json.Marshal(obj)
+		require.NoError(t, err)
+		return runtime.RawExtension{Raw: data}
+	}
+	request := func(operation admission.Operation, kind, namespace string, obj any) *hook.Request {
+		r := &hook.Request{
+			Operation: operation,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+			Namespace: namespace,
+		}
+		if operation == admission.Delete {
+			r.OldObject = raw(obj)
+		} else {
+			r.Object = raw(obj)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		fn      hook.AdmitFunc
+		request *hook.Request
+		calls   int
+	}{
+		{
+			name:    "pod in scope",
+			fn:      h.Create,
+			request: request(admission.Create, "Pod", "prod", newPod("", nil)),
+			calls:   1,
+		},
+		{
+			name:    "pod in a namespace out of scope",
+			fn:      h.Create,
+			request: request(admission.Create, "Pod", "sandbox", newPod("", nil)),
+		},
+		{
+			name:    "deleted pod out of scope",
+			fn:      h.Delete,
+			request: request(admission.Delete, "Pod", "prod", newPod("prod", map[string]string{"ephemeral": "true"})),
+		},
+		{
+			name:    "namespace out of scope",
+			fn:      h.Create,
+			request: request(admission.Create, "Namespace", "", &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sandbox"}}),
+		},
+		{
+			name:    "namespace in scope",
+			fn:      h.Create,
+			request: request(admission.Create, "Namespace", "", &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod"}}),
+			calls:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			result, err := tt.fn(context.Background(), tt.request)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+}
+
+func TestScope_Hook_Nil(t *testing.T) {
+	var s *scope.Scope
+	h := hook.Handler{Create: func(context.Context, *hook.Request) (*hook.Result, error) {
+		return &hook.Result{Allowed: true}, nil
+	}}
+	assert.NotNil(t, s.Hook(h, nil).Create)
+}
+
+func TestScope_Hook_LeftScope(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewMockClock(time.Now())
+	store, err := repo.NewInMemoryResourceRepository(clock)
+	require.NoError(t, err)
+
+	settings := &config.Settings{}
+	for _, pod := range []*corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "left-scope-updated", Namespace: "prod"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "left-scope-deleted", Namespace: "prod"}},
+	} {
+		record := handler.FormatPodData(pod, settings)
+		require.NoError(t, store.Create(ctx, &record))
+	}
+
+	s, err := scope.New(config.Scope{ExcludeObjectSelector: "ephemeral"}, nil)
+	require.NoError(t, err)
+	var calls int
+	allow := func(context.Context, *hook.Request) (*hook.Result, error) {
+		calls++
+		return &hook.Result{Allowed: true}, nil
+	}
+	h := s.Hook(hook.Handler{Update: allow, Delete: allow}, handler.NewRecordCloser(store, clock))
+
+	counter := scope.SkippedObjects.WithLabelValues(scope.SourceAdmission, "pod", scope.ReasonLeftScope)
+	before := testutil.ToFloat64(counter)
+
+	request := func(operation admission.Operation, name string) *hook.Request {
+		data, err := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "prod",
+			Labels:    map[string]string{"ephemeral": "true"},
+		}})
+		require.NoError(t, err)
+		r := &hook.Request{
+			Operation: operation,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: "prod",
+			Name:      name,
+		}
+		if operation == admission.Delete {
+			r.OldObject = runtime.RawExtension{Raw: data}
+		} else {
+			r.Object = runtime.RawExtension{Raw: data}
+		}
+		return r
+	}
+
+	// an update moving the pod out of scope, and the deletion of a pod out of
+	// scope, both close the stored record
+	for _, tt := range []struct {
+		fn      hook.AdmitFunc
+		request *hook.Request
+	}{
+		{h.Update, request(admission.Update, "left-scope-updated")},
+		{h.Delete, request(admission.Delete, "left-scope-deleted")},
+	} {
+		result, err := tt.fn(ctx, tt.request)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+
+		found, err := store.FindFirstBy(ctx, "type = ? AND name = ?", config.Pod, tt.request.Name)
+		require.NoError(t, err)
+		assert.NotNil(t, found.DeletedAt, tt.request.Name)
+	}
+	assert.Zero(t, calls)
+	assert.InDelta(t, before+2, testutil.ToFloat64(counter), 0)
+
+	// a record which is already closed is not closed again
+	_, err = h.Update(ctx, request(admission.Update, "left-scope-updated"))
+	require.NoError(t, err)
+	assert.InDelta(t, before+2, testutil.ToFloat64(counter), 0)
+}
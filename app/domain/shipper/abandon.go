@@ -12,15 +12,22 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/cloudzero/cloudzero-agent/app/build"
 	"github.com/cloudzero/cloudzero-agent/app/instr"
 	"github.com/rs/zerolog"
 )
 
+// AbandonAPIPayloadFile is an entry of an abandon request. It names either a
+// file by its reference ID, or a time range of a replay request for which no
+// file exists.
 type AbandonAPIPayloadFile struct {
-	ReferenceID string `json:"reference_id"` //nolint:tagliatelle // downstream expects camel case
-	Reason      string `json:"reason"`
+	ReferenceID       string     `json:"reference_id,omitempty"` //nolint:tagliatelle // downstream expects camel case
+	Reason            string     `json:"reason"`
+	Start             *time.Time `json:"start,omitempty"`
+	End               *time.Time `json:"end,omitempty"`
+	ContentIdentifier string     `json:"content_identifier,omitempty"` //nolint:tagliatelle // downstream expects camel case
 }
 
 // AbandonFiles sends an abandon request for a list of files with a given
@@ -34,16 +41,6 @@ func (m *MetricShipper) AbandonFiles(ctx context.Context, referenceIDs []string,
 		)
 		logger.Debug().Msg("Abandoning files ...")
 
-		if len(referenceIDs) == 0 {
-			return errors.New("cannot send in an empty slice")
-		}
-
-		// get the shipper id
-		shipperID, err := m.GetShipperID()
-		if err != nil {
-			return errors.Join(ErrInvalidShipperID, fmt.Errorf("failed to get the shipper id: %w", err))
-		}
-
 		// create the body
 		body := make([]*AbandonAPIPayloadFile, len(referenceIDs))
 		for i, item := range referenceIDs {
@@ -53,61 +50,110 @@ func (m *MetricShipper) AbandonFiles(ctx context.Context, referenceIDs []string,
 			}
 		}
 
-		// serialize the body
-		enc, err := json.Marshal(body)
-		if err != nil {
-			return errors.Join(ErrEncodeBody, fmt.Errorf("failed to encode the body: %w", err))
-		}
-
-		// Create a new HTTP request
-		abandonEndpoint, err := m.setting.GetRemoteAPIBase()
-		if err != nil {
-			return errors.Join(ErrGetRemoteBase, fmt.Errorf("failed to get the abandon endpoint: %w", err))
-		}
-		abandonEndpoint.Path += abandonAPIPath
-		req, err := http.NewRequestWithContext(m.ctx, "POST", abandonEndpoint.String(), bytes.NewBuffer(enc))
-		if err != nil {
-			return errors.Join(ErrHTTPUnknown, fmt.Errorf("failed to create the HTTP request: %w", err))
-		}
-
-		// Set necessary headers
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", m.setting.GetAPIKey())
-		req.Header.Set(ShipperIDRequestHeader, shipperID)
-		req.Header.Set(AppVersionRequestHeader, build.GetVersion())
-
-		// Make sure we set the query parameters for count, cloud_account_id, region, cluster_name
-		q := req.URL.Query()
-		q.Add("count", strconv.Itoa(len(referenceIDs)))
-		q.Add("cluster_name", m.setting.ClusterName)
-		q.Add("cloud_account_id", m.setting.CloudAccountID)
-		q.Add("region", m.setting.Region)
-		q.Add("shipper_id", shipperID)
-		req.URL.RawQuery = q.Encode()
-
-		logger.Debug().Str("url", req.URL.String()).Msg("Abandoning files")
-
-		// Send the request
-		resp, err := m.SendHTTPRequest(ctx, "shipper_AbandonFiles_httpRequest", req)
-		if err != nil {
+		if err := m.sendAbandonRequest(ctx, "shipper_AbandonFiles_httpRequest", body); err != nil {
 			return err
 		}
 
-		defer resp.Body.Close()
+		logger.Debug().Msg("Successfully abandoned files")
+		return nil
+	})
+}
+
+// AbandonRanges sends an abandon request for the parts of replay request
+// ranges which will never be uploaded, either because no data exists for them
+// or because the request expired.
+func (m *MetricShipper) AbandonRanges(ctx context.Context, ranges []ReplayRange, reason string) error {
+	return m.metrics.SpanCtx(ctx, "shipper_AbandonRanges", func(ctx context.Context, id string) error {
+		logger := instr.SpanLogger(ctx, id,
+			func(ctx zerolog.Context) zerolog.Context {
+				return ctx.Int("numRanges", len(ranges))
+			},
+		)
+		logger.Debug().Msg("Abandoning ranges ...")
 
-		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-			return ErrUnauthorized
+		// create the body
+		body := make([]*AbandonAPIPayloadFile, len(ranges))
+		for i, item := range ranges {
+			start, end := item.Start, item.End
+			body[i] = &AbandonAPIPayloadFile{
+				Reason:            reason,
+				Start:             &start,
+				End:               &end,
+				ContentIdentifier: item.ContentIdentifier,
+			}
 		}
 
-		// Check for HTTP errors
-		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			return errors.Join(ErrHTTPUnknown, fmt.Errorf("unexpected status code: statusCode=%d, body=%s", resp.StatusCode, string(bodyBytes)))
+		if err := m.sendAbandonRequest(ctx, "shipper_AbandonRanges_httpRequest", body); err != nil {
+			return err
 		}
 
-		logger.Debug().Msg("Successfully abandoned files")
-
-		// success
+		logger.Debug().Msg("Successfully abandoned ranges")
 		return nil
 	})
 }
+
+// sendAbandonRequest posts the abandon request entries to the remote.
+func (m *MetricShipper) sendAbandonRequest(ctx context.Context, spanName string, body []*AbandonAPIPayloadFile) error {
+	if len(body) == 0 {
+		return errors.New("cannot send in an empty slice")
+	}
+
+	// get the shipper id
+	shipperID, err := m.GetShipperID()
+	if err != nil {
+		return errors.Join(ErrInvalidShipperID, fmt.Errorf("failed to get the shipper id: %w", err))
+	}
+
+	// serialize the body
+	enc, err := json.Marshal(body)
+	if err != nil {
+		return errors.Join(ErrEncodeBody, fmt.Errorf("failed to encode the body: %w", err))
+	}
+
+	// Create a new HTTP request
+	abandonEndpoint, err := m.setting.GetRemoteAPIBase()
+	if err != nil {
+		return errors.Join(ErrGetRemoteBase, fmt.Errorf("failed to get the abandon endpoint: %w", err))
+	}
+	abandonEndpoint.Path += abandonAPIPath
+	req, err := http.NewRequestWithContext(m.ctx, "POST", abandonEndpoint.String(), bytes.NewBuffer(enc))
+	if err != nil {
+		return errors.Join(ErrHTTPUnknown, fmt.Errorf("failed to create the HTTP request: %w", err))
+	}
+
+	// Set necessary headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", m.setting.GetAPIKey())
+	req.Header.Set(ShipperIDRequestHeader, shipperID)
+	req.Header.Set(AppVersionRequestHeader, build.GetVersion())
+
+	// Make sure we set the query parameters for count, cloud_account_id, region, cluster_name
+	q := req.URL.Query()
+	q.Add("count", strconv.Itoa(len(body)))
+	q.Add("cluster_name", m.setting.ClusterName)
+	q.Add("cloud_account_id", m.setting.CloudAccountID)
+	q.Add("region", m.setting.Region)
+	q.Add("shipper_id", shipperID)
+	req.URL.RawQuery = q.Encode()
+
+	// Send the request
+	resp, err := m.SendHTTPRequest(ctx, spanName, req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return ErrUnauthorized
+	}
+
+	// Check for HTTP errors
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return errors.Join(ErrHTTPUnknown, fmt.Errorf("unexpected status code: statusCode=%d, body=%s", resp.StatusCode, string(bodyBytes)))
+	}
+
+	// success
+	return nil
+}
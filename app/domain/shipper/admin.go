@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudzero/cloudzero-agent/app/store"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// File categories exposed through the admin API.
+const (
+	FileCategoryUnsent     = "unsent"
+	FileCategoryUploaded   = "uploaded"
+	FileCategoryReplay     = "replay"
+	FileCategoryQuarantine = "quarantine"
+)
+
+// FileCategories lists every file category in display order.
+var FileCategories = []string{
+	FileCategoryUnsent,
+	FileCategoryUploaded,
+	FileCategoryReplay,
+	FileCategoryQuarantine,
+}
+
+// ErrUnknownFileCategory is returned when a file category is not one of
+// FileCategories.
+var ErrUnknownFileCategory = errors.New("unknown file category")
+
+// RunStatus is the outcome of a single shipper cycle.
+type RunStatus struct {
+	StartedAt    time.Time `json:"startedAt"`
+	FinishedAt   time.Time `json:"finishedAt"`
+	ShippedFiles uint64    `json:"shippedFiles"`
+	Error        string    `json:"error,omitempty"`
+	ErrorCode    string    `json:"errorCode,omitempty"`
+}
+
+// Status is a snapshot of the state of the shipper.
+type Status struct {
+	ShipperID    string     `json:"shipperId"`
+	ShippedFiles uint64     `json:"shippedFiles"`
+	LastRun      *RunStatus `json:"lastRun,omitempty"`
+}
+
+// StoredFile describes a file held by the shipper on disk.
+type StoredFile struct {
+	Name     string     `json:"name"`
+	Category string     `json:"category"`
+	Size     int64      `json:"size"`
+	ModTime  time.Time  `json:"modTime"`
+	Start    *time.Time `json:"start,omitempty"`
+	Stop     *time.Time `json:"stop,omitempty"`
+	Rows     *int       `json:"rows,omitempty"`
+}
+
+func (m *MetricShipper) setLastRun(status *RunStatus) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.lastRun = status
+}
+
+// Status returns the current state of the shipper, including the result of the
+// last shipper cycle.
+func (m *MetricShipper) Status() Status {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+
+	status := Status{
+		ShippedFiles: atomic.LoadUint64(&m.shippedFiles),
+	}
+	if id, err := m.GetShipperID(); err == nil {
+		status.ShipperID = id
+	}
+	if m.lastRun != nil {
+		lastRun := *m.lastRun
+		status.LastRun = &lastRun
+	}
+
+	return status
+}
+
+// RunNow runs a shipper cycle immediately, waiting for any cycle which is
+// already in progress to finish first.
+func (m *MetricShipper) RunNow() error {
+	if err := m.runShipper(m.ctx); err != nil {
+		metricShipperRunFailTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
+		return err
+	}
+	return nil
+}
+
+// SubmitReplayRequest queues a replay request for the given reference IDs and
+// time ranges, to be processed in the next shipper cycle.
+func (m *MetricShipper) SubmitReplayRequest(ctx context.Context, referenceIDs []string, ranges []ReplayRange) (*ReplayRequest, error) {
+	if len(referenceIDs) == 0 && len(ranges) == 0 {
+		return nil, errors.New("the replay request has no criteria")
+	}
+	for _, r := range ranges {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	rr := &ReplayRequest{
+		ReferenceIDs: types.NewSetFromList(referenceIDs),
+		Ranges:       ranges,
+	}
+	if err := m.SaveReplayRequest(ctx, rr); err != nil {
+		metricReplayRequestSaveErrorTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
+		return nil, err
+	}
+
+	metricReplayRequestTotal.WithLabelValues().Inc()
+	metricReplayRequestCurrent.WithLabelValues().Inc()
+
+	return rr, nil
+}
+
+// GetCategoryDir returns the directory holding the files of a category.
+func (m *MetricShipper) GetCategoryDir(category string) (string, error) {
+	switch category {
+	case FileCategoryUnsent:
+		return m.GetBaseDir(), nil
+	case FileCategoryUploaded:
+		return m.GetUploadedDir(), nil
+	case FileCategoryReplay:
+		return m.GetReplayRequestDir(), nil
+	case FileCategoryQuarantine:
+		return m.GetQuarantineDir(), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownFileCategory, category)
+	}
+}
+
+// ListStoredFiles lists the files of a category, oldest first.
+func (m *MetricShipper) ListStoredFiles(category string) ([]StoredFile, error) {
+	var (
+		paths []string
+		err   error
+	)
+	switch category {
+	case FileCategoryUnsent:
+		paths, err = m.store.GetFiles()
+	case FileCategoryUploaded:
+		paths, err = m.store.GetFiles(UploadedSubDirectory)
+	case FileCategoryQuarantine:
+		paths, err = m.store.GetFiles(QuarantineSubDirectory)
+	case FileCategoryReplay:
+		paths, err = filepath.Glob(filepath.Join(m.GetReplayRequestDir(), "*.json"))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFileCategory, category)
+	}
+	if err != nil {
+		return nil, errors.Join(ErrFilesList, fmt.Errorf("failed to list the %s files: %w", category, err))
+	}
+
+	files := make([]StoredFile, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// the file was moved since it was listed
+			continue
+		}
+
+		file := StoredFile{
+			Name:     filepath.Base(path),
+			Category: category,
+			Size:     info.Size(),
+			ModTime:  info.ModTime().UTC(),
+		}
+		if start, stop, ok := store.ParseFileTimeRange(path); ok && category != FileCategoryReplay {
+			file.Start = &start
+			file.Stop = &stop
+		}
+		if integrity := store.ParseFileIntegrity(path); integrity != nil {
+			rows := integrity.Rows
+			file.Rows = &rows
+		}
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime.Before(files[j].ModTime)
+	})
+
+	return files, nil
+}
+
+// GetStoredFilePath resolves the path of a single file in a category. Only
+// plain file names are accepted, so the result is always inside the category
+// directory.
+func (m *MetricShipper) GetStoredFilePath(category, name string) (string, error) {
+	dir, err := m.GetCategoryDir(category)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid file name: %s", name)
+	}
+
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", types.ErrNotFound, name)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%w: %s", types.ErrNotFound, name)
+	}
+
+	return path, nil
+}
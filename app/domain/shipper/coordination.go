@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper
+
+import (
+	"fmt"
+	"time"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/lock"
+)
+
+// newLocker creates the lock guarding a directory shared by the replicas,
+// using the configured coordination backend. With the lease backend, `name`
+// is appended to the configured lease name; with the file backend, the lock
+// file is created at `path`.
+func (m *MetricShipper) newLocker(name, path string) lock.Locker {
+	c := m.setting.Coordination
+	if c.Backend == config.CoordinationBackendLease {
+		return lock.NewLeaseLock(
+			m.ctx, m.k8sClient, c.LeaseNamespace, fmt.Sprintf("%s-%s", c.LeaseName, name),
+			lock.WithLeaseDuration(c.LeaseDuration),
+			lock.WithLeaseRenewInterval(c.RenewInterval),
+			lock.WithLeaseMaxRetry(lockMaxRetry),
+		)
+	}
+
+	return lock.NewFileLock(
+		m.ctx, path,
+		lock.WithStaleTimeout(time.Second*30), // detects stale timeout
+		lock.WithRefreshInterval(time.Second*5),
+		lock.WithMaxRetry(lockMaxRetry), // 5 min wait
+	)
+}
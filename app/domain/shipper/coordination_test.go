@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
+)
+
+func TestShipper_Unit_LeaseCoordination(t *testing.T) {
+	tmpDir := getTmpDir(t)
+
+	settings := getMockSettings("https://example.com", tmpDir)
+	settings.Coordination = config.Coordination{
+		Backend:        config.CoordinationBackendLease,
+		LeaseName:      "cloudzero-shipper",
+		LeaseNamespace: "cloudzero",
+		LeaseDuration:  time.Second,
+		RenewInterval:  100 * time.Millisecond,
+	}
+
+	// a kubernetes client is required
+	_, err := shipper.NewMetricShipper(context.Background(), settings, nil)
+	require.Error(t, err)
+
+	client := fake.NewClientset()
+	mockFiles := &MockAppendableFiles{baseDir: tmpDir}
+	mockFiles.On("GetFiles", []string(nil)).Return([]string{}, nil)
+
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, mockFiles, shipper.WithKubernetesClient(client))
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = &MockRoundTripper{status: http.StatusOK, mockResponseBody: map[string]string{}}
+
+	require.NoError(t, metricShipper.ProcessNewFiles(context.Background()))
+	require.NoError(t, metricShipper.ProcessReplayRequests(context.Background()))
+
+	// the leases were taken and released
+	for _, name := range []string{"cloudzero-shipper-new-files", "cloudzero-shipper-replay"} {
+		lease, err := client.CoordinationV1().Leases("cloudzero").Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		require.Nil(t, lease.Spec.HolderIdentity)
+	}
+}
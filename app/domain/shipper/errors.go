@@ -70,10 +70,11 @@ var (
 	ErrCreateLock      = NewShipperError("err-lock-create", "failed to create or acquire the lock")
 	ErrReleaseLock     = NewShipperError("err-lock-release", "failed to release the lock")
 
-	ErrFilesList  = NewShipperError("err-files-walk", "failed to list/walk the files")
-	ErrFileRemove = NewShipperError("err-file-remove", "failed to remove a file")
-	ErrFileCreate = NewShipperError("err-file-create", "failed to create a file")
-	ErrFileRead   = NewShipperError("err-file-read", "failed to read a file")
+	ErrFilesList   = NewShipperError("err-files-walk", "failed to list/walk the files")
+	ErrFileRemove  = NewShipperError("err-file-remove", "failed to remove a file")
+	ErrFileCreate  = NewShipperError("err-file-create", "failed to create a file")
+	ErrFileRead    = NewShipperError("err-file-read", "failed to read a file")
+	ErrFileCorrupt = NewShipperError("err-file-corrupt", "the file failed the integrity check")
 
 	ErrStorageCleanup = NewShipperError("err-storage-cleanup", "failed to clean up the disk")
 	ErrGetDiskUsage   = NewShipperError("err-get-disk-usage", "failed to get the disk usage")
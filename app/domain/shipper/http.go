@@ -39,6 +39,14 @@ func (m *MetricShipper) SendHTTPRequest(
 			return fmt.Errorf("failed to inspect the HTTP response: %w", err)
 		}
 
+		// the API key may have been rotated, so retry once with the next key
+		if inspector.IsUnauthorized(resp) {
+			if retry := m.retryWithNextAPIKey(req, logger); retry != nil {
+				resp.Body.Close()
+				resp = retry
+			}
+		}
+
 		logger.Debug().Msg("Successfully sent HTTP request")
 		return nil
 	})
@@ -48,3 +56,40 @@ func (m *MetricShipper) SendHTTPRequest(
 
 	return resp, nil
 }
+
+// retryWithNextAPIKey sends a request rejected as unauthorized again with the
+// next API key, and promotes the next key when it is accepted. It returns nil
+// when the request cannot be retried.
+func (m *MetricShipper) retryWithNextAPIKey(req *http.Request, logger zerolog.Logger) *http.Response {
+	next := m.setting.GetNextAPIKey()
+	if next == "" || req.Header.Get("Authorization") != m.setting.GetAPIKey() {
+		// no rotation is in progress, or the request was not authenticated
+		// with the API key
+		return nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", next)
+
+	logger.Info().Msg("Retrying the HTTP request with the next API key")
+	resp, err := m.HTTPClient.Do(retry)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to retry the HTTP request with the next API key")
+		return nil
+	}
+	if !inspector.IsUnauthorized(resp) {
+		m.setting.PromoteAPIKey(next)
+		logger.Info().Msg("The next API key was accepted and is now the active key")
+	}
+	return resp
+}
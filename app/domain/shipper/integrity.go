@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudzero/cloudzero-agent/app/instr"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/rs/zerolog"
+)
+
+// VerifyFiles checks the contents of each file against the checksum recorded
+// when the file was written, and returns the files which passed.
+//
+// Files which fail are moved to the quarantine directory so they are not
+// retried on every cycle, and are abandoned with the remote so the loss is
+// reported per file.
+func (m *MetricShipper) VerifyFiles(ctx context.Context, files []types.File) ([]types.File, error) {
+	valid := make([]types.File, 0, len(files))
+
+	err := m.metrics.SpanCtx(ctx, "shipper_VerifyFiles", func(ctx context.Context, id string) error {
+		logger := instr.SpanLogger(ctx, id, func(ctx zerolog.Context) zerolog.Context {
+			return ctx.Int("numFiles", len(files))
+		})
+		logger.Debug().Msg("Verifying file integrity ...")
+
+		corrupt := make([]string, 0)
+		for _, file := range files {
+			if err := file.Verify(); err != nil {
+				err = errors.Join(ErrFileCorrupt, err)
+				logger.Err(err).Str("fileId", GetRemoteFileID(file)).Msg("File failed the integrity check")
+				metricFileIntegrityErrorTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
+
+				if err := m.QuarantineFile(ctx, file); err != nil {
+					return err
+				}
+				corrupt = append(corrupt, GetRemoteFileID(file))
+				continue
+			}
+			valid = append(valid, file)
+		}
+
+		if len(corrupt) == 0 {
+			logger.Debug().Msg("All files passed the integrity check")
+			return nil
+		}
+
+		// report the corrupt files, but do not block the valid ones on it
+		if err := m.AbandonFiles(ctx, corrupt, "integrity check failed"); err != nil {
+			logger.Err(err).Int("numCorrupt", len(corrupt)).Msg("Failed to abandon the corrupt files")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return valid, nil
+}
+
+// QuarantineFile moves a file into the quarantine directory.
+func (m *MetricShipper) QuarantineFile(ctx context.Context, file types.File) error {
+	return m.metrics.SpanCtx(ctx, "shipper_QuarantineFile", func(ctx context.Context, id string) error {
+		logger := instr.SpanLogger(ctx, id, func(ctx zerolog.Context) zerolog.Context {
+			return ctx.Str("fileId", GetRemoteFileID(file))
+		})
+		logger.Debug().Msg("Quarantining file")
+
+		quarantineDir := m.GetQuarantineDir()
+		if err := os.MkdirAll(quarantineDir, filePermissions); err != nil {
+			return errors.Join(ErrCreateDirectory, fmt.Errorf("failed to create the quarantine directory: %w", err))
+		}
+
+		location, err := file.Location()
+		if err != nil {
+			return fmt.Errorf("failed to get the file location: %w", err)
+		}
+
+		if err := file.Rename(filepath.Join(quarantineDir, filepath.Base(location))); err != nil {
+			return fmt.Errorf("failed to move the file to the quarantine directory: %w", err)
+		}
+
+		logger.Debug().Msg("Successfully quarantined file")
+
+		return nil
+	})
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
+	"github.com/cloudzero/cloudzero-agent/app/store"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+func TestShipper_Unit_VerifyFiles_QuarantinesCorruptFiles(t *testing.T) {
+	tmpDir := getTmpDir(t)
+	mockURL := "https://example.com"
+
+	mockRoundTripper := &MockRoundTripper{
+		status:           http.StatusOK,
+		mockResponseBody: map[string]string{},
+	}
+
+	settings := getMockSettings(mockURL, tmpDir)
+
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, nil)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = mockRoundTripper
+
+	// a legacy file without a recorded checksum, and a file whose recorded
+	// checksum does not match the content
+	files := createTestFiles(t, tmpDir, 2)
+	location, err := files[1].Location()
+	require.NoError(t, err)
+	corruptPath := strings.TrimSuffix(location, ".json.br") + ".3." + strings.Repeat("0", 64) + ".json.br"
+	require.NoError(t, os.Rename(location, corruptPath))
+	corrupt, err := store.NewMetricFile(corruptPath)
+	require.NoError(t, err)
+
+	valid, err := metricShipper.VerifyFiles(context.Background(), []types.File{files[0], corrupt})
+	require.NoError(t, err)
+	require.Len(t, valid, 1)
+	assert.Equal(t, files[0].UniqueID(), valid[0].UniqueID())
+
+	// the corrupt file was moved out of the way
+	_, err = os.Stat(corruptPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(tmpDir, shipper.QuarantineSubDirectory, filepath.Base(corruptPath)))
+	assert.NoError(t, err)
+}
+
+func TestShipper_Unit_HandleRequest_RowCountMismatch(t *testing.T) {
+	tmpDir := getTmpDir(t)
+
+	// a finalized file whose name records one more row than it holds, with
+	// the checksum of its content
+	ds, err := store.NewDiskStore(config.Database{StoragePath: tmpDir, MaxRecords: 100}, store.WithContentIdentifier(store.CostContentIdentifier))
+	require.NoError(t, err)
+	require.NoError(t, ds.Put(context.Background(), testMetrics...))
+	require.NoError(t, ds.Flush())
+	paths, err := ds.GetFiles()
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	integrity := store.ParseFileIntegrity(paths[0])
+	require.NotNil(t, integrity)
+	path := strings.Replace(paths[0], integrity.Suffix(), (&store.FileIntegrity{Rows: integrity.Rows + 1, SHA256: integrity.SHA256}).Suffix(), 1)
+	require.NoError(t, os.Rename(paths[0], path))
+	file, err := store.NewMetricFile(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	urls := &MockRoundTripper{
+		status:           http.StatusOK,
+		mockResponseBody: map[string]string{shipper.GetRemoteFileID(file): "https://s3.amazonaws.com/bucket/file.parquet?signature=abc123"},
+	}
+	uploads := 0
+	settings := getMockSettings("https://example.com/upload", tmpDir)
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, nil)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodPut {
+			uploads++
+		}
+		return urls.RoundTrip(req)
+	})
+
+	// the checksum matches, so the file is only rejected once it is read
+	err = metricShipper.HandleRequest(context.Background(), []types.File{file})
+	require.ErrorIs(t, err, store.ErrRowCountMismatch)
+	assert.Zero(t, uploads)
+
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(metricShipper.GetUploadedDir(), filepath.Base(path)))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -92,6 +92,16 @@ var (
 		[]string{"error_status_code"},
 	)
 
+	// File integrity
+	// ----------------------------------------------------------
+	metricFileIntegrityErrorTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shipper_file_integrity_error_total",
+			Help: "Total number of files which failed the integrity check and were quarantined",
+		},
+		[]string{"error_status_code"},
+	)
+
 	// Replay Requests
 	// ----------------------------------------------------------
 	metricReplayRequestTotal = prometheus.NewCounterVec(
@@ -134,6 +144,30 @@ var (
 		[]string{},
 	)
 
+	metricReplayRequestErrorStatusTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shipper_replay_request_error_status_total",
+			Help: "Number of errors observed while processing replay requests, labeled by error status code",
+		},
+		[]string{"error_status_code"},
+	)
+
+	metricReplayRequestStateCurrent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shipper_replay_request_state_current",
+			Help: "The current number of queued replay requests in each state",
+		},
+		[]string{"state"},
+	)
+
+	metricReplayRequestFinishedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shipper_replay_request_finished_total",
+			Help: "Total number of replay requests finished, by final state",
+		},
+		[]string{"state"},
+	)
+
 	metricReplayRequestAbandonFilesTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "shipper_replay_request_abandon_files_total",
@@ -142,6 +176,22 @@ var (
 		[]string{},
 	)
 
+	metricReplayRequestGapRangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shipper_replay_request_gap_ranges_total",
+			Help: "total number of replay request range parts abandoned because no data exists for them",
+		},
+		[]string{},
+	)
+
+	metricReplayRequestExpiredRangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shipper_replay_request_expired_ranges_total",
+			Help: "total number of replay request ranges abandoned because the request expired",
+		},
+		[]string{},
+	)
+
 	metricReplayRequestAbandonFilesErrorTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "shipper_replay_request_abandon_files_error_total",
@@ -257,13 +307,21 @@ func InitMetrics() (*instr.PrometheusMetrics, error) {
 			metricFileUploadErrorTotal,
 			metricMarkFileUploadedErrorTotal,
 
+			// file integrity
+			metricFileIntegrityErrorTotal,
+
 			// replay requests
 			metricReplayRequestTotal,
 			metricReplayRequestCurrent,
 			metricReplayRequestSaveErrorTotal,
 			metricReplayRequestFileCount,
 			metricReplayRequestErrorTotal,
+			metricReplayRequestErrorStatusTotal,
+			metricReplayRequestStateCurrent,
+			metricReplayRequestFinishedTotal,
 			metricReplayRequestAbandonFilesTotal,
+			metricReplayRequestGapRangesTotal,
+			metricReplayRequestExpiredRangesTotal,
 			metricReplayRequestAbandonFilesErrorTotal,
 
 			// disk usage
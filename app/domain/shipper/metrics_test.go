@@ -38,13 +38,21 @@ func TestShipper_Unit_Metrics(t *testing.T) {
 	metricFileUploadErrorTotal.WithLabelValues("err").Inc()
 	metricMarkFileUploadedErrorTotal.WithLabelValues("err").Inc()
 
+	// file integrity
+	metricFileIntegrityErrorTotal.WithLabelValues("err").Inc()
+
 	// replay requests
 	metricReplayRequestTotal.WithLabelValues().Inc()
 	metricReplayRequestCurrent.WithLabelValues().Inc()
 	metricReplayRequestFileCount.Observe(100)
 	metricReplayRequestSaveErrorTotal.WithLabelValues("err").Inc()
 	metricReplayRequestErrorTotal.WithLabelValues().Inc()
+	metricReplayRequestErrorStatusTotal.WithLabelValues("err").Inc()
+	metricReplayRequestStateCurrent.WithLabelValues("pending").Set(1)
+	metricReplayRequestFinishedTotal.WithLabelValues("done").Inc()
 	metricReplayRequestAbandonFilesTotal.WithLabelValues().Inc()
+	metricReplayRequestGapRangesTotal.WithLabelValues().Inc()
+	metricReplayRequestExpiredRangesTotal.WithLabelValues().Inc()
 	metricReplayRequestAbandonFilesErrorTotal.WithLabelValues("err").Inc()
 
 	// disk usage
@@ -84,13 +92,20 @@ func TestShipper_Unit_Metrics(t *testing.T) {
 	require.Contains(t, string(body), "shipper_file_upload_error_total")
 	require.Contains(t, string(body), "shipper_mark_file_uploaded_error_total")
 
+	// file integrity
+	require.Contains(t, string(body), "shipper_file_integrity_error_total")
+
 	// replay requests
 	require.Contains(t, string(body), "shipper_replay_request_total")
 	require.Contains(t, string(body), "shipper_replay_request_current")
 	require.Contains(t, string(body), "shipper_replay_request_file_count")
 	require.Contains(t, string(body), "shipper_replay_request_save_error_total")
 	require.Contains(t, string(body), "shipper_replay_request_error_total")
+	require.Contains(t, string(body), "shipper_replay_request_state_current")
+	require.Contains(t, string(body), "shipper_replay_request_finished_total")
 	require.Contains(t, string(body), "shipper_replay_request_abandon_files_total")
+	require.Contains(t, string(body), "shipper_replay_request_gap_ranges_total")
+	require.Contains(t, string(body), "shipper_replay_request_expired_ranges_total")
 	require.Contains(t, string(body), "shipper_replay_request_abandon_files_error_total")
 
 	// disk usage
@@ -12,24 +12,67 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudzero/cloudzero-agent/app/instr"
-	"github.com/cloudzero/cloudzero-agent/app/lock"
 	"github.com/cloudzero/cloudzero-agent/app/store"
 	"github.com/cloudzero/cloudzero-agent/app/types"
 	"github.com/go-obvious/timestamp"
 	"github.com/rs/zerolog"
 )
 
+// ReplayRequestState is the processing state of a replay request.
+type ReplayRequestState string
+
+// Replay request states. Only pending and in-progress requests are stored on
+// disk, done and expired requests are removed.
+const (
+	ReplayRequestStatePending    ReplayRequestState = "pending"
+	ReplayRequestStateInProgress ReplayRequestState = "in-progress"
+	ReplayRequestStateDone       ReplayRequestState = "done"
+	ReplayRequestStateExpired    ReplayRequestState = "expired"
+)
+
+// ReplayRequestStates lists every replay request state.
+var ReplayRequestStates = []ReplayRequestState{
+	ReplayRequestStatePending,
+	ReplayRequestStateInProgress,
+	ReplayRequestStateDone,
+	ReplayRequestStateExpired,
+}
+
+// ReplayRequest is a request from the remote to upload files again. Files are
+// selected either by their reference ID, or by the time range they cover.
+//
+// The progress of the request is written back to its file as it is processed,
+// so a request interrupted by a restart resumes where it left off.
 type ReplayRequest struct {
 	Filepath     string             `json:"filepath"`
 	ReferenceIDs *types.Set[string] `json:"referenceIds"` //nolint:tagliatelle // I dont want to use IDs
+	Ranges       []ReplayRange      `json:"ranges,omitempty"`
+
+	State     ReplayRequestState `json:"state,omitempty"`
+	CreatedAt time.Time          `json:"createdAt,omitempty"`
+	UpdatedAt time.Time          `json:"updatedAt,omitempty"`
+	Attempts  int                `json:"attempts,omitempty"`
+	LastError string             `json:"lastError,omitempty"`
+	// Completed holds the reference IDs already uploaded or abandoned
+	Completed *types.Set[string] `json:"completed,omitempty"`
+	// Gaps holds the parts of the ranges for which no file was found, and
+	// which were already abandoned by their time range.
+	Gaps []ReplayRange `json:"gaps,omitempty"`
+	// Uploaded holds the time spans of the files uploaded for the ranges, so
+	// an expired request only abandons the parts which were not sent.
+	Uploaded []ReplayRange `json:"uploaded,omitempty"`
 }
 
 type replayRequestHeaderValue struct {
-	RefID string `json:"ref_id"` //nolint:tagliatelle // upstream uses cammel case
-	URL   string `json:"url"`
+	RefID             string     `json:"ref_id"` //nolint:tagliatelle // upstream uses cammel case
+	URL               string     `json:"url"`
+	Start             *time.Time `json:"start,omitempty"`
+	End               *time.Time `json:"end,omitempty"`
+	ContentIdentifier string     `json:"content_identifier,omitempty"` //nolint:tagliatelle // upstream uses cammel case
 }
 
 func NewReplayRequestFromHeader(value string) (*ReplayRequest, error) {
@@ -44,12 +87,47 @@ func NewReplayRequestFromHeader(value string) (*ReplayRequest, error) {
 		ReferenceIDs: types.NewSet[string](),
 	}
 	for _, item := range rrh {
-		rr.ReferenceIDs.Add(item.RefID)
+		if item.RefID != "" {
+			rr.ReferenceIDs.Add(item.RefID)
+		}
+
+		// time range criteria
+		if item.Start != nil || item.End != nil {
+			r := ReplayRange{ContentIdentifier: item.ContentIdentifier}
+			if item.Start != nil {
+				r.Start = item.Start.UTC()
+			}
+			if item.End != nil {
+				r.End = item.End.UTC()
+			}
+			if err := r.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid replay request range: %w", err)
+			}
+			rr.Ranges = append(rr.Ranges, r)
+		}
+	}
+
+	if rr.ReferenceIDs.Size() == 0 && len(rr.Ranges) == 0 {
+		return nil, errors.New("the replay request has no criteria")
 	}
 
 	return &rr, nil
 }
 
+// Matches returns true when the file at `path` is requested by this replay
+// request, either by reference ID or by time range.
+func (rr *ReplayRequest) Matches(path string, file types.File) bool {
+	if rr.ReferenceIDs != nil && rr.ReferenceIDs.Contains(GetRemoteFileID(file)) {
+		return true
+	}
+	for _, r := range rr.Ranges {
+		if r.Matches(path) {
+			return true
+		}
+	}
+	return false
+}
+
 // SaveReplayRequest saves a reply-request from the remote to disk to be picked
 // up on next iteration.
 func (m *MetricShipper) SaveReplayRequest(ctx context.Context, rr *ReplayRequest) error {
@@ -61,21 +139,36 @@ func (m *MetricShipper) SaveReplayRequest(ctx context.Context, rr *ReplayRequest
 		}
 
 		// compose the filename
-		rr.Filepath = filepath.Join(m.GetReplayRequestDir(), fmt.Sprintf(replayFileFormat, timestamp.Milli()))
+		now := timestamp.Milli()
+		rr.Filepath = filepath.Join(m.GetReplayRequestDir(), fmt.Sprintf(replayFileFormat, now))
+		rr.CreatedAt = time.UnixMilli(now).UTC()
+		rr.State = ReplayRequestStatePending
 
-		// encode to json
-		enc, err := json.Marshal(rr)
-		if err != nil {
-			return errors.Join(ErrEncodeBody, fmt.Errorf("failed to encode the replay request to json: %w", err))
-		}
+		return m.writeReplayRequest(rr)
+	})
+}
 
-		// write the file
-		if err := os.WriteFile(rr.Filepath, enc, filePermissions); err != nil {
-			return errors.Join(ErrFileCreate, fmt.Errorf("failed to write the replay request to file: %w", err))
-		}
+// writeReplayRequest writes the replay request to its file. The file is
+// replaced atomically, so a crash never leaves a partial request behind.
+func (m *MetricShipper) writeReplayRequest(rr *ReplayRequest) error {
+	rr.UpdatedAt = time.Now().UTC()
 
-		return nil
-	})
+	// encode to json
+	enc, err := json.Marshal(rr)
+	if err != nil {
+		return errors.Join(ErrEncodeBody, fmt.Errorf("failed to encode the replay request to json: %w", err))
+	}
+
+	// write the file
+	tmp := rr.Filepath + ".tmp"
+	if err := os.WriteFile(tmp, enc, filePermissions); err != nil {
+		return errors.Join(ErrFileCreate, fmt.Errorf("failed to write the replay request to file: %w", err))
+	}
+	if err := os.Rename(tmp, rr.Filepath); err != nil {
+		return errors.Join(ErrFileCreate, fmt.Errorf("failed to replace the replay request file: %w", err))
+	}
+
+	return nil
 }
 
 // GetActiveReplayRequests gets all active replay request files
@@ -101,8 +194,8 @@ func (m *MetricShipper) GetActiveReplayRequests(ctx context.Context) ([]*ReplayR
 				continue
 			}
 
-			// skip over invalid files (like lock files)
-			if !strings.Contains(item.Name(), strings.Split(replayFileFormat, "-")[0]) || !strings.Contains(item.Name(), ".json") {
+			// skip over invalid files (like lock files and partial writes)
+			if !strings.HasPrefix(item.Name(), strings.Split(replayFileFormat, "-")[0]) || !strings.HasSuffix(item.Name(), ".json") {
 				continue
 			}
 
@@ -118,6 +211,24 @@ func (m *MetricShipper) GetActiveReplayRequests(ctx context.Context) ([]*ReplayR
 			if err := json.Unmarshal(data, &rr); err != nil {
 				return errors.Join(ErrInvalidBody, fmt.Errorf("failed to decode the replay request: %w", err))
 			}
+			if rr.ReferenceIDs == nil {
+				rr.ReferenceIDs = types.NewSet[string]()
+			}
+			if rr.Completed == nil {
+				rr.Completed = types.NewSet[string]()
+			}
+
+			// requests written by older versions have no state
+			rr.Filepath = fullpath
+			if rr.State == "" {
+				rr.State = ReplayRequestStatePending
+			}
+			if rr.CreatedAt.IsZero() {
+				var ms int64
+				if _, err := fmt.Sscanf(item.Name(), replayFileFormat, &ms); err == nil {
+					rr.CreatedAt = time.UnixMilli(ms).UTC()
+				}
+			}
 			requests = append(requests, &rr)
 		}
 		return nil
@@ -141,12 +252,7 @@ func (m *MetricShipper) ProcessReplayRequests(ctx context.Context) error {
 
 		// lock the replay request dir for the duration of the replay request processing
 		logger.Debug().Msg("Aquiring replay request file lock")
-		l := lock.NewFileLock(
-			m.ctx, filepath.Join(m.GetReplayRequestDir(), ".lock"),
-			lock.WithStaleTimeout(time.Second*30), // detects stale timeout
-			lock.WithRefreshInterval(time.Second*5),
-			lock.WithMaxRetry(lockMaxRetry), // 5 min wait
-		)
+		l := m.newLocker(replayLockName, filepath.Join(m.GetReplayRequestDir(), ".lock"))
 		if err := l.Acquire(); err != nil {
 			return errors.Join(ErrCreateLock, fmt.Errorf("failed to acquire replay request lock: %w", err))
 		}
@@ -156,6 +262,11 @@ func (m *MetricShipper) ProcessReplayRequests(ctx context.Context) error {
 			}
 		}()
 
+		// stop as soon as the lock is lost
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(l.Context(), cancel)()
+
 		logger.Debug().Msg("Successfully acquired file lock")
 
 		// read all valid replay request files
@@ -171,44 +282,165 @@ func (m *MetricShipper) ProcessReplayRequests(ctx context.Context) error {
 
 		logger.Debug().Int("length", len(requests)).Msg("Processing replay requests")
 
-		// handle all valid replay requests
+		// handle all valid replay requests. A failed request is kept for the
+		// next cycle and does not stop the others from being processed.
+		var errs []error
+		remaining := make([]*ReplayRequest, 0, len(requests))
 		for _, rr := range requests {
-			logger.Debug().Str("replayRequestFilepath", rr.Filepath).Int("referenceIds", rr.ReferenceIDs.Size()).Msg("Processing replay request")
+			logger := logger.With().Str("replayRequestFilepath", rr.Filepath).Str("state", string(rr.State)).Int("attempts", rr.Attempts).Logger()
+
+			if ttl := m.setting.Cloudzero.ReplayTTL; ttl > 0 && !rr.CreatedAt.IsZero() && time.Since(rr.CreatedAt) > ttl {
+				logger.Warn().Time("createdAt", rr.CreatedAt).Dur("ttl", ttl).Msg("Replay request expired")
+				if err := m.ExpireReplayRequest(ctx, rr); err != nil {
+					logger.Err(err).Msg("failed to expire the replay request")
+					errs = append(errs, fmt.Errorf("failed to expire replay request '%s': %w", rr.Filepath, err))
+					remaining = append(remaining, rr)
+					continue
+				}
+				metricReplayRequestFinishedTotal.WithLabelValues(string(ReplayRequestStateExpired)).Inc()
+				metricReplayRequestCurrent.WithLabelValues().Dec()
+				continue
+			}
+
+			logger.Debug().Int("referenceIds", rr.ReferenceIDs.Size()).Int("completed", rr.Completed.Size()).Msg("Processing replay request")
 			metricReplayRequestFileCount.Observe(float64(rr.ReferenceIDs.Size()))
 
 			if err := m.HandleReplayRequest(ctx, rr); err != nil {
-				return fmt.Errorf("failed to process replay request '%s': %w", rr.Filepath, err)
+				logger.Err(err).Msg("failed to process the replay request, it will be retried")
+				errs = append(errs, fmt.Errorf("failed to process replay request '%s': %w", rr.Filepath, err))
+
+				// keep the progress and the error for the next attempt
+				rr.State = ReplayRequestStatePending
+				rr.LastError = err.Error()
+				if err := m.writeReplayRequest(rr); err != nil {
+					logger.Err(err).Msg("failed to save the replay request state")
+				}
+				remaining = append(remaining, rr)
+				continue
 			}
 
 			// decrease the current queue for this replay request
-			logger.Debug().Str("replayRequestFilepath", rr.Filepath).Msg("Successfully processed replay request")
+			logger.Debug().Msg("Successfully processed replay request")
+			metricReplayRequestFinishedTotal.WithLabelValues(string(ReplayRequestStateDone)).Inc()
 			metricReplayRequestCurrent.WithLabelValues().Dec()
 		}
 
+		// report the state of the requests left on disk
+		states := make(map[ReplayRequestState]int, len(ReplayRequestStates))
+		for _, rr := range remaining {
+			states[rr.State]++
+		}
+		for _, state := range ReplayRequestStates {
+			metricReplayRequestStateCurrent.WithLabelValues(string(state)).Set(float64(states[state]))
+		}
+
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+
 		logger.Debug().Msg("Successfully handled all replay requests")
 
 		return nil
 	})
 }
 
+// ExpireReplayRequest gives up on a replay request. The reference IDs not yet
+// uploaded, and the parts of the ranges neither uploaded nor already abandoned
+// as gaps, are abandoned, and the request is removed.
+func (m *MetricShipper) ExpireReplayRequest(ctx context.Context, rr *ReplayRequest) error {
+	return m.metrics.SpanCtx(ctx, "shipper_ExpireReplayRequest", func(ctx context.Context, id string) error {
+		logger := instr.SpanLogger(ctx, id, func(ctx zerolog.Context) zerolog.Context {
+			return ctx.Str("rr", rr.Filepath)
+		})
+
+		abandon := rr.ReferenceIDs.Diff(rr.Completed)
+		if abandon.Size() > 0 {
+			logger.Debug().Int("numExpired", abandon.Size()).Msg("Sending abandon requests for the expired replay request")
+			if err := m.AbandonFiles(ctx, abandon.List(), "expired"); err != nil {
+				metricReplayRequestAbandonFilesErrorTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
+				return fmt.Errorf("failed to send the abandon file request: %w", err)
+			}
+			metricReplayRequestAbandonFilesTotal.WithLabelValues().Add(float64(abandon.Size()))
+
+			// keep the progress in case abandoning the ranges fails
+			for _, item := range abandon.List() {
+				rr.Completed.Add(item)
+			}
+			if err := m.writeReplayRequest(rr); err != nil {
+				return err
+			}
+		}
+
+		// the ranges have no reference ID of their own, so the parts which
+		// were not sent are abandoned by their time range
+		done := make([]ReplayRange, 0, len(rr.Uploaded)+len(rr.Gaps))
+		done = append(done, rr.Uploaded...)
+		done = append(done, rr.Gaps...)
+		expired := make([]ReplayRange, 0)
+		for _, r := range rr.Ranges {
+			for _, part := range r.Missing(done, replayRangeMinGap) {
+				logger.Warn().
+					Time("start", part.Start).
+					Time("end", part.End).
+					Str("contentIdentifier", part.ContentIdentifier).
+					Msg("A part of the replay request range expired before it was uploaded")
+				expired = append(expired, part)
+			}
+		}
+		if len(expired) > 0 {
+			if err := m.AbandonRanges(ctx, expired, "expired"); err != nil {
+				metricReplayRequestAbandonFilesErrorTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
+				return fmt.Errorf("failed to send the abandon range request: %w", err)
+			}
+			metricReplayRequestExpiredRangesTotal.WithLabelValues().Add(float64(len(expired)))
+		}
+
+		rr.State = ReplayRequestStateExpired
+		if err := os.Remove(rr.Filepath); err != nil {
+			return errors.Join(ErrFileRemove, fmt.Errorf("failed to delete the replay request file: %w", err))
+		}
+
+		return nil
+	})
+}
+
 func (m *MetricShipper) HandleReplayRequest(ctx context.Context, rr *ReplayRequest) error {
 	return m.metrics.SpanCtx(ctx, "shipper_HandleReplayRequest", func(ctx context.Context, id string) error {
 		logger := instr.SpanLogger(ctx, id, func(ctx zerolog.Context) zerolog.Context {
-			return ctx.Str("rr", rr.Filepath).Int("numfiles", rr.ReferenceIDs.Size())
+			return ctx.Str("rr", rr.Filepath).Int("numfiles", rr.ReferenceIDs.Size()).Int("numRanges", len(rr.Ranges))
 		})
 		logger.Debug().Msg("Handling replay request ...")
 
-		// fetch the new files that match these ids
+		if rr.Completed == nil {
+			rr.Completed = types.NewSet[string]()
+		}
+
+		// mark the request as in progress before doing any work
+		rr.State = ReplayRequestStateInProgress
+		rr.Attempts++
+		if err := m.writeReplayRequest(rr); err != nil {
+			return err
+		}
+
+		// all metric file paths seen, used to find the gaps in the ranges
+		seen := make([]string, 0)
+
+		// fetch the new files that match the request
 		logger.Debug().Msg("Searching for new files in the disk store")
 		newFiles := make([]types.File, 0)
 		if err := m.metrics.SpanCtx(ctx, "shipper_HandleReplayRequest_listNewFiles", func(ctx context.Context, id string) error {
+			root := filepath.Clean(m.GetBaseDir())
 			return m.store.Walk("", func(path string, info fs.FileInfo, err error) error {
 				if err != nil {
 					return err
 				}
 
-				// skip dir
+				// skip dir, the uploaded files are searched separately and
+				// quarantined files are never replayed
 				if info.IsDir() {
+					if filepath.Clean(path) != root {
+						return filepath.SkipDir
+					}
 					return nil
 				}
 
@@ -218,8 +450,9 @@ func (m *MetricShipper) HandleReplayRequest(ctx context.Context, rr *ReplayReque
 					return errors.New("failed to create a new metric file")
 				}
 
-				// check for a match
-				if rr.ReferenceIDs.Contains(GetRemoteFileID(storeFile)) {
+				// check for a match, skipping files sent by a previous attempt
+				seen = append(seen, path)
+				if rr.Matches(path, storeFile) && !rr.Completed.Contains(GetRemoteFileID(storeFile)) {
 					newFiles = append(newFiles, storeFile)
 				}
 
@@ -230,7 +463,7 @@ func (m *MetricShipper) HandleReplayRequest(ctx context.Context, rr *ReplayReque
 		}
 		logger.Debug().Int("files", len(newFiles)).Msg("found new files")
 
-		// fetch the already uploadedFiles files that match these ids
+		// fetch the already uploadedFiles files that match the request
 		logger.Debug().Msg("Searching for previously uploaded files ...")
 		uploadedFiles := make([]types.File, 0)
 		if err := m.metrics.SpanCtx(ctx, "shipper_HandleReplayRequest_listUploadedFiles", func(ctx context.Context, id string) error {
@@ -250,8 +483,9 @@ func (m *MetricShipper) HandleReplayRequest(ctx context.Context, rr *ReplayReque
 					return errors.New("failed to create a new metric file")
 				}
 
-				// check for a match
-				if rr.ReferenceIDs.Contains(GetRemoteFileID(storeFile)) {
+				// check for a match, skipping files sent by a previous attempt
+				seen = append(seen, path)
+				if rr.Matches(path, storeFile) && !rr.Completed.Contains(GetRemoteFileID(storeFile)) {
 					uploadedFiles = append(uploadedFiles, storeFile)
 				}
 
@@ -276,7 +510,38 @@ func (m *MetricShipper) HandleReplayRequest(ctx context.Context, rr *ReplayReque
 		logger.Debug().Int("found", found.Size()).Int("totalRequested", rr.ReferenceIDs.Size()).Msg("Replay request files found")
 
 		// compare the results and discover which files were not found
-		missing := rr.ReferenceIDs.Diff(found)
+		missing := rr.ReferenceIDs.Diff(found).Diff(rr.Completed)
+
+		// the parts of the requested ranges without any data have no file to
+		// abandon, so they are abandoned by their time range. Gaps reported
+		// by a previous attempt are not sent again.
+		gaps := make([]ReplayRange, 0)
+		for _, r := range rr.Ranges {
+			for _, gap := range r.Gaps(seen, replayRangeMinGap) {
+				if containsReplayRange(rr.Gaps, gap) {
+					continue
+				}
+				logger.Warn().
+					Time("start", gap.Start).
+					Time("end", gap.End).
+					Str("contentIdentifier", gap.ContentIdentifier).
+					Msg("No files were found for a part of the replay request range")
+				gaps = append(gaps, gap)
+			}
+		}
+		if len(gaps) > 0 {
+			logger.Debug().Int("numGaps", len(gaps)).Msg("Sending abandon requests for the replay request range gaps")
+			if err := m.AbandonRanges(ctx, gaps, "not found"); err != nil {
+				metricReplayRequestAbandonFilesErrorTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
+				return fmt.Errorf("failed to send the abandon range request: %w", err)
+			}
+			metricReplayRequestGapRangesTotal.WithLabelValues().Add(float64(len(gaps)))
+
+			rr.Gaps = append(rr.Gaps, gaps...)
+			if err := m.writeReplayRequest(rr); err != nil {
+				return err
+			}
+		}
 
 		// send abandon requests for the non-found files
 		if missing.Size() > 0 {
@@ -289,12 +554,32 @@ func (m *MetricShipper) HandleReplayRequest(ctx context.Context, rr *ReplayReque
 
 			// log the number of success abandoned files
 			metricReplayRequestAbandonFilesTotal.WithLabelValues().Add(float64(missing.Size()))
+
+			for _, item := range missing.List() {
+				rr.Completed.Add(item)
+			}
+			if err := m.writeReplayRequest(rr); err != nil {
+				return err
+			}
 		}
 
-		// run the `HandleRequest` function for the found files
-		if err := m.HandleRequest(ctx, total); err != nil {
+		// upload the found files, recording each one as it completes
+		var mu sync.Mutex
+		err := m.handleRequest(ctx, total, func(file types.File) {
+			mu.Lock()
+			defer mu.Unlock()
+			rr.Completed.Add(GetRemoteFileID(file))
+			if span, ok := rr.rangeSpan(file); ok {
+				rr.Uploaded = append(rr.Uploaded, span)
+			}
+			if err := m.writeReplayRequest(rr); err != nil {
+				logger.Err(err).Msg("failed to save the replay request progress")
+			}
+		})
+		if err != nil {
 			return fmt.Errorf("failed to upload replay request files: %w", err)
 		}
+		rr.State = ReplayRequestStateDone
 
 		// delete the replay request
 		logger.Debug().Msg("Deleting the replay request")
@@ -307,3 +592,29 @@ func (m *MetricShipper) HandleReplayRequest(ctx context.Context, rr *ReplayReque
 		return nil
 	})
 }
+
+// rangeSpan returns the time span of a file matching one of the ranges of the
+// request.
+func (rr *ReplayRequest) rangeSpan(file types.File) (ReplayRange, bool) {
+	path, err := file.Location()
+	if err != nil {
+		return ReplayRange{}, false
+	}
+	for _, r := range rr.Ranges {
+		if r.Matches(path) {
+			start, stop, _ := store.ParseFileTimeRange(path)
+			return ReplayRange{Start: start, End: stop, ContentIdentifier: store.ParseFileContentIdentifier(path)}, true
+		}
+	}
+	return ReplayRange{}, false
+}
+
+// containsReplayRange returns true when `r` is one of `ranges`.
+func containsReplayRange(ranges []ReplayRange, r ReplayRange) bool {
+	for _, item := range ranges {
+		if item.Start.Equal(r.Start) && item.End.Equal(r.End) && item.ContentIdentifier == r.ContentIdentifier {
+			return true
+		}
+	}
+	return false
+}
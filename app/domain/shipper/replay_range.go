@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cloudzero/cloudzero-agent/app/store"
+)
+
+// ReplayRange asks for all data written in the half-open interval
+// [Start, End). When ContentIdentifier is set, only files with that content
+// identifier (e.g. `store.CostContentIdentifier`) match.
+type ReplayRange struct {
+	Start             time.Time `json:"start"`
+	End               time.Time `json:"end"`
+	ContentIdentifier string    `json:"contentIdentifier,omitempty"`
+}
+
+// Validate ensures the range is usable.
+func (r ReplayRange) Validate() error {
+	if r.Start.IsZero() || r.End.IsZero() {
+		return errors.New("replay range requires a start and an end")
+	}
+	if !r.End.After(r.Start) {
+		return fmt.Errorf("replay range end must be after the start: start=%s, end=%s", r.Start, r.End)
+	}
+	return nil
+}
+
+// Matches returns true when the metric file at `path` holds data for this
+// range, based on the timestamps and content identifier in the file name.
+func (r ReplayRange) Matches(path string) bool {
+	if r.ContentIdentifier != "" && store.ParseFileContentIdentifier(path) != r.ContentIdentifier {
+		return false
+	}
+
+	start, stop, ok := store.ParseFileTimeRange(path)
+	if !ok {
+		return false
+	}
+
+	return start.Before(r.End) && !stop.Before(r.Start)
+}
+
+// Gaps returns the parts of the range not covered by any of the matching
+// metric files in `paths`. Gaps shorter than `minGap` are ignored, as files
+// written back to back are a few milliseconds apart.
+func (r ReplayRange) Gaps(paths []string, minGap time.Duration) []ReplayRange {
+	covered := make([]ReplayRange, 0, len(paths))
+	for _, path := range paths {
+		if !r.Matches(path) {
+			continue
+		}
+		start, stop, _ := store.ParseFileTimeRange(path)
+		covered = append(covered, ReplayRange{Start: start, End: stop})
+	}
+	return r.Missing(covered, minGap)
+}
+
+// Missing returns the parts of the range not covered by any of the `covered`
+// ranges with the same content identifier. Parts shorter than `minGap` are
+// ignored.
+func (r ReplayRange) Missing(covered []ReplayRange, minGap time.Duration) []ReplayRange {
+	matching := make([]ReplayRange, 0, len(covered))
+	for _, item := range covered {
+		if r.ContentIdentifier != "" && item.ContentIdentifier != "" && item.ContentIdentifier != r.ContentIdentifier {
+			continue
+		}
+		matching = append(matching, item)
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].Start.Before(matching[j].Start)
+	})
+
+	gaps := make([]ReplayRange, 0)
+	addGap := func(start, end time.Time) {
+		if end.Sub(start) >= minGap && end.After(start) {
+			gaps = append(gaps, ReplayRange{Start: start, End: end, ContentIdentifier: r.ContentIdentifier})
+		}
+	}
+
+	cursor := r.Start
+	for _, item := range matching {
+		if !item.Start.Before(r.End) {
+			break
+		}
+		if item.Start.After(cursor) {
+			addGap(cursor, item.Start)
+		}
+		if item.End.After(cursor) {
+			cursor = item.End
+		}
+	}
+	if r.End.After(cursor) {
+		addGap(cursor, r.End)
+	}
+
+	return gaps
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
+)
+
+func fileName(ci string, start, stop time.Time) string {
+	return fmt.Sprintf("/data/%s_%d_%d.json.br", ci, start.UnixMilli(), stop.UnixMilli())
+}
+
+func TestShipper_Unit_ReplayRange_Matches(t *testing.T) {
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := shipper.ReplayRange{Start: base, End: base.Add(24 * time.Hour), ContentIdentifier: "metrics"}
+
+	assert.True(t, r.Matches(fileName("metrics", base.Add(time.Hour), base.Add(2*time.Hour))))
+	assert.True(t, r.Matches(fileName("metrics", base.Add(-time.Minute), base.Add(time.Minute))), "overlapping the start")
+	assert.False(t, r.Matches(fileName("metrics", base.Add(24*time.Hour), base.Add(25*time.Hour))), "end is exclusive")
+	assert.False(t, r.Matches(fileName("observability", base.Add(time.Hour), base.Add(2*time.Hour))))
+	assert.False(t, r.Matches("/data/.shipperid"))
+
+	r.ContentIdentifier = ""
+	assert.True(t, r.Matches(fileName("observability", base.Add(time.Hour), base.Add(2*time.Hour))))
+}
+
+func TestShipper_Unit_ReplayRange_Gaps(t *testing.T) {
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := shipper.ReplayRange{Start: base, End: base.Add(24 * time.Hour), ContentIdentifier: "metrics"}
+
+	paths := []string{
+		// back to back files from the start of the range
+		fileName("metrics", base.Add(-5*time.Minute), base.Add(time.Hour)),
+		fileName("metrics", base.Add(time.Hour+time.Millisecond), base.Add(2*time.Hour)),
+		// data after a hole
+		fileName("metrics", base.Add(6*time.Hour), base.Add(7*time.Hour)),
+		// other content does not fill the hole
+		fileName("observability", base.Add(2*time.Hour), base.Add(6*time.Hour)),
+	}
+
+	gaps := r.Gaps(paths, time.Minute)
+	require.Len(t, gaps, 2)
+	assert.Equal(t, shipper.ReplayRange{Start: base.Add(2 * time.Hour), End: base.Add(6 * time.Hour), ContentIdentifier: "metrics"}, gaps[0])
+	assert.Equal(t, shipper.ReplayRange{Start: base.Add(7 * time.Hour), End: base.Add(24 * time.Hour), ContentIdentifier: "metrics"}, gaps[1])
+
+	// no data at all abandons the whole range
+	gaps = r.Gaps(nil, time.Minute)
+	require.Len(t, gaps, 1)
+	assert.Equal(t, r, gaps[0])
+}
+
+func TestShipper_Unit_ReplayRange_Validate(t *testing.T) {
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, shipper.ReplayRange{Start: base, End: base.Add(time.Hour)}.Validate())
+	assert.Error(t, shipper.ReplayRange{Start: base, End: base}.Validate())
+	assert.Error(t, shipper.ReplayRange{End: base}.Validate())
+}
@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package shipper_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
+	"github.com/cloudzero/cloudzero-agent/app/store"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// failingAbandonRoundTripper fails every abandon request, and delegates all
+// other requests.
+type failingAbandonRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (f *failingAbandonRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/abandon") {
+		return (&MockRoundTripper{status: http.StatusInternalServerError, mockResponseBodyString: "boom"}).RoundTrip(req)
+	}
+	return f.next.RoundTrip(req)
+}
+
+func writeReplayRequestFile(t *testing.T, dir string, ms int64, rr map[string]any) string {
+	t.Helper()
+	path := filepath.Join(dir, fmt.Sprintf("replay-%d.json", ms))
+	enc, err := json.Marshal(rr)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, enc, 0o644))
+	return path
+}
+
+func readReplayRequestFile(t *testing.T, path string) *shipper.ReplayRequest {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	rr := &shipper.ReplayRequest{}
+	require.NoError(t, json.Unmarshal(data, rr))
+	return rr
+}
+
+func TestShipper_Unit_ReplayRequest_Resume(t *testing.T) {
+	tmpDir := getTmpDir(t)
+	files := createTestFiles(t, tmpDir, 3)
+
+	refIDs := make([]string, 0, len(files))
+	mockResponseBody := make(map[string]string)
+	for _, item := range files {
+		refIDs = append(refIDs, shipper.GetRemoteFileID(item))
+		mockResponseBody[shipper.GetRemoteFileID(item)] = "https://s3.amazonaws.com/bucket/" + shipper.GetRemoteFileID(item) + "?signature=abc123"
+	}
+
+	var mu sync.Mutex
+	uploads := make([]string, 0)
+	mockRoundTripper := &MockRoundTripper{
+		status:           http.StatusOK,
+		mockResponseBody: mockResponseBody,
+		onRequest: func(req *http.Request) {
+			if req.Method != http.MethodPut {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			uploads = append(uploads, strings.TrimPrefix(req.URL.Path, "/bucket/"))
+		},
+	}
+
+	settings := getMockSettings("https://example.com/upload", tmpDir)
+
+	mockFiles := &MockAppendableFiles{baseDir: tmpDir}
+	mockFiles.On("Walk", mock.Anything, mock.Anything).Return(nil)
+
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, mockFiles)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = mockRoundTripper
+
+	// a request interrupted after the first file was uploaded
+	path := writeReplayRequestFile(t, metricShipper.GetReplayRequestDir(), time.Now().UnixMilli(), map[string]any{
+		"referenceIds": refIDs,
+		"state":        shipper.ReplayRequestStateInProgress,
+		"attempts":     1,
+		"completed":    refIDs[:1],
+	})
+
+	requests, err := metricShipper.GetActiveReplayRequests(context.Background())
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, path, requests[0].Filepath)
+	assert.Equal(t, shipper.ReplayRequestStateInProgress, requests[0].State)
+	assert.False(t, requests[0].CreatedAt.IsZero())
+
+	require.NoError(t, metricShipper.ProcessReplayRequests(context.Background()))
+
+	// only the remaining files were uploaded
+	assert.ElementsMatch(t, refIDs[1:], uploads)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestShipper_Unit_ReplayRequest_Expire(t *testing.T) {
+	tmpDir := getTmpDir(t)
+
+	var mu sync.Mutex
+	abandoned := make(map[string]string)
+	abandonedRanges := make([]shipper.AbandonAPIPayloadFile, 0)
+	mockRoundTripper := &MockRoundTripper{
+		status:           http.StatusOK,
+		mockResponseBody: map[string]string{},
+		onRequest: func(req *http.Request) {
+			require.NotEqual(t, http.MethodPut, req.Method)
+			if !strings.HasSuffix(req.URL.Path, "/abandon") {
+				return
+			}
+			body := make([]*shipper.AbandonAPIPayloadFile, 0)
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			mu.Lock()
+			defer mu.Unlock()
+			for _, item := range body {
+				if item.ReferenceID == "" {
+					abandonedRanges = append(abandonedRanges, *item)
+					continue
+				}
+				abandoned[item.ReferenceID] = item.Reason
+			}
+		},
+	}
+
+	settings := getMockSettings("https://example.com/upload", tmpDir)
+	settings.Cloudzero.ReplayTTL = time.Hour
+
+	mockFiles := &MockAppendableFiles{baseDir: tmpDir}
+	mockFiles.On("Walk", mock.Anything, mock.Anything).Return(nil)
+
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, mockFiles)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = mockRoundTripper
+
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	created := time.Now().Add(-2 * time.Hour)
+	path := writeReplayRequestFile(t, metricShipper.GetReplayRequestDir(), created.UnixMilli(), map[string]any{
+		"referenceIds": []string{"file1", "file2"},
+		"ranges":       []shipper.ReplayRange{{Start: start, End: start.Add(time.Hour)}},
+		"completed":    []string{"file1"},
+		"createdAt":    created,
+	})
+
+	require.NoError(t, metricShipper.ProcessReplayRequests(context.Background()))
+
+	// the request was dropped, and the files not yet sent and the range were
+	// abandoned
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, map[string]string{"file2": "expired"}, abandoned)
+	require.Len(t, abandonedRanges, 1)
+	assert.Equal(t, "expired", abandonedRanges[0].Reason)
+	assert.True(t, start.Equal(*abandonedRanges[0].Start))
+	assert.True(t, start.Add(time.Hour).Equal(*abandonedRanges[0].End))
+}
+
+func TestShipper_Unit_ReplayRequest_FailureDoesNotBlock(t *testing.T) {
+	tmpDir := getTmpDir(t)
+	files := createTestFiles(t, tmpDir, 2)
+
+	refIDs := types.NewSet[string]()
+	mockResponseBody := make(map[string]string)
+	for _, item := range files {
+		refIDs.Add(shipper.GetRemoteFileID(item))
+		mockResponseBody[shipper.GetRemoteFileID(item)] = "https://s3.amazonaws.com/bucket/" + shipper.GetRemoteFileID(item) + "?signature=abc123"
+	}
+
+	settings := getMockSettings("https://example.com/upload", tmpDir)
+
+	mockFiles := &MockAppendableFiles{baseDir: tmpDir}
+	mockFiles.On("Walk", mock.Anything, mock.Anything).Return(nil)
+
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, mockFiles)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = &failingAbandonRoundTripper{
+		next: &MockRoundTripper{status: http.StatusOK, mockResponseBody: mockResponseBody},
+	}
+
+	// the first request can only be abandoned, which fails
+	now := time.Now()
+	failing := writeReplayRequestFile(t, metricShipper.GetReplayRequestDir(), now.UnixMilli(), map[string]any{
+		"referenceIds": []string{"missing"},
+	})
+	succeeding := writeReplayRequestFile(t, metricShipper.GetReplayRequestDir(), now.UnixMilli()+1, map[string]any{
+		"referenceIds": refIDs.List(),
+	})
+
+	require.Error(t, metricShipper.ProcessReplayRequests(context.Background()))
+
+	// the second request was still processed
+	_, err = os.Stat(succeeding)
+	assert.True(t, os.IsNotExist(err))
+	uploaded, err := os.ReadDir(metricShipper.GetUploadedDir())
+	require.NoError(t, err)
+	assert.Len(t, uploaded, 2)
+
+	// the failed request is kept for a retry
+	rr := readReplayRequestFile(t, failing)
+	assert.Equal(t, shipper.ReplayRequestStatePending, rr.State)
+	assert.Equal(t, 1, rr.Attempts)
+	assert.NotEmpty(t, rr.LastError)
+	assert.Equal(t, 0, rr.Completed.Size())
+}
+
+func TestShipper_Unit_ReplayRequest_ExpirePartiallySent(t *testing.T) {
+	tmpDir := getTmpDir(t)
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// two files inside the range, the range is empty before and after them
+	files := createTestFiles(t, tmpDir, 2)
+	names := []string{
+		fmt.Sprintf("metrics_%d_%d.json.br", base.Add(time.Hour).UnixMilli(), base.Add(2*time.Hour).UnixMilli()),
+		fmt.Sprintf("metrics_%d_%d.json.br", base.Add(2*time.Hour).UnixMilli(), base.Add(3*time.Hour).UnixMilli()),
+	}
+	for i, item := range files {
+		loc, err := item.Location()
+		require.NoError(t, err)
+		require.NoError(t, os.Rename(loc, filepath.Join(tmpDir, names[i])))
+	}
+
+	var mu sync.Mutex
+	abandonedRanges := make(map[string][]shipper.ReplayRange)
+	urls := &MockRoundTripper{
+		status: http.StatusOK,
+		mockResponseBody: map[string]string{
+			fmt.Sprintf("metrics_%d_%d.parquet", base.Add(time.Hour).UnixMilli(), base.Add(2*time.Hour).UnixMilli()):   "https://s3.amazonaws.com/bucket/1?signature=abc123",
+			fmt.Sprintf("metrics_%d_%d.parquet", base.Add(2*time.Hour).UnixMilli(), base.Add(3*time.Hour).UnixMilli()): "https://s3.amazonaws.com/bucket/2?signature=abc123",
+		},
+	}
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		switch {
+		case req.Method == http.MethodPut && strings.HasSuffix(req.URL.Path, "/2"):
+			// the upload of the second file fails
+			return (&MockRoundTripper{status: http.StatusForbidden, mockResponseBodyString: "denied"}).RoundTrip(req)
+		case strings.HasSuffix(req.URL.Path, "/abandon"):
+			body := make([]*shipper.AbandonAPIPayloadFile, 0)
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			mu.Lock()
+			for _, item := range body {
+				require.NotNil(t, item.Start)
+				abandonedRanges[item.Reason] = append(abandonedRanges[item.Reason], shipper.ReplayRange{
+					Start:             item.Start.UTC(),
+					End:               item.End.UTC(),
+					ContentIdentifier: item.ContentIdentifier,
+				})
+			}
+			mu.Unlock()
+			return (&MockRoundTripper{status: http.StatusOK, mockResponseBody: map[string]string{}}).RoundTrip(req)
+		}
+		return urls.RoundTrip(req)
+	})
+
+	settings := getMockSettings("https://example.com/upload", tmpDir)
+	mockFiles := &MockAppendableFiles{baseDir: tmpDir}
+	mockFiles.On("Walk", mock.Anything, mock.Anything).Return(nil)
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, mockFiles)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = transport
+
+	_, err = metricShipper.SubmitReplayRequest(context.Background(), nil, []shipper.ReplayRange{
+		{Start: base, End: base.Add(4 * time.Hour), ContentIdentifier: store.CostContentIdentifier},
+	})
+	require.NoError(t, err)
+	requests, err := metricShipper.GetActiveReplayRequests(context.Background())
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+
+	// the first file was uploaded and the gaps abandoned, before the upload
+	// of the second file failed
+	require.Error(t, metricShipper.HandleReplayRequest(context.Background(), requests[0]))
+	rr := readReplayRequestFile(t, requests[0].Filepath)
+	rr.Filepath = requests[0].Filepath
+	require.Len(t, rr.Uploaded, 1)
+	require.Len(t, rr.Gaps, 2)
+
+	// only the part of the second file is abandoned once the request expires
+	require.NoError(t, metricShipper.ExpireReplayRequest(context.Background(), rr))
+	assert.Equal(t, []shipper.ReplayRange{
+		{Start: base.Add(2 * time.Hour), End: base.Add(3 * time.Hour), ContentIdentifier: store.CostContentIdentifier},
+	}, abandonedRanges["expired"])
+	assert.Len(t, abandonedRanges["not found"], 2)
+}
@@ -7,10 +7,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cloudzero/cloudzero-agent/app/config/gator"
 	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
+	"github.com/cloudzero/cloudzero-agent/app/store"
 	"github.com/cloudzero/cloudzero-agent/app/types"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -221,3 +225,109 @@ func TestShipper_Unit_ReplayRequest_Abandon(t *testing.T) {
 	require.NoError(t, err)
 	require.Empty(t, replays)
 }
+
+func TestShipper_Unit_ReplayRequest_FromHeader(t *testing.T) {
+	rr, err := shipper.NewReplayRequestFromHeader(`[{"ref_id":"id-1"},{"start":"2026-10-01T00:00:00Z","end":"2026-10-02T00:00:00Z","content_identifier":"metrics"}]`)
+	require.NoError(t, err)
+	require.True(t, rr.ReferenceIDs.Contains("id-1"))
+	require.Len(t, rr.Ranges, 1)
+	require.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), rr.Ranges[0].Start)
+	require.Equal(t, time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC), rr.Ranges[0].End)
+	require.Equal(t, "metrics", rr.Ranges[0].ContentIdentifier)
+
+	_, err = shipper.NewReplayRequestFromHeader(`[{"start":"2026-10-02T00:00:00Z","end":"2026-10-01T00:00:00Z"}]`)
+	require.Error(t, err)
+
+	_, err = shipper.NewReplayRequestFromHeader(`[]`)
+	require.Error(t, err)
+}
+
+func TestShipper_Unit_ReplayRequest_TimeRange(t *testing.T) {
+	tmpDir := getTmpDir(t)
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// place files inside and outside of the requested range
+	files := createTestFiles(t, tmpDir, 3)
+	names := []string{
+		fmt.Sprintf("metrics_%d_%d.json.br", base.Add(time.Hour).UnixMilli(), base.Add(2*time.Hour).UnixMilli()),
+		fmt.Sprintf("metrics_%d_%d.json.br", base.Add(30*time.Hour).UnixMilli(), base.Add(31*time.Hour).UnixMilli()),
+		filepath.Join(shipper.UploadedSubDirectory, fmt.Sprintf("metrics_%d_%d.json.br", base.Add(2*time.Hour).UnixMilli(), base.Add(3*time.Hour).UnixMilli())),
+	}
+	for i, item := range files {
+		loc, err := item.Location()
+		require.NoError(t, err)
+		require.NoError(t, os.Rename(loc, filepath.Join(tmpDir, names[i])))
+	}
+
+	// record the abandon requests
+	var mu sync.Mutex
+	abandoned := make([]string, 0)
+	abandonedRanges := make([]shipper.ReplayRange, 0)
+	mockRoundTripper := &MockRoundTripper{
+		status: http.StatusOK,
+		mockResponseBody: map[string]string{
+			fmt.Sprintf("metrics_%d_%d.parquet", base.Add(time.Hour).UnixMilli(), base.Add(2*time.Hour).UnixMilli()):   "https://s3.amazonaws.com/bucket/1?signature=abc123",
+			fmt.Sprintf("metrics_%d_%d.parquet", base.Add(2*time.Hour).UnixMilli(), base.Add(3*time.Hour).UnixMilli()): "https://s3.amazonaws.com/bucket/2?signature=abc123",
+		},
+		onRequest: func(req *http.Request) {
+			if !strings.HasSuffix(req.URL.Path, "/abandon") {
+				return
+			}
+			body := make([]*shipper.AbandonAPIPayloadFile, 0)
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			mu.Lock()
+			defer mu.Unlock()
+			for _, item := range body {
+				if item.ReferenceID != "" {
+					abandoned = append(abandoned, item.ReferenceID)
+					continue
+				}
+				require.Equal(t, "not found", item.Reason)
+				require.NotNil(t, item.Start)
+				require.NotNil(t, item.End)
+				abandonedRanges = append(abandonedRanges, shipper.ReplayRange{
+					Start:             item.Start.UTC(),
+					End:               item.End.UTC(),
+					ContentIdentifier: item.ContentIdentifier,
+				})
+			}
+		},
+	}
+
+	settings := getMockSettings("https://example.com/upload", tmpDir)
+
+	mockFiles := &MockAppendableFiles{baseDir: tmpDir}
+	mockFiles.On("Walk", mock.Anything, mock.Anything).Return(nil)
+
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, mockFiles)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = mockRoundTripper
+
+	_, err = metricShipper.SubmitReplayRequest(context.Background(), nil, []shipper.ReplayRange{
+		{Start: base, End: base.Add(24 * time.Hour), ContentIdentifier: store.CostContentIdentifier},
+	})
+	require.NoError(t, err)
+
+	requests, err := metricShipper.GetActiveReplayRequests(context.Background())
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	rr := requests[0]
+	require.NoError(t, metricShipper.HandleReplayRequest(context.Background(), rr))
+
+	// both matching files were uploaded, the file outside the range was not
+	uploaded, err := os.ReadDir(metricShipper.GetUploadedDir())
+	require.NoError(t, err)
+	require.Len(t, uploaded, 2)
+	_, err = os.Stat(filepath.Join(tmpDir, names[1]))
+	require.NoError(t, err)
+
+	// the empty parts of the range are abandoned by their time range, as no
+	// file exists for them
+	gaps := []shipper.ReplayRange{
+		{Start: base, End: base.Add(time.Hour), ContentIdentifier: store.CostContentIdentifier},
+		{Start: base.Add(3 * time.Hour), End: base.Add(24 * time.Hour), ContentIdentifier: store.CostContentIdentifier},
+	}
+	require.Empty(t, abandoned)
+	require.Equal(t, gaps, abandonedRanges)
+	require.Equal(t, gaps, rr.Gaps)
+}
@@ -12,18 +12,19 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
 	"github.com/cloudzero/cloudzero-agent/app/instr"
-	"github.com/cloudzero/cloudzero-agent/app/lock"
 	"github.com/cloudzero/cloudzero-agent/app/parallel"
 	"github.com/cloudzero/cloudzero-agent/app/store"
 	"github.com/cloudzero/cloudzero-agent/app/types"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/kubernetes"
 )
 
 // MetricShipper handles the periodic shipping of metrics to Cloudzero.
@@ -38,10 +39,27 @@ type MetricShipper struct {
 	shippedFiles uint64 // Counter for shipped files
 	metrics      *instr.PrometheusMetrics
 	shipperID    string // unique id for the shipper
+
+	runMu    sync.Mutex // serializes shipper cycles
+	statusMu sync.Mutex // guards lastRun
+	lastRun  *RunStatus // result of the last shipper cycle
+
+	k8sClient kubernetes.Interface // used by the lease coordination backend
+}
+
+// MetricShipperOption configures optional dependencies of the MetricShipper.
+type MetricShipperOption func(m *MetricShipper)
+
+// WithKubernetesClient sets the client used to coordinate with the other
+// replicas when the lease coordination backend is configured.
+func WithKubernetesClient(client kubernetes.Interface) MetricShipperOption {
+	return func(m *MetricShipper) {
+		m.k8sClient = client
+	}
 }
 
 // NewMetricShipper initializes a new MetricShipper.
-func NewMetricShipper(ctx context.Context, s *config.Settings, store types.ReadableStore) (*MetricShipper, error) {
+func NewMetricShipper(ctx context.Context, s *config.Settings, store types.ReadableStore, opts ...MetricShipperOption) (*MetricShipper, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
 	// Initialize an HTTP client with the specified timeout
@@ -66,14 +84,24 @@ func NewMetricShipper(ctx context.Context, s *config.Settings, store types.Reada
 		fmt.Println(string(enc))
 	}
 
-	return &MetricShipper{
+	m := &MetricShipper{
 		setting:    s,
 		store:      store,
 		ctx:        ctx,
 		cancel:     cancel,
 		HTTPClient: httpClient,
 		metrics:    metrics,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if s.Coordination.Backend == config.CoordinationBackendLease && m.k8sClient == nil {
+		cancel()
+		return nil, errors.New("the lease coordination backend requires a kubernetes client")
+	}
+
+	return m, nil
 }
 
 func (m *MetricShipper) GetMetricHandler() http.Handler {
@@ -140,7 +168,11 @@ func (m *MetricShipper) Run() error {
 }
 
 func (m *MetricShipper) runShipper(ctx context.Context) error {
-	return m.metrics.SpanCtx(ctx, "shipper_runShipper", func(ctx context.Context, id string) error {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	status := &RunStatus{StartedAt: time.Now().UTC()}
+	err := m.metrics.SpanCtx(ctx, "shipper_runShipper", func(ctx context.Context, id string) error {
 		logger := instr.SpanLogger(ctx, id)
 		logger.Debug().Msg("Running shipper cycle ...")
 
@@ -152,7 +184,8 @@ func (m *MetricShipper) runShipper(ctx context.Context) error {
 
 		// run the replay request
 		if err := m.ProcessReplayRequests(ctx); err != nil {
-			metricReplayRequestErrorTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
+			metricReplayRequestErrorTotal.WithLabelValues().Inc()
+			metricReplayRequestErrorStatusTotal.WithLabelValues(GetErrStatusCode(err)).Inc()
 			return fmt.Errorf("failed to process the replay requests: %w", err)
 		}
 
@@ -168,6 +201,16 @@ func (m *MetricShipper) runShipper(ctx context.Context) error {
 
 		return nil
 	})
+
+	status.FinishedAt = time.Now().UTC()
+	status.ShippedFiles = atomic.LoadUint64(&m.shippedFiles)
+	if err != nil {
+		status.Error = err.Error()
+		status.ErrorCode = GetErrStatusCode(err)
+	}
+	m.setLastRun(status)
+
+	return err
 }
 
 func (m *MetricShipper) ProcessNewFiles(ctx context.Context) error {
@@ -177,12 +220,7 @@ func (m *MetricShipper) ProcessNewFiles(ctx context.Context) error {
 
 		// lock the base dir for the duration of the new file handling
 		logger.Debug().Msg("Aquiring file lock")
-		l := lock.NewFileLock(
-			m.ctx, filepath.Join(m.GetBaseDir(), ".lock"),
-			lock.WithStaleTimeout(time.Second*30), // detects stale timeout
-			lock.WithRefreshInterval(time.Second*5),
-			lock.WithMaxRetry(lockMaxRetry), // 5 min wait
-		)
+		l := m.newLocker(newFilesLockName, filepath.Join(m.GetBaseDir(), ".lock"))
 		if err := l.Acquire(); err != nil {
 			return errors.Join(ErrCreateLock, fmt.Errorf("failed to acquire the lock file: %w", err))
 		}
@@ -192,6 +230,11 @@ func (m *MetricShipper) ProcessNewFiles(ctx context.Context) error {
 			}
 		}()
 
+		// stop as soon as the lock is lost
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(l.Context(), cancel)()
+
 		logger.Debug().Msg("Successfully acquired lock file")
 		logger.Debug().Msg("Fetching the files from the disk store")
 
@@ -233,10 +276,17 @@ func (m *MetricShipper) ProcessNewFiles(ctx context.Context) error {
 
 // HandleRequest takes in a list of files and runs them through the following:
 //
+// - Verify the file integrity
 // - Generate presigned URL
 // - Upload to the remote API
 // - Rename the file to indicate upload
 func (m *MetricShipper) HandleRequest(ctx context.Context, files []types.File) error {
+	return m.handleRequest(ctx, files, nil)
+}
+
+// handleRequest is HandleRequest, calling `onUploaded` (when set) from the
+// upload workers after each file is uploaded and marked as such.
+func (m *MetricShipper) handleRequest(ctx context.Context, files []types.File, onUploaded func(types.File)) error {
 	return m.metrics.SpanCtx(ctx, "shipper_handle_request", func(ctx context.Context, id string) error {
 		logger := instr.SpanLogger(ctx, id)
 		logger.Debug().Int("numFiles", len(files)).Msg("Handling request")
@@ -252,6 +302,16 @@ func (m *MetricShipper) HandleRequest(ctx context.Context, files []types.File) e
 
 		for i, chunk := range chunks {
 			logger.Debug().Int("chunk", i).Msg("Handling chunk")
+
+			// drop any corrupt files before allocating urls for them
+			chunk, err := m.VerifyFiles(ctx, chunk)
+			if err != nil {
+				return fmt.Errorf("failed to verify the files: %w", err)
+			}
+			if len(chunk) == 0 {
+				continue
+			}
+
 			pm := parallel.New(shipperWorkerCount)
 			defer pm.Close()
 
@@ -278,6 +338,9 @@ func (m *MetricShipper) HandleRequest(ctx context.Context, files []types.File) e
 					}
 
 					atomic.AddUint64(&m.shippedFiles, 1)
+					if onUploaded != nil {
+						onUploaded(file)
+					}
 					return nil
 				}
 				pm.Run(fn, waiter)
@@ -311,6 +374,10 @@ func (m *MetricShipper) GetUploadedDir() string {
 	return filepath.Join(m.GetBaseDir(), UploadedSubDirectory)
 }
 
+func (m *MetricShipper) GetQuarantineDir() string {
+	return filepath.Join(m.GetBaseDir(), QuarantineSubDirectory)
+}
+
 // Shutdown gracefully stops the MetricShipper service.
 func (m *MetricShipper) Shutdown() error {
 	m.cancel()
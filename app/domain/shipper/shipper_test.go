@@ -22,6 +22,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/domain/apikey"
 	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
 	"github.com/cloudzero/cloudzero-agent/app/store"
 	"github.com/cloudzero/cloudzero-agent/app/types"
@@ -56,6 +57,11 @@ func TestShipper_Unit_PerformShipping(t *testing.T) {
 		err = metricShipper.Shutdown()
 		require.NoError(t, err)
 		mockLister.AssertExpectations(t)
+
+		// the cycle result is recorded for the admin api
+		lastRun := metricShipper.Status().LastRun
+		require.NotNil(t, lastRun)
+		require.Empty(t, lastRun.Error)
 	})
 
 	// ensure no errors when running
@@ -313,6 +319,57 @@ func TestShiper_Unit_AllocatePresignedURL_Unauthorized(t *testing.T) {
 	assert.Empty(t, presignedURL)
 }
 
+func TestShipper_Unit_AllocatePresignedURL_NextAPIKey(t *testing.T) {
+	// Setup
+	tmpDir := getTmpDir(t)
+	mockURL := "https://example.com/upload"
+	testFiles := createTestFiles(t, tmpDir, 2)
+
+	mockResponseBody := map[string]string{}
+	for _, item := range testFiles {
+		mockResponseBody[shipper.GetRemoteFileID(item)] = "https://s3.amazonaws.com/bucket/file.parquet?signature=abc123"
+	}
+
+	t.Setenv("TEST_API_KEY", "old-key")
+	t.Setenv("TEST_NEXT_API_KEY", "new-key")
+	settings := getMockSettings(mockURL, tmpDir)
+	settings.Cloudzero.APIKeySource = apikey.Config{Source: apikey.SourceEnv, Env: "TEST_API_KEY", NextEnv: "TEST_NEXT_API_KEY"}
+	require.NoError(t, settings.SetAPIKey())
+
+	// the old key was revoked
+	accepted := &MockRoundTripper{status: http.StatusOK, mockResponseBody: mockResponseBody}
+	rejected := &MockRoundTripper{status: http.StatusUnauthorized, mockResponseBody: map[string]string{}}
+	var keys []string
+	metricShipper, err := shipper.NewMetricShipper(context.Background(), settings, nil)
+	require.NoError(t, err)
+	metricShipper.HTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		keys = append(keys, req.Header.Get("Authorization"))
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.NotEmpty(t, body)
+		if req.Header.Get("Authorization") == "new-key" {
+			return accepted.RoundTrip(req)
+		}
+		return rejected.RoundTrip(req)
+	})
+
+	// Execute
+	urlResponse, err := metricShipper.AllocatePresignedURLs(testFiles)
+	require.NoError(t, err)
+
+	// Verify
+	require.Equal(t, mockResponseBody, urlResponse)
+	require.Equal(t, []string{"old-key", "new-key"}, keys)
+	require.Equal(t, "new-key", settings.GetAPIKey())
+	require.Empty(t, settings.GetNextAPIKey())
+
+	// the promoted key is sent without a retry
+	keys = nil
+	_, err = metricShipper.AllocatePresignedURLs(testFiles)
+	require.NoError(t, err)
+	require.Equal(t, []string{"new-key"}, keys)
+}
+
 func TestShipper_Unit_AllocatePresignedURL_EmptyPresignedURL(t *testing.T) {
 	// Setup
 	tmpDir := getTmpDir(t)
@@ -6,6 +6,8 @@ package shipper
 import (
 	"bytes"
 	"context"
+	"crypto/md5" //nolint:gosec // md5 is what S3 uses to verify the upload content
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -42,6 +44,10 @@ func (m *MetricShipper) UploadFile(ctx context.Context, file types.File, presign
 			return errors.Join(ErrHTTPUnknown, fmt.Errorf("failed to create upload HTTP request: %w", err))
 		}
 
+		// allow the remote to reject content corrupted in transit
+		sum := md5.Sum(data) //nolint:gosec // md5 is what S3 uses to verify the upload content
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+
 		// Send the request
 		resp, err := m.SendHTTPRequest(ctx, "shipper_UploadFile_httpRequest", req)
 		if err != nil {
@@ -67,9 +67,13 @@ type MockRoundTripper struct {
 	mockResponseBodyString string
 	mockError              error
 	headers                http.Header
+	onRequest              func(req *http.Request)
 }
 
 func (m *MockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if m.onRequest != nil {
+		m.onRequest(req)
+	}
 	if m.mockResponseBodyString != "" {
 		return &http.Response{
 			StatusCode: m.status,
@@ -89,6 +93,13 @@ func (m *MockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 	}
 }
 
+// roundTripperFunc adapts a function to an http.RoundTripper.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func getTmpDir(t *testing.T) string {
 	// get a tmp dir
 	tmpDir := t.TempDir()
@@ -3,10 +3,13 @@
 
 package shipper
 
+import "time"
+
 // public
 const (
 	ReplaySubDirectory      = "replay"
 	UploadedSubDirectory    = "uploaded"
+	QuarantineSubDirectory  = "quarantine"
 	CriticalPurgePercent    = 20
 	ReplayRequestHeader     = "X-CloudZero-Replay"
 	ShipperIDRequestHeader  = "X-CloudZero-Shipper-ID"
@@ -22,6 +25,10 @@ const (
 	replayFileFormat    = "replay-%d.json"
 	filesChunkSize      = 200
 	remoteFileExtension = ".parquet"
+	replayRangeMinGap   = time.Minute
+
+	newFilesLockName = "new-files"
+	replayLockName   = "replay"
 
 	abandonAPIPath = "/abandon"
 	uploadAPIPath  = "/upload"
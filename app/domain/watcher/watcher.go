@@ -0,0 +1,291 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package watcher tracks Kubernetes resources with client-go shared informers.
+// Every add, update and delete event of an enabled resource type is formatted
+// with the same functions as the admission webhook handlers, and written to the
+// resource store.
+//
+// The watcher can run alongside the webhook, or by itself in clusters where
+// admission webhooks are not allowed. As the informers list every resource when
+// they start, no separate backfill is needed in the latter case.
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/scope"
+	"github.com/cloudzero/cloudzero-agent/app/domain/workload"
+	"github.com/cloudzero/cloudzero-agent/app/http/handler"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+type Watcher struct {
+	k8sClient   kubernetes.Interface
+	store       types.ResourceStore
+	clock       types.TimeProvider
+	settings    *config.Settings
+	running     bool
+	originalCtx context.Context
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	factory     informers.SharedInformerFactory
+	dynFactory  dynamicinformer.DynamicSharedInformerFactory
+	synced      []cache.InformerSynced
+	resolver    *workload.Resolver
+	dynamic     dynamic.Interface
+	scope       *scope.Scope
+}
+
+type Option func(w *Watcher)
+
+// WithWorkloadResolver attaches the workload owning each pod to its record.
+func WithWorkloadResolver(resolver *workload.Resolver) Option {
+	return func(w *Watcher) {
+		w.resolver = resolver
+	}
+}
+
+// WithDynamicClient watches the custom resources with the dynamic client. The
+// custom resources are not watched without it.
+func WithDynamicClient(client dynamic.Interface) Option {
+	return func(w *Watcher) {
+		w.dynamic = client
+	}
+}
+
+// WithScope skips the objects out of scope.
+func WithScope(s *scope.Scope) Option {
+	return func(w *Watcher) {
+		w.scope = s
+	}
+}
+
+func New(
+	ctx context.Context,
+	k8sClient kubernetes.Interface,
+	store types.ResourceStore,
+	clock types.TimeProvider,
+	settings *config.Settings,
+	opts ...Option,
+) *Watcher {
+	newCtx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		k8sClient:   k8sClient,
+		store:       store,
+		clock:       clock,
+		settings:    settings,
+		originalCtx: ctx,
+		ctx:         newCtx,
+		cancel:      cancel,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Run starts an informer for every enabled resource type. The informers first
+// list every existing resource, then watch for changes until Shutdown.
+func (w *Watcher) Run() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running {
+		return nil
+	}
+
+	// resyncs are disabled, as they would write every unchanged resource again
+	factory := informers.NewSharedInformerFactory(w.k8sClient, 0)
+	filters := w.settings.Filters
+
+	// the informers are only created when enabled, as the factory starts every
+	// informer which was requested from it
+	for _, r := range []struct {
+		enabled  bool
+		informer func() cache.SharedIndexInformer
+	}{
+		{filters.Labels.Resources.Nodes || filters.Annotations.Resources.Nodes, factory.Core().V1().Nodes().Informer},
+		{filters.Labels.Resources.Namespaces || filters.Annotations.Resources.Namespaces, factory.Core().V1().Namespaces().Informer},
+		{filters.Labels.Resources.Pods || filters.Annotations.Resources.Pods, factory.Core().V1().Pods().Informer},
+		{filters.Labels.Resources.Deployments || filters.Annotations.Resources.Deployments, factory.Apps().V1().Deployments().Informer},
+		{filters.Labels.Resources.StatefulSets || filters.Annotations.Resources.StatefulSets, factory.Apps().V1().StatefulSets().Informer},
+		{filters.Labels.Resources.DaemonSets || filters.Annotations.Resources.DaemonSets, factory.Apps().V1().DaemonSets().Informer},
+		{filters.Labels.Resources.Jobs || filters.Annotations.Resources.Jobs, factory.Batch().V1().Jobs().Informer},
+		{filters.Labels.Resources.CronJobs || filters.Annotations.Resources.CronJobs, factory.Batch().V1().CronJobs().Informer},
+		{filters.Labels.Resources.ReplicaSets || filters.Annotations.Resources.ReplicaSets, factory.Apps().V1().ReplicaSets().Informer},
+		{filters.Labels.Resources.PersistentVolumes || filters.Annotations.Resources.PersistentVolumes, factory.Core().V1().PersistentVolumes().Informer},
+		{filters.Labels.Resources.PersistentVolumeClaims || filters.Annotations.Resources.PersistentVolumeClaims, factory.Core().V1().PersistentVolumeClaims().Informer},
+		{filters.Labels.Resources.Services || filters.Annotations.Resources.Services, factory.Core().V1().Services().Informer},
+		{filters.Labels.Resources.Ingresses || filters.Annotations.Resources.Ingresses, factory.Networking().V1().Ingresses().Informer},
+		{filters.Labels.Resources.ResourceQuotas || filters.Annotations.Resources.ResourceQuotas, factory.Core().V1().ResourceQuotas().Informer},
+	} {
+		if !r.enabled {
+			continue
+		}
+		if err := w.addInformer(r.informer()); err != nil {
+			return err
+		}
+	}
+
+	// custom resources follow the global label and annotation settings
+	var dynFactory dynamicinformer.DynamicSharedInformerFactory
+	if w.dynamic != nil && len(w.settings.CustomResources) > 0 && (filters.Labels.Enabled || filters.Annotations.Enabled) {
+		dynFactory = dynamicinformer.NewDynamicSharedInformerFactory(w.dynamic, 0)
+		for _, c := range w.settings.CustomResources {
+			gvr := schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Plural()}
+			if err := w.addInformer(dynFactory.ForResource(gvr).Informer()); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Info().Int("informers", len(w.synced)).Msg("Starting resource informers")
+	factory.Start(w.ctx.Done())
+	if dynFactory != nil {
+		dynFactory.Start(w.ctx.Done())
+	}
+	w.factory = factory
+	w.dynFactory = dynFactory
+	w.running = true
+	return nil
+}
+
+// WaitForCacheSync blocks until every informer has listed the existing
+// resources, returning false when the context is cancelled first.
+func (w *Watcher) WaitForCacheSync(ctx context.Context) bool {
+	w.mu.Lock()
+	synced := w.synced
+	w.mu.Unlock()
+	return cache.WaitForCacheSync(ctx.Done(), synced...)
+}
+
+func (w *Watcher) Shutdown() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.running {
+		return nil
+	}
+	w.cancel()
+	w.factory.Shutdown()
+	if w.dynFactory != nil {
+		w.dynFactory.Shutdown()
+	}
+	w.reset()
+	return nil
+}
+
+func (w *Watcher) reset() {
+	w.running = false
+	ctx, cancel := context.WithCancel(w.originalCtx)
+	w.ctx = ctx
+	w.cancel = cancel
+	w.factory = nil
+	w.dynFactory = nil
+	w.synced = nil
+}
+
+func (w *Watcher) IsRunning() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running
+}
+
+func (w *Watcher) addInformer(informer cache.SharedIndexInformer) error {
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.onAdd,
+		UpdateFunc: w.onUpdate,
+		DeleteFunc: w.onDelete,
+	}); err != nil {
+		return fmt.Errorf("failed to add the event handler: %w", err)
+	}
+	w.synced = append(w.synced, informer.HasSynced)
+	return nil
+}
+
+func (w *Watcher) onAdd(obj any) {
+	record, err := handler.FormatResourceData(obj, w.settings)
+	if err != nil {
+		log.Err(err).Msg("Failed to format data")
+		return
+	}
+	if w.scope.Skip(w.ctx, scope.SourceWatch, config.ResourceTypeToMetricName[record.Type], obj) {
+		w.leaveScope(&record)
+		return
+	}
+	w.resolver.Enrich(w.ctx, obj, &record)
+	if w.unchanged(&record) {
+		return
+	}
+	handler.WriteDataToStorage(w.ctx, w.store, w.clock, record)
+}
+
+// unchanged returns true when the stored record of a live resource already
+// has its tags. Most updates, like the status changes of a pod, and the initial
+// listing of the informers would otherwise write the record again and send it
+// with the next flush.
+func (w *Watcher) unchanged(record *types.ResourceTags) bool {
+	conditions := []any{"type = ? AND name = ?", record.Type, record.Name}
+	if record.Namespace != nil {
+		conditions = []any{"type = ? AND name = ? AND namespace = ?", record.Type, record.Name, *record.Namespace}
+	}
+	found, err := w.store.FindFirstBy(w.ctx, conditions...)
+	if err != nil || found == nil {
+		return false
+	}
+	// a deleted record is written again when its resource was created again
+	return found.DeletedAt == nil && handler.SameTags(found, record)
+}
+
+// leaveScope closes the stored record of a resource out of scope, which was
+// tracked before it left the scope.
+func (w *Watcher) leaveScope(record *types.ResourceTags) {
+	namespace := ""
+	if record.Namespace != nil {
+		namespace = *record.Namespace
+	}
+	if handler.CloseStoredRecord(w.ctx, w.store, w.clock, record.Type, namespace, record.Name) {
+		scope.SkippedObjects.WithLabelValues(scope.SourceWatch, config.ResourceTypeToMetricName[record.Type], scope.ReasonLeftScope).Inc()
+	}
+}
+
+func (w *Watcher) onUpdate(oldObj, newObj any) {
+	// skip events which do not change the resource
+	oldMeta, oldOk := oldObj.(metav1.Object)
+	newMeta, newOk := newObj.(metav1.Object)
+	if oldOk && newOk && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+		return
+	}
+	w.onAdd(newObj)
+}
+
+func (w *Watcher) onDelete(obj any) {
+	// the final state is unknown when the deletion was missed while the watch
+	// was disconnected, the last known state is used instead
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	record, err := handler.FormatResourceData(obj, w.settings)
+	if err != nil {
+		log.Err(err).Msg("Failed to format data")
+		return
+	}
+	if w.scope.Skip(w.ctx, scope.SourceWatch, config.ResourceTypeToMetricName[record.Type], obj) {
+		w.leaveScope(&record)
+		return
+	}
+	w.resolver.Enrich(w.ctx, obj, &record)
+	handler.WriteDeletionToStorage(w.ctx, w.store, w.clock, record)
+}
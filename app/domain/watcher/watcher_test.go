@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package watcher_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/scope"
+	"github.com/cloudzero/cloudzero-agent/app/domain/watcher"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/cloudzero/cloudzero-agent/app/utils"
+)
+
+func getDefaultSettings() *config.Settings {
+	return &config.Settings{
+		Filters: config.Filters{
+			Labels: config.Labels{
+				Enabled: true,
+				Resources: config.Resources{
+					Pods:       true,
+					Namespaces: true,
+				},
+				Patterns: []string{".*"},
+			},
+		},
+		LabelMatches: []regexp.Regexp{*regexp.MustCompile(".*")},
+	}
+}
+
+func findRecord(t *testing.T, store types.ResourceStore, resourceType config.ResourceType, name string) *types.ResourceTags {
+	t.Helper()
+	record, err := store.FindFirstBy(context.Background(), "type = ? AND name = ?", resourceType, name)
+	if err != nil {
+		return nil
+	}
+	return record
+}
+
+func TestWatcher_Events(t *testing.T) {
+	ctx := context.Background()
+	clock := &utils.Clock{}
+	store, err := repo.NewInMemoryResourceRepository(clock)
+	require.NoError(t, err)
+
+	// resources which exist before the watcher starts
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-1",
+			Namespace: "default",
+			Labels:    map[string]string{"team": "a"},
+		}},
+		// deployments are not enabled
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	)
+
+	w := watcher.New(ctx, client, store, clock, getDefaultSettings())
+	require.NoError(t, w.Run())
+	defer w.Shutdown()
+	assert.True(t, w.IsRunning())
+
+	syncCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.True(t, w.WaitForCacheSync(syncCtx))
+
+	// the existing resources are written
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.NotNil(c, findRecord(t, store, config.Namespace, "default"))
+		assert.NotNil(c, findRecord(t, store, config.Pod, "pod-1"))
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, findRecord(t, store, config.Node, "node-1"))
+
+	// updates are written
+	pod, err := client.CoreV1().Pods("default").Get(ctx, "pod-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	pod.Labels["team"] = "b"
+	pod.ResourceVersion = "2"
+	_, err = client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		record := findRecord(t, store, config.Pod, "pod-1")
+		if assert.NotNil(c, record) && assert.NotNil(c, record.Labels) {
+			assert.Equal(c, "b", (*record.Labels)["team"])
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// deletions are recorded
+	require.NoError(t, client.CoreV1().Pods("default").Delete(ctx, "pod-1", metav1.DeleteOptions{}))
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		record := findRecord(t, store, config.Pod, "pod-1")
+		if assert.NotNil(c, record) {
+			assert.NotNil(c, record.DeletedAt)
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, w.Shutdown())
+	assert.False(t, w.IsRunning())
+}
+
+func TestWatcher_SkipsUnchanged(t *testing.T) {
+	ctx := context.Background()
+	clock := &utils.Clock{}
+	store, err := repo.NewInMemoryResourceRepository(clock)
+	require.NoError(t, err)
+
+	client := fake.NewClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-1",
+			Namespace: "default",
+			Labels:    map[string]string{"team": "a"},
+		}},
+	)
+
+	w := watcher.New(ctx, client, store, clock, getDefaultSettings())
+	require.NoError(t, w.Run())
+	defer w.Shutdown()
+	syncCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.True(t, w.WaitForCacheSync(syncCtx))
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.NotNil(c, findRecord(t, store, config.Pod, "pod-1"))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the record is sent
+	record := findRecord(t, store, config.Pod, "pod-1")
+	sentAt := time.Now().UTC()
+	record.SentAt = &sentAt
+	require.NoError(t, store.Update(ctx, record))
+
+	// a status change keeps the record as sent
+	pod, err := client.CoreV1().Pods("default").Get(ctx, "pod-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	pod.Status.Phase = corev1.PodRunning
+	pod.ResourceVersion = "2"
+	_, err = client.CoreV1().Pods("default").UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	// the events of the informer are handled in order, so the status change
+	// was handled once the next pod is written
+	_, err = client.CoreV1().Pods("default").Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.NotNil(c, findRecord(t, store, config.Pod, "pod-2"))
+	}, 5*time.Second, 10*time.Millisecond)
+	record = findRecord(t, store, config.Pod, "pod-1")
+	require.NotNil(t, record)
+	assert.NotNil(t, record.SentAt)
+
+	// a label change is written and sent again
+	pod.Labels["team"] = "b"
+	pod.ResourceVersion = "3"
+	_, err = client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		record := findRecord(t, store, config.Pod, "pod-1")
+		if assert.NotNil(c, record) {
+			assert.Nil(c, record.SentAt)
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWatcher_LeftScope(t *testing.T) {
+	ctx := context.Background()
+	clock := &utils.Clock{}
+	store, err := repo.NewInMemoryResourceRepository(clock)
+	require.NoError(t, err)
+
+	client := fake.NewClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-scoped", Namespace: "default"}},
+	)
+	objectScope, err := scope.New(config.Scope{ExcludeObjectSelector: "ephemeral"}, nil)
+	require.NoError(t, err)
+
+	w := watcher.New(ctx, client, store, clock, getDefaultSettings(), watcher.WithScope(objectScope))
+	require.NoError(t, w.Run())
+	defer w.Shutdown()
+	syncCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.True(t, w.WaitForCacheSync(syncCtx))
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.NotNil(c, findRecord(t, store, config.Pod, "pod-scoped"))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	counter := scope.SkippedObjects.WithLabelValues(scope.SourceWatch, "pod", scope.ReasonLeftScope)
+	before := testutil.ToFloat64(counter)
+
+	// an update moving the pod out of scope closes its record
+	pod, err := client.CoreV1().Pods("default").Get(ctx, "pod-scoped", metav1.GetOptions{})
+	require.NoError(t, err)
+	pod.Labels = map[string]string{"ephemeral": "true"}
+	pod.ResourceVersion = "2"
+	_, err = client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		record := findRecord(t, store, config.Pod, "pod-scoped")
+		if assert.NotNil(c, record) {
+			assert.NotNil(c, record.DeletedAt)
+		}
+		assert.InDelta(c, before+1, testutil.ToFloat64(counter), 0)
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,332 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package workload resolves the workload which owns a pod, by following the
+// owner references of the pod up to the top-most controller, such as
+// pod → ReplicaSet → Deployment or pod → Job → CronJob.
+//
+// Intermediate owners are looked up from the API server, and cached. The owners
+// of other kinds, such as an Argo Rollout, are looked up with the dynamic
+// client from the API version of the owner reference. When an owner can not be
+// looked up, the naming conventions of the controllers are used instead: a
+// ReplicaSet named `<deployment>-<hash>` belongs to a Deployment, and a Job
+// named `<cronjob>-<timestamp>` belongs to a CronJob.
+package workload
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// Metric labels attached to the records of pods.
+const (
+	MetricLabelKind = "workload_kind"
+	MetricLabelName = "workload_name"
+)
+
+const (
+	// DefaultCacheTTL is used when the cache TTL is not set.
+	DefaultCacheTTL = 5 * time.Minute
+	// failedLookupTTL is how long an owner which could not be looked up, such
+	// as a missing owner or a kind the agent may not read, is not looked up
+	// again.
+	failedLookupTTL = 30 * time.Second
+	// maxDepth bounds the owner chain, which is never deeper in practice.
+	maxDepth = 5
+	// maxCacheEntries triggers the pruning of expired cache entries.
+	maxCacheEntries = 10000
+)
+
+// errUnsupportedKind is returned for the owners of a kind which can not be
+// looked up.
+var errUnsupportedKind = errors.New("unsupported owner kind")
+
+// Workload is the top-most controller owning a pod.
+type Workload struct {
+	Kind   string
+	Name   string
+	Labels map[string]string
+}
+
+// object is the cached state of an owner looked up from the API server.
+type object struct {
+	owner  *metav1.OwnerReference
+	labels map[string]string
+}
+
+type cacheEntry struct {
+	object  *object
+	expires time.Time
+}
+
+// Resolver resolves the workload owning a pod. A nil Resolver is valid, and
+// leaves the records unchanged.
+type Resolver struct {
+	k8sClient kubernetes.Interface
+	dynamic   dynamic.Interface
+	settings  *config.Settings
+	clock     types.TimeProvider
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type Option func(r *Resolver)
+
+// WithDynamicClient looks up the owners of the kinds which have no typed
+// client. Only the built-in kinds are looked up without it.
+func WithDynamicClient(client dynamic.Interface) Option {
+	return func(r *Resolver) {
+		r.dynamic = client
+	}
+}
+
+// NewResolver creates a resolver. The k8s client can be nil, in which case
+// only the naming conventions are used, and no labels are inherited.
+func NewResolver(k8sClient kubernetes.Interface, clock types.TimeProvider, settings *config.Settings, opts ...Option) *Resolver {
+	ttl := settings.Workloads.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	r := &Resolver{
+		k8sClient: k8sClient,
+		settings:  settings,
+		clock:     clock,
+		ttl:       ttl,
+		cache:     map[string]cacheEntry{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Enrich attaches the workload owning a pod to its record, and merges the
+// labels the pod inherits from its workload and namespace. Other resources are
+// left unchanged.
+func (r *Resolver) Enrich(ctx context.Context, obj any, record *types.ResourceTags) {
+	pod, ok := obj.(*corev1.Pod)
+	if r == nil || !ok {
+		return
+	}
+
+	w := r.Resolve(ctx, pod.GetNamespace(), pod.GetOwnerReferences())
+	if w == nil {
+		return
+	}
+
+	if record.MetricLabels == nil {
+		record.MetricLabels = &config.MetricLabels{}
+	}
+	(*record.MetricLabels)[MetricLabelKind] = w.Kind
+	(*record.MetricLabels)[MetricLabelName] = w.Name
+
+	// inheritance only applies when the labels of pods are tracked
+	labels := r.settings.Filters.Labels
+	if len(r.settings.InheritMatches) == 0 || !labels.Enabled || !labels.Resources.Pods {
+		return
+	}
+
+	// the namespace has the lowest precedence, then the workload, then the pod
+	inherited := config.MetricLabelTags{}
+	for _, source := range []map[string]string{r.NamespaceLabels(ctx, pod.GetNamespace()), w.Labels} {
+		for key, value := range config.Filter(source, r.settings.InheritMatches, true, r.settings) {
+			inherited[key] = value
+		}
+	}
+	if record.Labels != nil {
+		for key, value := range *record.Labels {
+			inherited[key] = value
+		}
+	}
+	record.Labels = &inherited
+}
+
+// Resolve follows the controller references of an object in a namespace up to
+// the top-most controller. It returns nil when the object has no controller.
+func (r *Resolver) Resolve(ctx context.Context, namespace string, refs []metav1.OwnerReference) *Workload {
+	ref := controllerOf(refs)
+	if ref == nil {
+		return nil
+	}
+
+	owner := *ref
+	var labels map[string]string
+	for range maxDepth {
+		o := r.lookup(ctx, namespace, owner)
+		if o == nil {
+			// not a kind which can be looked up, or the lookup failed
+			next, ok := ownerByConvention(owner.Kind, owner.Name)
+			if !ok {
+				break
+			}
+			owner, labels = next, nil
+			continue
+		}
+		labels = o.labels
+		if o.owner == nil {
+			break
+		}
+		owner, labels = *o.owner, nil
+	}
+
+	return &Workload{Kind: owner.Kind, Name: owner.Name, Labels: labels}
+}
+
+// lookup returns the owner and labels of an object, or nil when the kind is
+// not supported or the object can not be retrieved. A failed lookup is cached
+// for a short time, so a missing owner or a kind the agent may not read is not
+// requested for every pod.
+func (r *Resolver) lookup(ctx context.Context, namespace string, ref metav1.OwnerReference) *object {
+	if r.k8sClient == nil {
+		return nil
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil
+	}
+	key := namespace + "/" + gv.Group + "/" + ref.Kind + "/" + ref.Name
+	now := r.clock.GetCurrentTime()
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.object
+	}
+
+	meta, err := r.get(ctx, namespace, gv, ref)
+	if errors.Is(err, errUnsupportedKind) {
+		return nil
+	}
+	if err != nil {
+		log.Ctx(ctx).Debug().Err(err).Str("kind", ref.Kind).Str("name", ref.Name).Msg("failed to look up the owner")
+		r.store(key, nil, now.Add(min(failedLookupTTL, r.ttl)))
+		return nil
+	}
+
+	o := &object{
+		owner:  controllerOf(meta.GetOwnerReferences()),
+		labels: meta.GetLabels(),
+	}
+	r.store(key, o, now.Add(r.ttl))
+	return o
+}
+
+// get retrieves an object with the typed client of its kind, or with the
+// dynamic client for the other kinds.
+func (r *Resolver) get(ctx context.Context, namespace string, gv schema.GroupVersion, ref metav1.OwnerReference) (metav1.Object, error) {
+	opts := metav1.GetOptions{}
+	switch gv.Group {
+	case "", "apps", "batch":
+		// an owner reference without an API version is taken as one of
+		// the built-in kinds
+		switch ref.Kind {
+		case "ReplicaSet":
+			meta, err := r.k8sClient.AppsV1().ReplicaSets(namespace).Get(ctx, ref.Name, opts)
+			return meta, err
+		case "Deployment":
+			meta, err := r.k8sClient.AppsV1().Deployments(namespace).Get(ctx, ref.Name, opts)
+			return meta, err
+		case "StatefulSet":
+			meta, err := r.k8sClient.AppsV1().StatefulSets(namespace).Get(ctx, ref.Name, opts)
+			return meta, err
+		case "DaemonSet":
+			meta, err := r.k8sClient.AppsV1().DaemonSets(namespace).Get(ctx, ref.Name, opts)
+			return meta, err
+		case "Job":
+			meta, err := r.k8sClient.BatchV1().Jobs(namespace).Get(ctx, ref.Name, opts)
+			return meta, err
+		case "CronJob":
+			meta, err := r.k8sClient.BatchV1().CronJobs(namespace).Get(ctx, ref.Name, opts)
+			return meta, err
+		case "Namespace":
+			meta, err := r.k8sClient.CoreV1().Namespaces().Get(ctx, ref.Name, opts)
+			return meta, err
+		}
+	}
+
+	if r.dynamic == nil || gv.Version == "" {
+		return nil, errUnsupportedKind
+	}
+	// the plural of a custom resource is the configured one, or the lowercase
+	// kind followed by `s`
+	resource, ok := r.settings.FindCustomResource(gv.Group, ref.Kind)
+	if !ok {
+		resource = config.CustomResource{Group: gv.Group, Version: gv.Version, Kind: ref.Kind}
+	}
+	gvr := gv.WithResource(resource.Plural())
+	return r.dynamic.Resource(gvr).Namespace(namespace).Get(ctx, ref.Name, opts)
+}
+
+func (r *Resolver) store(key string, o *object, expires time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.cache) >= maxCacheEntries {
+		now := r.clock.GetCurrentTime()
+		for k, entry := range r.cache {
+			if !now.Before(entry.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	r.cache[key] = cacheEntry{object: o, expires: expires}
+}
+
+// NamespaceLabels returns the labels of a namespace, or nil when it can not be
+// looked up.
+func (r *Resolver) NamespaceLabels(ctx context.Context, namespace string) map[string]string {
+	if r == nil {
+		return nil
+	}
+	if o := r.lookup(ctx, "", metav1.OwnerReference{APIVersion: "v1", Kind: "Namespace", Name: namespace}); o != nil {
+		return o.labels
+	}
+	return nil
+}
+
+// controllerOf returns the reference marked as the controller.
+func controllerOf(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+// ownerByConvention derives the owner of a ReplicaSet or Job from its name.
+func ownerByConvention(kind, name string) (metav1.OwnerReference, bool) {
+	i := strings.LastIndex(name, "-")
+	if i <= 0 || i == len(name)-1 {
+		return metav1.OwnerReference{}, false
+	}
+	prefix, suffix := name[:i], name[i+1:]
+
+	switch kind {
+	case "ReplicaSet":
+		return metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: prefix}, true
+	case "Job":
+		if strings.Trim(suffix, "0123456789") != "" {
+			return metav1.OwnerReference{}, false
+		}
+		return metav1.OwnerReference{APIVersion: "batch/v1", Kind: "CronJob", Name: prefix}, true
+	default:
+		return metav1.OwnerReference{}, false
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package workload_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/workload"
+	"github.com/cloudzero/cloudzero-agent/app/http/handler"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func controllerRef(kind, name string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: ptr.To(true)}}
+}
+
+func meta(name string, labels map[string]string, owners []metav1.OwnerReference) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels, OwnerReferences: owners}
+}
+
+func getSettings() *config.Settings {
+	return &config.Settings{
+		Filters: config.Filters{
+			Labels: config.Labels{
+				Enabled:   true,
+				Resources: config.Resources{Pods: true},
+				Patterns:  []string{".*"},
+			},
+		},
+		LabelMatches:   []regexp.Regexp{*regexp.MustCompile(".*")},
+		InheritMatches: []regexp.Regexp{*regexp.MustCompile("^(team|cost-center)$")},
+	}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&appsv1.ReplicaSet{ObjectMeta: meta("web-5d4f8", nil, controllerRef("Deployment", "web"))},
+		&appsv1.Deployment{ObjectMeta: meta("web", map[string]string{"team": "a"}, nil)},
+		&batchv1.Job{ObjectMeta: meta("backup-28901", nil, controllerRef("CronJob", "backup"))},
+		&batchv1.CronJob{ObjectMeta: meta("backup", nil, nil)},
+		&appsv1.ReplicaSet{ObjectMeta: meta("canary-7c9d", nil, controllerRef("Rollout", "canary"))},
+	)
+	resolver := workload.NewResolver(client, mocks.NewMockClock(time.Now()), getSettings())
+
+	tests := []struct {
+		name     string
+		refs     []metav1.OwnerReference
+		expected *workload.Workload
+	}{
+		{"deployment", controllerRef("ReplicaSet", "web-5d4f8"), &workload.Workload{Kind: "Deployment", Name: "web", Labels: map[string]string{"team": "a"}}},
+		{"cronjob", controllerRef("Job", "backup-28901"), &workload.Workload{Kind: "CronJob", Name: "backup"}},
+		{"unsupported owner kind", controllerRef("ReplicaSet", "canary-7c9d"), &workload.Workload{Kind: "Rollout", Name: "canary"}},
+		{"statefulset not found", controllerRef("StatefulSet", "db"), &workload.Workload{Kind: "StatefulSet", Name: "db"}},
+		{"no controller", []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d4f8"}}, nil},
+		{"no owner", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, resolver.Resolve(ctx, "default", tt.refs))
+		})
+	}
+}
+
+func TestResolver_Resolve_Convention(t *testing.T) {
+	ctx := context.Background()
+	resolver := workload.NewResolver(nil, mocks.NewMockClock(time.Now()), getSettings())
+
+	assert.Equal(t, &workload.Workload{Kind: "Deployment", Name: "web-app"},
+		resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "web-app-5d4f8")))
+	assert.Equal(t, &workload.Workload{Kind: "CronJob", Name: "backup"},
+		resolver.Resolve(ctx, "default", controllerRef("Job", "backup-28901")))
+	assert.Equal(t, &workload.Workload{Kind: "Job", Name: "migrate-db"},
+		resolver.Resolve(ctx, "default", controllerRef("Job", "migrate-db")))
+}
+
+func TestResolver_Cache(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&appsv1.ReplicaSet{ObjectMeta: meta("web-5d4f8", nil, controllerRef("Deployment", "web"))},
+		&appsv1.Deployment{ObjectMeta: meta("web", nil, nil)},
+	)
+	gets := 0
+	client.PrependReactor("get", "*", func(k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+
+	clock := mocks.NewMockClock(time.Now())
+	settings := getSettings()
+	settings.Workloads.CacheTTL = time.Minute
+	resolver := workload.NewResolver(client, clock, settings)
+
+	resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "web-5d4f8"))
+	resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "web-5d4f8"))
+	assert.Equal(t, 2, gets)
+
+	// the owners are looked up again once expired
+	clock.AdvanceTime(2 * time.Minute)
+	resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "web-5d4f8"))
+	assert.Equal(t, 4, gets)
+}
+
+func rolloutRef(name string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Name: name, Controller: ptr.To(true)}}
+}
+
+func newRollout(name string, labels map[string]string) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{}
+	o.SetAPIVersion("argoproj.io/v1alpha1")
+	o.SetKind("Rollout")
+	o.SetName(name)
+	o.SetNamespace("default")
+	o.SetLabels(labels)
+	return o
+}
+
+func TestResolver_Resolve_CustomOwner(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&appsv1.ReplicaSet{ObjectMeta: meta("canary-7c9d", nil, rolloutRef("canary"))},
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newRollout("canary", map[string]string{"team": "b"}))
+	resolver := workload.NewResolver(client, mocks.NewMockClock(time.Now()), getSettings(), workload.WithDynamicClient(dynamicClient))
+
+	assert.Equal(t, &workload.Workload{Kind: "Rollout", Name: "canary", Labels: map[string]string{"team": "b"}},
+		resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "canary-7c9d")))
+
+	// without the dynamic client, the owner is known but not its labels
+	resolver = workload.NewResolver(client, mocks.NewMockClock(time.Now()), getSettings())
+	assert.Equal(t, &workload.Workload{Kind: "Rollout", Name: "canary"},
+		resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "canary-7c9d")))
+}
+
+func TestResolver_Cache_FailedLookup(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&appsv1.ReplicaSet{ObjectMeta: meta("canary-7c9d", nil, rolloutRef("canary"))},
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	gets := 0
+	dynamicClient.PrependReactor("get", "rollouts", func(k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "argoproj.io", Resource: "rollouts"}, "canary", nil)
+	})
+	missing := 0
+	client.PrependReactor("get", "statefulsets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		missing++
+		return false, nil, nil
+	})
+
+	clock := mocks.NewMockClock(time.Now())
+	resolver := workload.NewResolver(client, clock, getSettings(), workload.WithDynamicClient(dynamicClient))
+
+	for range 2 {
+		assert.Equal(t, &workload.Workload{Kind: "Rollout", Name: "canary"},
+			resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "canary-7c9d")))
+		assert.Equal(t, &workload.Workload{Kind: "StatefulSet", Name: "db"},
+			resolver.Resolve(ctx, "default", controllerRef("StatefulSet", "db")))
+	}
+	assert.Equal(t, 1, gets)
+	assert.Equal(t, 1, missing)
+
+	// the failed lookups are retried sooner than the cache TTL
+	clock.AdvanceTime(time.Minute)
+	resolver.Resolve(ctx, "default", controllerRef("ReplicaSet", "canary-7c9d"))
+	resolver.Resolve(ctx, "default", controllerRef("StatefulSet", "db"))
+	assert.Equal(t, 2, gets)
+	assert.Equal(t, 2, missing)
+}
+
+func TestResolver_Enrich(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{
+			"team":        "namespace",
+			"cost-center": "namespace",
+			"env":         "prod",
+		}}},
+		&appsv1.ReplicaSet{ObjectMeta: meta("web-5d4f8", nil, controllerRef("Deployment", "web"))},
+		&appsv1.Deployment{ObjectMeta: meta("web", map[string]string{"team": "workload", "cost-center": "workload"}, nil)},
+	)
+	settings := getSettings()
+	resolver := workload.NewResolver(client, mocks.NewMockClock(time.Now()), settings)
+
+	pod := &corev1.Pod{ObjectMeta: meta("web-5d4f8-abcde", map[string]string{"team": "pod"}, controllerRef("ReplicaSet", "web-5d4f8"))}
+	record := handler.FormatPodData(pod, settings)
+	resolver.Enrich(ctx, pod, &record)
+
+	require.NotNil(t, record.MetricLabels)
+	assert.Equal(t, "Deployment", (*record.MetricLabels)[workload.MetricLabelKind])
+	assert.Equal(t, "web", (*record.MetricLabels)[workload.MetricLabelName])
+
+	// the pod takes precedence over the workload, which takes precedence over
+	// the namespace. labels which do not match the inherit patterns are ignored.
+	require.NotNil(t, record.Labels)
+	assert.Equal(t, config.MetricLabelTags{"team": "pod", "cost-center": "workload"}, *record.Labels)
+
+	// other resources are left unchanged
+	deployment := &appsv1.Deployment{ObjectMeta: meta("web", nil, nil)}
+	deploymentRecord := handler.FormatDeploymentData(deployment, settings)
+	expected := handler.FormatDeploymentData(deployment, settings)
+	resolver.Enrich(ctx, deployment, &deploymentRecord)
+	assert.Equal(t, expected, deploymentRecord)
+
+	// a nil resolver is a no-op
+	var nilResolver *workload.Resolver
+	record = handler.FormatPodData(pod, settings)
+	nilResolver.Enrich(ctx, pod, &record)
+	assert.NotContains(t, *record.MetricLabels, workload.MetricLabelKind)
+}
@@ -15,25 +15,37 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/cloudzero/cloudzero-agent/app/build"
 	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
 	"github.com/cloudzero/cloudzero-agent/app/domain/backfiller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/cluster"
+	"github.com/cloudzero/cloudzero-agent/app/domain/filterdiff"
+	"github.com/cloudzero/cloudzero-agent/app/domain/healthz"
 	"github.com/cloudzero/cloudzero-agent/app/domain/housekeeper"
+	"github.com/cloudzero/cloudzero-agent/app/domain/inventory"
 	"github.com/cloudzero/cloudzero-agent/app/domain/k8s"
 	"github.com/cloudzero/cloudzero-agent/app/domain/monitor"
 	"github.com/cloudzero/cloudzero-agent/app/domain/pusher"
+	"github.com/cloudzero/cloudzero-agent/app/domain/scope"
+	"github.com/cloudzero/cloudzero-agent/app/domain/watcher"
+	"github.com/cloudzero/cloudzero-agent/app/domain/workload"
 	"github.com/cloudzero/cloudzero-agent/app/http"
 	"github.com/cloudzero/cloudzero-agent/app/http/handler"
 	"github.com/cloudzero/cloudzero-agent/app/logging"
 	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/types"
 	"github.com/cloudzero/cloudzero-agent/app/utils"
 )
 
 func main() {
 	var configFiles config.Files
+	var diffConfigFiles config.Files
 	var backfill bool
 	flag.Var(&configFiles, "config", "Path to the configuration file(s)")
+	flag.Var(&diffConfigFiles, "diff-config", "Path to the proposed configuration file(s); prints the labels and annotations they would add or remove, and exits")
 	flag.BoolVar(&backfill, "backfill", false, "Enable backfill mode")
 	flag.Parse()
 
@@ -79,10 +91,30 @@ func main() {
 		fmt.Println(string(enc))
 	}
 
-	// setup database
-	store, err := repo.NewInMemoryResourceRepository(clock)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create in-memory resource repository")
+	// compare the filters against a proposed configuration, before any store
+	// is created so nothing is written
+	if len(diffConfigFiles) > 0 {
+		if err = diffFilters(context.Background(), clock, settings, diffConfigFiles); err != nil {
+			log.Fatal().Err(err).Msg("Failed to compare the filters")
+		}
+		return
+	}
+
+	// setup database, on disk when enabled so the send state survives restarts
+	var (
+		store   types.ResourceStore
+		rebuilt bool
+	)
+	if settings.Database.Enabled {
+		store, rebuilt, err = repo.NewPersistentResourceRepository(clock, settings.Database.StoragePath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create persistent resource repository")
+		}
+	} else {
+		store, err = repo.NewInMemoryResourceRepository(clock)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create in-memory resource repository")
+		}
 	}
 
 	// Start a monitor that can pickup secrets changes and update the settings
@@ -99,8 +131,74 @@ func main() {
 		}
 	}()
 
+	// start the housekeeper to delete old data
+	hk := housekeeper.New(ctx, store, clock, settings)
+	if err = hk.Run(); err != nil {
+		log.Fatal().Err(err).Msg("failed to start database housekeeper")
+	}
+	defer func() {
+		if innerErr := hk.Shutdown(); innerErr != nil {
+			log.Err(innerErr).Msg("failed to shut down database housekeeper")
+		}
+	}()
+
+	// periodically write a snapshot of the tracked resources for the shipper
+	if settings.Inventory.Enabled {
+		snapshotter := inventory.New(ctx, store, clock, settings)
+		if err = snapshotter.Run(); err != nil {
+			log.Fatal().Err(err).Msg("failed to start resource inventory snapshots")
+		}
+		defer func() {
+			if innerErr := snapshotter.Shutdown(); innerErr != nil {
+				log.Err(innerErr).Msg("failed to shut down resource inventory snapshots")
+			}
+		}()
+	}
+
+	// setup k8s client, when any feature needs access to the API server
+	var k8sClient kubernetes.Interface
+	if backfill || rebuilt || settings.Backfill.Interval > 0 || settings.Watch.Active() || settings.Workloads.Enabled || settings.Mutation.Enabled || settings.Scope.NeedsNamespaceLabels() || settings.LogicalClusters.NeedsNamespaceLabels() || settings.Certificate.Managed.Enabled {
+		k8sClient, err = k8s.NewClient(settings.K8sClient.KubeConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to build k8s client")
+		}
+	}
+
+	// custom resources, and owners of other kinds than the built-in ones,
+	// have no typed client
+	var dynamicClient dynamic.Interface
+	if (len(settings.CustomResources) > 0 && (backfill || rebuilt || settings.Backfill.Interval > 0 || settings.Watch.Active())) || settings.Workloads.Enabled {
+		dynamicClient, err = k8s.NewDynamicClient(settings.K8sClient.KubeConfig)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to build dynamic k8s client")
+		}
+	}
+
+	// resolve the workload owning each pod
+	var resolver *workload.Resolver
+	if settings.Workloads.Enabled {
+		resolver = workload.NewResolver(k8sClient, clock, settings, workload.WithDynamicClient(dynamicClient))
+	}
+
+	// the namespace labels are looked up for the defaults of the label rules,
+	// for the namespace selectors of the scope and of the logical clusters
+	labeler := resolver
+	if labeler == nil && (settings.Mutation.Enabled || settings.Scope.NeedsNamespaceLabels() || settings.LogicalClusters.NeedsNamespaceLabels()) {
+		labeler = workload.NewResolver(k8sClient, clock, settings)
+	}
+	objectScope, err := scope.New(settings.Scope, labeler)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to build the object scope")
+	}
+	clusterMapper, err := cluster.New(settings, labeler)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to build the logical clusters")
+	}
+	// the records are assigned to their cluster when they are written
+	store = cluster.NewStore(store, clusterMapper, cluster.HostCluster(settings))
+
 	// create remote metrics writer
-	dataPusher := pusher.New(ctx, store, clock, settings)
+	dataPusher := pusher.New(ctx, store, clock, settings, pusher.WithClusterMapper(clusterMapper))
 	if err = dataPusher.Run(); err != nil {
 		log.Fatal().Err(err).Msg("failed to start remote metrics writer")
 	}
@@ -114,43 +212,112 @@ func main() {
 		}
 	}()
 
-	// start the housekeeper to delete old data
-	hk := housekeeper.New(ctx, store, clock, settings)
-	if err = hk.Run(); err != nil {
-		log.Fatal().Err(err).Msg("failed to start database housekeeper")
+	if backfill {
+		log.Ctx(ctx).Info().Msg("Starting backfill mode")
+		backfiller.NewBackfiller(k8sClient, store, clock, settings, backfiller.WithWorkloadResolver(resolver), backfiller.WithDynamicClient(dynamicClient), backfiller.WithScope(objectScope)).Start(context.Background())
+		return
 	}
-	defer func() {
-		if innerErr := hk.Shutdown(); innerErr != nil {
-			log.Err(innerErr).Msg("failed to shut down database housekeeper")
+
+	// the corrupted database was replaced with an empty one, repopulate it
+	if rebuilt && !backfill {
+		log.Ctx(ctx).Warn().Msg("Rebuilding the resource database from the cluster")
+		go backfiller.NewBackfiller(k8sClient, store, clock, settings, backfiller.WithWorkloadResolver(resolver), backfiller.WithDynamicClient(dynamicClient), backfiller.WithScope(objectScope)).Start(ctx)
+	}
+
+	// periodically reconcile the stored resources against the cluster
+	if settings.Backfill.Interval > 0 {
+		reconciler := backfiller.NewReconciler(ctx, backfiller.NewBackfiller(k8sClient, store, clock, settings, backfiller.WithWorkloadResolver(resolver), backfiller.WithDynamicClient(dynamicClient), backfiller.WithScope(objectScope)), settings.Backfill.Interval)
+		if err = reconciler.Run(); err != nil {
+			log.Fatal().Err(err).Msg("failed to start resource reconciler")
 		}
-	}()
+		defer func() {
+			if innerErr := reconciler.Shutdown(); innerErr != nil {
+				log.Err(innerErr).Msg("failed to shut down resource reconciler")
+			}
+		}()
+	}
 
-	if backfill {
-		log.Ctx(ctx).Info().Msg("Starting backfill mode")
-		// setup k8s client
-		k8sClient, err := k8s.NewClient(settings.K8sClient.KubeConfig)
-		if err != nil {
-			log.Fatal().Err(err).Msg("Failed to build k8s client")
+	// watch resources with informers, alongside or instead of the webhook
+	if settings.Watch.Active() {
+		resourceWatcher := watcher.New(ctx, k8sClient, store, clock, settings, watcher.WithWorkloadResolver(resolver), watcher.WithDynamicClient(dynamicClient), watcher.WithScope(objectScope))
+		if err = resourceWatcher.Run(); err != nil {
+			log.Fatal().Err(err).Msg("failed to start resource watcher")
 		}
-		backfiller.NewBackfiller(k8sClient, store, settings).Start(context.Background())
-		return
+		defer func() {
+			if innerErr := resourceWatcher.Shutdown(); innerErr != nil {
+				log.Err(innerErr).Msg("failed to shut down resource watcher")
+			}
+		}()
 	}
 
 	// error channel
 	errChan := make(chan error)
 
+	admissionRoutes := []http.AdmissionRouteSegment{
+		{Route: "/validate/pod", Hook: handler.NewPodHandler(store, settings, clock, errChan, handler.WithWorkloadResolver(resolver))},
+		{Route: "/validate/deployment", Hook: handler.NewDeploymentHandler(store, settings, clock, errChan)},
+		{Route: "/validate/statefulset", Hook: handler.NewStatefulsetHandler(store, settings, clock, errChan)},
+		{Route: "/validate/namespace", Hook: handler.NewNamespaceHandler(store, settings, clock, errChan)},
+		{Route: "/validate/node", Hook: handler.NewNodeHandler(store, settings, clock, errChan)},
+		{Route: "/validate/job", Hook: handler.NewJobHandler(store, settings, clock, errChan)},
+		{Route: "/validate/cronjob", Hook: handler.NewCronJobHandler(store, settings, clock, errChan)},
+		{Route: "/validate/daemonset", Hook: handler.NewDaemonSetHandler(store, settings, clock, errChan)},
+		{Route: "/validate/replicaset", Hook: handler.NewReplicaSetHandler(store, settings, clock, errChan)},
+		{Route: "/validate/persistentvolumeclaim", Hook: handler.NewPersistentVolumeClaimHandler(store, settings, clock, errChan)},
+		{Route: "/validate/persistentvolume", Hook: handler.NewPersistentVolumeHandler(store, settings, clock, errChan)},
+		{Route: "/validate/service", Hook: handler.NewServiceHandler(store, settings, clock, errChan)},
+		{Route: "/validate/ingress", Hook: handler.NewIngressHandler(store, settings, clock, errChan)},
+		{Route: "/validate/resourcequota", Hook: handler.NewResourceQuotaHandler(store, settings, clock, errChan)},
+	}
+	for _, c := range settings.CustomResources {
+		admissionRoutes = append(admissionRoutes, http.AdmissionRouteSegment{
+			Route: handler.CustomResourceRoute(c),
+			Hook:  handler.NewCustomResourceHandler(c, store, settings, clock, errChan),
+		})
+	}
+	// the objects out of scope are not tracked, the labels of every object are
+	// still mutated
+	recordCloser := handler.NewRecordCloser(store, clock)
+	for i := range admissionRoutes {
+		admissionRoutes[i].Hook = objectScope.Hook(admissionRoutes[i].Hook, recordCloser)
+	}
+	if settings.Mutation.Enabled {
+		admissionRoutes = append(admissionRoutes, http.AdmissionRouteSegment{
+			Route:    handler.MutationRoute,
+			Hook:     handler.NewMutationHandler(settings, labeler),
+			Mutating: true,
+		})
+	}
+	if settings.Watch.Standalone {
+		// only serve the health and metrics endpoints
+		log.Ctx(ctx).Info().Msg("Watching resources without the admission webhook")
+		admissionRoutes = nil
+	}
+
+	var routes []http.RouteSegment
+	if history, ok := store.(types.ResourceHistoryStore); ok {
+		routes = append(routes, http.RouteSegment{Route: http.LabelHistoryRoute, Hook: http.NewLabelHistoryHandler(history, clock)})
+	}
+
+	// report the served certificate, and warn before it expires; the health
+	// check only fails when no certificate could be loaded, while the
+	// certificate check also fails within the expiry window
+	managed := settings.Certificate.Managed.Enabled
+	tlsEnabled := managed || (settings.Certificate.Cert != "" && settings.Certificate.Key != "")
+	var certStatus *monitor.CertificateStatus
+	if tlsEnabled {
+		certPath := settings.Certificate.Cert
+		if managed {
+			certPath = ""
+		}
+		certStatus = monitor.NewCertificateStatus(settings.Certificate.ExpiryWindow, certPath)
+		healthz.Register("tls-certificate", certStatus.Check)
+		routes = append(routes, http.RouteSegment{Route: http.CertificateHealthRoute, Hook: healthz.CheckHandler("tls-certificate-expiry", certStatus.ExpiryCheck)})
+	}
+
 	server := http.NewServer(settings,
-		nil,
-		[]http.AdmissionRouteSegment{
-			{Route: "/validate/pod", Hook: handler.NewPodHandler(store, settings, clock, errChan)},
-			{Route: "/validate/deployment", Hook: handler.NewDeploymentHandler(store, settings, clock, errChan)},
-			{Route: "/validate/statefulset", Hook: handler.NewStatefulsetHandler(store, settings, clock, errChan)},
-			{Route: "/validate/namespace", Hook: handler.NewNamespaceHandler(store, settings, clock, errChan)},
-			{Route: "/validate/node", Hook: handler.NewNodeHandler(store, settings, clock, errChan)},
-			{Route: "/validate/job", Hook: handler.NewJobHandler(store, settings, clock, errChan)},
-			{Route: "/validate/cronjob", Hook: handler.NewCronJobHandler(store, settings, clock, errChan)},
-			{Route: "/validate/daemonset", Hook: handler.NewDaemonSetHandler(store, settings, clock, errChan)},
-		}..., // variadic arguments expansion
+		routes,
+		admissionRoutes..., // variadic arguments expansion
 	)
 
 	go func() {
@@ -165,7 +332,7 @@ func main() {
 		}
 	}()
 
-	if settings.Certificate.Cert == "" || settings.Certificate.Key == "" {
+	if !tlsEnabled {
 		log.Ctx(ctx).Info().Msg("Starting server without TLS")
 		err := server.ListenAndServe()
 		if err != nil {
@@ -181,11 +348,29 @@ func main() {
 		// Options
 		sig := monitor.WithSIGHUPReload(sigc)
 		certs := monitor.WithCertificatesPaths(settings.Certificate.Cert, settings.Certificate.Key, "")
+		if managed {
+			// the certificate is generated, stored in a secret and rotated
+			certManager := monitor.NewCertificateManager(ctx, k8sClient, clock, settings)
+			if err = certManager.Run(); err != nil {
+				log.Fatal().Err(err).Msg("failed to issue the webhook certificate")
+			}
+			defer func() {
+				if innerErr := certManager.Shutdown(); innerErr != nil {
+					log.Err(innerErr).Msg("failed to shut down the certificate manager")
+				}
+			}()
+			sig = monitor.WithReloadFunc(certManager.NeedsReload)
+			certs = monitor.WithProvider(certManager)
+		}
 		verify := monitor.WithVerifyConnection()
 		cb := monitor.WithOnReload(func(_ *tls.Config) {
 			log.Ctx(ctx).Info().Msg("TLS certificates rotated !!")
 		})
-		server.TLSConfig = monitor.TLSConfig(sig, certs, verify, cb)
+		server.TLSConfig = monitor.TLSConfig(sig, certs, verify, cb, monitor.WithStatus(certStatus))
+		// load the certificate now, so a missing one is reported at startup
+		if _, err = server.TLSConfig.GetCertificate(nil); err != nil {
+			log.Ctx(ctx).Err(err).Msg("Failed to load the TLS certificate")
+		}
 
 		err := server.ListenAndServeTLS("", "")
 		if err != nil {
@@ -195,3 +380,55 @@ func main() {
 	// Print a message when the server is stopped.
 	log.Ctx(ctx).Info().Msg("Server stopped")
 }
+
+// diffFilters lists the live resources through the backfiller, and prints the
+// labels and annotations which the proposed configuration would add or remove
+// compared to the current one. The objects in scope of the current
+// configuration are compared.
+func diffFilters(ctx context.Context, clock types.TimeProvider, settings *config.Settings, proposedFiles config.Files) error {
+	proposed, err := config.NewSettings(proposedFiles...)
+	if err != nil {
+		return fmt.Errorf("failed to load the proposed settings: %w", err)
+	}
+	listSettings := filterdiff.ListSettings(settings, proposed)
+
+	k8sClient, err := k8s.NewClient(settings.K8sClient.KubeConfig)
+	if err != nil {
+		return fmt.Errorf("failed to build k8s client: %w", err)
+	}
+	var dynamicClient dynamic.Interface
+	if len(listSettings.CustomResources) > 0 || settings.Workloads.Enabled || proposed.Workloads.Enabled {
+		if dynamicClient, err = k8s.NewDynamicClient(settings.K8sClient.KubeConfig); err != nil {
+			return fmt.Errorf("failed to build dynamic k8s client: %w", err)
+		}
+	}
+	// the labels which pods inherit are compared with the workloads of each
+	// configuration
+	var currentResolver, proposedResolver *workload.Resolver
+	if settings.Workloads.Enabled {
+		currentResolver = workload.NewResolver(k8sClient, clock, settings, workload.WithDynamicClient(dynamicClient))
+	}
+	if proposed.Workloads.Enabled {
+		proposedResolver = workload.NewResolver(k8sClient, clock, proposed, workload.WithDynamicClient(dynamicClient))
+	}
+	labeler := currentResolver
+	if labeler == nil && settings.Scope.NeedsNamespaceLabels() {
+		labeler = workload.NewResolver(k8sClient, clock, settings)
+	}
+	objectScope, err := scope.New(settings.Scope, labeler)
+	if err != nil {
+		return fmt.Errorf("failed to build the object scope: %w", err)
+	}
+
+	// the diff of the listed objects is printed even when a list request failed
+	diff := filterdiff.New(settings, proposed, filterdiff.WithWorkloadResolvers(currentResolver, proposedResolver))
+	walkErr := backfiller.NewBackfiller(k8sClient, nil, clock, listSettings, backfiller.WithDynamicClient(dynamicClient), backfiller.WithScope(objectScope)).Walk(ctx, func(item any) {
+		if addErr := diff.Add(ctx, item); addErr != nil {
+			log.Err(addErr).Msg("Failed to format data")
+		}
+	})
+	if err = diff.Write(os.Stdout); err != nil {
+		return fmt.Errorf("failed to print the diff: %w", err)
+	}
+	return walkErr
+}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/cloudzero/cloudzero-agent/app/build"
 	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/domain/k8s"
 	"github.com/cloudzero/cloudzero-agent/app/domain/monitor"
 	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
 	"github.com/cloudzero/cloudzero-agent/app/handlers"
@@ -82,8 +83,20 @@ func main() {
 		os.Exit(0)
 	}()
 
+	// the lease coordination backend talks to the cluster api
+	opts := []shipper.MetricShipperOption{}
+	if settings.Coordination.Backend == config.CoordinationBackendLease {
+		client, err := k8s.NewClient("") //nolint:govet // I actively and vehemently disagree with `shadowing` of `err` in golang
+		if err != nil {
+			log.Err(err).Msg("failed to create the kubernetes client")
+			exitCode = 1
+			return
+		}
+		opts = append(opts, shipper.WithKubernetesClient(client))
+	}
+
 	// Create the shipper and start in a thread
-	domain, err := shipper.NewMetricShipper(ctx, settings, store)
+	domain, err := shipper.NewMetricShipper(ctx, settings, store, opts...)
 	if err != nil {
 		log.Err(err).Msg("failed to create the metric shipper")
 		exitCode = 1
@@ -107,8 +120,13 @@ func main() {
 		}
 	}()
 
+	apis := []server.API{handlers.NewShipperAPI("/", domain)}
+	if settings.Admin.Enabled {
+		apis = append(apis, handlers.NewShipperAdminAPI("/admin", domain, &settings.Admin))
+	}
+
 	logger.Info().Msg("Starting service")
-	server.New(build.Version(), nil, handlers.NewShipperAPI("/", domain)).Run(context.Background())
+	server.New(build.Version(), nil, apis...).Run(context.Background())
 	logger.Info().Msg("Service stopping")
 
 	defer func() {
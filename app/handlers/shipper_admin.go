@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-obvious/server"
+	"github.com/go-obvious/server/api"
+	"github.com/go-obvious/server/request"
+	"github.com/rs/zerolog/log"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
+	"github.com/cloudzero/cloudzero-agent/app/store"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// ShipperAdminAPI exposes the state of the shipper for debugging, and allows
+// an operator to trigger a cycle, request a replay, and inspect stored files.
+// Every route requires the bearer token configured in `admin.token_path`.
+type ShipperAdminAPI struct {
+	api.Service
+	shipper *shipper.MetricShipper
+	admin   *config.Admin
+}
+
+type replayRequestBody struct {
+	ReferenceIDs []string              `json:"referenceIds"` //nolint:tagliatelle // matches the persisted replay request
+	Ranges       []shipper.ReplayRange `json:"ranges"`
+}
+
+func NewShipperAdminAPI(base string, d *shipper.MetricShipper, admin *config.Admin) *ShipperAdminAPI {
+	a := &ShipperAdminAPI{
+		shipper: d,
+		admin:   admin,
+		Service: api.Service{
+			APIName: "shipper-admin",
+			Mounts:  map[string]*chi.Mux{},
+		},
+	}
+	a.Service.Mounts[base] = a.Routes()
+	return a
+}
+
+func (a *ShipperAdminAPI) Register(app server.Server) error {
+	if err := a.Service.Register(app); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *ShipperAdminAPI) Routes() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(a.authenticate)
+	r.Get("/status", a.GetStatus)
+	r.Post("/flush", a.PostFlush)
+	r.Post("/replay", a.PostReplay)
+	r.Get("/files", a.ListFiles)
+	r.Get("/files/{category}/{name}", a.GetFile)
+	return r
+}
+
+// authenticate rejects any request which does not carry the admin token.
+func (a *ShipperAdminAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := a.admin.GetToken()
+		if err != nil {
+			log.Ctx(r.Context()).Err(err).Msg("failed to read the admin token")
+			request.Reply(r, w, "admin API unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			request.Reply(r, w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *ShipperAdminAPI) GetStatus(w http.ResponseWriter, r *http.Request) {
+	request.Reply(r, w, a.shipper.Status(), http.StatusOK)
+}
+
+func (a *ShipperAdminAPI) PostFlush(w http.ResponseWriter, r *http.Request) {
+	if err := a.shipper.RunNow(); err != nil {
+		log.Ctx(r.Context()).Err(err).Msg("manual shipper cycle failed")
+	}
+	request.Reply(r, w, a.shipper.Status(), http.StatusOK)
+}
+
+func (a *ShipperAdminAPI) PostReplay(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var body replayRequestBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxPayloadSize)).Decode(&body); err != nil {
+		request.Reply(r, w, "invalid replay request body", http.StatusBadRequest)
+		return
+	}
+
+	rr, err := a.shipper.SubmitReplayRequest(r.Context(), body.ReferenceIDs, body.Ranges)
+	if err != nil {
+		log.Ctx(r.Context()).Err(err).Msg("failed to submit the replay request")
+		request.Reply(r, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request.Reply(r, w, rr, http.StatusAccepted)
+}
+
+func (a *ShipperAdminAPI) ListFiles(w http.ResponseWriter, r *http.Request) {
+	categories := shipper.FileCategories
+	if category := r.URL.Query().Get("category"); category != "" {
+		categories = []string{category}
+	}
+
+	res := map[string][]shipper.StoredFile{}
+	for _, category := range categories {
+		files, err := a.shipper.ListStoredFiles(category)
+		if errors.Is(err, shipper.ErrUnknownFileCategory) {
+			request.Reply(r, w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Ctx(r.Context()).Err(err).Str("category", category).Msg("failed to list the files")
+			request.Reply(r, w, "failed to list the files", http.StatusInternalServerError)
+			return
+		}
+		res[category] = files
+	}
+
+	request.Reply(r, w, res, http.StatusOK)
+}
+
+// GetFile downloads a single file. Metric files are decoded and returned as
+// JSON, or as CSV with `?format=csv`. Replay requests are returned as stored.
+func (a *ShipperAdminAPI) GetFile(w http.ResponseWriter, r *http.Request) {
+	category := chi.URLParam(r, "category")
+	path, err := a.shipper.GetStoredFilePath(category, chi.URLParam(r, "name"))
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		request.Reply(r, w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		request.Reply(r, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if category == shipper.FileCategoryReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Ctx(r.Context()).Err(err).Str("path", path).Msg("failed to read the replay request")
+			request.Reply(r, w, "failed to read the file", http.StatusInternalServerError)
+			return
+		}
+		request.ReplyBytes(r, w, data, http.StatusOK, "application/json")
+		return
+	}
+
+	metrics, err := store.ReadCompressedJSONFile(path)
+	if err != nil {
+		// quarantined files are expected to be unreadable
+		request.Reply(r, w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		request.Reply(r, w, metrics, http.StatusOK)
+	case "csv":
+		writeMetricsCSV(w, metrics)
+	default:
+		request.Reply(r, w, "unsupported format: "+format, http.StatusBadRequest)
+	}
+}
+
+func writeMetricsCSV(w http.ResponseWriter, metrics []types.Metric) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"id", "cluster_name", "cloud_account_id", "metric_name", "node_name", "created_at", "timestamp", "labels", "value"})
+	for _, metric := range metrics {
+		labels, _ := json.Marshal(metric.Labels)
+		_ = writer.Write([]string{
+			metric.ID.String(),
+			metric.ClusterName,
+			metric.CloudAccountID,
+			metric.MetricName,
+			metric.NodeName,
+			metric.CreatedAt.UTC().Format(time.RFC3339Nano),
+			metric.TimeStamp.UTC().Format(time.RFC3339Nano),
+			string(labels),
+			metric.Value,
+		})
+	}
+	writer.Flush()
+}
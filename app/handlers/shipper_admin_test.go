@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-obvious/server/test"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/domain/shipper"
+	"github.com/cloudzero/cloudzero-agent/app/handlers"
+	"github.com/cloudzero/cloudzero-agent/app/store"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+const adminToken = "admin-token"
+
+func setupShipperAdmin(t *testing.T) (*handlers.ShipperAdminAPI, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte(adminToken+"\n"), 0o600))
+
+	settings := &config.Settings{
+		Cloudzero: config.Cloudzero{
+			Host:        "http://example.com",
+			SendTimeout: time.Second,
+		},
+		Database: config.Database{
+			StoragePath: tmpDir,
+		},
+		Admin: config.Admin{
+			Enabled:   true,
+			TokenPath: tokenPath,
+		},
+	}
+
+	diskStore, err := store.NewDiskStore(settings.Database, store.WithContentIdentifier(store.CostContentIdentifier))
+	require.NoError(t, err)
+	require.NoError(t, diskStore.Put(context.Background(), types.Metric{
+		ID:             uuid.New(),
+		ClusterName:    "cluster",
+		CloudAccountID: "account",
+		MetricName:     "test_metric",
+		NodeName:       "node",
+		CreatedAt:      time.Now().UTC(),
+		TimeStamp:      time.Now().UTC(),
+		Labels:         map[string]string{"foo": "bar"},
+		Value:          "1",
+	}))
+	require.NoError(t, diskStore.Flush())
+
+	d, err := shipper.NewMetricShipper(context.Background(), settings, diskStore)
+	require.NoError(t, err)
+
+	files, err := diskStore.GetFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	return handlers.NewShipperAdminAPI(MountBase, d, &settings.Admin), filepath.Base(files[0])
+}
+
+func invokeAdmin(t *testing.T, handler *handlers.ShipperAdminAPI, method, route, token string, body io.Reader) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, route, body)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := test.InvokeService(handler.Service, route, *req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestShipperAdmin_Unauthorized(t *testing.T) {
+	handler, _ := setupShipperAdmin(t)
+
+	resp := invokeAdmin(t, handler, http.MethodGet, "/status", "", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = invokeAdmin(t, handler, http.MethodGet, "/status", "wrong", nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestShipperAdmin_Status(t *testing.T) {
+	handler, _ := setupShipperAdmin(t)
+
+	resp := invokeAdmin(t, handler, http.MethodGet, "/status", adminToken, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var status shipper.Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.NotEmpty(t, status.ShipperID)
+	assert.Nil(t, status.LastRun)
+}
+
+func TestShipperAdmin_ListFiles(t *testing.T) {
+	handler, name := setupShipperAdmin(t)
+
+	resp := invokeAdmin(t, handler, http.MethodGet, "/files", adminToken, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var files map[string][]shipper.StoredFile
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&files))
+	require.Len(t, files[shipper.FileCategoryUnsent], 1)
+	file := files[shipper.FileCategoryUnsent][0]
+	assert.Equal(t, name, file.Name)
+	assert.NotNil(t, file.Start)
+	assert.NotNil(t, file.Stop)
+	require.NotNil(t, file.Rows)
+	assert.Equal(t, 1, *file.Rows)
+	assert.Empty(t, files[shipper.FileCategoryUploaded])
+
+	resp = invokeAdmin(t, handler, http.MethodGet, "/files?category=bogus", adminToken, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestShipperAdmin_GetFile(t *testing.T) {
+	handler, name := setupShipperAdmin(t)
+
+	resp := invokeAdmin(t, handler, http.MethodGet, "/files/unsent/"+name, adminToken, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var metrics []types.Metric
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&metrics))
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "test_metric", metrics[0].MetricName)
+
+	resp = invokeAdmin(t, handler, http.MethodGet, "/files/unsent/"+name+"?format=csv", adminToken, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "test_metric", records[1][3])
+
+	resp = invokeAdmin(t, handler, http.MethodGet, "/files/uploaded/"+name, adminToken, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = invokeAdmin(t, handler, http.MethodGet, "/files/bogus/"+name, adminToken, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestShipperAdmin_Replay(t *testing.T) {
+	handler, _ := setupShipperAdmin(t)
+
+	body, err := json.Marshal(map[string][]string{"referenceIds": {"metrics_1_2.parquet"}})
+	require.NoError(t, err)
+
+	resp := invokeAdmin(t, handler, http.MethodPost, "/replay", adminToken, bytes.NewReader(body))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp = invokeAdmin(t, handler, http.MethodGet, "/files?category=replay", adminToken, nil)
+	defer resp.Body.Close()
+	var files map[string][]shipper.StoredFile
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&files))
+	assert.Len(t, files[shipper.FileCategoryReplay], 1)
+
+	resp = invokeAdmin(t, handler, http.MethodPost, "/replay", adminToken, bytes.NewReader([]byte(`{}`)))
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+)
+
+// DefaultAdmissionAuditBufferSize is how many audit records wait to be
+// written before new ones are dropped.
+const DefaultAdmissionAuditBufferSize = 1000
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	UID       string    `json:"uid"`
+	Route     string    `json:"route"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Operation string    `json:"operation"`
+	User      string    `json:"user"`
+}
+
+// auditLog writes every admission request the controller saw as a JSON line.
+// The records are written in the background, so the disk writes and the
+// rotation of the file never delay the admission. When the buffer is full,
+// the records are dropped and counted.
+type auditLog struct {
+	w       io.WriteCloser
+	enc     *json.Encoder
+	records chan auditRecord
+	done    chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAuditLog(w io.WriteCloser, size int) *auditLog {
+	if size <= 0 {
+		size = DefaultAdmissionAuditBufferSize
+	}
+	a := &auditLog{
+		w:       w,
+		enc:     json.NewEncoder(w),
+		records: make(chan auditRecord, size),
+		done:    make(chan struct{}),
+	}
+	go a.write()
+	return a
+}
+
+// record queues a request to be written to the audit log, without waiting
+// for it to be written. A nil log records nothing.
+func (a *auditLog) record(route string, r *hook.Request) {
+	if a == nil {
+		return
+	}
+	record := auditRecord{
+		Time:      time.Now().UTC(),
+		UID:       string(r.UID),
+		Route:     route,
+		Kind:      r.Kind.Kind,
+		Namespace: r.Namespace,
+		Name:      r.Name,
+		Operation: string(r.Operation),
+		User:      r.UserInfo.Username,
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		AdmissionAuditDropped.WithLabelValues("shutdown").Inc()
+		return
+	}
+	select {
+	case a.records <- record:
+	default:
+		AdmissionAuditDropped.WithLabelValues("buffer_full").Inc()
+	}
+}
+
+// write writes the queued records to the file until the log is closed.
+func (a *auditLog) write() {
+	defer close(a.done)
+	for record := range a.records {
+		if err := a.enc.Encode(record); err != nil {
+			log.Warn().Err(err).Msg("Failed to write the admission audit log")
+		}
+	}
+}
+
+// Close writes the queued records, and closes the underlying file.
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.records)
+	a.mu.Unlock()
+
+	<-a.done
+	return a.w.Close()
+}
@@ -5,23 +5,111 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 	admission "k8s.io/api/admission/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
 	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/logging"
+)
+
+// admissionBuckets cover the latencies expected of a webhook, from a
+// millisecond up to the 10s default timeout of the API server.
+var admissionBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	// AdmissionResponseDuration measures how long the webhook takes to
+	// respond, which is how long it delays the admission.
+	AdmissionResponseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "admission_response_duration_seconds",
+			Help:    "Histogram of the time taken to respond to admission requests, labeled by route and operation.",
+			Buckets: admissionBuckets,
+		},
+		[]string{"route", "operation"},
+	)
+
+	// AdmissionProcessingDuration measures how long the handlers take to
+	// process the requests, whether queued or not.
+	AdmissionProcessingDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "admission_processing_duration_seconds",
+			Help:    "Histogram of the time taken to process admission requests, labeled by route and operation.",
+			Buckets: admissionBuckets,
+		},
+		[]string{"route", "operation"},
+	)
+
+	// AdmissionQueueWait measures how long the requests wait in the queue.
+	AdmissionQueueWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "admission_queue_wait_seconds",
+			Help:    "Histogram of the time admission requests wait in the queue, labeled by route.",
+			Buckets: admissionBuckets,
+		},
+		[]string{"route"},
+	)
+
+	// AdmissionQueueLength is the number of queued requests.
+	AdmissionQueueLength = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "admission_queue_length",
+			Help: "Number of admission requests waiting to be processed.",
+		},
+	)
+
+	// AdmissionDropped counts the requests which were not processed.
+	AdmissionDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "admission_dropped_total",
+			Help: "Count of admission requests dropped without being processed, labeled by route and reason.",
+		},
+		[]string{"route", "reason"},
+	)
+
+	// AdmissionTimeouts counts the synchronous requests which were allowed
+	// before they were processed.
+	AdmissionTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "admission_timeouts_total",
+			Help: "Count of admission requests allowed once the fail-safe timeout passed, labeled by route.",
+		},
+		[]string{"route"},
+	)
+
+	// AdmissionAuditDropped counts the audit records which were not written.
+	AdmissionAuditDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "admission_audit_dropped_total",
+			Help: "Count of admission audit records dropped without being written, labeled by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	admissionStatsOnce sync.Once
 )
 
 // admissionHandler represents the HTTP handler for an admission webhook
 type admissionHandler struct {
 	decoder runtime.Decoder
+	// queue processes the requests in the background, they are processed
+	// before responding when nil
+	queue *admissionQueue
+	// timeout bounds the processing of the synchronous requests
+	timeout time.Duration
+	audit   *auditLog
 }
 
 // handler returns an instance of AdmissionHandler
@@ -31,9 +119,65 @@ func handler() *admissionHandler {
 	}
 }
 
+// newHandler returns an instance of AdmissionHandler configured by the
+// admission settings.
+func newHandler(cfg *config.Settings) *admissionHandler {
+	admissionStatsOnce.Do(func() {
+		prometheus.MustRegister(
+			AdmissionResponseDuration,
+			AdmissionProcessingDuration,
+			AdmissionQueueWait,
+			AdmissionQueueLength,
+			AdmissionDropped,
+			AdmissionTimeouts,
+			AdmissionAuditDropped,
+		)
+	})
+
+	h := handler()
+	h.timeout = cfg.Admission.Timeout
+	if h.timeout <= 0 {
+		h.timeout = DefaultAdmissionTimeout
+	}
+	if !cfg.Admission.Synchronous {
+		h.queue = newAdmissionQueue(cfg.Admission.QueueSize, cfg.Admission.Workers, cfg.Admission.DropPolicy, h.timeout)
+	}
+	if audit := cfg.Admission.Audit; audit.Enabled {
+		file, err := logging.NewRotatingFile(audit.Path, int64(audit.MaxSize)<<20, audit.MaxBackups)
+		if err != nil {
+			// the admission requests are still processed
+			log.Error().Err(err).Msg("Failed to open the admission audit log")
+		} else {
+			h.audit = newAuditLog(file, DefaultAdmissionAuditBufferSize)
+		}
+	}
+	return h
+}
+
+// Close processes the queued requests, and closes the audit log.
+func (h *admissionHandler) Close() {
+	if h.queue != nil {
+		h.queue.Close()
+	}
+	if err := h.audit.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close the admission audit log")
+	}
+}
+
 // Serve returns a http.HandlerFunc for an admission webhook
 func (h *admissionHandler) Serve(handler hook.Handler) http.HandlerFunc {
+	return h.serve(handler, false)
+}
+
+// ServeMutating returns a http.HandlerFunc for a mutating admission webhook,
+// whose requests are never queued as the response carries their patch.
+func (h *admissionHandler) ServeMutating(handler hook.Handler) http.HandlerFunc {
+	return h.serve(handler, true)
+}
+
+func (h *admissionHandler) serve(handler hook.Handler, mutating bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
 		w.Header().Set("Content-Type", "application/json")
 
 		log.Ctx(r.Context()).Debug().Msg("Handling admissions request ...")
@@ -66,12 +210,34 @@ func (h *admissionHandler) Serve(handler hook.Handler) http.HandlerFunc {
 			return
 		}
 
-		log.Ctx(r.Context()).Debug().Str("operation", string(review.Request.Operation)).Msg("Executing the review request ...")
-		result, err := handler.Execute(r.Context(), review.Request)
-		if err != nil {
-			log.Ctx(r.Context()).Error().Err(err).Send()
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		route := r.URL.Path
+		operation := string(review.Request.Operation)
+		defer func() {
+			AdmissionResponseDuration.WithLabelValues(route, operation).Observe(time.Since(start).Seconds())
+		}()
+
+		h.audit.record(route, review.Request)
+
+		var result *hook.Result
+		if h.queue != nil && !mutating {
+			// the resource is recorded in the background, and the validating
+			// webhooks always allow it
+			log.Ctx(r.Context()).Debug().Str("operation", operation).Msg("Queueing the review request ...")
+			h.queue.push(admissionJob{
+				ctx:     context.WithoutCancel(r.Context()),
+				route:   route,
+				handler: handler,
+				request: review.Request,
+			})
+			result = &hook.Result{Allowed: true}
+		} else {
+			log.Ctx(r.Context()).Debug().Str("operation", operation).Msg("Executing the review request ...")
+			result, err = h.execute(r.Context(), route, handler, review.Request)
+			if err != nil {
+				log.Ctx(r.Context()).Error().Err(err).Send()
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 		}
 
 		admissionResponse := admission.AdmissionReview{
@@ -80,11 +246,17 @@ func (h *admissionHandler) Serve(handler hook.Handler) http.HandlerFunc {
 				APIVersion: "admission.k8s.io/v1",
 			},
 			Response: &admission.AdmissionResponse{
-				UID:     review.Request.UID,
-				Allowed: result.Allowed,
-				Result:  &meta.Status{Message: result.Msg},
+				UID:      review.Request.UID,
+				Allowed:  result.Allowed,
+				Result:   &meta.Status{Message: result.Msg},
+				Warnings: result.Warnings,
 			},
 		}
+		if len(result.Patch) > 0 {
+			patchType := admission.PatchTypeJSONPatch
+			admissionResponse.Response.Patch = result.Patch
+			admissionResponse.Response.PatchType = &patchType
+		}
 
 		res, err := json.Marshal(admissionResponse)
 		if err != nil {
@@ -104,3 +276,46 @@ func (h *admissionHandler) Serve(handler hook.Handler) http.HandlerFunc {
 			Msg("Webhook Handled")
 	}
 }
+
+// execute processes a request before responding. The request is allowed
+// without its result once the timeout passes, and is processed in the
+// background.
+func (h *admissionHandler) execute(ctx context.Context, route string, handler hook.Handler, r *hook.Request) (*hook.Result, error) {
+	if h.timeout <= 0 {
+		return executeHook(ctx, route, handler, r)
+	}
+
+	type outcome struct {
+		result *hook.Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := executeHook(context.WithoutCancel(ctx), route, handler, r)
+		done <- outcome{result, err}
+	}()
+
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timer.C:
+		AdmissionTimeouts.WithLabelValues(route).Inc()
+		log.Ctx(ctx).Warn().
+			Str("route", route).
+			Str("uid", string(r.UID)).
+			Dur("timeout", h.timeout).
+			Msg("Allowing the admission request, which is still being processed")
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+// executeHook runs the handler of a request, and measures its duration.
+func executeHook(ctx context.Context, route string, handler hook.Handler, r *hook.Request) (*hook.Result, error) {
+	start := time.Now()
+	defer func() {
+		AdmissionProcessingDuration.WithLabelValues(route, string(r.Operation)).Observe(time.Since(start).Seconds())
+	}()
+	return handler.Execute(ctx, r)
+}
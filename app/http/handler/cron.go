@@ -25,6 +25,7 @@ func NewCronJobHandler(store types.ResourceStore, settings *config.Settings, clo
 	h := &CronJobHandler{settings: settings}
 	h.Handler.Create = h.Create()
 	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
 	h.Handler.Store = store
 	h.Handler.ErrorChan = errChan
 	h.clock = clock
@@ -55,6 +56,18 @@ func (h *CronJobHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *CronJobHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.CronJobs || h.settings.Filters.Annotations.Resources.CronJobs {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *CronJobHandler) parseV1(data []byte) (*batchv1.CronJob, error) {
 	var o batchv1.CronJob
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -67,6 +80,10 @@ func (h *CronJobHandler) writeDataToStorage(ctx context.Context, o *batchv1.Cron
 	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatCronJobData(o, h.settings))
 }
 
+func (h *CronJobHandler) writeDeletionToStorage(ctx context.Context, o *batchv1.CronJob) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatCronJobData(o, h.settings))
+}
+
 func FormatCronJobData(o *batchv1.CronJob, settings *config.Settings) types.ResourceTags {
 	var (
 		labels      = config.MetricLabelTags{}
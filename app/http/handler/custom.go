@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// CustomResourceHandler handles the admission requests of a kind configured
+// in `custom_resources`, such as a CRD, without a dedicated handler.
+type CustomResourceHandler struct {
+	hook.Handler
+	resource config.CustomResource
+	settings *config.Settings
+	clock    types.TimeProvider
+}
+
+// NewCustomResourceHandler creates a new instance of custom resource validation hook
+func NewCustomResourceHandler(resource config.CustomResource, store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+	h := &CustomResourceHandler{resource: resource, settings: settings}
+	h.Handler.Create = h.Create()
+	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
+	h.Handler.Store = store
+	h.Handler.ErrorChan = errChan
+	h.clock = clock
+	return h.Handler
+}
+
+// CustomResourceRoute returns the route of the admission requests of a custom
+// resource.
+func CustomResourceRoute(resource config.CustomResource) string {
+	return "/validate/custom/" + resource.Plural() + "." + resource.Group
+}
+
+func (h *CustomResourceHandler) Create() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Enabled || h.settings.Filters.Annotations.Enabled {
+			if o, err := h.parse(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *CustomResourceHandler) Update() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Enabled || h.settings.Filters.Annotations.Enabled {
+			if o, err := h.parse(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *CustomResourceHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Enabled || h.settings.Filters.Annotations.Enabled {
+			if o, err := h.parse(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *CustomResourceHandler) parse(data []byte) (*unstructured.Unstructured, error) {
+	o := &unstructured.Unstructured{}
+	if err := o.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	if gvk := o.GroupVersionKind(); gvk.Group != h.resource.Group || gvk.Kind != h.resource.Kind {
+		return nil, fmt.Errorf("unexpected kind %s, expected %s", gvk, h.resource)
+	}
+	return o, nil
+}
+
+func (h *CustomResourceHandler) writeDataToStorage(ctx context.Context, o *unstructured.Unstructured) {
+	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatCustomResourceData(o, h.resource, h.settings))
+}
+
+func (h *CustomResourceHandler) writeDeletionToStorage(ctx context.Context, o *unstructured.Unstructured) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatCustomResourceData(o, h.resource, h.settings))
+}
+
+// FormatCustomResourceData formats an object of a custom resource. The name of
+// the object is attached with the metric name of the kind as the key, e.g.
+// `inferenceservice`.
+func FormatCustomResourceData(o *unstructured.Unstructured, resource config.CustomResource, settings *config.Settings) types.ResourceTags {
+	var (
+		labels      = config.MetricLabelTags{}
+		annotations = config.MetricLabelTags{}
+		name        = o.GetName()
+		kind        = resource.MetricName()
+	)
+	if settings.Filters.Labels.Enabled {
+		labels = config.Filter(o.GetLabels(), settings.LabelMatches, true, settings)
+	}
+	if settings.Filters.Annotations.Enabled {
+		annotations = config.Filter(o.GetAnnotations(), settings.AnnotationMatches, true, settings)
+	}
+	metricLabels := config.MetricLabels{
+		kind:            name, // standard metric labels to attach to metric
+		"resource_type": kind,
+	}
+	record := types.ResourceTags{
+		Type:         resource.ResourceType(),
+		Name:         name,
+		MetricLabels: &metricLabels,
+		Labels:       &labels,
+		Annotations:  &annotations,
+	}
+	if namespace := o.GetNamespace(); namespace != "" {
+		metricLabels["namespace"] = namespace
+		record.Namespace = &namespace
+	}
+	return record
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+var testRollout = config.CustomResource{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}
+
+func makeUnstructured(apiVersion, kind, name, namespace string, labels map[string]string) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{}
+	o.SetAPIVersion(apiVersion)
+	o.SetKind(kind)
+	o.SetName(name)
+	o.SetNamespace(namespace)
+	o.SetLabels(labels)
+	return o
+}
+
+func makeCustomResourceRequest(o *unstructured.Unstructured) *hook.Request {
+	raw, _ := o.MarshalJSON()
+	return &hook.Request{
+		Object: runtime.RawExtension{
+			Raw: raw,
+		},
+	}
+}
+
+func TestCustomResourceHandler_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		object  *unstructured.Unstructured
+		written bool
+	}{
+		{
+			name:    "Test create with labels enabled",
+			enabled: true,
+			object:  makeUnstructured("argoproj.io/v1alpha1", "Rollout", "web", "default", map[string]string{"app": "test"}),
+			written: true,
+		},
+		{
+			name:    "Test create with labels disabled",
+			enabled: false,
+			object:  makeUnstructured("argoproj.io/v1alpha1", "Rollout", "web", "default", nil),
+		},
+		{
+			name:    "Test create with another kind",
+			enabled: true,
+			object:  makeUnstructured("argoproj.io/v1alpha1", "AnalysisRun", "web", "default", nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtl := gomock.NewController(t)
+			defer mockCtl.Finish()
+			writer := mocks.NewMockResourceStore(mockCtl)
+
+			settings := NewTestSettings()
+			settings.Filters.Labels.Enabled = tt.enabled
+			settings.Filters.Annotations.Enabled = tt.enabled
+			if tt.written {
+				writer.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, nil)
+				writer.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+				writer.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			handler := NewCustomResourceHandler(testRollout, writer, settings, mocks.NewMockClock(time.Now()), make(chan error))
+			result, err := handler.Create(context.Background(), makeCustomResourceRequest(tt.object))
+			assert.NoError(t, err)
+			assert.Equal(t, &hook.Result{Allowed: true}, result)
+		})
+	}
+}
+
+func TestFormatCustomResourceData(t *testing.T) {
+	settings := NewTestSettings()
+
+	record := FormatCustomResourceData(makeUnstructured("argoproj.io/v1alpha1", "Rollout", "web", "default", map[string]string{"team": "a"}), testRollout, settings)
+	assert.Equal(t, testRollout.ResourceType(), record.Type)
+	assert.Equal(t, "web", record.Name)
+	require.NotNil(t, record.Namespace)
+	assert.Equal(t, "default", *record.Namespace)
+	assert.Equal(t, config.MetricLabels{"rollout": "web", "namespace": "default", "resource_type": "rollout"}, *record.MetricLabels)
+	assert.Equal(t, config.MetricLabelTags{"team": "a"}, *record.Labels)
+
+	// cluster-scoped kinds have no namespace
+	nodePool := config.CustomResource{Group: "karpenter.sh", Version: "v1", Kind: "NodePool"}
+	record = FormatCustomResourceData(makeUnstructured("karpenter.sh/v1", "NodePool", "default", "", nil), nodePool, settings)
+	assert.Nil(t, record.Namespace)
+	assert.Equal(t, config.MetricLabels{"nodepool": "default", "resource_type": "nodepool"}, *record.MetricLabels)
+}
+
+func TestFormatResourceData_CustomResource(t *testing.T) {
+	settings := NewTestSettings()
+	settings.CustomResources = []config.CustomResource{testRollout}
+
+	record, err := FormatResourceData(makeUnstructured("argoproj.io/v1alpha1", "Rollout", "web", "default", nil), settings)
+	require.NoError(t, err)
+	assert.Equal(t, testRollout.ResourceType(), record.Type)
+
+	_, err = FormatResourceData(makeUnstructured("example.com/v1", "Widget", "web", "default", nil), settings)
+	assert.Error(t, err)
+}
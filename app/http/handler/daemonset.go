@@ -26,6 +26,7 @@ func NewDaemonSetHandler(store types.ResourceStore, settings *config.Settings, c
 	h := &DaemonSetHandler{settings: settings}
 	h.Handler.Create = h.Create()
 	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
 	h.Handler.Store = store
 	h.Handler.ErrorChan = errChan
 	h.clock = clock
@@ -56,6 +57,18 @@ func (h *DaemonSetHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *DaemonSetHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.DaemonSets || h.settings.Filters.Annotations.Resources.DaemonSets {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *DaemonSetHandler) parseV1(data []byte) (*v1.DaemonSet, error) {
 	var o v1.DaemonSet
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -68,6 +81,10 @@ func (h *DaemonSetHandler) writeDataToStorage(ctx context.Context, o *v1.DaemonS
 	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatDaemonSetData(o, h.settings))
 }
 
+func (h *DaemonSetHandler) writeDeletionToStorage(ctx context.Context, o *v1.DaemonSet) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatDaemonSetData(o, h.settings))
+}
+
 func FormatDaemonSetData(o *v1.DaemonSet, settings *config.Settings) types.ResourceTags {
 	namespace := o.GetNamespace()
 	labels := config.Filter(o.GetLabels(), settings.LabelMatches, (settings.Filters.Labels.Enabled && settings.Filters.Labels.Resources.DaemonSets), settings)
@@ -27,6 +27,7 @@ func NewDeploymentHandler(store types.ResourceStore, settings *config.Settings,
 	d := &DeploymentHandler{settings: settings}
 	d.Handler.Create = d.Create()
 	d.Handler.Update = d.Update()
+	d.Handler.Delete = d.Delete()
 	d.Handler.Store = store
 	d.Handler.ErrorChan = errChan
 	d.clock = clock
@@ -57,6 +58,18 @@ func (h *DeploymentHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *DeploymentHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Deployments || h.settings.Filters.Annotations.Resources.Deployments {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *DeploymentHandler) parseV1(data []byte) (*v1.Deployment, error) {
 	var o v1.Deployment
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -69,6 +82,10 @@ func (h *DeploymentHandler) writeDataToStorage(ctx context.Context, o *v1.Deploy
 	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatDeploymentData(o, h.settings))
 }
 
+func (h *DeploymentHandler) writeDeletionToStorage(ctx context.Context, o *v1.Deployment) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatDeploymentData(o, h.settings))
+}
+
 func FormatDeploymentData(o *v1.Deployment, settings *config.Settings) types.ResourceTags {
 	var (
 		labels      = config.MetricLabelTags{}
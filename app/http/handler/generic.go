@@ -7,6 +7,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
 
 	"github.com/cloudzero/cloudzero-agent/app/instr"
 	"github.com/cloudzero/cloudzero-agent/app/types"
@@ -58,6 +68,8 @@ func genericWriteDataToStorage(
 					record.RecordCreated = found.RecordCreated
 					record.RecordUpdated = clock.GetCurrentTime()
 					record.SentAt = nil // reset send
+					record.StartSent = found.StartSent
+					record.Cluster = found.Cluster
 					return store.Update(txCtx, &record)
 				})
 			})
@@ -77,3 +89,150 @@ func genericWriteDataToStorage(
 		log.Ctx(ctx).Debug().Msg("Successfully wrote data to storage")
 	}
 }
+
+// genericWriteDeletionToStorage records the deletion of a k8s object, so the
+// end of its lifetime is sent with the next flush.
+func genericWriteDeletionToStorage(
+	ctx context.Context,
+	store types.ResourceStore,
+	clock types.TimeProvider,
+	record types.ResourceTags,
+) {
+	deletedAt := clock.GetCurrentTime()
+	record.DeletedAt = &deletedAt
+	genericWriteDataToStorage(ctx, store, clock, record)
+}
+
+// WriteDataToStorage creates or updates the record of a k8s object, the same
+// way the admission handlers do.
+func WriteDataToStorage(ctx context.Context, store types.ResourceStore, clock types.TimeProvider, record types.ResourceTags) {
+	genericWriteDataToStorage(ctx, store, clock, record)
+}
+
+// WriteDeletionToStorage records the deletion of a k8s object, the same way
+// the admission handlers do.
+func WriteDeletionToStorage(ctx context.Context, store types.ResourceStore, clock types.TimeProvider, record types.ResourceTags) {
+	genericWriteDeletionToStorage(ctx, store, clock, record)
+}
+
+// CloseStoredRecord records the deletion of the live stored record of a k8s
+// object, such as an object which is no longer tracked. The namespace is empty
+// for cluster-scoped objects. It returns true when a record was closed.
+func CloseStoredRecord(
+	ctx context.Context,
+	store types.ResourceStore,
+	clock types.TimeProvider,
+	resourceType config.ResourceType,
+	namespace, name string,
+) bool {
+	conditions := []any{"type = ? AND name = ? AND deleted_at IS NULL", resourceType, name}
+	if namespace != "" {
+		conditions = []any{"type = ? AND name = ? AND namespace = ? AND deleted_at IS NULL", resourceType, name, namespace}
+	}
+	found, err := store.FindFirstBy(ctx, conditions...)
+	if err != nil || found == nil {
+		return false
+	}
+	genericWriteDeletionToStorage(ctx, store, clock, *found)
+	return true
+}
+
+// RecordCloser closes the stored records of the objects which are no longer
+// tracked with CloseStoredRecord.
+type RecordCloser struct {
+	store types.ResourceStore
+	clock types.TimeProvider
+}
+
+// NewRecordCloser creates a RecordCloser writing to the store.
+func NewRecordCloser(store types.ResourceStore, clock types.TimeProvider) *RecordCloser {
+	return &RecordCloser{store: store, clock: clock}
+}
+
+// CloseRecord closes the live stored record of an object, and returns true
+// when a record was closed.
+func (c *RecordCloser) CloseRecord(ctx context.Context, resourceType config.ResourceType, namespace, name string) bool {
+	return CloseStoredRecord(ctx, c.store, c.clock, resourceType, namespace, name)
+}
+
+// SameTags returns true when the stored record matches the formatted resource,
+// so writing the resource again would change nothing but the send state.
+func SameTags(found, record *types.ResourceTags) bool {
+	return sameMap(found.Labels, record.Labels) &&
+		sameMap(found.Annotations, record.Annotations) &&
+		sameMap(found.MetricLabels, record.MetricLabels) &&
+		reflect.DeepEqual(found.Spec, record.Spec)
+}
+
+func sameMap[T ~map[string]string](a, b *T) bool {
+	var left, right T
+	if a != nil {
+		left = *a
+	}
+	if b != nil {
+		right = *b
+	}
+	if len(left) == 0 && len(right) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// FormatResourceData formats any supported k8s object with the matching
+// `Format*Data` function. Unstructured objects are formatted as the custom
+// resource configured for their group and kind.
+func FormatResourceData(obj any, settings *config.Settings) (types.ResourceTags, error) {
+	switch o := obj.(type) {
+	case *corev1.Node:
+		return FormatNodeData(o, settings), nil
+	case *corev1.Namespace:
+		return FormatNamespaceData(o, settings), nil
+	case *corev1.Pod:
+		return FormatPodData(o, settings), nil
+	case *appsv1.Deployment:
+		return FormatDeploymentData(o, settings), nil
+	case *appsv1.StatefulSet:
+		return FormatStatefulsetData(o, settings), nil
+	case *appsv1.DaemonSet:
+		return FormatDaemonSetData(o, settings), nil
+	case *batchv1.Job:
+		return FormatJobData(o, settings), nil
+	case *batchv1.CronJob:
+		return FormatCronJobData(o, settings), nil
+	case *corev1.PersistentVolumeClaim:
+		return FormatPersistentVolumeClaimData(o, settings), nil
+	case *corev1.PersistentVolume:
+		return FormatPersistentVolumeData(o, settings), nil
+	case *corev1.Service:
+		return FormatServiceData(o, settings), nil
+	case *networkingv1.Ingress:
+		return FormatIngressData(o, settings), nil
+	case *corev1.ResourceQuota:
+		return FormatResourceQuotaData(o, settings), nil
+	case *appsv1.ReplicaSet:
+		return FormatReplicaSetData(o, settings), nil
+	case *unstructured.Unstructured:
+		gvk := o.GroupVersionKind()
+		resource, ok := settings.FindCustomResource(gvk.Group, gvk.Kind)
+		if !ok {
+			return types.ResourceTags{}, fmt.Errorf("unsupported custom resource: %s", gvk)
+		}
+		return FormatCustomResourceData(o, resource, settings), nil
+	default:
+		return types.ResourceTags{}, fmt.Errorf("unsupported resource type: %s", reflect.TypeOf(obj))
+	}
+}
+
+// loadBalancerAddresses joins the hostnames or IPs of a load balancer, which
+// identify the load balancer of the cloud provider.
+func loadBalancerAddresses(ingresses []corev1.LoadBalancerIngress) string {
+	addresses := make([]string, 0, len(ingresses))
+	for _, ingress := range ingresses {
+		if ingress.Hostname != "" {
+			addresses = append(addresses, ingress.Hostname)
+		} else if ingress.IP != "" {
+			addresses = append(addresses, ingress.IP)
+		}
+	}
+	return strings.Join(addresses, ",")
+}
@@ -1,6 +1,127 @@
 // SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
+//nolint:dupl // There is currently substantial duplication in the handlers :(
 package handler
 
-type IngressHandler struct{} // &networkingv1.Ingress{}
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+type IngressHandler struct {
+	hook.Handler
+	settings *config.Settings
+	clock    types.TimeProvider
+}
+
+// NewIngressHandler creates a new instance of ingress validation hook
+func NewIngressHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+	h := &IngressHandler{settings: settings}
+	h.Handler.Create = h.Create()
+	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
+	h.Handler.Store = store
+	h.Handler.ErrorChan = errChan
+	h.clock = clock
+	return h.Handler
+}
+
+func (h *IngressHandler) Create() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Ingresses || h.settings.Filters.Annotations.Resources.Ingresses {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *IngressHandler) Update() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Ingresses || h.settings.Filters.Annotations.Resources.Ingresses {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *IngressHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Ingresses || h.settings.Filters.Annotations.Resources.Ingresses {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *IngressHandler) parseV1(data []byte) (*networkingv1.Ingress, error) {
+	var o networkingv1.Ingress
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (h *IngressHandler) writeDataToStorage(ctx context.Context, o *networkingv1.Ingress) {
+	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatIngressData(o, h.settings))
+}
+
+func (h *IngressHandler) writeDeletionToStorage(ctx context.Context, o *networkingv1.Ingress) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatIngressData(o, h.settings))
+}
+
+// FormatIngressData formats an ingress, including its class and the address of
+// the load balancer of the cloud provider.
+func FormatIngressData(o *networkingv1.Ingress, settings *config.Settings) types.ResourceTags {
+	var (
+		labels      = config.MetricLabelTags{}
+		annotations = config.MetricLabelTags{}
+		namespace   = o.GetNamespace()
+		name        = o.GetName()
+	)
+	if settings.Filters.Labels.Resources.Ingresses {
+		labels = config.Filter(o.GetLabels(), settings.LabelMatches, (settings.Filters.Labels.Enabled && settings.Filters.Labels.Resources.Ingresses), settings)
+	}
+	if settings.Filters.Annotations.Resources.Ingresses {
+		annotations = config.Filter(o.GetAnnotations(), settings.AnnotationMatches, (settings.Filters.Annotations.Enabled && settings.Filters.Annotations.Resources.Ingresses), settings)
+	}
+	metricLabels := config.MetricLabels{
+		"ingress":       name, // standard metric labels to attach to metric
+		"namespace":     namespace,
+		"resource_type": config.ResourceTypeToMetricName[config.Ingress],
+	}
+	if class := o.Spec.IngressClassName; class != nil {
+		metricLabels["ingress_class"] = *class
+	}
+	ingresses := make([]corev1.LoadBalancerIngress, 0, len(o.Status.LoadBalancer.Ingress))
+	for _, ingress := range o.Status.LoadBalancer.Ingress {
+		ingresses = append(ingresses, corev1.LoadBalancerIngress{IP: ingress.IP, Hostname: ingress.Hostname})
+	}
+	if address := loadBalancerAddresses(ingresses); address != "" {
+		metricLabels["load_balancer_ingress"] = address
+	}
+	return types.ResourceTags{
+		Type:         config.Ingress,
+		Name:         name,
+		Namespace:    &namespace,
+		MetricLabels: &metricLabels,
+		Labels:       &labels,
+		Annotations:  &annotations,
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func makeIngressRequest(record TestRecord, o *networkingv1.Ingress) *hook.Request {
+	o.ObjectMeta = metav1.ObjectMeta{
+		Name:        record.Name,
+		Namespace:   *record.Namespace,
+		Labels:      record.Labels,
+		Annotations: record.Annotations,
+	}
+
+	scheme := runtime.NewScheme()
+	networkingv1.AddToScheme(scheme)
+	codecs := serializer.NewCodecFactory(scheme)
+	encoder := codecs.LegacyCodec(networkingv1.SchemeGroupVersion)
+	raw, _ := runtime.Encode(encoder, o)
+
+	return &hook.Request{
+		Object: runtime.RawExtension{
+			Raw: raw,
+		},
+		OldObject: runtime.RawExtension{
+			Raw: raw,
+		},
+	}
+}
+
+func TestIngressHandler_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "Test create with labels and annotations enabled", enabled: true},
+		{name: "Test create with labels and annotations disabled", enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtl := gomock.NewController(t)
+			defer mockCtl.Finish()
+			writer := mocks.NewMockResourceStore(mockCtl)
+
+			settings := &config.Settings{
+				Filters: config.Filters{
+					Labels:      config.Labels{Enabled: tt.enabled, Resources: config.Resources{Ingresses: tt.enabled}},
+					Annotations: config.Annotations{Enabled: tt.enabled, Resources: config.Resources{Ingresses: tt.enabled}},
+				},
+			}
+			if tt.enabled {
+				writer.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, nil)
+				writer.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+				writer.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			handler := NewIngressHandler(writer, settings, mocks.NewMockClock(time.Now()), make(chan error))
+			result, err := handler.Create(context.Background(), makeIngressRequest(TestRecord{
+				Name:      "test-ingress",
+				Namespace: stringPtr("default"),
+				Labels:    map[string]string{"app": "test"},
+			}, &networkingv1.Ingress{}))
+			assert.NoError(t, err)
+			assert.Equal(t, &hook.Result{Allowed: true}, result)
+		})
+	}
+}
+
+func TestFormatIngressData(t *testing.T) {
+	settings := NewTestSettings()
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       networkingv1.IngressSpec{IngressClassName: stringPtr("alb")},
+		Status: networkingv1.IngressStatus{LoadBalancer: networkingv1.IngressLoadBalancerStatus{
+			Ingress: []networkingv1.IngressLoadBalancerIngress{{Hostname: "web.elb.amazonaws.com"}},
+		}},
+	}
+
+	record := FormatIngressData(ingress, settings)
+	assert.Equal(t, config.Ingress, record.Type)
+	assert.Equal(t, config.MetricLabels{
+		"ingress":               "web",
+		"namespace":             "default",
+		"resource_type":         "ingress",
+		"ingress_class":         "alb",
+		"load_balancer_ingress": "web.elb.amazonaws.com",
+	}, *record.MetricLabels)
+}
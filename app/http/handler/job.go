@@ -25,6 +25,7 @@ func NewJobHandler(store types.ResourceStore, settings *config.Settings, clock t
 	h := &JobHandler{settings: settings}
 	h.Handler.Create = h.Create()
 	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
 	h.Handler.Store = store
 	h.Handler.ErrorChan = errChan
 	h.clock = clock
@@ -55,6 +56,18 @@ func (h *JobHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *JobHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Jobs || h.settings.Filters.Annotations.Resources.Jobs {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *JobHandler) parseV1(data []byte) (*batchv1.Job, error) {
 	var o batchv1.Job
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -67,6 +80,10 @@ func (h *JobHandler) writeDataToStorage(ctx context.Context, o *batchv1.Job) {
 	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatJobData(o, h.settings))
 }
 
+func (h *JobHandler) writeDeletionToStorage(ctx context.Context, o *batchv1.Job) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatJobData(o, h.settings))
+}
+
 func FormatJobData(o *batchv1.Job, settings *config.Settings) types.ResourceTags {
 	var (
 		labels      = config.MetricLabelTags{}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+)
+
+// MutationRoute is the route of the mutating webhook.
+const MutationRoute = "/mutate"
+
+// Actions taken on a resource which breaks a label rule.
+const (
+	actionAudited   = "audited"
+	actionDefaulted = "defaulted"
+	actionWarned    = "warned"
+	actionDenied    = "denied"
+)
+
+var (
+	mutationStatsOnce sync.Once
+	// LabelRuleViolations counts the admitted resources which broke a label
+	// rule, by the action which was taken.
+	LabelRuleViolations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "label_rule_violations_total",
+			Help: "Total number of admitted resources missing a label required by a label rule, by the action taken.",
+		},
+		[]string{"rule", "mode", "kind", "action"},
+	)
+)
+
+// NamespaceLabeler looks up the labels of a namespace.
+type NamespaceLabeler interface {
+	NamespaceLabels(ctx context.Context, namespace string) map[string]string
+}
+
+// MutationHandler applies the label rules to the admitted resources. A missing
+// label is added with a JSONPatch when a default value is known, and otherwise
+// the resource is admitted with a warning or denied, depending on the mode of
+// the rule.
+type MutationHandler struct {
+	hook.Handler
+	settings   *config.Settings
+	namespaces NamespaceLabeler
+}
+
+// NewMutationHandler creates a new instance of the mutating hook. The
+// namespace labeler can be nil, in which case only the static defaults are
+// used.
+func NewMutationHandler(settings *config.Settings, namespaces NamespaceLabeler) hook.Handler {
+	mutationStatsOnce.Do(func() {
+		prometheus.MustRegister(LabelRuleViolations)
+	})
+	h := &MutationHandler{settings: settings, namespaces: namespaces}
+	h.Handler.Create = h.Mutate()
+	h.Handler.Update = h.Mutate()
+	h.Handler.Delete = allow
+	h.Handler.Connect = allow
+	return h.Handler
+}
+
+func allow(context.Context, *hook.Request) (*hook.Result, error) {
+	return &hook.Result{Allowed: true}, nil
+}
+
+func (h *MutationHandler) Mutate() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		o := &metav1.PartialObjectMetadata{}
+		if err := json.Unmarshal(r.Object.Raw, o); err != nil {
+			// never block an admission on an object which can not be read
+			log.Ctx(ctx).Warn().Err(err).Str("kind", r.Kind.Kind).Msg("failed to parse the object to mutate")
+			return &hook.Result{Allowed: true}, nil
+		}
+
+		kind := h.kindName(r)
+		namespace := r.Namespace
+		if kind == config.ResourceTypeToMetricName[config.Namespace] {
+			// namespaces are in the scope of the rules of their own name
+			namespace = o.GetName()
+		}
+
+		var (
+			labels   = o.GetLabels()
+			added    = map[string]string{}
+			warnings []string
+			missing  []string
+		)
+		for _, rule := range h.settings.Mutation.Rules {
+			if !rule.Applies(kind, namespace) {
+				continue
+			}
+			if _, ok := labels[rule.Label]; ok {
+				continue
+			}
+			if _, ok := added[rule.Label]; ok {
+				continue
+			}
+
+			mode := rule.EffectiveMode()
+			value := h.defaultValue(ctx, rule, namespace)
+			var action string
+			switch {
+			case mode == config.RuleModeAudit:
+				action = actionAudited
+			case value != "":
+				action = actionDefaulted
+				added[rule.Label] = value
+				if mode == config.RuleModeWarn {
+					warnings = append(warnings, fmt.Sprintf("the label '%s' required by rule '%s' was set to '%s'", rule.Label, rule.RuleName(), value))
+				}
+			case mode == config.RuleModeWarn:
+				action = actionWarned
+				warnings = append(warnings, fmt.Sprintf("the label '%s' is required by rule '%s'", rule.Label, rule.RuleName()))
+			default:
+				action = actionDenied
+				missing = append(missing, rule.Label)
+			}
+
+			LabelRuleViolations.WithLabelValues(rule.RuleName(), string(mode), kind, action).Inc()
+			log.Ctx(ctx).Info().
+				Str("rule", rule.RuleName()).
+				Str("mode", string(mode)).
+				Str("action", action).
+				Str("kind", kind).
+				Str("namespace", namespace).
+				Str("name", o.GetName()).
+				Msg("Resource is missing a required label")
+		}
+
+		if len(missing) > 0 {
+			return &hook.Result{
+				Allowed: false,
+				Msg:     fmt.Sprintf("missing the required labels: %s", strings.Join(missing, ", ")),
+			}, nil
+		}
+		patch, err := labelPatch(labels, added)
+		if err != nil {
+			return nil, err
+		}
+		return &hook.Result{Allowed: true, Patch: patch, Warnings: warnings}, nil
+	}
+}
+
+// kindName returns the metric name of the kind of a request, which the rules
+// are scoped with.
+func (h *MutationHandler) kindName(r *hook.Request) string {
+	if c, ok := h.settings.FindCustomResource(r.Kind.Group, r.Kind.Kind); ok {
+		return c.MetricName()
+	}
+	return strings.ToLower(r.Kind.Kind)
+}
+
+// defaultValue returns the value of a missing label, from the labels of the
+// namespace or else the default of the rule.
+func (h *MutationHandler) defaultValue(ctx context.Context, rule config.LabelRule, namespace string) string {
+	if rule.NamespaceLabel != "" && namespace != "" && h.namespaces != nil {
+		if value := h.namespaces.NamespaceLabels(ctx, namespace)[rule.NamespaceLabel]; value != "" {
+			return value
+		}
+	}
+	return rule.Default
+}
+
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// labelPatch returns a JSONPatch adding labels to an object, or nothing when
+// no label is added.
+func labelPatch(existing, added map[string]string) ([]byte, error) {
+	if len(added) == 0 {
+		return nil, nil
+	}
+	if existing == nil {
+		return json.Marshal([]patchOperation{{Op: "add", Path: "/metadata/labels", Value: added}})
+	}
+
+	keys := make([]string, 0, len(added))
+	for key := range added {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	ops := make([]patchOperation, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, patchOperation{Op: "add", Path: "/metadata/labels/" + escapeJSONPointer(key), Value: added[key]})
+	}
+	return json.Marshal(ops)
+}
+
+// escapeJSONPointer escapes a label key for a JSON pointer, e.g.
+// `example.com/team` is `example.com~1team`.
+func escapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+)
+
+type staticNamespaceLabeler map[string]map[string]string
+
+func (s staticNamespaceLabeler) NamespaceLabels(_ context.Context, namespace string) map[string]string {
+	return s[namespace]
+}
+
+func makeMutationRequest(kind, namespace string, labels map[string]string) *hook.Request {
+	o := makeUnstructured("apps/v1", kind, "web", namespace, labels)
+	r := makeCustomResourceRequest(o)
+	r.Kind = metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: kind}
+	r.Namespace = namespace
+	return r
+}
+
+func TestMutationHandler_Mutate(t *testing.T) {
+	namespaces := staticNamespaceLabeler{
+		"team-a": {"cost-center": "cc-1"},
+		"team-b": {},
+	}
+	rule := func(mode config.RuleMode) config.LabelRule {
+		return config.LabelRule{
+			Name:             "cost-center",
+			Label:            "cost-center",
+			Mode:             mode,
+			NamespaceLabel:   "cost-center",
+			Kinds:            []string{"deployment"},
+			ExemptNamespaces: []string{"kube-system"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		rules     []config.LabelRule
+		kind      string
+		namespace string
+		labels    map[string]string
+		allowed   bool
+		patch     string
+		warnings  int
+	}{
+		{
+			name:      "default from the namespace",
+			rules:     []config.LabelRule{rule(config.RuleModeEnforce)},
+			kind:      "Deployment",
+			namespace: "team-a",
+			labels:    map[string]string{"app": "web"},
+			allowed:   true,
+			patch:     `[{"op":"add","path":"/metadata/labels/cost-center","value":"cc-1"}]`,
+		},
+		{
+			name:      "default on an object without labels",
+			rules:     []config.LabelRule{rule(config.RuleModeEnforce)},
+			kind:      "Deployment",
+			namespace: "team-a",
+			allowed:   true,
+			patch:     `[{"op":"add","path":"/metadata/labels","value":{"cost-center":"cc-1"}}]`,
+		},
+		{
+			name:      "static default with an escaped key",
+			rules:     []config.LabelRule{{Label: "example.com/team", Default: "platform", Mode: config.RuleModeWarn}},
+			kind:      "Deployment",
+			namespace: "team-b",
+			labels:    map[string]string{"app": "web"},
+			allowed:   true,
+			patch:     `[{"op":"add","path":"/metadata/labels/example.com~1team","value":"platform"}]`,
+			warnings:  1,
+		},
+		{
+			name:      "enforce without a default denies",
+			rules:     []config.LabelRule{rule(config.RuleModeEnforce)},
+			kind:      "Deployment",
+			namespace: "team-b",
+			allowed:   false,
+		},
+		{
+			name:      "warn without a default warns",
+			rules:     []config.LabelRule{rule(config.RuleModeWarn)},
+			kind:      "Deployment",
+			namespace: "team-b",
+			allowed:   true,
+			warnings:  1,
+		},
+		{
+			name:      "audit does not change the object",
+			rules:     []config.LabelRule{rule(config.RuleModeAudit)},
+			kind:      "Deployment",
+			namespace: "team-a",
+			allowed:   true,
+		},
+		{
+			name:      "label already set",
+			rules:     []config.LabelRule{rule(config.RuleModeEnforce)},
+			kind:      "Deployment",
+			namespace: "team-b",
+			labels:    map[string]string{"cost-center": "cc-2"},
+			allowed:   true,
+		},
+		{
+			name:      "exempt namespace",
+			rules:     []config.LabelRule{rule(config.RuleModeEnforce)},
+			kind:      "Deployment",
+			namespace: "kube-system",
+			allowed:   true,
+		},
+		{
+			name:      "kind out of scope",
+			rules:     []config.LabelRule{rule(config.RuleModeEnforce)},
+			kind:      "StatefulSet",
+			namespace: "team-b",
+			allowed:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &config.Settings{Mutation: config.Mutation{Enabled: true, Rules: tt.rules}}
+			h := NewMutationHandler(settings, namespaces)
+
+			result, err := h.Create(context.Background(), makeMutationRequest(tt.kind, tt.namespace, tt.labels))
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, result.Allowed)
+			if tt.patch == "" {
+				assert.Empty(t, result.Patch)
+			} else {
+				assert.JSONEq(t, tt.patch, string(result.Patch))
+			}
+			assert.Len(t, result.Warnings, tt.warnings)
+		})
+	}
+}
@@ -25,6 +25,7 @@ func NewNamespaceHandler(store types.ResourceStore, settings *config.Settings, c
 	h := &NamespaceHandler{settings: settings}
 	h.Handler.Create = h.Create()
 	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
 	h.Handler.Store = store
 	h.Handler.ErrorChan = errChan
 	h.clock = clock
@@ -55,6 +56,18 @@ func (h *NamespaceHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *NamespaceHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Namespaces || h.settings.Filters.Annotations.Resources.Namespaces {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *NamespaceHandler) parseV1(data []byte) (*corev1.Namespace, error) {
 	var o corev1.Namespace
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -67,6 +80,10 @@ func (h *NamespaceHandler) writeDataToStorage(ctx context.Context, o *corev1.Nam
 	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatNamespaceData(o, h.settings))
 }
 
+func (h *NamespaceHandler) writeDeletionToStorage(ctx context.Context, o *corev1.Namespace) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatNamespaceData(o, h.settings))
+}
+
 func FormatNamespaceData(h *corev1.Namespace, settings *config.Settings) types.ResourceTags {
 	var (
 		labels      = config.MetricLabelTags{}
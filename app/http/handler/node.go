@@ -25,6 +25,7 @@ func NewNodeHandler(store types.ResourceStore, settings *config.Settings, clock
 	h := &NodeHandler{settings: settings}
 	h.Handler.Create = h.Create()
 	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
 	h.Handler.Store = store
 	h.Handler.ErrorChan = errChan
 	h.clock = clock
@@ -55,6 +56,18 @@ func (h *NodeHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *NodeHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Nodes || h.settings.Filters.Annotations.Resources.Nodes {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *NodeHandler) parseV1(data []byte) (*corev1.Node, error) {
 	var o corev1.Node
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -67,6 +80,10 @@ func (h *NodeHandler) writeDataToStorage(ctx context.Context, o *corev1.Node) {
 	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatNodeData(o, h.settings))
 }
 
+func (h *NodeHandler) writeDeletionToStorage(ctx context.Context, o *corev1.Node) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatNodeData(o, h.settings))
+}
+
 func FormatNodeData(o *corev1.Node, settings *config.Settings) types.ResourceTags {
 	var (
 		labels      = config.MetricLabelTags{}
@@ -85,7 +102,7 @@ func FormatNodeData(o *corev1.Node, settings *config.Settings) types.ResourceTag
 		"node":          workload, // standard metric labels to attach to metric
 		"resource_type": config.ResourceTypeToMetricName[config.Node],
 	}
-	return types.ResourceTags{
+	record := types.ResourceTags{
 		Name:         workload,
 		Namespace:    nil,
 		Type:         config.Node,
@@ -93,4 +110,8 @@ func FormatNodeData(o *corev1.Node, settings *config.Settings) types.ResourceTag
 		Labels:       &labels,
 		Annotations:  &annotations,
 	}
+	if settings.Specs.Enabled {
+		record.Spec = nodeSpec(o)
+	}
+	return record
 }
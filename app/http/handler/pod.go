@@ -11,6 +11,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/domain/workload"
 	"github.com/cloudzero/cloudzero-agent/app/http/hook"
 	"github.com/cloudzero/cloudzero-agent/app/types"
 )
@@ -19,12 +20,26 @@ type PodHandler struct {
 	hook.Handler
 	settings *config.Settings
 	clock    types.TimeProvider
+	resolver *workload.Resolver
 }
 
-func NewPodHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+type PodHandlerOption func(h *PodHandler)
+
+// WithWorkloadResolver attaches the workload owning each pod to its record.
+func WithWorkloadResolver(resolver *workload.Resolver) PodHandlerOption {
+	return func(h *PodHandler) {
+		h.resolver = resolver
+	}
+}
+
+func NewPodHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error, opts ...PodHandlerOption) hook.Handler {
 	h := &PodHandler{settings: settings}
+	for _, opt := range opts {
+		opt(h)
+	}
 	h.Handler.Create = h.Create()
 	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
 	h.Handler.Store = store
 	h.Handler.ErrorChan = errChan
 	h.clock = clock
@@ -55,6 +70,18 @@ func (h *PodHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *PodHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Pods || h.settings.Filters.Annotations.Resources.Pods {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *PodHandler) parseV1(data []byte) (*corev1.Pod, error) {
 	var o corev1.Pod
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -64,7 +91,15 @@ func (h *PodHandler) parseV1(data []byte) (*corev1.Pod, error) {
 }
 
 func (h *PodHandler) writeDataToStorage(ctx context.Context, o *corev1.Pod) {
-	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatPodData(o, h.settings))
+	record := FormatPodData(o, h.settings)
+	h.resolver.Enrich(ctx, o, &record)
+	genericWriteDataToStorage(ctx, h.Store, h.clock, record)
+}
+
+func (h *PodHandler) writeDeletionToStorage(ctx context.Context, o *corev1.Pod) {
+	record := FormatPodData(o, h.settings)
+	h.resolver.Enrich(ctx, o, &record)
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, record)
 }
 
 func FormatPodData(o *corev1.Pod, settings *config.Settings) types.ResourceTags {
@@ -85,7 +120,7 @@ func FormatPodData(o *corev1.Pod, settings *config.Settings) types.ResourceTags
 		"namespace":     namespace,
 		"resource_type": config.ResourceTypeToMetricName[config.Pod],
 	}
-	return types.ResourceTags{
+	record := types.ResourceTags{
 		Type:         config.Pod,
 		Name:         podName,
 		Namespace:    &namespace,
@@ -93,4 +128,8 @@ func FormatPodData(o *corev1.Pod, settings *config.Settings) types.ResourceTags
 		Labels:       &labels,
 		Annotations:  &annotations,
 	}
+	if settings.Specs.Enabled {
+		record.Spec = podSpec(o)
+	}
+	return record
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -428,6 +429,55 @@ func TestPodHandler_Update(t *testing.T) {
 	}
 }
 
+func TestPodHandler_Delete(t *testing.T) {
+	deletedAt := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	settings := &config.Settings{
+		Filters: config.Filters{
+			Labels: config.Labels{
+				Enabled: true,
+				Resources: config.Resources{
+					Pods: true,
+				},
+			},
+		},
+	}
+
+	// the deleted object is sent as the old object
+	request := makePodRequest(TestRecord{
+		Name:      "test-pod",
+		Namespace: stringPtr("default"),
+		Labels: map[string]string{
+			"app": "test",
+		},
+	})
+	request.OldObject, request.Object = request.Object, runtime.RawExtension{}
+
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+	writer := mocks.NewMockResourceStore(mockCtl)
+	writer.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, nil)
+	writer.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+	var created *types.ResourceTags
+	writer.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, r *types.ResourceTags) error {
+		created = r
+		return nil
+	})
+
+	handler := NewPodHandler(writer, settings, mocks.NewMockClock(deletedAt), make(chan error))
+	result, err := handler.Execute(context.Background(), &hook.Request{
+		Operation: "DELETE",
+		OldObject: request.OldObject,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &hook.Result{Allowed: true}, result)
+
+	// the deletion time was recorded
+	require.NotNil(t, created)
+	assert.Equal(t, "test-pod", created.Name)
+	require.NotNil(t, created.DeletedAt)
+	assert.Equal(t, deletedAt, *created.DeletedAt)
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
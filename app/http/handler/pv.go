@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//nolint:dupl // There is currently substantial duplication in the handlers :(
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+type PersistentVolumeHandler struct {
+	hook.Handler
+	settings *config.Settings
+	clock    types.TimeProvider
+}
+
+// NewPersistentVolumeHandler creates a new instance of persistent volume validation hook
+func NewPersistentVolumeHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+	h := &PersistentVolumeHandler{settings: settings}
+	h.Handler.Create = h.Create()
+	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
+	h.Handler.Store = store
+	h.Handler.ErrorChan = errChan
+	h.clock = clock
+	return h.Handler
+}
+
+func (h *PersistentVolumeHandler) Create() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.PersistentVolumes || h.settings.Filters.Annotations.Resources.PersistentVolumes {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *PersistentVolumeHandler) Update() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.PersistentVolumes || h.settings.Filters.Annotations.Resources.PersistentVolumes {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *PersistentVolumeHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.PersistentVolumes || h.settings.Filters.Annotations.Resources.PersistentVolumes {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *PersistentVolumeHandler) parseV1(data []byte) (*corev1.PersistentVolume, error) {
+	var o corev1.PersistentVolume
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (h *PersistentVolumeHandler) writeDataToStorage(ctx context.Context, o *corev1.PersistentVolume) {
+	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatPersistentVolumeData(o, h.settings))
+}
+
+func (h *PersistentVolumeHandler) writeDeletionToStorage(ctx context.Context, o *corev1.PersistentVolume) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatPersistentVolumeData(o, h.settings))
+}
+
+// FormatPersistentVolumeData formats a persistent volume, including its storage
+// class, capacity in bytes, claim, and the CSI volume handle which identifies
+// the disk of the cloud provider.
+func FormatPersistentVolumeData(o *corev1.PersistentVolume, settings *config.Settings) types.ResourceTags {
+	var (
+		labels      = config.MetricLabelTags{}
+		annotations = config.MetricLabelTags{}
+		name        = o.GetName()
+	)
+	if settings.Filters.Labels.Resources.PersistentVolumes {
+		labels = config.Filter(o.GetLabels(), settings.LabelMatches, (settings.Filters.Labels.Enabled && settings.Filters.Labels.Resources.PersistentVolumes), settings)
+	}
+	if settings.Filters.Annotations.Resources.PersistentVolumes {
+		annotations = config.Filter(o.GetAnnotations(), settings.AnnotationMatches, (settings.Filters.Annotations.Enabled && settings.Filters.Annotations.Resources.PersistentVolumes), settings)
+	}
+	metricLabels := config.MetricLabels{
+		"persistentvolume": name, // standard metric labels to attach to metric
+		"resource_type":    config.ResourceTypeToMetricName[config.PersistentVolume],
+	}
+	if o.Spec.StorageClassName != "" {
+		metricLabels["storage_class"] = o.Spec.StorageClassName
+	}
+	if storage, ok := o.Spec.Capacity[corev1.ResourceStorage]; ok {
+		metricLabels["capacity_bytes"] = strconv.FormatInt(storage.Value(), 10)
+	}
+	if o.Spec.PersistentVolumeReclaimPolicy != "" {
+		metricLabels["reclaim_policy"] = string(o.Spec.PersistentVolumeReclaimPolicy)
+	}
+	if claim := o.Spec.ClaimRef; claim != nil {
+		metricLabels["claim_namespace"] = claim.Namespace
+		metricLabels["claim_name"] = claim.Name
+	}
+	if csi := o.Spec.CSI; csi != nil {
+		metricLabels["csi_driver"] = csi.Driver
+		metricLabels["volume_handle"] = csi.VolumeHandle
+	}
+	return types.ResourceTags{
+		Type:         config.PersistentVolume,
+		Name:         name,
+		Namespace:    nil,
+		MetricLabels: &metricLabels,
+		Labels:       &labels,
+		Annotations:  &annotations,
+	}
+}
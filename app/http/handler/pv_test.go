@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func makePersistentVolumeRequest(record TestRecord, o *corev1.PersistentVolume) *hook.Request {
+	o.ObjectMeta = metav1.ObjectMeta{
+		Name:        record.Name,
+		Labels:      record.Labels,
+		Annotations: record.Annotations,
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	codecs := serializer.NewCodecFactory(scheme)
+	encoder := codecs.LegacyCodec(corev1.SchemeGroupVersion)
+	raw, _ := runtime.Encode(encoder, o)
+
+	return &hook.Request{
+		Object: runtime.RawExtension{
+			Raw: raw,
+		},
+		OldObject: runtime.RawExtension{
+			Raw: raw,
+		},
+	}
+}
+
+func TestPersistentVolumeHandler_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "Test create with labels and annotations enabled", enabled: true},
+		{name: "Test create with labels and annotations disabled", enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtl := gomock.NewController(t)
+			defer mockCtl.Finish()
+			writer := mocks.NewMockResourceStore(mockCtl)
+
+			settings := &config.Settings{
+				Filters: config.Filters{
+					Labels:      config.Labels{Enabled: tt.enabled, Resources: config.Resources{PersistentVolumes: tt.enabled}},
+					Annotations: config.Annotations{Enabled: tt.enabled, Resources: config.Resources{PersistentVolumes: tt.enabled}},
+				},
+			}
+			if tt.enabled {
+				writer.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, nil)
+				writer.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+				writer.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			handler := NewPersistentVolumeHandler(writer, settings, mocks.NewMockClock(time.Now()), make(chan error))
+			result, err := handler.Create(context.Background(), makePersistentVolumeRequest(TestRecord{
+				Name:   "test-persistentvolume",
+				Labels: map[string]string{"app": "test"},
+			}, &corev1.PersistentVolume{}))
+			assert.NoError(t, err)
+			assert.Equal(t, &hook.Result{Allowed: true}, result)
+		})
+	}
+}
+
+func TestFormatPersistentVolumeData(t *testing.T) {
+	settings := NewTestSettings()
+	settings.Filters.Labels.Resources.PersistentVolumes = true
+	volume := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1234", Labels: map[string]string{"topology.kubernetes.io/zone": "us-east-1a"}},
+		Spec: corev1.PersistentVolumeSpec{
+			StorageClassName:              "gp3",
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			ClaimRef:                      &corev1.ObjectReference{Namespace: "default", Name: "data"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com", VolumeHandle: "vol-0abc"},
+			},
+		},
+	}
+
+	record := FormatPersistentVolumeData(volume, settings)
+	assert.Equal(t, config.PersistentVolume, record.Type)
+	assert.Nil(t, record.Namespace)
+	assert.Equal(t, config.MetricLabels{
+		"persistentvolume": "pvc-1234",
+		"resource_type":    "persistentvolume",
+		"storage_class":    "gp3",
+		"capacity_bytes":   "1073741824",
+		"reclaim_policy":   "Delete",
+		"claim_namespace":  "default",
+		"claim_name":       "data",
+		"csi_driver":       "ebs.csi.aws.com",
+		"volume_handle":    "vol-0abc",
+	}, *record.MetricLabels)
+}
@@ -1,6 +1,130 @@
 // SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
+//nolint:dupl // There is currently substantial duplication in the handlers :(
 package handler
 
-type PersistentVolumeClaimHandler struct{} // &corev1.PersistentVolumeClaim{}
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+type PersistentVolumeClaimHandler struct {
+	hook.Handler
+	settings *config.Settings
+	clock    types.TimeProvider
+}
+
+// NewPersistentVolumeClaimHandler creates a new instance of persistent volume claim validation hook
+func NewPersistentVolumeClaimHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+	h := &PersistentVolumeClaimHandler{settings: settings}
+	h.Handler.Create = h.Create()
+	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
+	h.Handler.Store = store
+	h.Handler.ErrorChan = errChan
+	h.clock = clock
+	return h.Handler
+}
+
+func (h *PersistentVolumeClaimHandler) Create() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.PersistentVolumeClaims || h.settings.Filters.Annotations.Resources.PersistentVolumeClaims {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *PersistentVolumeClaimHandler) Update() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.PersistentVolumeClaims || h.settings.Filters.Annotations.Resources.PersistentVolumeClaims {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *PersistentVolumeClaimHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.PersistentVolumeClaims || h.settings.Filters.Annotations.Resources.PersistentVolumeClaims {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *PersistentVolumeClaimHandler) parseV1(data []byte) (*corev1.PersistentVolumeClaim, error) {
+	var o corev1.PersistentVolumeClaim
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (h *PersistentVolumeClaimHandler) writeDataToStorage(ctx context.Context, o *corev1.PersistentVolumeClaim) {
+	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatPersistentVolumeClaimData(o, h.settings))
+}
+
+func (h *PersistentVolumeClaimHandler) writeDeletionToStorage(ctx context.Context, o *corev1.PersistentVolumeClaim) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatPersistentVolumeClaimData(o, h.settings))
+}
+
+// FormatPersistentVolumeClaimData formats a persistent volume claim, including
+// the storage class, the bound volume, and the requested and provisioned
+// storage in bytes.
+func FormatPersistentVolumeClaimData(o *corev1.PersistentVolumeClaim, settings *config.Settings) types.ResourceTags {
+	var (
+		labels      = config.MetricLabelTags{}
+		annotations = config.MetricLabelTags{}
+		namespace   = o.GetNamespace()
+		name        = o.GetName()
+	)
+	if settings.Filters.Labels.Resources.PersistentVolumeClaims {
+		labels = config.Filter(o.GetLabels(), settings.LabelMatches, (settings.Filters.Labels.Enabled && settings.Filters.Labels.Resources.PersistentVolumeClaims), settings)
+	}
+	if settings.Filters.Annotations.Resources.PersistentVolumeClaims {
+		annotations = config.Filter(o.GetAnnotations(), settings.AnnotationMatches, (settings.Filters.Annotations.Enabled && settings.Filters.Annotations.Resources.PersistentVolumeClaims), settings)
+	}
+	metricLabels := config.MetricLabels{
+		"persistentvolumeclaim": name, // standard metric labels to attach to metric
+		"namespace":             namespace,
+		"resource_type":         config.ResourceTypeToMetricName[config.PersistentVolumeClaim],
+	}
+	if class := o.Spec.StorageClassName; class != nil {
+		metricLabels["storage_class"] = *class
+	}
+	if o.Spec.VolumeName != "" {
+		metricLabels["volume_name"] = o.Spec.VolumeName
+	}
+	if storage, ok := o.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		metricLabels["requested_storage_bytes"] = strconv.FormatInt(storage.Value(), 10)
+	}
+	if storage, ok := o.Status.Capacity[corev1.ResourceStorage]; ok {
+		metricLabels["capacity_bytes"] = strconv.FormatInt(storage.Value(), 10)
+	}
+	return types.ResourceTags{
+		Type:         config.PersistentVolumeClaim,
+		Name:         name,
+		Namespace:    &namespace,
+		MetricLabels: &metricLabels,
+		Labels:       &labels,
+		Annotations:  &annotations,
+	}
+}
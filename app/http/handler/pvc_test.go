@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func makePersistentVolumeClaimRequest(record TestRecord, o *corev1.PersistentVolumeClaim) *hook.Request {
+	o.ObjectMeta = metav1.ObjectMeta{
+		Name:        record.Name,
+		Namespace:   *record.Namespace,
+		Labels:      record.Labels,
+		Annotations: record.Annotations,
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	codecs := serializer.NewCodecFactory(scheme)
+	encoder := codecs.LegacyCodec(corev1.SchemeGroupVersion)
+	raw, _ := runtime.Encode(encoder, o)
+
+	return &hook.Request{
+		Object: runtime.RawExtension{
+			Raw: raw,
+		},
+		OldObject: runtime.RawExtension{
+			Raw: raw,
+		},
+	}
+}
+
+func TestPersistentVolumeClaimHandler_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "Test create with labels and annotations enabled", enabled: true},
+		{name: "Test create with labels and annotations disabled", enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtl := gomock.NewController(t)
+			defer mockCtl.Finish()
+			writer := mocks.NewMockResourceStore(mockCtl)
+
+			settings := &config.Settings{
+				Filters: config.Filters{
+					Labels:      config.Labels{Enabled: tt.enabled, Resources: config.Resources{PersistentVolumeClaims: tt.enabled}},
+					Annotations: config.Annotations{Enabled: tt.enabled, Resources: config.Resources{PersistentVolumeClaims: tt.enabled}},
+				},
+			}
+			if tt.enabled {
+				writer.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, nil)
+				writer.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+				writer.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			handler := NewPersistentVolumeClaimHandler(writer, settings, mocks.NewMockClock(time.Now()), make(chan error))
+			result, err := handler.Create(context.Background(), makePersistentVolumeClaimRequest(TestRecord{
+				Name:      "test-persistentvolumeclaim",
+				Namespace: stringPtr("default"),
+				Labels:    map[string]string{"app": "test"},
+			}, &corev1.PersistentVolumeClaim{}))
+			assert.NoError(t, err)
+			assert.Equal(t, &hook.Result{Allowed: true}, result)
+		})
+	}
+}
+
+func TestFormatPersistentVolumeClaimData(t *testing.T) {
+	settings := NewTestSettings()
+	settings.Filters.Labels.Resources.PersistentVolumeClaims = true
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default", Labels: map[string]string{"app": "db"}},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: stringPtr("gp3"),
+			VolumeName:       "pvc-1234",
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("20Gi")},
+		},
+	}
+
+	record := FormatPersistentVolumeClaimData(claim, settings)
+	assert.Equal(t, config.PersistentVolumeClaim, record.Type)
+	assert.Equal(t, "default", *record.Namespace)
+	assert.Equal(t, config.MetricLabels{
+		"persistentvolumeclaim":   "data",
+		"namespace":               "default",
+		"resource_type":           "persistentvolumeclaim",
+		"storage_class":           "gp3",
+		"volume_name":             "pvc-1234",
+		"requested_storage_bytes": "10737418240",
+		"capacity_bytes":          "21474836480",
+	}, *record.MetricLabels)
+	assert.Equal(t, config.MetricLabelTags{"app": "db"}, *record.Labels)
+}
@@ -1,6 +1,119 @@
 // SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
+//nolint:dupl // There is currently substantial duplication in the handlers :(
 package handler
 
-type ResourceQuotaHandler struct{} // &corev1.ResourceQuota{}
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+type ResourceQuotaHandler struct {
+	hook.Handler
+	settings *config.Settings
+	clock    types.TimeProvider
+}
+
+// NewResourceQuotaHandler creates a new instance of resource quota validation hook
+func NewResourceQuotaHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+	h := &ResourceQuotaHandler{settings: settings}
+	h.Handler.Create = h.Create()
+	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
+	h.Handler.Store = store
+	h.Handler.ErrorChan = errChan
+	h.clock = clock
+	return h.Handler
+}
+
+func (h *ResourceQuotaHandler) Create() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.ResourceQuotas || h.settings.Filters.Annotations.Resources.ResourceQuotas {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ResourceQuotaHandler) Update() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.ResourceQuotas || h.settings.Filters.Annotations.Resources.ResourceQuotas {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ResourceQuotaHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.ResourceQuotas || h.settings.Filters.Annotations.Resources.ResourceQuotas {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ResourceQuotaHandler) parseV1(data []byte) (*corev1.ResourceQuota, error) {
+	var o corev1.ResourceQuota
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (h *ResourceQuotaHandler) writeDataToStorage(ctx context.Context, o *corev1.ResourceQuota) {
+	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatResourceQuotaData(o, h.settings))
+}
+
+func (h *ResourceQuotaHandler) writeDeletionToStorage(ctx context.Context, o *corev1.ResourceQuota) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatResourceQuotaData(o, h.settings))
+}
+
+// FormatResourceQuotaData formats a resource quota, including every hard limit
+// as a `hard_<resource>` metric label, e.g. `hard_requests_cpu`.
+func FormatResourceQuotaData(o *corev1.ResourceQuota, settings *config.Settings) types.ResourceTags {
+	var (
+		labels      = config.MetricLabelTags{}
+		annotations = config.MetricLabelTags{}
+		namespace   = o.GetNamespace()
+		name        = o.GetName()
+	)
+	if settings.Filters.Labels.Resources.ResourceQuotas {
+		labels = config.Filter(o.GetLabels(), settings.LabelMatches, (settings.Filters.Labels.Enabled && settings.Filters.Labels.Resources.ResourceQuotas), settings)
+	}
+	if settings.Filters.Annotations.Resources.ResourceQuotas {
+		annotations = config.Filter(o.GetAnnotations(), settings.AnnotationMatches, (settings.Filters.Annotations.Enabled && settings.Filters.Annotations.Resources.ResourceQuotas), settings)
+	}
+	metricLabels := config.MetricLabels{
+		"resourcequota": name, // standard metric labels to attach to metric
+		"namespace":     namespace,
+		"resource_type": config.ResourceTypeToMetricName[config.ResourceQuota],
+	}
+	for resource, quantity := range o.Spec.Hard {
+		metricLabels["hard_"+config.MetricLabelName(string(resource))] = quantity.String()
+	}
+	return types.ResourceTags{
+		Type:         config.ResourceQuota,
+		Name:         name,
+		Namespace:    &namespace,
+		MetricLabels: &metricLabels,
+		Labels:       &labels,
+		Annotations:  &annotations,
+	}
+}
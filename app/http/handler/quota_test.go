@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func makeResourceQuotaRequest(record TestRecord, o *corev1.ResourceQuota) *hook.Request {
+	o.ObjectMeta = metav1.ObjectMeta{
+		Name:        record.Name,
+		Namespace:   *record.Namespace,
+		Labels:      record.Labels,
+		Annotations: record.Annotations,
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	codecs := serializer.NewCodecFactory(scheme)
+	encoder := codecs.LegacyCodec(corev1.SchemeGroupVersion)
+	raw, _ := runtime.Encode(encoder, o)
+
+	return &hook.Request{
+		Object: runtime.RawExtension{
+			Raw: raw,
+		},
+		OldObject: runtime.RawExtension{
+			Raw: raw,
+		},
+	}
+}
+
+func TestResourceQuotaHandler_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "Test create with labels and annotations enabled", enabled: true},
+		{name: "Test create with labels and annotations disabled", enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtl := gomock.NewController(t)
+			defer mockCtl.Finish()
+			writer := mocks.NewMockResourceStore(mockCtl)
+
+			settings := &config.Settings{
+				Filters: config.Filters{
+					Labels:      config.Labels{Enabled: tt.enabled, Resources: config.Resources{ResourceQuotas: tt.enabled}},
+					Annotations: config.Annotations{Enabled: tt.enabled, Resources: config.Resources{ResourceQuotas: tt.enabled}},
+				},
+			}
+			if tt.enabled {
+				writer.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, nil)
+				writer.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+				writer.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			handler := NewResourceQuotaHandler(writer, settings, mocks.NewMockClock(time.Now()), make(chan error))
+			result, err := handler.Create(context.Background(), makeResourceQuotaRequest(TestRecord{
+				Name:      "test-resourcequota",
+				Namespace: stringPtr("default"),
+				Labels:    map[string]string{"app": "test"},
+			}, &corev1.ResourceQuota{}))
+			assert.NoError(t, err)
+			assert.Equal(t, &hook.Result{Allowed: true}, result)
+		})
+	}
+}
+
+func TestFormatResourceQuotaData(t *testing.T) {
+	settings := NewTestSettings()
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "team-a"},
+		Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+			corev1.ResourceRequestsCPU:    resource.MustParse("10"),
+			corev1.ResourceRequestsMemory: resource.MustParse("64Gi"),
+			"requests.nvidia.com/gpu":     resource.MustParse("2"),
+		}},
+	}
+
+	record := FormatResourceQuotaData(quota, settings)
+	assert.Equal(t, config.ResourceQuota, record.Type)
+	assert.Equal(t, config.MetricLabels{
+		"resourcequota":                "compute",
+		"namespace":                    "team-a",
+		"resource_type":                "resourcequota",
+		"hard_requests_cpu":            "10",
+		"hard_requests_memory":         "64Gi",
+		"hard_requests_nvidia_com_gpu": "2",
+	}, *record.MetricLabels)
+}
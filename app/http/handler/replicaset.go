@@ -1,6 +1,122 @@
 // SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
+//nolint:dupl // There is currently substantial duplication in the handlers :(
 package handler
 
-type ReplicaSetHandler struct{} // &appsv1.ReplicaSet{}
+import (
+	"context"
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+type ReplicaSetHandler struct {
+	hook.Handler
+	settings *config.Settings
+	clock    types.TimeProvider
+}
+
+// NewReplicaSetHandler creates a new instance of replicaset validation hook
+func NewReplicaSetHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+	h := &ReplicaSetHandler{settings: settings}
+	h.Handler.Create = h.Create()
+	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
+	h.Handler.Store = store
+	h.Handler.ErrorChan = errChan
+	h.clock = clock
+	return h.Handler
+}
+
+func (h *ReplicaSetHandler) Create() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.ReplicaSets || h.settings.Filters.Annotations.Resources.ReplicaSets {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ReplicaSetHandler) Update() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.ReplicaSets || h.settings.Filters.Annotations.Resources.ReplicaSets {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ReplicaSetHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.ReplicaSets || h.settings.Filters.Annotations.Resources.ReplicaSets {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ReplicaSetHandler) parseV1(data []byte) (*appsv1.ReplicaSet, error) {
+	var o appsv1.ReplicaSet
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (h *ReplicaSetHandler) writeDataToStorage(ctx context.Context, o *appsv1.ReplicaSet) {
+	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatReplicaSetData(o, h.settings))
+}
+
+func (h *ReplicaSetHandler) writeDeletionToStorage(ctx context.Context, o *appsv1.ReplicaSet) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatReplicaSetData(o, h.settings))
+}
+
+// FormatReplicaSetData formats a replicaset, including the controller which
+// owns it, typically a Deployment.
+func FormatReplicaSetData(o *appsv1.ReplicaSet, settings *config.Settings) types.ResourceTags {
+	var (
+		labels      = config.MetricLabelTags{}
+		annotations = config.MetricLabelTags{}
+		namespace   = o.GetNamespace()
+		name        = o.GetName()
+	)
+	if settings.Filters.Labels.Resources.ReplicaSets {
+		labels = config.Filter(o.GetLabels(), settings.LabelMatches, (settings.Filters.Labels.Enabled && settings.Filters.Labels.Resources.ReplicaSets), settings)
+	}
+	if settings.Filters.Annotations.Resources.ReplicaSets {
+		annotations = config.Filter(o.GetAnnotations(), settings.AnnotationMatches, (settings.Filters.Annotations.Enabled && settings.Filters.Annotations.Resources.ReplicaSets), settings)
+	}
+	metricLabels := config.MetricLabels{
+		"workload":      name, // standard metric labels to attach to metric
+		"namespace":     namespace,
+		"resource_type": config.ResourceTypeToMetricName[config.ReplicaSet],
+	}
+	for _, ref := range o.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller {
+			metricLabels["owner_kind"] = ref.Kind
+			metricLabels["owner_name"] = ref.Name
+		}
+	}
+	return types.ResourceTags{
+		Type:         config.ReplicaSet,
+		Name:         name,
+		Namespace:    &namespace,
+		MetricLabels: &metricLabels,
+		Labels:       &labels,
+		Annotations:  &annotations,
+	}
+}
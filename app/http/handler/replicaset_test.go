@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/utils/ptr"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func makeReplicaSetRequest(record TestRecord, o *appsv1.ReplicaSet) *hook.Request {
+	o.ObjectMeta = metav1.ObjectMeta{
+		Name:        record.Name,
+		Namespace:   *record.Namespace,
+		Labels:      record.Labels,
+		Annotations: record.Annotations,
+	}
+
+	scheme := runtime.NewScheme()
+	appsv1.AddToScheme(scheme)
+	codecs := serializer.NewCodecFactory(scheme)
+	encoder := codecs.LegacyCodec(appsv1.SchemeGroupVersion)
+	raw, _ := runtime.Encode(encoder, o)
+
+	return &hook.Request{
+		Object: runtime.RawExtension{
+			Raw: raw,
+		},
+		OldObject: runtime.RawExtension{
+			Raw: raw,
+		},
+	}
+}
+
+func TestReplicaSetHandler_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "Test create with labels and annotations enabled", enabled: true},
+		{name: "Test create with labels and annotations disabled", enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtl := gomock.NewController(t)
+			defer mockCtl.Finish()
+			writer := mocks.NewMockResourceStore(mockCtl)
+
+			settings := &config.Settings{
+				Filters: config.Filters{
+					Labels:      config.Labels{Enabled: tt.enabled, Resources: config.Resources{ReplicaSets: tt.enabled}},
+					Annotations: config.Annotations{Enabled: tt.enabled, Resources: config.Resources{ReplicaSets: tt.enabled}},
+				},
+			}
+			if tt.enabled {
+				writer.EXPECT().FindFirstBy(gomock.Any(), gomock.Any()).Return(nil, nil)
+				writer.EXPECT().Tx(gomock.Any(), gomock.Any()).Return(nil)
+				writer.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			handler := NewReplicaSetHandler(writer, settings, mocks.NewMockClock(time.Now()), make(chan error))
+			result, err := handler.Create(context.Background(), makeReplicaSetRequest(TestRecord{
+				Name:      "test-replicaset",
+				Namespace: stringPtr("default"),
+				Labels:    map[string]string{"app": "test"},
+			}, &appsv1.ReplicaSet{}))
+			assert.NoError(t, err)
+			assert.Equal(t, &hook.Result{Allowed: true}, result)
+		})
+	}
+}
+
+func TestFormatReplicaSetData(t *testing.T) {
+	settings := NewTestSettings()
+	replicaset := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-5d4f8",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Deployment", Name: "web", Controller: ptr.To(true)},
+			},
+		},
+	}
+
+	record := FormatReplicaSetData(replicaset, settings)
+	assert.Equal(t, config.ReplicaSet, record.Type)
+	assert.Equal(t, config.MetricLabels{
+		"workload":      "web-5d4f8",
+		"namespace":     "default",
+		"resource_type": "replicaset",
+		"owner_kind":    "Deployment",
+		"owner_name":    "web",
+	}, *record.MetricLabels)
+}
@@ -1,6 +1,125 @@
 // SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
+//nolint:dupl // There is currently substantial duplication in the handlers :(
 package handler
 
-type ServiceHandler struct{} // &corev1.Service{}
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+type ServiceHandler struct {
+	hook.Handler
+	settings *config.Settings
+	clock    types.TimeProvider
+}
+
+// NewServiceHandler creates a new instance of service validation hook
+func NewServiceHandler(store types.ResourceStore, settings *config.Settings, clock types.TimeProvider, errChan chan<- error) hook.Handler {
+	h := &ServiceHandler{settings: settings}
+	h.Handler.Create = h.Create()
+	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
+	h.Handler.Store = store
+	h.Handler.ErrorChan = errChan
+	h.clock = clock
+	return h.Handler
+}
+
+func (h *ServiceHandler) Create() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Services || h.settings.Filters.Annotations.Resources.Services {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ServiceHandler) Update() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Services || h.settings.Filters.Annotations.Resources.Services {
+			if o, err := h.parseV1(r.Object.Raw); err == nil {
+				h.writeDataToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ServiceHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.Services || h.settings.Filters.Annotations.Resources.Services {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
+func (h *ServiceHandler) parseV1(data []byte) (*corev1.Service, error) {
+	var o corev1.Service
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (h *ServiceHandler) writeDataToStorage(ctx context.Context, o *corev1.Service) {
+	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatServiceData(o, h.settings))
+}
+
+func (h *ServiceHandler) writeDeletionToStorage(ctx context.Context, o *corev1.Service) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatServiceData(o, h.settings))
+}
+
+// FormatServiceData formats a service, including its type and, for load
+// balancers, the class and address of the load balancer of the cloud provider.
+func FormatServiceData(o *corev1.Service, settings *config.Settings) types.ResourceTags {
+	var (
+		labels      = config.MetricLabelTags{}
+		annotations = config.MetricLabelTags{}
+		namespace   = o.GetNamespace()
+		name        = o.GetName()
+	)
+	if settings.Filters.Labels.Resources.Services {
+		labels = config.Filter(o.GetLabels(), settings.LabelMatches, (settings.Filters.Labels.Enabled && settings.Filters.Labels.Resources.Services), settings)
+	}
+	if settings.Filters.Annotations.Resources.Services {
+		annotations = config.Filter(o.GetAnnotations(), settings.AnnotationMatches, (settings.Filters.Annotations.Enabled && settings.Filters.Annotations.Resources.Services), settings)
+	}
+	metricLabels := config.MetricLabels{
+		"service":       name, // standard metric labels to attach to metric
+		"namespace":     namespace,
+		"resource_type": config.ResourceTypeToMetricName[config.Service],
+	}
+	metricLabels["service_type"] = string(o.Spec.Type)
+	if o.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		if class := o.Spec.LoadBalancerClass; class != nil {
+			metricLabels["load_balancer_class"] = *class
+		}
+		if address := loadBalancerAddresses(o.Status.LoadBalancer.Ingress); address != "" {
+			metricLabels["load_balancer_ingress"] = address
+		}
+	}
+	return types.ResourceTags{
+		Type:         config.Service,
+		Name:         name,
+		Namespace:    &namespace,
+		MetricLabels: &metricLabels,
+		Labels:       &labels,
+		Annotations:  &annotations,
+	}
+}
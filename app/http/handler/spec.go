@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// Well-known labels of the nodes.
+const (
+	labelInstanceType       = "node.kubernetes.io/instance-type"
+	labelInstanceTypeLegacy = "beta.kubernetes.io/instance-type"
+	labelZone               = "topology.kubernetes.io/zone"
+	labelZoneLegacy         = "failure-domain.beta.kubernetes.io/zone"
+	labelRegion             = "topology.kubernetes.io/region"
+	labelRegionLegacy       = "failure-domain.beta.kubernetes.io/region"
+)
+
+// podSpec returns the resource specification of a pod.
+func podSpec(o *corev1.Pod) *types.ResourceSpec {
+	spec := &types.ResourceSpec{
+		QOSClass:      string(o.Status.QOSClass),
+		PriorityClass: o.Spec.PriorityClassName,
+		NodeName:      o.Spec.NodeName,
+	}
+	for _, c := range o.Spec.Containers {
+		spec.Containers = append(spec.Containers, types.ContainerResources{
+			Name:     c.Name,
+			Requests: quantities(c.Resources.Requests),
+			Limits:   quantities(c.Resources.Limits),
+		})
+	}
+	if spec.QOSClass == "" {
+		// the QoS class is only set once the pod was created
+		spec.QOSClass = string(podQOSClass(o))
+	}
+	return spec
+}
+
+// nodeSpec returns the resource specification of a node.
+func nodeSpec(o *corev1.Node) *types.ResourceSpec {
+	return &types.ResourceSpec{
+		InstanceType: firstLabel(o.GetLabels(), labelInstanceType, labelInstanceTypeLegacy),
+		Zone:         firstLabel(o.GetLabels(), labelZone, labelZoneLegacy),
+		Region:       firstLabel(o.GetLabels(), labelRegion, labelRegionLegacy),
+		Capacity:     quantities(o.Status.Capacity),
+		Allocatable:  quantities(o.Status.Allocatable),
+	}
+}
+
+// quantities converts a resource list to plain numbers, CPU in cores and
+// memory in bytes.
+func quantities(list corev1.ResourceList) types.ResourceQuantities {
+	if len(list) == 0 {
+		return nil
+	}
+	result := make(types.ResourceQuantities, len(list))
+	for name, quantity := range list {
+		result[string(name)] = quantity.AsApproximateFloat64()
+	}
+	return result
+}
+
+// podQOSClass computes the QoS class of a pod the way the API server does,
+// from the CPU and memory requests and limits of its containers.
+func podQOSClass(o *corev1.Pod) corev1.PodQOSClass {
+	var requests, limits int
+	guaranteed := true
+	for _, c := range append(append([]corev1.Container{}, o.Spec.InitContainers...), o.Spec.Containers...) {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			request, hasRequest := c.Resources.Requests[name]
+			limit, hasLimit := c.Resources.Limits[name]
+			if hasRequest && !request.IsZero() {
+				requests++
+			}
+			if hasLimit && !limit.IsZero() {
+				limits++
+			}
+			// a missing request defaults to the limit
+			if !hasLimit || (hasRequest && request.Cmp(limit) != 0) {
+				guaranteed = false
+			}
+		}
+	}
+	switch {
+	case requests == 0 && limits == 0:
+		return corev1.PodQOSBestEffort
+	case guaranteed:
+		return corev1.PodQOSGuaranteed
+	default:
+		return corev1.PodQOSBurstable
+	}
+}
+
+func firstLabel(labels map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := labels[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+func resources(requests, limits corev1.ResourceList) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{Requests: requests, Limits: limits}
+}
+
+func TestFormatPodData_Spec(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName:          "node-1",
+			PriorityClassName: "high",
+			Containers: []corev1.Container{
+				{
+					Name: "app",
+					Resources: resources(
+						corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("250m"),
+							corev1.ResourceMemory: resource.MustParse("128Mi"),
+						},
+						corev1.ResourceList{
+							corev1.ResourceMemory:                 resource.MustParse("256Mi"),
+							corev1.ResourceName("nvidia.com/gpu"): resource.MustParse("1"),
+						},
+					),
+				},
+				{Name: "sidecar"},
+			},
+		},
+		Status: corev1.PodStatus{QOSClass: corev1.PodQOSBurstable},
+	}
+
+	// the specification is only captured when enabled
+	assert.Nil(t, FormatPodData(pod, &config.Settings{}).Spec)
+
+	record := FormatPodData(pod, &config.Settings{Specs: config.Specs{Enabled: true}})
+	require.NotNil(t, record.Spec)
+	assert.Equal(t, &types.ResourceSpec{
+		Containers: []types.ContainerResources{
+			{
+				Name:     "app",
+				Requests: types.ResourceQuantities{"cpu": 0.25, "memory": 128 * 1024 * 1024},
+				Limits:   types.ResourceQuantities{"memory": 256 * 1024 * 1024, "nvidia.com/gpu": 1},
+			},
+			{Name: "sidecar"},
+		},
+		QOSClass:      "Burstable",
+		PriorityClass: "high",
+		NodeName:      "node-1",
+	}, record.Spec)
+}
+
+func TestFormatNodeData_Spec(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Labels: map[string]string{
+				"node.kubernetes.io/instance-type":         "m5.large",
+				"topology.kubernetes.io/zone":              "us-east-1a",
+				"failure-domain.beta.kubernetes.io/region": "us-east-1",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("8Gi"),
+			},
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1930m"),
+				corev1.ResourceMemory: resource.MustParse("7Gi"),
+			},
+		},
+	}
+
+	record := FormatNodeData(node, &config.Settings{Specs: config.Specs{Enabled: true}})
+	assert.Equal(t, &types.ResourceSpec{
+		InstanceType: "m5.large",
+		Zone:         "us-east-1a",
+		Region:       "us-east-1",
+		Capacity:     types.ResourceQuantities{"cpu": 2, "memory": 8 * 1024 * 1024 * 1024},
+		Allocatable:  types.ResourceQuantities{"cpu": 1.93, "memory": 7 * 1024 * 1024 * 1024},
+	}, record.Spec)
+}
+
+func TestPodQOSClass(t *testing.T) {
+	cpu := resource.MustParse("100m")
+	memory := resource.MustParse("64Mi")
+	both := corev1.ResourceList{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory}
+
+	tests := []struct {
+		name       string
+		containers []corev1.Container
+		want       corev1.PodQOSClass
+	}{
+		{
+			name:       "no requests or limits",
+			containers: []corev1.Container{{Name: "app"}},
+			want:       corev1.PodQOSBestEffort,
+		},
+		{
+			name:       "limits equal to the requests",
+			containers: []corev1.Container{{Name: "app", Resources: resources(both, both)}},
+			want:       corev1.PodQOSGuaranteed,
+		},
+		{
+			name:       "limits only",
+			containers: []corev1.Container{{Name: "app", Resources: resources(nil, both)}},
+			want:       corev1.PodQOSGuaranteed,
+		},
+		{
+			name:       "requests only",
+			containers: []corev1.Container{{Name: "app", Resources: resources(both, nil)}},
+			want:       corev1.PodQOSBurstable,
+		},
+		{
+			name: "a container without limits",
+			containers: []corev1.Container{
+				{Name: "app", Resources: resources(both, both)},
+				{Name: "sidecar"},
+			},
+			want: corev1.PodQOSBurstable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: tt.containers}}
+			assert.Equal(t, tt.want, podQOSClass(pod))
+		})
+	}
+}
@@ -24,6 +24,7 @@ func NewStatefulsetHandler(store types.ResourceStore, settings *config.Settings,
 	h := &StatefulSetHandler{settings: settings}
 	h.Handler.Create = h.Create()
 	h.Handler.Update = h.Update()
+	h.Handler.Delete = h.Delete()
 	h.Handler.Store = store
 	h.Handler.ErrorChan = errChan
 	h.clock = clock
@@ -54,6 +55,18 @@ func (h *StatefulSetHandler) Update() hook.AdmitFunc {
 	}
 }
 
+func (h *StatefulSetHandler) Delete() hook.AdmitFunc {
+	return func(ctx context.Context, r *hook.Request) (*hook.Result, error) {
+		// only process if enabled, always return allowed to not block an admission
+		if h.settings.Filters.Labels.Resources.StatefulSets || h.settings.Filters.Annotations.Resources.StatefulSets {
+			if o, err := h.parseV1(r.OldObject.Raw); err == nil {
+				h.writeDeletionToStorage(ctx, o)
+			}
+		}
+		return &hook.Result{Allowed: true}, nil
+	}
+}
+
 func (h *StatefulSetHandler) parseV1(data []byte) (*v1.StatefulSet, error) {
 	var o v1.StatefulSet
 	if err := json.Unmarshal(data, &o); err != nil {
@@ -66,6 +79,10 @@ func (h *StatefulSetHandler) writeDataToStorage(ctx context.Context, o *v1.State
 	genericWriteDataToStorage(ctx, h.Store, h.clock, FormatStatefulsetData(o, h.settings))
 }
 
+func (h *StatefulSetHandler) writeDeletionToStorage(ctx context.Context, o *v1.StatefulSet) {
+	genericWriteDeletionToStorage(ctx, h.Store, h.clock, FormatStatefulsetData(o, h.settings))
+}
+
 func FormatStatefulsetData(o *v1.StatefulSet, settings *config.Settings) types.ResourceTags {
 	var (
 		labels      = config.MetricLabelTags{}
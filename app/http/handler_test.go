@@ -7,13 +7,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"gotest.tools/v3/assert"
 	admission "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
 	"github.com/cloudzero/cloudzero-agent/app/http/hook"
 )
 
@@ -88,3 +97,131 @@ func TestServe(t *testing.T) {
 		})
 	}
 }
+
+func TestServe_Patch(t *testing.T) {
+	patch := []byte(`[{"op":"add","path":"/metadata/labels/cost-center","value":"cc-1"}]`)
+	handlerFunc := handler().Serve(hook.Handler{
+		Create: func(context.Context, *hook.Request) (*hook.Result, error) {
+			return &hook.Result{Allowed: true, Patch: patch, Warnings: []string{"defaulted"}}, nil
+		},
+	})
+
+	body, _ := json.Marshal(admission.AdmissionReview{Request: &admission.AdmissionRequest{UID: "12345", Operation: admission.Create}})
+	mockRequest, _ := http.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	mockRequest.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handlerFunc(rr, mockRequest)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var review admission.AdmissionReview
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &review))
+	assert.Assert(t, review.Response.PatchType != nil)
+	assert.Equal(t, admission.PatchTypeJSONPatch, *review.Response.PatchType)
+	assert.Equal(t, string(patch), string(review.Response.Patch))
+	assert.DeepEqual(t, []string{"defaulted"}, review.Response.Warnings)
+}
+
+func sendReview(t *testing.T, handlerFunc http.HandlerFunc, route string, request *admission.AdmissionRequest) admission.AdmissionReview {
+	body, _ := json.Marshal(admission.AdmissionReview{Request: request})
+	mockRequest, _ := http.NewRequest(http.MethodPost, route, bytes.NewReader(body))
+	mockRequest.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handlerFunc(rr, mockRequest)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var review admission.AdmissionReview
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &review))
+	return review
+}
+
+func TestServe_Queued(t *testing.T) {
+	b := newBlockingHook()
+	h := newHandler(&config.Settings{})
+	defer h.Close()
+
+	// the response does not wait for the handler
+	review := sendReview(t, h.Serve(b.handler()), "/validate/pod", &admission.AdmissionRequest{UID: "12345", Operation: admission.Create})
+	assert.Assert(t, review.Response.Allowed)
+	assert.Equal(t, 0, len(b.processed()))
+
+	close(b.release)
+	h.Close()
+	assert.DeepEqual(t, []string{"12345"}, b.processed())
+}
+
+func TestServe_MutatingIsNotQueued(t *testing.T) {
+	patch := []byte(`[{"op":"add","path":"/metadata/labels/team","value":"cost"}]`)
+	h := newHandler(&config.Settings{})
+	defer h.Close()
+
+	review := sendReview(t, h.ServeMutating(hook.Handler{
+		Create: func(context.Context, *hook.Request) (*hook.Result, error) {
+			return &hook.Result{Allowed: true, Patch: patch}, nil
+		},
+	}), "/mutate", &admission.AdmissionRequest{UID: "12345", Operation: admission.Create})
+	assert.Equal(t, string(patch), string(review.Response.Patch))
+}
+
+func TestServe_FailSafeTimeout(t *testing.T) {
+	AdmissionTimeouts.Reset()
+	b := newBlockingHook()
+	defer close(b.release)
+	h := newHandler(&config.Settings{Admission: config.Admission{Synchronous: true, Timeout: 10 * time.Millisecond}})
+	defer h.Close()
+
+	review := sendReview(t, h.Serve(b.handler()), "/validate/pod", &admission.AdmissionRequest{UID: "12345", Operation: admission.Create})
+	assert.Assert(t, review.Response.Allowed)
+	assert.Equal(t, 1.0, testutil.ToFloat64(AdmissionTimeouts.WithLabelValues("/validate/pod")))
+}
+
+func TestServe_Audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	h := newHandler(&config.Settings{Admission: config.Admission{Audit: config.AdmissionAudit{Enabled: true, Path: path}}})
+
+	sendReview(t, h.Serve(NewMockHandler()), "/validate/deployment", &admission.AdmissionRequest{
+		UID:       "12345",
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Namespace: "default",
+		Name:      "web",
+		Operation: admission.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+	})
+	h.Close()
+
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Equal(t, 1, len(lines))
+
+	var record map[string]any
+	assert.NilError(t, json.Unmarshal([]byte(lines[0]), &record))
+	delete(record, "time")
+	assert.DeepEqual(t, map[string]any{
+		"uid":       "12345",
+		"route":     "/validate/deployment",
+		"kind":      "Deployment",
+		"namespace": "default",
+		"name":      "web",
+		"operation": "CREATE",
+		"user":      "alice",
+	}, record)
+}
+
+func TestAuditLog_Full(t *testing.T) {
+	// nothing is written while the file is blocked
+	r, w := io.Pipe()
+	audit := newAuditLog(w, 1)
+	request := &hook.Request{UID: "12345"}
+
+	// the records beyond the buffer are dropped without waiting
+	before := testutil.ToFloat64(AdmissionAuditDropped.WithLabelValues("buffer_full"))
+	for range 3 {
+		audit.record("/validate/pod", request)
+	}
+	assert.Assert(t, testutil.ToFloat64(AdmissionAuditDropped.WithLabelValues("buffer_full")) >= before+1)
+
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+	assert.NilError(t, audit.Close())
+	audit.record("/validate/pod", request)
+	assert.Equal(t, 1.0, testutil.ToFloat64(AdmissionAuditDropped.WithLabelValues("shutdown")))
+}
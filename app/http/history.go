@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// LabelHistoryRoute is the route of the label history lookups.
+const LabelHistoryRoute = "/history/labels"
+
+// LabelsAtResponse is the response of a label history lookup.
+type LabelsAtResponse struct {
+	Kind         string                 `json:"kind"`
+	Namespace    *string                `json:"namespace,omitempty"`
+	Name         string                 `json:"name"`
+	MetricLabels config.MetricLabels    `json:"metric_labels"`
+	Labels       config.MetricLabelTags `json:"labels"`
+	Annotations  config.MetricLabelTags `json:"annotations"`
+	ValidFrom    time.Time              `json:"valid_from"`
+	ValidTo      *time.Time             `json:"valid_to,omitempty"`
+}
+
+// NewLabelHistoryHandler returns a handler which answers which labels a
+// resource had at a given time, e.g.
+// `/history/labels?kind=deployment&namespace=default&name=web&at=2024-10-01T12:00:00Z`.
+// The time defaults to now, and the namespace is omitted for cluster-scoped
+// resources.
+func NewLabelHistoryHandler(history types.ResourceHistoryStore, clock types.TimeProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid method only GET requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		kind, name := query.Get("kind"), query.Get("name")
+		resourceType, ok := config.ResourceTypeOf(kind)
+		if !ok || name == "" {
+			http.Error(w, "a known kind and a name are required", http.StatusBadRequest)
+			return
+		}
+		var namespace *string
+		if query.Has("namespace") {
+			ns := query.Get("namespace")
+			namespace = &ns
+		}
+		at := clock.GetCurrentTime()
+		if value := query.Get("at"); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "the time must be in RFC 3339 format", http.StatusBadRequest)
+				return
+			}
+			at = parsed
+		}
+
+		version, err := history.LabelsAt(r.Context(), resourceType, namespace, name, at)
+		switch {
+		case errors.Is(err, types.ErrNotFound):
+			http.Error(w, "no labels are known for the resource at the given time", http.StatusNotFound)
+			return
+		case err != nil:
+			log.Ctx(r.Context()).Error().Err(err).Msg("failed to look up the label history")
+			http.Error(w, "failed to look up the label history", http.StatusInternalServerError)
+			return
+		}
+
+		res := LabelsAtResponse{
+			Kind:      kind,
+			Namespace: version.Namespace,
+			Name:      version.Name,
+			ValidFrom: version.ValidFrom,
+			ValidTo:   version.ValidTo,
+		}
+		if version.MetricLabels != nil {
+			res.MetricLabels = *version.MetricLabels
+		}
+		if version.Labels != nil {
+			res.Labels = *version.Labels
+		}
+		if version.Annotations != nil {
+			res.Annotations = *version.Annotations
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Ctx(r.Context()).Error().Err(err).Msg("failed to write the label history")
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func TestLabelHistoryHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := now.Add(-24 * time.Hour)
+	validTo := now.Add(-time.Hour)
+	namespace := "default"
+	history := mocks.NewMockResourceHistoryStore(ctrl)
+	history.EXPECT().LabelsAt(gomock.Any(), config.Deployment, &namespace, "web", at).Return(&types.ResourceTagsVersion{
+		Type:      config.Deployment,
+		Name:      "web",
+		Namespace: &namespace,
+		Labels:    &config.MetricLabelTags{"team": "a"},
+		ValidFrom: at.Add(-time.Hour),
+		ValidTo:   &validTo,
+	}, nil)
+	history.EXPECT().LabelsAt(gomock.Any(), config.Node, nil, "node", now).Return(nil, types.ErrNotFound)
+
+	handler := NewLabelHistoryHandler(history, mocks.NewMockClock(now))
+
+	t.Run("labels at a time", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, LabelHistoryRoute+"?kind=deployment&namespace=default&name=web&at="+at.Format(time.RFC3339), nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var res LabelsAtResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+		assert.Equal(t, "deployment", res.Kind)
+		assert.Equal(t, config.MetricLabelTags{"team": "a"}, res.Labels)
+		require.NotNil(t, res.ValidTo)
+		assert.True(t, validTo.Equal(*res.ValidTo))
+	})
+
+	tests := []struct {
+		name         string
+		method       string
+		query        string
+		expectedCode int
+	}{
+		{name: "unknown resource", method: http.MethodGet, query: "?kind=node&name=node", expectedCode: http.StatusNotFound},
+		{name: "unknown kind", method: http.MethodGet, query: "?kind=widget&name=web", expectedCode: http.StatusBadRequest},
+		{name: "missing name", method: http.MethodGet, query: "?kind=deployment", expectedCode: http.StatusBadRequest},
+		{name: "invalid time", method: http.MethodGet, query: "?kind=deployment&name=web&at=yesterday", expectedCode: http.StatusBadRequest},
+		{name: "invalid method", method: http.MethodPost, query: "?kind=deployment&name=web", expectedCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, LabelHistoryRoute+tt.query, nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tt.expectedCode, rr.Code)
+		})
+	}
+}
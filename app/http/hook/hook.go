@@ -20,6 +20,10 @@ type Request = v1.AdmissionRequest
 type Result struct {
 	Allowed bool
 	Msg     string
+	// Patch is a JSONPatch applied to the object, by a mutating webhook.
+	Patch []byte
+	// Warnings are returned to the client which made the request.
+	Warnings []string
 }
 
 // AdmitFunc defines how to process an admission request
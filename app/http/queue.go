@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+)
+
+const (
+	// DefaultAdmissionQueueSize is used when the queue size is not set.
+	DefaultAdmissionQueueSize = 10000
+	// DefaultAdmissionWorkers is used when the number of workers is not set.
+	DefaultAdmissionWorkers = 2
+	// DefaultAdmissionTimeout is used when the fail-safe timeout is not set.
+	DefaultAdmissionTimeout = 2 * time.Second
+)
+
+// admissionJob is an admission request waiting to be processed.
+type admissionJob struct {
+	ctx     context.Context
+	route   string
+	handler hook.Handler
+	request *hook.Request
+	queued  time.Time
+}
+
+// admissionQueue is a bounded queue of admission requests, processed in the
+// background by a fixed number of workers. Every worker has its own share of
+// the queue, and the requests of an object always go to the same worker, so
+// they are processed in the order they were received.
+type admissionQueue struct {
+	jobs    []chan admissionJob
+	policy  config.DropPolicy
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newAdmissionQueue creates the queue and starts its workers.
+func newAdmissionQueue(size, workers int, policy config.DropPolicy, timeout time.Duration) *admissionQueue {
+	if size <= 0 {
+		size = DefaultAdmissionQueueSize
+	}
+	if workers <= 0 {
+		workers = DefaultAdmissionWorkers
+	}
+	if policy == "" {
+		policy = config.DropOldest
+	}
+	q := &admissionQueue{
+		jobs:    make([]chan admissionJob, workers),
+		policy:  policy,
+		timeout: timeout,
+	}
+	q.wg.Add(workers)
+	for i := range q.jobs {
+		q.jobs[i] = make(chan admissionJob, max(size/workers, 1))
+		go q.work(q.jobs[i])
+	}
+	return q
+}
+
+// length returns the number of queued requests.
+func (q *admissionQueue) length() int {
+	total := 0
+	for _, jobs := range q.jobs {
+		total += len(jobs)
+	}
+	return total
+}
+
+// worker returns the queue of the worker processing the requests of the
+// object, found from its kind, namespace and name.
+func (q *admissionQueue) worker(request *hook.Request) chan admissionJob {
+	name := request.Name
+	if name == "" {
+		// the name of an object created with a generated name is only set
+		// in the object
+		var obj struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(request.Object.Raw, &obj); err == nil {
+			name = obj.Metadata.Name
+		}
+	}
+	h := fnv.New32a()
+	for _, part := range []string{request.Kind.Group, request.Kind.Kind, request.Namespace, name} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return q.jobs[h.Sum32()%uint32(len(q.jobs))]
+}
+
+// push queues a request, applying the drop policy when the queue is full.
+func (q *admissionQueue) push(job admissionJob) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.drop(job, "shutdown")
+		return
+	}
+	defer func() { AdmissionQueueLength.Set(float64(q.length())) }()
+
+	jobs := q.worker(job.request)
+	job.queued = time.Now()
+	select {
+	case jobs <- job:
+		return
+	default:
+	}
+
+	switch q.policy {
+	case config.DropNewest:
+		q.drop(job, "queue_full")
+	case config.Block:
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case jobs <- job:
+		case <-timer.C:
+			q.drop(job, "timeout")
+		}
+	default:
+		// make room by dropping the oldest requests
+		for {
+			select {
+			case jobs <- job:
+				return
+			default:
+			}
+			select {
+			case oldest := <-jobs:
+				q.drop(oldest, "queue_full")
+			default:
+			}
+		}
+	}
+}
+
+func (q *admissionQueue) drop(job admissionJob, reason string) {
+	AdmissionDropped.WithLabelValues(job.route, reason).Inc()
+	log.Ctx(job.ctx).Warn().
+		Str("route", job.route).
+		Str("reason", reason).
+		Str("uid", string(job.request.UID)).
+		Msg("Dropped an admission request")
+}
+
+func (q *admissionQueue) work(jobs chan admissionJob) {
+	defer q.wg.Done()
+	for job := range jobs {
+		AdmissionQueueLength.Set(float64(q.length()))
+		AdmissionQueueWait.WithLabelValues(job.route).Observe(time.Since(job.queued).Seconds())
+		if _, err := executeHook(job.ctx, job.route, job.handler, job.request); err != nil {
+			log.Ctx(job.ctx).Error().Err(err).Str("route", job.route).Msg("Failed to process an admission request")
+		}
+	}
+}
+
+// Close stops accepting requests, and waits for the queued ones to be
+// processed.
+func (q *admissionQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	for _, jobs := range q.jobs {
+		close(jobs)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	admission "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/http/hook"
+)
+
+// blockingHook records the requests it processes, once it is released.
+type blockingHook struct {
+	release chan struct{}
+	mu      sync.Mutex
+	uids    []string
+}
+
+func newBlockingHook() *blockingHook {
+	return &blockingHook{release: make(chan struct{})}
+}
+
+func (b *blockingHook) handler() hook.Handler {
+	return hook.Handler{
+		Create: func(_ context.Context, r *hook.Request) (*hook.Result, error) {
+			<-b.release
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.uids = append(b.uids, string(r.UID))
+			return &hook.Result{Allowed: true}, nil
+		},
+	}
+}
+
+func (b *blockingHook) processed() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.uids...)
+}
+
+func queueJob(h hook.Handler, route, uid string) admissionJob {
+	return admissionJob{
+		ctx:     context.Background(),
+		route:   route,
+		handler: h,
+		request: &hook.Request{UID: types.UID(uid), Operation: admission.Create},
+	}
+}
+
+func TestAdmissionQueue_DropPolicies(t *testing.T) {
+	tests := []struct {
+		policy  config.DropPolicy
+		reason  string
+		want    []string
+		timeout time.Duration
+	}{
+		// the first request is taken by the worker, the second one is queued
+		{policy: config.DropNewest, reason: "queue_full", want: []string{"1", "2"}},
+		{policy: config.DropOldest, reason: "queue_full", want: []string{"1", "3"}},
+		{policy: config.Block, reason: "timeout", want: []string{"1", "2"}, timeout: 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			AdmissionDropped.Reset()
+			route := "/validate/" + string(tt.policy)
+			b := newBlockingHook()
+			q := newAdmissionQueue(1, 1, tt.policy, tt.timeout)
+
+			q.push(queueJob(b.handler(), route, "1"))
+			assert.Eventually(t, func() bool { return q.length() == 0 }, time.Second, time.Millisecond)
+			q.push(queueJob(b.handler(), route, "2"))
+			q.push(queueJob(b.handler(), route, "3"))
+			assert.Equal(t, 1.0, testutil.ToFloat64(AdmissionDropped.WithLabelValues(route, tt.reason)))
+
+			close(b.release)
+			q.Close()
+			assert.Equal(t, tt.want, b.processed())
+
+			// the requests are dropped once the queue is closed
+			q.push(queueJob(b.handler(), route, "4"))
+			assert.Equal(t, 1.0, testutil.ToFloat64(AdmissionDropped.WithLabelValues(route, "shutdown")))
+		})
+	}
+}
+
+func TestAdmissionQueue_OrderPerObject(t *testing.T) {
+	var mu sync.Mutex
+	var operations []string
+	record := func(r *hook.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		operations = append(operations, string(r.Operation))
+	}
+	release := make(chan struct{})
+	h := hook.Handler{
+		Create: func(_ context.Context, r *hook.Request) (*hook.Result, error) {
+			<-release
+			record(r)
+			return &hook.Result{Allowed: true}, nil
+		},
+		Delete: func(_ context.Context, r *hook.Request) (*hook.Result, error) {
+			record(r)
+			return &hook.Result{Allowed: true}, nil
+		},
+	}
+	q := newAdmissionQueue(100, 8, config.DropOldest, 0)
+
+	// the deletion waits for the creation, even with idle workers
+	for _, operation := range []admission.Operation{admission.Create, admission.Delete} {
+		q.push(admissionJob{
+			ctx:     context.Background(),
+			route:   "/validate/order",
+			handler: h,
+			request: &hook.Request{
+				UID:       types.UID(operation),
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Namespace: "default",
+				Name:      "web",
+				Operation: operation,
+			},
+		})
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	q.Close()
+	assert.Equal(t, []string{"CREATE", "DELETE"}, operations)
+}
@@ -14,6 +14,10 @@ import (
 	"github.com/cloudzero/cloudzero-agent/app/http/hook"
 )
 
+// CertificateHealthRoute is the route of the health check which fails when the
+// served TLS certificate is close to expiry.
+const CertificateHealthRoute = "/healthz/certificate"
+
 type RouteSegment struct {
 	Route string
 	Hook  http.Handler
@@ -22,14 +26,24 @@ type RouteSegment struct {
 type AdmissionRouteSegment struct {
 	Route string
 	Hook  hook.Handler
+	// Mutating routes are processed before responding, as the response
+	// carries the patch.
+	Mutating bool
 }
 
 // NewServer creates and return a http.Server
 func NewServer(cfg *config.Settings, routes []RouteSegment, admissionRoutes ...AdmissionRouteSegment) *http.Server {
-	ah := handler()
+	var ah *admissionHandler
 	mux := http.NewServeMux()
+	if len(admissionRoutes) > 0 {
+		ah = newHandler(cfg)
+	}
 	for _, route := range admissionRoutes {
-		mux.Handle(route.Route, ah.Serve(route.Hook))
+		if route.Mutating {
+			mux.Handle(route.Route, ah.ServeMutating(route.Hook))
+		} else {
+			mux.Handle(route.Route, ah.Serve(route.Hook))
+		}
 	}
 	// Internal routes
 	mux.Handle("/healthz", healthz.NewHealthz().EndpointHandler())
@@ -42,11 +56,16 @@ func NewServer(cfg *config.Settings, routes []RouteSegment, admissionRoutes ...A
 	handler := MetricsMiddlewareWrapper(mux)
 	handler = LoggingMiddlewareWrapper(handler)
 
-	return &http.Server{
+	server := &http.Server{
 		Addr:         ":" + cfg.Server.Port,
 		Handler:      handler,
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout),
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout),
 		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),
 	}
+	if ah != nil {
+		// process the queued admission requests on shutdown
+		server.RegisterOnShutdown(ah.Close)
+	}
+	return server
 }
@@ -22,7 +22,8 @@ func New() *Inspector {
 	i := &Inspector{}
 
 	i.inspectors = map[int]ResponseInspectorFunc{
-		http.StatusForbidden: i.inspect403,
+		http.StatusUnauthorized: i.inspect401,
+		http.StatusForbidden:    i.inspect403,
 	}
 
 	return i
@@ -53,3 +54,9 @@ func (i *Inspector) Inspect(ctx context.Context, resp *http.Response, logger zer
 
 	return nil
 }
+
+// IsUnauthorized returns true if the API key was rejected, in which case a
+// client may retry the request once with the next API key.
+func IsUnauthorized(resp *http.Response) bool {
+	return resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden)
+}
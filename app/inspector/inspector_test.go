@@ -44,6 +44,15 @@ func TestInspector_Inspect(t *testing.T) {
 				"message": "Unknown HTTP error",
 			},
 		},
+		{
+			name: "401 Unauthorized",
+			resp: &http.Response{StatusCode: http.StatusUnauthorized},
+			want: map[string]any{
+				"status":  float64(http.StatusUnauthorized),
+				"level":   "error",
+				"message": "Invalid CloudZero API key",
+			},
+		},
 		{
 			name: "403 Forbidden generic",
 			resp: &http.Response{
@@ -117,3 +126,19 @@ func TestInspector_Inspect(t *testing.T) {
 		})
 	}
 }
+
+func TestIsUnauthorized(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusUnauthorized:        true,
+		http.StatusForbidden:           true,
+		http.StatusInternalServerError: false,
+	} {
+		if got := inspector.IsUnauthorized(&http.Response{StatusCode: status}); got != want {
+			t.Errorf("IsUnauthorized(%d) = %v, want %v", status, got, want)
+		}
+	}
+	if inspector.IsUnauthorized(nil) {
+		t.Error("IsUnauthorized(nil) = true, want false")
+	}
+}
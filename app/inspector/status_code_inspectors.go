@@ -11,6 +11,11 @@ import (
 
 type ResponseInspectorFunc func(ctx context.Context, resp *responseData, logger zerolog.Logger) (bool, error)
 
+func (i *Inspector) inspect401(_ context.Context, _ *responseData, logger zerolog.Logger) (bool, error) {
+	logger.Error().Msg("Invalid CloudZero API key")
+	return true, nil
+}
+
 func (i *Inspector) inspect403(_ context.Context, resp *responseData, logger zerolog.Logger) (bool, error) {
 	if match, err := resp.JSONMatch(".message == \"User is not authorized to access this resource\""); err != nil {
 		return false, err
@@ -0,0 +1,319 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+var (
+	DefaultLeaseDuration      = 15 * time.Second
+	DefaultLeaseRenewInterval = 5 * time.Second
+)
+
+// LeaseLock is a lock backed by a `coordination.k8s.io/v1` Lease, so it can be
+// shared by processes on different nodes.
+//
+// The holder renews the lease in the background. When the lease can not be
+// renewed, the lock is considered lost one renew interval before the lease
+// expires and its context is cancelled, so the holder stops before another
+// process can take over.
+type LeaseLock struct {
+	client        kubernetes.Interface
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	renewInterval time.Duration
+	retryInterval time.Duration
+	maxRetry      int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	held   context.Context
+	mu     sync.Mutex
+}
+
+var _ Locker = (*LeaseLock)(nil)
+
+type LeaseLockOption func(ll *LeaseLock)
+
+// WithLeaseIdentity sets the holder identity written to the lease. It must be
+// unique per process, and defaults to `<hostname>-<pid>`.
+func WithLeaseIdentity(identity string) LeaseLockOption {
+	return func(ll *LeaseLock) {
+		ll.identity = identity
+	}
+}
+
+// WithLeaseDuration sets how long the lease is valid without being renewed.
+func WithLeaseDuration(duration time.Duration) LeaseLockOption {
+	return func(ll *LeaseLock) {
+		ll.leaseDuration = duration
+	}
+}
+
+// WithLeaseRenewInterval sets how often the holder renews the lease. It should
+// be well below the lease duration.
+func WithLeaseRenewInterval(interval time.Duration) LeaseLockOption {
+	return func(ll *LeaseLock) {
+		ll.renewInterval = interval
+	}
+}
+
+func WithLeaseRetryInterval(interval time.Duration) LeaseLockOption {
+	return func(ll *LeaseLock) {
+		ll.retryInterval = interval
+	}
+}
+
+func WithLeaseMaxRetry(retry int) LeaseLockOption {
+	return func(ll *LeaseLock) {
+		ll.maxRetry = retry
+	}
+}
+
+func NewLeaseLock(ctx context.Context, client kubernetes.Interface, namespace, name string, opts ...LeaseLockOption) *LeaseLock {
+	hostname, _ := os.Hostname()
+
+	// create with defaults
+	ll := &LeaseLock{
+		client:        client,
+		namespace:     namespace,
+		name:          name,
+		identity:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		leaseDuration: DefaultLeaseDuration,
+		renewInterval: DefaultLeaseRenewInterval,
+		retryInterval: DefaultRetryInterval,
+		maxRetry:      DefaultMaxRetry,
+		ctx:           ctx,
+	}
+
+	// apply the options
+	for _, opt := range opts {
+		opt(ll)
+	}
+
+	return ll
+}
+
+func (ll *LeaseLock) Acquire() error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	leases := ll.client.CoordinationV1().Leases(ll.namespace)
+
+	// track retry count
+	retry := 0
+
+	for {
+		select {
+		case <-ll.ctx.Done():
+			return fmt.Errorf("%w: context cancelled", ErrLockAcquire)
+		default:
+			// break if max retry is met
+			if retry > ll.maxRetry {
+				return ErrMaxRetryExceeded
+			}
+
+			now := metav1.NewMicroTime(time.Now())
+			lease, err := leases.Get(ll.ctx, ll.name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				// nobody held the lock yet
+				_, err = leases.Create(ll.ctx, &coordinationv1.Lease{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      ll.name,
+						Namespace: ll.namespace,
+					},
+					Spec: coordinationv1.LeaseSpec{
+						HolderIdentity:       ptr.To(ll.identity),
+						LeaseDurationSeconds: ptr.To(ll.leaseDurationSeconds()),
+						AcquireTime:          &now,
+						RenewTime:            &now,
+						LeaseTransitions:     ptr.To(int32(0)),
+					},
+				}, metav1.CreateOptions{})
+				if apierrors.IsAlreadyExists(err) {
+					// another process created it first
+					retry += 1
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("%w: failed to create the lease: %v", ErrLockAcquire, err)
+				}
+				ll.startRenew(now.Time)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%w: failed to get the lease: %v", ErrLockAcquire, err)
+			}
+
+			// wait for a lease held by another process to be released or expire
+			holder := ptr.Deref(lease.Spec.HolderIdentity, "")
+			if holder != "" && holder != ll.identity && !leaseExpired(lease, now.Time) {
+				retry += 1
+				time.Sleep(ll.retryInterval)
+				continue
+			}
+
+			// take over the lease. the update fails with a conflict when
+			// another process changed the lease since it was read.
+			if holder != ll.identity {
+				lease.Spec.AcquireTime = &now
+				lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+			}
+			lease.Spec.HolderIdentity = ptr.To(ll.identity)
+			lease.Spec.LeaseDurationSeconds = ptr.To(ll.leaseDurationSeconds())
+			lease.Spec.RenewTime = &now
+			if _, err := leases.Update(ll.ctx, lease, metav1.UpdateOptions{}); err != nil {
+				if apierrors.IsConflict(err) {
+					// another process changed the lease first
+					retry += 1
+					continue
+				}
+				return fmt.Errorf("%w: failed to update the lease: %v", ErrLockAcquire, err)
+			}
+			ll.startRenew(now.Time)
+			return nil
+		}
+	}
+}
+
+func (ll *LeaseLock) Release() error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	// propigate the cancel across context
+	if ll.cancel != nil {
+		ll.cancel()
+		ll.cancel = nil
+	}
+
+	// clear the holder, unless the lease was already taken over. a new
+	// context is used as the lock is commonly released during shutdown.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ll.ctx), ll.leaseDuration)
+	defer cancel()
+
+	leases := ll.client.CoordinationV1().Leases(ll.namespace)
+	lease, err := leases.Get(ctx, ll.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != ll.identity {
+		return nil
+	}
+
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil && !apierrors.IsConflict(err) {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+func (ll *LeaseLock) Context() context.Context {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	return heldContext(ll.held)
+}
+
+// startRenew starts renewing the lease, which was renewed at `renewed`, in the
+// background. Must be called with the mutex held.
+func (ll *LeaseLock) startRenew(renewed time.Time) {
+	ctx, cancel := context.WithCancel(ll.ctx)
+	ll.cancel = cancel
+	ll.held = ctx
+	go ll.renewLease(ctx, cancel, renewed)
+}
+
+func (ll *LeaseLock) renewLease(ctx context.Context, cancel context.CancelFunc, lastRenew time.Time) {
+	ticker := time.NewTicker(ll.renewInterval)
+	defer ticker.Stop()
+
+	stepDown := ll.stepDownAfter()
+	for {
+		select {
+		case <-ticker.C:
+			attempt := time.Now()
+			err := ll.updateLease(ctx)
+			if err == nil {
+				lastRenew = attempt
+				continue
+			}
+
+			// transient failures are retried until the next attempt could
+			// come after the lease expired, losing the lease to another
+			// process stops immediately
+			if errors.Is(err, ErrLockLost) || time.Since(lastRenew) >= stepDown {
+				cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ll *LeaseLock) updateLease(ctx context.Context) error {
+	leases := ll.client.CoordinationV1().Leases(ll.namespace)
+	lease, err := leases.Get(ctx, ll.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return err
+	}
+
+	// ensure the lease belongs to this process
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != ll.identity {
+		return ErrLockLost
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// stepDownAfter returns how long after the last renewal the holder gives up the
+// lock, which is one renew interval before the lease expires.
+func (ll *LeaseLock) stepDownAfter() time.Duration {
+	stepDown := ll.leaseDuration - ll.renewInterval
+	if stepDown <= 0 {
+		stepDown = ll.leaseDuration / 2
+	}
+	return stepDown
+}
+
+func (ll *LeaseLock) leaseDurationSeconds() int32 {
+	seconds := int32(ll.leaseDuration / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// leaseExpired returns true when the lease was not renewed within its duration.
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	testLeaseNamespace = "cloudzero"
+	testLeaseName      = "shipper"
+)
+
+func newTestLeaseLock(client kubernetes.Interface, identity string, opts ...LeaseLockOption) *LeaseLock {
+	opts = append([]LeaseLockOption{
+		WithLeaseIdentity(identity),
+		WithLeaseDuration(time.Second),
+		WithLeaseRenewInterval(50 * time.Millisecond),
+		WithLeaseRetryInterval(10 * time.Millisecond),
+		WithLeaseMaxRetry(3),
+	}, opts...)
+	return NewLeaseLock(context.Background(), client, testLeaseNamespace, testLeaseName, opts...)
+}
+
+func getTestLease(t *testing.T, client kubernetes.Interface) *coordinationv1.Lease {
+	t.Helper()
+	lease, err := client.CoordinationV1().Leases(testLeaseNamespace).Get(context.Background(), testLeaseName, metav1.GetOptions{})
+	require.NoError(t, err)
+	return lease
+}
+
+func TestLease_AcquireAndRelease(t *testing.T) {
+	t.Parallel()
+	client := fake.NewClientset()
+
+	ll := newTestLeaseLock(client, "a")
+	require.Error(t, ll.Context().Err())
+
+	// acquire creates the lease
+	require.NoError(t, ll.Acquire())
+	require.NoError(t, ll.Context().Err())
+	lease := getTestLease(t, client)
+	require.Equal(t, "a", ptr.Deref(lease.Spec.HolderIdentity, ""))
+	require.Equal(t, int32(0), ptr.Deref(lease.Spec.LeaseTransitions, -1))
+
+	// release clears the holder and cancels the context
+	held := ll.Context()
+	require.NoError(t, ll.Release())
+	require.Error(t, held.Err())
+	lease = getTestLease(t, client)
+	require.Nil(t, lease.Spec.HolderIdentity)
+
+	// another process can now take over
+	ll2 := newTestLeaseLock(client, "b")
+	require.NoError(t, ll2.Acquire())
+	lease = getTestLease(t, client)
+	require.Equal(t, "b", ptr.Deref(lease.Spec.HolderIdentity, ""))
+	require.Equal(t, int32(1), ptr.Deref(lease.Spec.LeaseTransitions, -1))
+	require.NoError(t, ll2.Release())
+}
+
+func TestLease_Contention(t *testing.T) {
+	t.Parallel()
+	client := fake.NewClientset()
+
+	ll := newTestLeaseLock(client, "a")
+	require.NoError(t, ll.Acquire())
+	defer ll.Release()
+
+	// the lease is renewed, so the other process gives up
+	ll2 := newTestLeaseLock(client, "b")
+	require.ErrorIs(t, ll2.Acquire(), ErrMaxRetryExceeded)
+
+	// releasing a lock which is not held leaves the lease alone
+	require.NoError(t, ll2.Release())
+	require.Equal(t, "a", ptr.Deref(getTestLease(t, client).Spec.HolderIdentity, ""))
+}
+
+func TestLease_ExpiredLeaseTakeover(t *testing.T) {
+	t.Parallel()
+	client := fake.NewClientset()
+
+	// a lease left behind by a process which died
+	renewed := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	_, err := client.CoordinationV1().Leases(testLeaseNamespace).Create(context.Background(), &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: testLeaseName, Namespace: testLeaseNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("dead"),
+			LeaseDurationSeconds: ptr.To(int32(15)),
+			AcquireTime:          &renewed,
+			RenewTime:            &renewed,
+			LeaseTransitions:     ptr.To(int32(4)),
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	ll := newTestLeaseLock(client, "a")
+	require.NoError(t, ll.Acquire())
+	defer ll.Release()
+
+	lease := getTestLease(t, client)
+	require.Equal(t, "a", ptr.Deref(lease.Spec.HolderIdentity, ""))
+	require.Equal(t, int32(5), ptr.Deref(lease.Spec.LeaseTransitions, -1))
+}
+
+func TestLease_LossDetection(t *testing.T) {
+	t.Parallel()
+	client := fake.NewClientset()
+
+	ll := newTestLeaseLock(client, "a")
+	require.NoError(t, ll.Acquire())
+	defer ll.Release()
+
+	// another process forcefully takes over the lease
+	lease := getTestLease(t, client)
+	lease.Spec.HolderIdentity = ptr.To("b")
+	_, err := client.CoordinationV1().Leases(testLeaseNamespace).Update(context.Background(), lease, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	// the context is cancelled on the next renewal
+	select {
+	case <-ll.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("the lease loss was not detected")
+	}
+
+	// the lease of the new holder is left alone
+	require.NoError(t, ll.Release())
+	require.Equal(t, "b", ptr.Deref(getTestLease(t, client).Spec.HolderIdentity, ""))
+}
+
+func TestLease_StepDownBeforeExpiry(t *testing.T) {
+	t.Parallel()
+	client := fake.NewClientset()
+
+	ll := newTestLeaseLock(client, "a", WithLeaseRenewInterval(300*time.Millisecond))
+	require.NoError(t, ll.Acquire())
+	defer ll.Release()
+	lease := getTestLease(t, client)
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+
+	// the lease can no longer be renewed
+	client.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServiceUnavailable("unavailable")
+	})
+
+	// the holder steps down before another process could take over
+	select {
+	case <-ll.Context().Done():
+		require.True(t, time.Now().Before(expiry), "the lock was held after the lease expired")
+	case <-time.After(2 * time.Second):
+		t.Fatal("the lock was not given up")
+	}
+}
+
+func TestLease_ConflictsAreRetriedBounded(t *testing.T) {
+	t.Parallel()
+
+	// every attempt to create the lease loses the race
+	client := fake.NewClientset()
+	client.PrependReactor("create", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewAlreadyExists(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, testLeaseName)
+	})
+	require.ErrorIs(t, newTestLeaseLock(client, "a").Acquire(), ErrMaxRetryExceeded)
+
+	// every attempt to take over the lease loses the race
+	client = fake.NewClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: testLeaseName, Namespace: testLeaseNamespace},
+	})
+	client.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "coordination.k8s.io", Resource: "leases"}, testLeaseName, nil)
+	})
+	require.ErrorIs(t, newTestLeaseLock(client, "a").Acquire(), ErrMaxRetryExceeded)
+}
+
+func TestLease_ContextCancelled(t *testing.T) {
+	t.Parallel()
+	client := fake.NewClientset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ll := NewLeaseLock(ctx, client, testLeaseNamespace, testLeaseName)
+	require.ErrorIs(t, ll.Acquire(), ErrLockAcquire)
+}
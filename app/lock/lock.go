@@ -1,7 +1,8 @@
 // SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
-// Package lock provides an interface for file-based locking.
+// Package lock provides an interface for locking shared state, backed either
+// by a lock file or by a Kubernetes Lease.
 package lock
 
 import (
@@ -34,6 +35,21 @@ var (
 	DefaultMaxRetry        = 5
 )
 
+// Locker is a lock held by a single process at a time.
+type Locker interface {
+	// Acquire blocks until the lock is held, or fails once the retries are
+	// exhausted.
+	Acquire() error
+	// Release gives up the lock.
+	Release() error
+	// Context is cancelled once the lock is released or lost. Work done while
+	// holding the lock should use it, so it stops when another process may
+	// have taken over.
+	Context() context.Context
+}
+
+var _ Locker = (*FileLock)(nil)
+
 type FileLock struct {
 	filepath        string
 	staleTimeout    time.Duration
@@ -45,6 +61,7 @@ type FileLock struct {
 	pid      int
 	ctx      context.Context
 	cancel   context.CancelFunc
+	held     context.Context
 	mu       sync.Mutex
 }
 
@@ -149,6 +166,7 @@ func (fl *FileLock) Acquire() error {
 				// start background refresh
 				ctx, cancel := context.WithCancel(fl.ctx)
 				fl.cancel = cancel
+				fl.held = ctx
 				go fl.refreshLock(ctx)
 				return nil
 			}
@@ -209,6 +227,12 @@ func (fl *FileLock) Release() error {
 	return nil
 }
 
+func (fl *FileLock) Context() context.Context {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return heldContext(fl.held)
+}
+
 func (fl *FileLock) refreshLock(ctx context.Context) {
 	ticker := time.NewTicker(fl.refreshInterval)
 	defer ticker.Stop()
@@ -291,3 +315,14 @@ func (fl *FileLock) writeLock(f *os.File) error {
 
 	return nil
 }
+
+// heldContext returns the context of a held lock, or a cancelled context when
+// the lock was never acquired.
+func heldContext(held context.Context) context.Context {
+	if held != nil {
+		return held
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
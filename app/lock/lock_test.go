@@ -299,3 +299,20 @@ func writeLockFile(t *testing.T, path string, content lockContent) {
 		t.Fatalf("Failed to write test lock: %v", err)
 	}
 }
+
+func TestLock_Context(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	lockPath := filepath.Join(tempDir, "test.lock")
+
+	fl := NewFileLock(context.Background(), lockPath)
+	require.Error(t, fl.Context().Err())
+
+	// the context is valid while the lock is held
+	require.NoError(t, fl.Acquire())
+	held := fl.Context()
+	require.NoError(t, held.Err())
+
+	require.NoError(t, fl.Release())
+	require.Error(t, held.Err())
+}
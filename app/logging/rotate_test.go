@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package logging_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudzero/cloudzero-agent/app/logging"
+)
+
+func TestUnit_Logging_RotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "admission.log")
+	file, err := logging.NewRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	require.Equal(t, "fourth\n", read(path))
+	require.Equal(t, "third\n", read(path+".1"))
+	require.Equal(t, "second\n", read(path+".2"))
+	require.NoFileExists(t, path+".3")
+
+	// the size of an existing file is taken into account
+	file, err = logging.NewRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.Equal(t, "fifth\n", read(path))
+	require.Equal(t, "fourth\n", read(path+".1"))
+
+	_, err = file.Write([]byte("closed\n"))
+	require.ErrorIs(t, err, os.ErrClosed)
+}
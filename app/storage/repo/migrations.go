@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/storage/core"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// SchemaMigration records a migration which was applied to the database.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations are applied in order, each exactly once. Applied migrations must
+// never change; the schema is changed by appending a new migration instead.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create resource_tags",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&resourceTagsV1{})
+		},
+	},
+	{
+		version: 2,
+		name:    "index unsent records",
+		up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE INDEX IF NOT EXISTS idx_resource_tags_sent_at ON resource_tags (sent_at)").Error
+		},
+	},
+	{
+		version: 3,
+		name:    "create resource_tags_versions",
+		up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&resourceTagsVersionV3{}); err != nil {
+				return err
+			}
+			// the existing records start their history with their current labels
+			records := []*resourceTagsV1{}
+			if err := tx.Find(&records).Error; err != nil {
+				return err
+			}
+			for _, record := range records {
+				version := &resourceTagsVersionV3{
+					ID:           core.NewID(),
+					Type:         record.Type,
+					Name:         record.Name,
+					Namespace:    record.Namespace,
+					MetricLabels: record.MetricLabels,
+					Labels:       record.Labels,
+					Annotations:  record.Annotations,
+					ValidFrom:    record.RecordUpdated,
+					ValidTo:      record.DeletedAt,
+					SentAt:       record.SentAt,
+				}
+				if err := tx.Create(version).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version: 4,
+		name:    "add resource_tags spec",
+		up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&types.ResourceTags{}, "Spec") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&types.ResourceTags{}, "Spec")
+		},
+	},
+	{
+		version: 5,
+		name:    "add resource_tags start_sent",
+		up: func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE resource_tags ADD COLUMN start_sent numeric NOT NULL DEFAULT 0").Error; err != nil {
+				return err
+			}
+			// the records which were sent already had their start sent
+			return tx.Exec("UPDATE resource_tags SET start_sent = 1 WHERE sent_at IS NOT NULL").Error
+		},
+	},
+	{
+		version: 6,
+		name:    "add resource_tags_version start_sent",
+		up: func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE resource_tags_version ADD COLUMN start_sent numeric NOT NULL DEFAULT 0").Error; err != nil {
+				return err
+			}
+			// the versions which were sent already had their start sent
+			return tx.Exec("UPDATE resource_tags_version SET start_sent = 1 WHERE sent_at IS NOT NULL").Error
+		},
+	},
+	{
+		version: 7,
+		name:    "add resource_tags cluster",
+		up: func(tx *gorm.DB) error {
+			// the cluster of the existing records is resolved when they are sent
+			return tx.Exec("ALTER TABLE resource_tags ADD COLUMN cluster text NOT NULL DEFAULT ''").Error
+		},
+	},
+}
+
+// resourceTagsV1 is the schema of resource_tags created by the first
+// migration. It is a copy of types.ResourceTags at that version, so the
+// migration does not change when fields are added to the record.
+type resourceTagsV1 struct {
+	ID            string                  `gorm:"unique;autoIncrement"`
+	Type          config.ResourceType     `gorm:"primaryKey"`
+	Name          string                  `gorm:"primaryKey"`
+	Namespace     *string                 `gorm:"primaryKey"`
+	MetricLabels  *config.MetricLabels    `gorm:"serializer:json"`
+	Labels        *config.MetricLabelTags `gorm:"serializer:json"`
+	Annotations   *config.MetricLabelTags `gorm:"serializer:json"`
+	RecordCreated time.Time
+	RecordUpdated time.Time
+	SentAt        *time.Time
+	DeletedAt     *time.Time
+	Size          int `gorm:"->;type:GENERATED ALWAYS AS (octet_length(name) + IFNULL(octet_length(namespace), 0) + IFNULL(octet_length(labels), 0) + IFNULL(octet_length(annotations), 0)) VIRTUAL;"`
+}
+
+func (resourceTagsV1) TableName() string {
+	return "resource_tags"
+}
+
+// resourceTagsVersionV3 is the schema of resource_tags_version created by the
+// third migration, a copy of types.ResourceTagsVersion at that version.
+type resourceTagsVersionV3 struct {
+	ID           string                  `gorm:"primaryKey"`
+	Type         config.ResourceType     `gorm:"index:idx_resource_tags_versions_resource"`
+	Name         string                  `gorm:"index:idx_resource_tags_versions_resource"`
+	Namespace    *string                 `gorm:"index:idx_resource_tags_versions_resource"`
+	MetricLabels *config.MetricLabels    `gorm:"serializer:json"`
+	Labels       *config.MetricLabelTags `gorm:"serializer:json"`
+	Annotations  *config.MetricLabelTags `gorm:"serializer:json"`
+	ValidFrom    time.Time
+	ValidTo      *time.Time
+	SentAt       *time.Time
+}
+
+func (resourceTagsVersionV3) TableName() string {
+	return "resource_tags_version"
+}
+
+// migrate applies the migrations which were not applied to the database yet,
+// each in its own transaction.
+func migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create the migrations table: %w", err)
+	}
+
+	var current int
+	if err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&current).Error; err != nil {
+		return fmt.Errorf("failed to read the schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Debug().Int("version", m.version).Str("name", m.name).Msg("Applied schema migration")
+	}
+	return nil
+}
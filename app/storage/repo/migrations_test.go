@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudzero/cloudzero-agent/app/storage/sqlite"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+func TestMigrations_Frozen(t *testing.T) {
+	db, err := sqlite.NewSQLiteDriver(sqlite.InMemoryDSN)
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	// the first migration creates the table as it was at its version, and the
+	// columns added to the record later come from their own migration
+	require.NoError(t, migrations[0].up(db))
+	assert.True(t, db.Migrator().HasColumn("resource_tags", "deleted_at"))
+	assert.False(t, db.Migrator().HasColumn("resource_tags", "spec"))
+	sentAt := time.Now().UTC()
+	require.NoError(t, db.Create(&resourceTagsV1{ID: "sent", Name: "sent", Namespace: new(string), SentAt: &sentAt}).Error)
+	require.NoError(t, db.Create(&resourceTagsV1{ID: "unsent", Name: "unsent", Namespace: new(string)}).Error)
+
+	require.NoError(t, migrate(db))
+	assert.True(t, db.Migrator().HasColumn("resource_tags", "spec"))
+	assert.True(t, db.Migrator().HasColumn("resource_tags", "cluster"))
+
+	// the records which were sent had their start sent
+	records := []*types.ResourceTags{}
+	require.NoError(t, db.Order("id").Find(&records).Error)
+	require.Len(t, records, 2)
+	assert.True(t, records[0].StartSent)
+	assert.False(t, records[1].StartSent)
+}
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"github.com/cloudzero/cloudzero-agent/app/storage/core"
+	"github.com/cloudzero/cloudzero-agent/app/storage/sqlite"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+const (
+	// DefaultStoragePath is used when the storage path is not set.
+	DefaultStoragePath = "/opt/insights"
+	// DatabaseFile is the name of the database in the storage path.
+	DatabaseFile = "resources.db"
+)
+
+// ErrCorrupted is returned when the integrity check of a database fails.
+var ErrCorrupted = errors.New("database is corrupted")
+
+// NewPersistentResourceRepository creates a resource repository backed by a
+// SQLite database in the storage directory, so the records and their send
+// state survive restarts.
+//
+// A corrupted database is moved aside and replaced with an empty one, in which
+// case rebuilt is true and the caller is expected to repopulate it with a
+// backfill.
+func NewPersistentResourceRepository(clock types.TimeProvider, dir string) (store types.ResourceStore, rebuilt bool, err error) {
+	registerStats()
+
+	if dir == "" {
+		dir = DefaultStoragePath
+	}
+	if err = os.MkdirAll(dir, 0o750); err != nil {
+		return nil, false, fmt.Errorf("failed to create the storage directory: %w", err)
+	}
+	path := filepath.Join(dir, DatabaseFile)
+
+	db, err := openDatabase(path)
+	if isCorrupted(err) {
+		log.Warn().Err(err).Str("path", path).Msg("The resource database is corrupted, replacing it with an empty one")
+		if err = quarantine(path, clock); err != nil {
+			return nil, false, err
+		}
+		StorageDatabaseRebuilds.WithLabelValues().Inc()
+		rebuilt = true
+		db, err = openDatabase(path)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open the resource database: %w", err)
+	}
+
+	store, err = NewResourceRepository(clock, db)
+	if err != nil {
+		return nil, false, err
+	}
+	return store, rebuilt, nil
+}
+
+// openDatabase opens the database and checks its integrity.
+func openDatabase(path string) (*gorm.DB, error) {
+	db, err := sqlite.NewSQLiteDriver(sqlite.FileDSN(path))
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, core.TranslateError(err)
+	}
+	// a single connection serializes the writes, as with the in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetMaxIdleConns(1)
+
+	var result string
+	if err = db.Raw("PRAGMA quick_check").Scan(&result).Error; err == nil && result != "ok" {
+		err = fmt.Errorf("%w: %s", ErrCorrupted, result)
+	}
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// isCorrupted returns true when the error shows the database file is damaged,
+// or is not a database at all.
+func isCorrupted(err error) bool {
+	if errors.Is(err, ErrCorrupted) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB)
+}
+
+// quarantine moves a corrupted database aside, so it can be investigated, and
+// removes its journal files.
+func quarantine(path string, clock types.TimeProvider) error {
+	target := path + ".corrupt-" + strconv.FormatInt(clock.GetCurrentTime().Unix(), 10)
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("failed to move the corrupted database aside: %w", err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove the journal of the corrupted database: %w", err)
+		}
+	}
+	log.Warn().Str("path", target).Msg("Moved the corrupted resource database aside")
+	return nil
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package repo_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+func TestPersistentResourceRepository_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	clock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+
+	store, rebuilt, err := repo.NewPersistentResourceRepository(clock, dir)
+	require.NoError(t, err)
+	assert.False(t, rebuilt)
+
+	namespace := "default"
+	record := types.ResourceTags{Type: config.Pod, Name: "pod", Namespace: &namespace, Labels: &config.MetricLabelTags{"team": "a"}}
+	require.NoError(t, store.Create(ctx, &record))
+	sentAt := clock.GetCurrentTime()
+	record.SentAt = &sentAt
+	require.NoError(t, store.Update(ctx, &record))
+
+	// the send state is read back after a restart, and the migrations are not
+	// applied again
+	store, rebuilt, err = repo.NewPersistentResourceRepository(clock, dir)
+	require.NoError(t, err)
+	assert.False(t, rebuilt)
+
+	found, err := store.FindFirstBy(ctx, "name = ?", "pod")
+	require.NoError(t, err)
+	require.NotNil(t, found.SentAt)
+	assert.True(t, sentAt.Equal(*found.SentAt))
+	assert.Equal(t, config.MetricLabelTags{"team": "a"}, *found.Labels)
+
+	unsent, err := store.FindAllBy(ctx, "sent_at IS NULL")
+	require.NoError(t, err)
+	assert.Empty(t, unsent)
+}
+
+func TestPersistentResourceRepository_Corrupted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	clock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+
+	path := filepath.Join(dir, repo.DatabaseFile)
+	require.NoError(t, os.WriteFile(path, []byte("this is not a sqlite database, it is only a few bytes of text"), 0o600))
+
+	store, rebuilt, err := repo.NewPersistentResourceRepository(clock, dir)
+	require.NoError(t, err)
+	assert.True(t, rebuilt)
+
+	// the corrupted database is kept for investigation
+	quarantined, err := filepath.Glob(path + ".corrupt-*")
+	require.NoError(t, err)
+	assert.Len(t, quarantined, 1)
+
+	// the new database is usable
+	namespace := "default"
+	require.NoError(t, store.Create(ctx, &types.ResourceTags{Type: config.Pod, Name: "pod", Namespace: &namespace}))
+	found, err := store.FindAllBy(ctx, "1 = 1")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+}
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"gorm.io/gorm"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/storage/core"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+)
+
+// LabelsAt returns the version of a resource which was valid at the given time.
+func (r *resourceRepoImpl) LabelsAt(ctx context.Context, resourceType config.ResourceType, namespace *string, name string, at time.Time) (*types.ResourceTagsVersion, error) {
+	// the times are compared as stored, which is in UTC
+	at = at.UTC()
+	it := &types.ResourceTagsVersion{}
+	err := whereResource(r.DB(ctx), resourceType, namespace, name).
+		Where("valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)", at, at).
+		Order("valid_from DESC").
+		First(it).Error
+	if err != nil {
+		return nil, core.TranslateError(err)
+	}
+	return it, nil
+}
+
+// FindUnsentVersions returns the versions of the resources of the records
+// which were not sent yet, oldest first. The versions are read with a single
+// query by name, and the ones of other resources with the same name are left
+// out.
+func (r *resourceRepoImpl) FindUnsentVersions(ctx context.Context, records []*types.ResourceTags) ([]*types.ResourceTagsVersion, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(records))
+	resources := make(map[string]bool, len(records))
+	for _, record := range records {
+		names = append(names, record.Name)
+		resources[versionKey(record.Type, record.Namespace, record.Name)] = true
+	}
+
+	found := []*types.ResourceTagsVersion{}
+	err := r.DB(ctx).
+		Where("sent_at IS NULL AND name IN ?", names).
+		Order("valid_from").
+		Find(&found).Error
+	if err != nil {
+		return nil, core.TranslateError(err)
+	}
+	it := []*types.ResourceTagsVersion{}
+	for _, version := range found {
+		if resources[versionKey(version.Type, version.Namespace, version.Name)] {
+			it = append(it, version)
+		}
+	}
+	return it, nil
+}
+
+func versionKey(resourceType config.ResourceType, namespace *string, name string) string {
+	if namespace == nil {
+		return fmt.Sprintf("%d//%s", resourceType, name)
+	}
+	return fmt.Sprintf("%d/%s/%s", resourceType, *namespace, name)
+}
+
+// MarkVersionsSent records that the versions of a resource were sent. A
+// version which was closed after the given time is left unsent, so the end of
+// it is sent with the next flush, but its start is not sent again.
+func (r *resourceRepoImpl) MarkVersionsSent(ctx context.Context, resourceType config.ResourceType, namespace *string, name string, at time.Time) error {
+	at = at.UTC()
+	err := whereResource(r.DB(ctx).Model(&types.ResourceTagsVersion{}), resourceType, namespace, name).
+		Where("sent_at IS NULL AND valid_from <= ?", at).
+		Updates(map[string]interface{}{
+			"sent_at":    gorm.Expr("CASE WHEN valid_to IS NULL OR valid_to <= ? THEN ? END", at, at),
+			"start_sent": true,
+		}).Error
+	return core.TranslateError(err)
+}
+
+// DeleteVersionsBefore removes the versions which ended before the given time.
+func (r *resourceRepoImpl) DeleteVersionsBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.DB(ctx).
+		Where("valid_to IS NOT NULL AND valid_to < ?", before.UTC()).
+		Delete(&types.ResourceTagsVersion{})
+	return result.RowsAffected, core.TranslateError(result.Error)
+}
+
+// recordVersion keeps the history of a record in step with it. The latest
+// version is closed and a new one is opened when the labels, annotations or
+// metric labels changed, and the latest version is closed when the resource
+// was deleted.
+func (r *resourceRepoImpl) recordVersion(ctx context.Context, it *types.ResourceTags, at time.Time) error {
+	latest := &types.ResourceTagsVersion{}
+	err := whereResource(r.DB(ctx), it.Type, it.Namespace, it.Name).
+		Order("valid_from DESC").
+		First(latest).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		latest = nil
+	case err != nil:
+		return core.TranslateError(err)
+	}
+
+	if latest != nil && sameVersion(latest, it) {
+		switch {
+		case latest.ValidTo == nil && it.DeletedAt != nil:
+			return r.closeVersion(ctx, latest, *it.DeletedAt)
+		case latest.ValidTo == nil || it.DeletedAt != nil:
+			// nothing changed, or a repeated deletion
+			return nil
+		}
+	}
+	if latest != nil && latest.ValidTo == nil {
+		if err := r.closeVersion(ctx, latest, at); err != nil {
+			return err
+		}
+	}
+
+	version := &types.ResourceTagsVersion{
+		ID:           core.NewID(),
+		Type:         it.Type,
+		Name:         it.Name,
+		Namespace:    it.Namespace,
+		MetricLabels: it.MetricLabels,
+		Labels:       it.Labels,
+		Annotations:  it.Annotations,
+		ValidFrom:    at,
+		ValidTo:      it.DeletedAt,
+	}
+	return core.TranslateError(r.DB(ctx).Create(version).Error)
+}
+
+// closeVersion ends a version. The version is sent again, so the end of it is
+// sent as well, and its start is only sent when it was not sent yet.
+func (r *resourceRepoImpl) closeVersion(ctx context.Context, version *types.ResourceTagsVersion, at time.Time) error {
+	err := r.DB(ctx).Model(&types.ResourceTagsVersion{}).
+		Where("id = ?", version.ID).
+		Updates(map[string]interface{}{"valid_to": at, "sent_at": nil}).Error
+	return core.TranslateError(err)
+}
+
+func whereResource(db *gorm.DB, resourceType config.ResourceType, namespace *string, name string) *gorm.DB {
+	db = db.Where("type = ? AND name = ?", resourceType, name)
+	if namespace == nil {
+		return db.Where("namespace IS NULL")
+	}
+	return db.Where("namespace = ?", *namespace)
+}
+
+func sameVersion(version *types.ResourceTagsVersion, it *types.ResourceTags) bool {
+	return sameTags(version.MetricLabels, it.MetricLabels) &&
+		sameTags(version.Labels, it.Labels) &&
+		sameTags(version.Annotations, it.Annotations)
+}
+
+// sameTags compares two sets of tags, where a missing set is the same as an
+// empty one.
+func sameTags(a, b *map[string]string) bool {
+	var x, y map[string]string
+	if a != nil {
+		x = *a
+	}
+	if b != nil {
+		y = *b
+	}
+	return maps.Equal(x, y)
+}
@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package repo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+	"github.com/cloudzero/cloudzero-agent/app/storage/repo"
+	"github.com/cloudzero/cloudzero-agent/app/storage/sqlite"
+	"github.com/cloudzero/cloudzero-agent/app/types"
+	"github.com/cloudzero/cloudzero-agent/app/types/mocks"
+)
+
+// setupHistoryRepo initializes a repository on its own in-memory database, so
+// no history is shared with the other tests.
+func setupHistoryRepo(t *testing.T, clock types.TimeProvider) (types.ResourceStore, types.ResourceHistoryStore) {
+	db, err := sqlite.NewSQLiteDriver(sqlite.InMemoryDSN)
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	store, err := repo.NewResourceRepository(clock, db)
+	require.NoError(t, err)
+	history, ok := store.(types.ResourceHistoryStore)
+	require.True(t, ok)
+	return store, history
+}
+
+func TestResourceHistory_LabelsAt(t *testing.T) {
+	ctx := context.Background()
+	createdAt := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := mocks.NewMockClock(createdAt)
+	store, history := setupHistoryRepo(t, clock)
+
+	namespace := "default"
+	record := types.ResourceTags{Type: config.Deployment, Name: "web", Namespace: &namespace, Labels: &config.MetricLabelTags{"team": "a"}}
+	require.NoError(t, store.Create(ctx, &record))
+
+	// marking the record as sent does not change its labels
+	clock.AdvanceTime(time.Hour)
+	sentAt := clock.GetCurrentTime()
+	record.SentAt = &sentAt
+	require.NoError(t, store.Update(ctx, &record))
+
+	clock.AdvanceTime(24 * time.Hour)
+	changedAt := clock.GetCurrentTime()
+	record.Labels = &config.MetricLabelTags{"team": "b"}
+	record.SentAt = nil
+	require.NoError(t, store.Update(ctx, &record))
+
+	tests := []struct {
+		at       time.Time
+		expected string
+	}{
+		{at: createdAt, expected: "a"},
+		{at: changedAt.Add(-time.Second), expected: "a"},
+		{at: changedAt, expected: "b"},
+		{at: changedAt.Add(time.Hour), expected: "b"},
+	}
+	for _, tt := range tests {
+		version, err := history.LabelsAt(ctx, config.Deployment, &namespace, "web", tt.at)
+		require.NoError(t, err)
+		assert.Equal(t, config.MetricLabelTags{"team": tt.expected}, *version.Labels, tt.at)
+	}
+
+	// nothing is known before the resource was seen, or of another namespace
+	_, err := history.LabelsAt(ctx, config.Deployment, &namespace, "web", createdAt.Add(-time.Second))
+	assert.ErrorIs(t, err, types.ErrNotFound)
+	other := "other"
+	_, err = history.LabelsAt(ctx, config.Deployment, &other, "web", changedAt)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	// the history is kept after the resource is deleted
+	clock.AdvanceTime(time.Hour)
+	deletedAt := clock.GetCurrentTime()
+	record.DeletedAt = &deletedAt
+	require.NoError(t, store.Update(ctx, &record))
+	require.NoError(t, store.Delete(ctx, record.ID))
+
+	version, err := history.LabelsAt(ctx, config.Deployment, &namespace, "web", changedAt)
+	require.NoError(t, err)
+	require.NotNil(t, version.ValidTo)
+	assert.True(t, deletedAt.Equal(*version.ValidTo))
+	_, err = history.LabelsAt(ctx, config.Deployment, &namespace, "web", deletedAt)
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestResourceHistory_SendState(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	store, history := setupHistoryRepo(t, clock)
+
+	record := types.ResourceTags{Type: config.Node, Name: "node", Labels: &config.MetricLabelTags{"zone": "a"}}
+	require.NoError(t, store.Create(ctx, &record))
+	clock.AdvanceTime(time.Minute)
+	record.Labels = &config.MetricLabelTags{"zone": "b"}
+	require.NoError(t, store.Update(ctx, &record))
+
+	unsent, err := history.FindUnsentVersions(ctx, []*types.ResourceTags{&record})
+	require.NoError(t, err)
+	require.Len(t, unsent, 2)
+	assert.Equal(t, config.MetricLabelTags{"zone": "a"}, *unsent[0].Labels)
+	assert.NotNil(t, unsent[0].ValidTo)
+	assert.Equal(t, config.MetricLabelTags{"zone": "b"}, *unsent[1].Labels)
+	assert.Nil(t, unsent[1].ValidTo)
+
+	require.NoError(t, history.MarkVersionsSent(ctx, config.Node, nil, "node", clock.GetCurrentTime()))
+	unsent, err = history.FindUnsentVersions(ctx, []*types.ResourceTags{&record})
+	require.NoError(t, err)
+	assert.Empty(t, unsent)
+
+	// the end of a sent version is sent again
+	clock.AdvanceTime(time.Minute)
+	deletedAt := clock.GetCurrentTime()
+	record.DeletedAt = &deletedAt
+	require.NoError(t, store.Update(ctx, &record))
+	unsent, err = history.FindUnsentVersions(ctx, []*types.ResourceTags{&record})
+	require.NoError(t, err)
+	require.Len(t, unsent, 1)
+	assert.True(t, deletedAt.Equal(*unsent[0].ValidTo))
+	assert.True(t, unsent[0].StartSent)
+
+	// a version which ended after the flush is left unsent
+	require.NoError(t, history.MarkVersionsSent(ctx, config.Node, nil, "node", deletedAt.Add(-time.Second)))
+	unsent, err = history.FindUnsentVersions(ctx, []*types.ResourceTags{&record})
+	require.NoError(t, err)
+	require.Len(t, unsent, 1)
+
+	// only the closed versions past the retention are removed
+	deleted, err := history.DeleteVersionsBefore(ctx, deletedAt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	deleted, err = history.DeleteVersionsBefore(ctx, deletedAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
+
+func TestResourceHistory_FindUnsentVersions(t *testing.T) {
+	ctx := context.Background()
+	clock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	store, history := setupHistoryRepo(t, clock)
+
+	// resources with the same name in other namespaces, or of another type,
+	// are left out
+	records := []*types.ResourceTags{}
+	for _, namespace := range []string{"a", "b", "c"} {
+		record := &types.ResourceTags{Type: config.Deployment, Name: "web", Namespace: &namespace, Labels: &config.MetricLabelTags{"team": namespace}}
+		require.NoError(t, store.Create(ctx, record))
+		records = append(records, record)
+	}
+	require.NoError(t, store.Create(ctx, &types.ResourceTags{Type: config.Node, Name: "web"}))
+
+	unsent, err := history.FindUnsentVersions(ctx, records[:2])
+	require.NoError(t, err)
+	namespaces := []string{}
+	for _, version := range unsent {
+		assert.Equal(t, config.Deployment, version.Type)
+		namespaces = append(namespaces, *version.Namespace)
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, namespaces)
+
+	unsent, err = history.FindUnsentVersions(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, unsent)
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -35,15 +36,27 @@ var (
 		},
 		[]string{"resource_type", "namespace", "resource_name", "action"},
 	)
+	StorageDatabaseRebuilds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_database_rebuilds_total",
+			Help: "Total number of corrupted databases which were replaced with an empty one.",
+		},
+		[]string{},
+	)
 )
 
-// NewInMemoryResourceRepository creates a new in-memory resource repository.
-func NewInMemoryResourceRepository(clock types.TimeProvider) (types.ResourceStore, error) {
+func registerStats() {
 	remoteWriteStatsOnce.Do(func() {
 		prometheus.MustRegister(
 			StorageWriteFailures,
+			StorageDatabaseRebuilds,
 		)
 	})
+}
+
+// NewInMemoryResourceRepository creates a new in-memory resource repository.
+func NewInMemoryResourceRepository(clock types.TimeProvider) (types.ResourceStore, error) {
+	registerStats()
 
 	db, err := sqlite.NewSQLiteDriver(sqlite.MemorySharedCached)
 	if err != nil {
@@ -69,8 +82,9 @@ func NewInMemoryResourceRepository(clock types.TimeProvider) (types.ResourceStor
 }
 
 // NewResourceRepository creates a new resource repository with the given clock and database connection.
+// The pending schema migrations are applied first.
 func NewResourceRepository(clock types.TimeProvider, db *gorm.DB) (types.ResourceStore, error) {
-	if err := db.AutoMigrate(&types.ResourceTags{}); err != nil {
+	if err := migrate(db); err != nil {
 		return nil, core.TranslateError(err)
 	}
 
@@ -88,17 +102,24 @@ type resourceRepoImpl struct {
 	clock types.TimeProvider
 }
 
-// Create inserts a new resource tag instance with the ID and RecordCreated fields set.
+// Create inserts a new resource tag instance with the ID and RecordCreated fields set,
+// and opens the first version of its history.
 func (r *resourceRepoImpl) Create(ctx context.Context, it *types.ResourceTags) error {
 	it.ID = core.NewID()
 	ct := r.clock.GetCurrentTime()
 	it.RecordCreated, it.RecordUpdated = ct, ct
 
-	err := r.DB(ctx).
-		Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "type"}, {Name: "name"}, {Name: "namespace"}},
-			DoNothing: true,
-		}).Create(it).Error
+	err := r.Tx(ctx, func(txCtx context.Context) error {
+		result := r.DB(txCtx).
+			Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "type"}, {Name: "name"}, {Name: "namespace"}},
+				DoNothing: true,
+			}).Create(it)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return r.recordVersion(txCtx, it, ct)
+	})
 	if err != nil {
 		log.Ctx(ctx).Warn().Err(err).Msg("storage write create failure")
 		StorageWriteFailures.With(prometheus.Labels{
@@ -120,6 +141,27 @@ func (r *resourceRepoImpl) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// MarkSent records that a resource tag was sent. Only the send state is
+// written, so the changes written since the record was read are kept.
+func (r *resourceRepoImpl) MarkSent(ctx context.Context, id string, recordUpdated, at time.Time) error {
+	err := r.DB(ctx).Model(&types.ResourceTags{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"sent_at":    gorm.Expr("CASE WHEN record_updated = ? THEN ? ELSE sent_at END", recordUpdated, at),
+			"start_sent": true,
+		}).Error
+	return core.TranslateError(err)
+}
+
+// DeleteSent removes the resource tag of a deleted resource, unless it was
+// updated since it was read.
+func (r *resourceRepoImpl) DeleteSent(ctx context.Context, id string, recordUpdated time.Time) error {
+	err := r.DB(ctx).
+		Where("id = ? AND record_updated = ? AND deleted_at IS NOT NULL", id, recordUpdated).
+		Delete(&types.ResourceTags{}).Error
+	return core.TranslateError(err)
+}
+
 // Get retrieves a resource tag instance by its ID.
 func (r *resourceRepoImpl) Get(ctx context.Context, id string) (*types.ResourceTags, error) {
 	it := &types.ResourceTags{}
@@ -129,7 +171,8 @@ func (r *resourceRepoImpl) Get(ctx context.Context, id string) (*types.ResourceT
 	return it, nil
 }
 
-// Update modifies an existing resource tag instance.
+// Update modifies an existing resource tag instance. A new version of its history
+// is opened when the labels, annotations or metric labels changed.
 func (r *resourceRepoImpl) Update(ctx context.Context, it *types.ResourceTags) error {
 	if it.ID == "" {
 		return types.ErrMissingKey
@@ -166,19 +209,39 @@ func (r *resourceRepoImpl) Update(ctx context.Context, it *types.ResourceTags) e
 		}
 	}
 
+	// Serialize Spec
+	var specJSON []byte
+	if it.Spec != nil {
+		var err error
+		specJSON, err = json.Marshal(it.Spec)
+		if err != nil {
+			return fmt.Errorf("failed to serialize Spec: %w", err)
+		}
+	}
+
 	// Prepare the updates map with serialized JSON
 	updates := map[string]interface{}{
 		"metric_labels":  string(metricLabelsJSON),
 		"labels":         string(labelsJSON),
 		"annotations":    string(annotationsJSON),
+		"spec":           string(specJSON),
+		"cluster":        it.Cluster,
 		"sent_at":        it.SentAt,
+		"start_sent":     it.StartSent,
+		"deleted_at":     it.DeletedAt,
 		"record_updated": it.RecordUpdated,
 	}
 
 	// Perform the update
-	if err := r.DB(ctx).Model(it).
-		Where("id = ?", it.ID).
-		Updates(updates).Error; err != nil {
+	err := r.Tx(ctx, func(txCtx context.Context) error {
+		if err := r.DB(txCtx).Model(it).
+			Where("id = ?", it.ID).
+			Updates(updates).Error; err != nil {
+			return err
+		}
+		return r.recordVersion(txCtx, it, it.RecordUpdated)
+	})
+	if err != nil {
 		log.Ctx(ctx).Warn().Err(err).Msg("storage write update failure")
 		StorageWriteFailures.With(prometheus.Labels{
 			"action":        "update",
@@ -208,3 +271,13 @@ func (r *resourceRepoImpl) FindAllBy(ctx context.Context, conds ...interface{})
 	}
 	return it, nil
 }
+
+// FindPageBy returns up to limit records that match the provided conditions,
+// with an ID after the given one, ordered by ID.
+func (r *resourceRepoImpl) FindPageBy(ctx context.Context, after string, limit int, conds ...interface{}) ([]*types.ResourceTags, error) {
+	it := []*types.ResourceTags{}
+	if err := r.DB(ctx).Where("id > ?", after).Order("id").Limit(limit).Find(&it, conds...).Error; err != nil {
+		return nil, core.TranslateError(err)
+	}
+	return it, nil
+}
@@ -112,6 +112,23 @@ func TestResourceRepoImpl_Update(t *testing.T) {
 		assert.Nil(t, got.SentAt)
 	})
 
+	t.Run("Update Spec", func(t *testing.T) {
+		createdResource.Spec = &types.ResourceSpec{
+			Containers: []types.ContainerResources{{Name: "app", Requests: types.ResourceQuantities{"cpu": 0.5}}},
+		}
+		require.NoError(t, repo.Update(ctx, &createdResource))
+
+		got, err := repo.Get(ctx, createdResource.ID)
+		require.NoError(t, err)
+		assert.Equal(t, createdResource.Spec, got.Spec)
+
+		createdResource.Spec = nil
+		require.NoError(t, repo.Update(ctx, &createdResource))
+		got, err = repo.Get(ctx, createdResource.ID)
+		require.NoError(t, err)
+		assert.Nil(t, got.Spec)
+	})
+
 	t.Run("Update SentAt", func(t *testing.T) {
 		// Advance the mock clock to simulate time passage
 		newTime := initialTime.Add(2 * time.Hour)
@@ -168,6 +185,27 @@ func TestResourceRepoImpl_Update(t *testing.T) {
 		assert.Equal(t, newTime, got.RecordUpdated)
 		assert.Nil(t, got.Annotations)
 	})
+
+	t.Run("Update DeletedAt", func(t *testing.T) {
+		newTime := initialTime.Add(5 * time.Hour)
+		mockClock.SetCurrentTime(newTime)
+		createdResource.DeletedAt = &newTime
+
+		err := repo.Update(ctx, &createdResource)
+		require.NoError(t, err)
+
+		got, err := repo.Get(ctx, createdResource.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got.DeletedAt)
+		assert.Equal(t, newTime, *got.DeletedAt)
+
+		// a recreated resource clears the deletion
+		createdResource.DeletedAt = nil
+		require.NoError(t, repo.Update(ctx, &createdResource))
+		got, err = repo.Get(ctx, createdResource.ID)
+		require.NoError(t, err)
+		assert.Nil(t, got.DeletedAt)
+	})
 }
 
 func TestResourceRepoImpl_Get(t *testing.T) {
@@ -378,6 +416,111 @@ func TestResourceRepoImpl_FindAllBy(t *testing.T) {
 	})
 }
 
+func TestResourceRepoImpl_FindPageBy(t *testing.T) {
+	mockClock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	repo := setupTestRepo(t, mockClock)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		createTestResource(t, repo, ctx, types.ResourceTags{Type: config.StatefulSet, Name: fmt.Sprintf("TestResourceRepoImpl_FindPageBy%d", i)})
+	}
+
+	// the pages are read after the last id of the previous page, until a
+	// page is not full
+	var (
+		ids   []string
+		after string
+		pages int
+	)
+	for {
+		page, err := repo.FindPageBy(ctx, after, 2, "type = ? AND name like ?", config.StatefulSet, "TestResourceRepoImpl_FindPageBy%")
+		require.NoError(t, err)
+		pages++
+		for _, resource := range page {
+			ids = append(ids, resource.ID)
+		}
+		if len(page) < 2 {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+	assert.Equal(t, 3, pages)
+	require.Len(t, ids, 5)
+	assert.IsIncreasing(t, ids)
+}
+
+func TestResourceRepoImpl_MarkSent(t *testing.T) {
+	mockClock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	repo := setupTestRepo(t, mockClock)
+	ctx := context.Background()
+
+	created := createTestResource(t, repo, ctx, types.ResourceTags{Type: config.StatefulSet, Name: "TestResourceRepoImpl_MarkSent", Labels: &config.MetricLabelTags{"team": "a"}})
+	read, err := repo.Get(ctx, created.ID)
+	require.NoError(t, err)
+
+	// the record is changed while it is sent
+	mockClock.AdvanceTime(time.Minute)
+	changed := *read
+	changed.Labels = &config.MetricLabelTags{"team": "b"}
+	require.NoError(t, repo.Update(ctx, &changed))
+
+	// the change is kept and left unsent, and the start of the record was sent
+	mockClock.AdvanceTime(time.Minute)
+	require.NoError(t, repo.MarkSent(ctx, read.ID, read.RecordUpdated, mockClock.GetCurrentTime()))
+	found, err := repo.Get(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, config.MetricLabelTags{"team": "b"}, *found.Labels)
+	assert.Nil(t, found.SentAt)
+	assert.True(t, found.StartSent)
+
+	// the unchanged record is marked as sent
+	require.NoError(t, repo.MarkSent(ctx, found.ID, found.RecordUpdated, mockClock.GetCurrentTime()))
+	found, err = repo.Get(ctx, created.ID)
+	require.NoError(t, err)
+	require.NotNil(t, found.SentAt)
+	assert.True(t, mockClock.GetCurrentTime().Equal(*found.SentAt))
+	assert.Equal(t, config.MetricLabelTags{"team": "b"}, *found.Labels)
+}
+
+func TestResourceRepoImpl_DeleteSent(t *testing.T) {
+	mockClock := mocks.NewMockClock(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	repo := setupTestRepo(t, mockClock)
+	ctx := context.Background()
+
+	// a live record is never removed
+	created := createTestResource(t, repo, ctx, types.ResourceTags{Type: config.Job, Name: "TestResourceRepoImpl_DeleteSent"})
+	require.NoError(t, repo.DeleteSent(ctx, created.ID, created.RecordUpdated))
+	read, err := repo.Get(ctx, created.ID)
+	require.NoError(t, err)
+
+	// the resource is deleted, and created again while its end of life is sent
+	mockClock.AdvanceTime(time.Minute)
+	deletedAt := mockClock.GetCurrentTime()
+	read.DeletedAt = &deletedAt
+	require.NoError(t, repo.Update(ctx, read))
+	deleted, err := repo.Get(ctx, created.ID)
+	require.NoError(t, err)
+
+	mockClock.AdvanceTime(time.Minute)
+	again := *deleted
+	again.DeletedAt = nil
+	require.NoError(t, repo.Update(ctx, &again))
+
+	// the record of the new resource is kept
+	require.NoError(t, repo.DeleteSent(ctx, deleted.ID, deleted.RecordUpdated))
+	_, err = repo.Get(ctx, created.ID)
+	require.NoError(t, err)
+
+	// an unchanged deleted record is removed
+	mockClock.AdvanceTime(time.Minute)
+	deletedAt = mockClock.GetCurrentTime()
+	again.DeletedAt = &deletedAt
+	require.NoError(t, repo.Update(ctx, &again))
+	require.NoError(t, repo.DeleteSent(ctx, again.ID, again.RecordUpdated))
+	_, err = repo.Get(ctx, created.ID)
+	require.ErrorIs(t, err, types.ErrNotFound)
+}
+
 func TestResourceRepoImpl_CreateWithTransaction(t *testing.T) {
 	// Initialize MockClock with a fixed current time
 	initialTime := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
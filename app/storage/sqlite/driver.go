@@ -19,6 +19,13 @@ const (
 	MemorySharedCached = "file:memory?mode=memory&cache=shared"
 )
 
+// FileDSN returns the DSN of an on-disk database. The database is journaled
+// with a write-ahead log, so readers do not block the writer and a crash never
+// leaves a partially written transaction behind.
+func FileDSN(path string) string {
+	return "file:" + path + "?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000"
+}
+
 // NewSQLiteDriver creates a gorm SQLite driver configured with our settings.
 func NewSQLiteDriver(dsn string) (*gorm.DB, error) {
 	db, err := core.NewDriver(sqlite.Open(dsn))
@@ -5,8 +5,12 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -34,6 +38,9 @@ const (
 const (
 	CostContentIdentifier          = "metrics"
 	ObservabilityContentIdentifier = "observability"
+	// InventoryContentIdentifier names the snapshots of the resources tracked
+	// by the insights controller.
+	InventoryContentIdentifier = "inventory"
 )
 
 type DiskStoreOpt = func(d *DiskStore) error
@@ -54,6 +61,7 @@ type DiskStore struct {
 	rowLimit          int
 	rowCount          int
 	file              *os.File
+	hasher            hash.Hash
 	compressionLevel  int
 	compressor        *brotli.Writer
 	writer            *jwriter.Writer
@@ -134,7 +142,10 @@ func (d *DiskStore) newFileWriter() error {
 		return fmt.Errorf("failed to create active file: %w", err)
 	}
 
-	compressor := brotli.NewWriterLevel(file, d.compressionLevel)
+	// checksum the bytes as they are written so the shipper can detect
+	// corruption on disk before the file is uploaded
+	hasher := sha256.New()
+	compressor := brotli.NewWriterLevel(io.MultiWriter(file, hasher), d.compressionLevel)
 
 	writer := jwriter.NewStreamingWriter(compressor, jsonBufferSize)
 	arrayState := writer.Array()
@@ -142,6 +153,7 @@ func (d *DiskStore) newFileWriter() error {
 	d.rowCount = 0
 	d.startTime = timestamp.Milli() // Capture the start time
 	d.file = file
+	d.hasher = hasher
 	d.compressor = compressor
 	d.writer = &writer
 	d.arrayState = &arrayState
@@ -227,12 +239,18 @@ func (d *DiskStore) flushUnlocked() error {
 	// Capture stop time
 	stopTime := timestamp.Milli()
 
+	// record the row count and checksum of the finalized file in the name
+	integrity := &FileIntegrity{
+		Rows:   d.rowCount,
+		SHA256: hex.EncodeToString(d.hasher.Sum(nil)),
+	}
+
 	// create filename
 	filename := d.contentIdentifier
 	if filename == "" {
 		filename = "file"
 	}
-	filename += fmt.Sprintf("_%d_%d.json.br", d.startTime, stopTime)
+	filename += fmt.Sprintf("_%d_%d", d.startTime, stopTime) + integrity.Suffix() + metricFileExtension
 
 	// Reset the ticker to the max interval
 	d.ticker.Reset(d.maxInterval)
@@ -251,6 +269,7 @@ func (d *DiskStore) flushUnlocked() error {
 	d.writer = nil
 	d.arrayState = nil
 	d.file = nil
+	d.hasher = nil
 	d.rowCount = 0 // Reset row count after flush
 	return nil
 }
@@ -276,7 +295,7 @@ func (d *DiskStore) GetFiles(paths ...string) ([]string, error) {
 	}
 
 	// add file filter
-	allPaths = append(allPaths, base+"_*_*.json.br")
+	allPaths = append(allPaths, base+"_*_*"+metricFileExtension)
 
 	// list with glob find
 	pattern := filepath.Join(allPaths...)
@@ -302,7 +321,7 @@ func (d *DiskStore) Walk(loc string, process filepath.WalkFunc) error {
 // All retrieves all metrics from uncompacted .json.br files, excluding the active and compressed files.
 // It reads the data into memory and returns a MetricRange.
 func (d *DiskStore) All(ctx context.Context, file string) (types.MetricRange, error) {
-	metrics, err := d.readCompressedJSONFile(file)
+	metrics, err := ReadCompressedJSONFile(file)
 	if err != nil {
 		return types.MetricRange{}, fmt.Errorf("failed to read parquet file %s: %w", file, err)
 	}
@@ -313,8 +332,63 @@ func (d *DiskStore) All(ctx context.Context, file string) (types.MetricRange, er
 	}, nil
 }
 
-// readCompressedJSONFile reads all metrics from a single .json.br file and returns them as a slice.
-func (d *DiskStore) readCompressedJSONFile(filePath string) ([]types.Metric, error) {
+// WriteCompressedJSONFile writes metrics to a finalized file in a directory,
+// named like the files of the DiskStore so the shipper uploads it. The file is
+// written under a temporary name and renamed once complete, so a partial file
+// is never uploaded. It returns the path of the file.
+func WriteCompressedJSONFile(dirPath, contentIdentifier string, start, stop time.Time, metrics []types.Metric) (string, error) {
+	if err := os.MkdirAll(dirPath, directoryMode); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// the temporary name does not match the pattern of the finalized files
+	file, err := os.CreateTemp(dirPath, "."+contentIdentifier+"-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the file: %w", err)
+	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath) // no-op once renamed
+
+	hasher := sha256.New()
+	compressor := brotli.NewWriterLevel(io.MultiWriter(file, hasher), config.DefaultDatabaseCompressionLevel)
+	writer := jwriter.NewStreamingWriter(compressor, jsonBufferSize)
+	arrayState := writer.Array()
+	for _, metric := range metrics {
+		encodedMetric, err := json.Marshal(metric)
+		if err != nil {
+			file.Close()
+			return "", fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		arrayState.Raw(encodedMetric)
+	}
+	arrayState.End()
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to flush JSON writer: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to close compressor: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to close JSON file: %w", err)
+	}
+
+	integrity := &FileIntegrity{
+		Rows:   len(metrics),
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+	}
+	filePath := filepath.Join(dirPath,
+		fmt.Sprintf("%s_%d_%d", contentIdentifier, start.UnixMilli(), stop.UnixMilli())+integrity.Suffix()+metricFileExtension)
+	if err := os.Rename(tempPath, filePath); err != nil {
+		return "", fmt.Errorf("failed to rename the file: %w", err)
+	}
+	return filePath, nil
+}
+
+// ReadCompressedJSONFile reads all metrics from a single .json.br file and returns them as a slice.
+func ReadCompressedJSONFile(filePath string) ([]types.Metric, error) {
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return []types.Metric{}, nil // No file to read
 	}
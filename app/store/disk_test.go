@@ -254,3 +254,40 @@ func TestDiskStore_GetUsage(t *testing.T) {
 	_, err = d.GetUsage()
 	require.NoError(t, err)
 }
+
+func TestWriteCompressedJSONFile(t *testing.T) {
+	dirPath := t.TempDir()
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	stop := start.Add(time.Second)
+	metrics := []types.Metric{
+		{ID: uuid.New(), ClusterName: "cluster", MetricName: "cloudzero_inventory", CreatedAt: start, TimeStamp: start, Labels: map[string]string{"resource_type": "pod"}, Value: "1"},
+		{ID: uuid.New(), ClusterName: "cluster", MetricName: "cloudzero_inventory", CreatedAt: start, TimeStamp: start, Labels: map[string]string{"resource_type": "node"}, Value: "1"},
+	}
+
+	path, err := store.WriteCompressedJSONFile(dirPath, store.InventoryContentIdentifier, start, stop, metrics)
+	require.NoError(t, err)
+
+	// the file is named like the files of the disk store, and no temporary
+	// file is left behind
+	files, err := filepath.Glob(filepath.Join(dirPath, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, files)
+	assert.Equal(t, store.InventoryContentIdentifier, store.ParseFileContentIdentifier(path))
+	fileStart, fileStop, ok := store.ParseFileTimeRange(path)
+	require.True(t, ok)
+	assert.Equal(t, start, fileStart)
+	assert.Equal(t, stop, fileStop)
+
+	// the recorded integrity matches the content
+	file, err := store.NewMetricFile(path)
+	require.NoError(t, err)
+	defer file.Close()
+	require.NotNil(t, file.Integrity())
+	assert.Equal(t, len(metrics), file.Integrity().Rows)
+	require.NoError(t, file.Verify())
+
+	read, err := store.ReadCompressedJSONFile(path)
+	require.NoError(t, err)
+	require.Len(t, read, len(metrics))
+	assert.Equal(t, metrics[1].Labels, read[1].Labels)
+}
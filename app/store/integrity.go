@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	metricFileExtension = ".json.br"
+)
+
+var (
+	// ErrChecksumMismatch is returned when the content of a metric file does not
+	// match the checksum recorded when the file was finalized.
+	ErrChecksumMismatch = errors.New("metric file checksum mismatch")
+
+	// ErrRowCountMismatch is returned when the number of rows decoded from a
+	// metric file does not match the row count recorded when the file was
+	// finalized.
+	ErrRowCountMismatch = errors.New("metric file row count mismatch")
+)
+
+// FileIntegrity is the checksum and row count recorded in the name of a metric
+// file when the DiskStore finalizes it.
+//
+// A finalized file is named `<content>_<start>_<stop>.<rows>.<sha256>.json.br`.
+// Files written before integrity information was recorded do not carry these
+// segments, and have no FileIntegrity.
+type FileIntegrity struct {
+	// Rows is the number of metrics written to the file.
+	Rows int
+	// SHA256 is the hex encoded SHA-256 of the compressed file contents.
+	SHA256 string
+}
+
+// Suffix returns the file name segment which encodes the integrity information.
+func (fi *FileIntegrity) Suffix() string {
+	return fmt.Sprintf(".%d.%s", fi.Rows, fi.SHA256)
+}
+
+// ParseFileIntegrity extracts the integrity information from the name of a
+// metric file. nil is returned when the name does not carry any.
+func ParseFileIntegrity(path string) *FileIntegrity {
+	base := filepath.Base(path)
+	if !strings.HasSuffix(base, metricFileExtension) {
+		return nil
+	}
+
+	parts := strings.Split(strings.TrimSuffix(base, metricFileExtension), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	rows, err := strconv.Atoi(parts[1])
+	if err != nil || rows < 0 {
+		return nil
+	}
+
+	sum, err := hex.DecodeString(parts[2])
+	if err != nil || len(sum) != sha256.Size {
+		return nil
+	}
+
+	return &FileIntegrity{
+		Rows:   rows,
+		SHA256: parts[2],
+	}
+}
+
+// VerifyChecksum reads all of `r` and compares the SHA-256 of the content
+// against the recorded checksum.
+func (fi *FileIntegrity) VerifyChecksum(r io.Reader) error {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return fmt.Errorf("failed to read the file contents: %w", err)
+	}
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != fi.SHA256 {
+		return fmt.Errorf("%w: expected=%s, actual=%s", ErrChecksumMismatch, fi.SHA256, actual)
+	}
+
+	return nil
+}
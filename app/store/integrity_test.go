@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package store_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudzero/cloudzero-agent/app/config/gator"
+	"github.com/cloudzero/cloudzero-agent/app/store"
+)
+
+func TestParseFileIntegrity(t *testing.T) {
+	sum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	tests := []struct {
+		name     string
+		path     string
+		expected *store.FileIntegrity
+	}{
+		{
+			name:     "with integrity",
+			path:     "/data/metrics_1_2.42." + sum + ".json.br",
+			expected: &store.FileIntegrity{Rows: 42, SHA256: sum},
+		},
+		{
+			name: "legacy name",
+			path: "/data/metrics_1_2.json.br",
+		},
+		{
+			name: "invalid row count",
+			path: "/data/metrics_1_2.x." + sum + ".json.br",
+		},
+		{
+			name: "invalid checksum",
+			path: "/data/metrics_1_2.42.abc.json.br",
+		},
+		{
+			name: "wrong extension",
+			path: "/data/metrics_1_2.42." + sum + ".parquet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, store.ParseFileIntegrity(tt.path))
+		})
+	}
+}
+
+func TestDiskStore_FlushRecordsIntegrity(t *testing.T) {
+	dirPath := t.TempDir()
+	ctx := context.Background()
+
+	ps, err := store.NewDiskStore(config.Database{StoragePath: dirPath, MaxRecords: 100}, store.WithContentIdentifier(store.CostContentIdentifier))
+	require.NoError(t, err)
+
+	require.NoError(t, ps.Put(ctx, testMetrics...))
+	require.NoError(t, ps.Flush())
+
+	files, err := ps.GetFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	integrity := store.ParseFileIntegrity(files[0])
+	require.NotNil(t, integrity)
+	assert.Equal(t, len(testMetrics), integrity.Rows)
+
+	file, err := store.NewMetricFile(files[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	// the unique id must not change with the integrity information
+	assert.NotContains(t, file.UniqueID(), ".")
+
+	require.NoError(t, file.Verify())
+	_, err = io.ReadAll(file)
+	require.NoError(t, err)
+}
+
+func TestMetricFile_VerifyDetectsCorruption(t *testing.T) {
+	dirPath := t.TempDir()
+	ctx := context.Background()
+
+	ps, err := store.NewDiskStore(config.Database{StoragePath: dirPath, MaxRecords: 100}, store.WithContentIdentifier(store.CostContentIdentifier))
+	require.NoError(t, err)
+
+	require.NoError(t, ps.Put(ctx, testMetrics...))
+	require.NoError(t, ps.Flush())
+
+	files, err := ps.GetFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// truncate the file on disk
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(files[0], info.Size()/2))
+
+	file, err := store.NewMetricFile(files[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	assert.ErrorIs(t, file.Verify(), store.ErrChecksumMismatch)
+
+	// reading must not transcode the corrupt content
+	_, err = io.ReadAll(file)
+	assert.ErrorIs(t, err, store.ErrChecksumMismatch)
+}
+
+func TestMetricFile_ReadDetectsRowCountMismatch(t *testing.T) {
+	dirPath := t.TempDir()
+	ctx := context.Background()
+
+	ps, err := store.NewDiskStore(config.Database{StoragePath: dirPath, MaxRecords: 100}, store.WithContentIdentifier(store.CostContentIdentifier))
+	require.NoError(t, err)
+
+	require.NoError(t, ps.Put(ctx, testMetrics...))
+	require.NoError(t, ps.Flush())
+
+	files, err := ps.GetFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// record one more row than the file holds, with the checksum of its
+	// content
+	integrity := store.ParseFileIntegrity(files[0])
+	require.NotNil(t, integrity)
+	mismatched := integrity.Rows + 1
+	path := strings.Replace(files[0], integrity.Suffix(), (&store.FileIntegrity{Rows: mismatched, SHA256: integrity.SHA256}).Suffix(), 1)
+	require.NoError(t, os.Rename(files[0], path))
+
+	file, err := store.NewMetricFile(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	require.NoError(t, file.Verify())
+	_, err = io.ReadAll(file)
+	assert.ErrorIs(t, err, store.ErrRowCountMismatch)
+}
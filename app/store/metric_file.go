@@ -8,7 +8,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cloudzero/cloudzero-agent/app/types"
 )
@@ -16,8 +18,10 @@ import (
 type MetricFile struct {
 	*os.File // wrapper around an os.File
 
-	location string
-	reader   io.ReadCloser
+	location  string
+	reader    io.ReadCloser
+	integrity *FileIntegrity
+	verified  bool
 }
 
 // ensure MetricFile implements File
@@ -42,8 +46,9 @@ func NewMetricFile(path string) (*MetricFile, error) {
 	}
 
 	metricFile := &MetricFile{
-		File:     file,
-		location: path,
+		File:      file,
+		location:  path,
+		integrity: ParseFileIntegrity(path),
 	}
 
 	return metricFile, nil
@@ -61,6 +66,42 @@ func (f *MetricFile) UniqueID() string {
 	return base
 }
 
+// ParseFileTimeRange returns the start and stop time encoded in the name of a
+// metric file, which is named `<content>_<start>_<stop>` followed by its
+// extensions. `ok` is false when the name does not follow that format.
+func ParseFileTimeRange(path string) (start, stop time.Time, ok bool) {
+	base := filepath.Base(path)
+	if idx := strings.Index(base, "."); idx != -1 {
+		base = base[:idx]
+	}
+
+	parts := strings.Split(base, "_")
+	if len(parts) < 3 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	startMilli, err := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	stopMilli, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return time.UnixMilli(startMilli).UTC(), time.UnixMilli(stopMilli).UTC(), true
+}
+
+// ParseFileContentIdentifier returns the content identifier encoded in the name
+// of a metric file, such as CostContentIdentifier.
+func ParseFileContentIdentifier(path string) string {
+	base := filepath.Base(path)
+	if idx := strings.Index(base, "_"); idx != -1 {
+		return base[:idx]
+	}
+	return ""
+}
+
 func (f *MetricFile) Location() (string, error) {
 	return f.location, nil
 }
@@ -79,13 +120,49 @@ func (f *MetricFile) Size() (int64, error) {
 	return s.Size(), nil
 }
 
+// Integrity returns the checksum and row count recorded for the file, or nil
+// if the file was written without them.
+func (f *MetricFile) Integrity() *FileIntegrity {
+	return f.integrity
+}
+
+// Verify compares the file contents against the checksum recorded in the file
+// name. Files without a recorded checksum are considered valid.
+func (f *MetricFile) Verify() error {
+	if f.integrity == nil {
+		return nil
+	}
+
+	if _, err := f.File.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to beginning of file: %w", err)
+	}
+	if err := f.integrity.VerifyChecksum(f.File); err != nil {
+		return err
+	}
+
+	f.verified = true
+	return nil
+}
+
 func (f *MetricFile) Read(p []byte) (int, error) {
 	if f.reader == nil {
+		// never transcode content which does not match the recorded checksum
+		if !f.verified {
+			if err := f.Verify(); err != nil {
+				return 0, err
+			}
+		}
+
 		_, err := f.File.Seek(0, io.SeekStart)
 		if err != nil {
 			return 0, fmt.Errorf("failed to seek to beginning of file: %w", err)
 		}
-		f.reader = NewParquetStreamer(f.File)
+
+		expectedRows := -1
+		if f.integrity != nil {
+			expectedRows = f.integrity.Rows
+		}
+		f.reader = newParquetStreamer(f.File, expectedRows)
 	}
 	return f.reader.Read(p)
 }
@@ -22,6 +22,13 @@ const (
 // Metrics, and returns a reader with the data transcoded to Snappy-compressed
 // Parquet.
 func NewParquetStreamer(input io.Reader) io.ReadCloser {
+	return newParquetStreamer(input, -1)
+}
+
+// newParquetStreamer is NewParquetStreamer which additionally fails the stream
+// when the number of decoded rows differs from `expectedRows`. A negative
+// `expectedRows` disables the check.
+func newParquetStreamer(input io.Reader, expectedRows int) io.ReadCloser {
 	decompressor := brotli.NewReader(input)
 
 	decoder := json.NewDecoder(decompressor)
@@ -38,6 +45,8 @@ func NewParquetStreamer(input io.Reader) io.ReadCloser {
 			// decompressor.Close() // Necessary for cbrotli, but not the Go-native version
 		}()
 
+		rows := 0
+
 		if firstToken, err := decoder.Token(); err != nil {
 			pipeWriter.CloseWithError(fmt.Errorf("failed to read first token from JSON: %w", err))
 			return
@@ -57,6 +66,7 @@ func NewParquetStreamer(input io.Reader) io.ReadCloser {
 				}
 				metrics = append(metrics, metric.Parquet())
 			}
+			rows += len(metrics)
 
 			_, err := parquetWriter.Write(metrics)
 			if err != nil {
@@ -72,6 +82,11 @@ func NewParquetStreamer(input io.Reader) io.ReadCloser {
 			pipeWriter.CloseWithError(fmt.Errorf("expected ']' at the end of the file, got %s", lastToken))
 			return
 		}
+
+		if expectedRows >= 0 && rows != expectedRows {
+			pipeWriter.CloseWithError(fmt.Errorf("%w: expected=%d, actual=%d", ErrRowCountMismatch, expectedRows, rows))
+			return
+		}
 	}()
 
 	return pipeReader
@@ -19,4 +19,7 @@ type File interface {
 
 	// get the size of the file in bytes
 	Size() (int64, error)
+
+	// verify the contents of the file against any recorded checksum
+	Verify() error
 }
@@ -12,6 +12,7 @@ package mocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	types "github.com/cloudzero/cloudzero-agent/app/types"
 	gomock "go.uber.org/mock/gomock"
@@ -98,6 +99,20 @@ func (mr *MockResourceStoreMockRecorder) DeleteAll(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAll", reflect.TypeOf((*MockResourceStore)(nil).DeleteAll), ctx)
 }
 
+// DeleteSent mocks base method.
+func (m *MockResourceStore) DeleteSent(ctx context.Context, id string, recordUpdated time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSent", ctx, id, recordUpdated)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSent indicates an expected call of DeleteSent.
+func (mr *MockResourceStoreMockRecorder) DeleteSent(ctx, id, recordUpdated any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSent", reflect.TypeOf((*MockResourceStore)(nil).DeleteSent), ctx, id, recordUpdated)
+}
+
 // FindAllBy mocks base method.
 func (m *MockResourceStore) FindAllBy(ctx context.Context, conds ...any) ([]*types.ResourceTags, error) {
 	m.ctrl.T.Helper()
@@ -138,6 +153,26 @@ func (mr *MockResourceStoreMockRecorder) FindFirstBy(ctx any, conds ...any) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindFirstBy", reflect.TypeOf((*MockResourceStore)(nil).FindFirstBy), varargs...)
 }
 
+// FindPageBy mocks base method.
+func (m *MockResourceStore) FindPageBy(ctx context.Context, after string, limit int, conds ...any) ([]*types.ResourceTags, error) {
+	m.ctrl.T.Helper()
+	varargs := []any{ctx, after, limit}
+	for _, a := range conds {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "FindPageBy", varargs...)
+	ret0, _ := ret[0].([]*types.ResourceTags)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindPageBy indicates an expected call of FindPageBy.
+func (mr *MockResourceStoreMockRecorder) FindPageBy(ctx, after, limit any, conds ...any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]any{ctx, after, limit}, conds...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindPageBy", reflect.TypeOf((*MockResourceStore)(nil).FindPageBy), varargs...)
+}
+
 // Get mocks base method.
 func (m *MockResourceStore) Get(ctx context.Context, id string) (*types.ResourceTags, error) {
 	m.ctrl.T.Helper()
@@ -153,6 +188,20 @@ func (mr *MockResourceStoreMockRecorder) Get(ctx, id any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockResourceStore)(nil).Get), ctx, id)
 }
 
+// MarkSent mocks base method.
+func (m *MockResourceStore) MarkSent(ctx context.Context, id string, recordUpdated, at time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkSent", ctx, id, recordUpdated, at)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkSent indicates an expected call of MarkSent.
+func (mr *MockResourceStoreMockRecorder) MarkSent(ctx, id, recordUpdated, at any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkSent", reflect.TypeOf((*MockResourceStore)(nil).MarkSent), ctx, id, recordUpdated, at)
+}
+
 // Tx mocks base method.
 func (m *MockResourceStore) Tx(ctx context.Context, block func(context.Context) error) error {
 	m.ctrl.T.Helper()
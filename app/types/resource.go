@@ -22,9 +22,13 @@ type ResourceTags struct {
 	MetricLabels  *config.MetricLabels    `gorm:"serializer:json"` // Metric labels of the resource; nullable
 	Labels        *config.MetricLabelTags `gorm:"serializer:json"` // Labels of the resource; nullable
 	Annotations   *config.MetricLabelTags `gorm:"serializer:json"` // Annotations of the resource; nullable
+	Spec          *ResourceSpec           `gorm:"serializer:json"` // Resource specification of a pod or node, when captured; nullable
+	Cluster       string                  // Cluster the resource was assigned to when the record was written, or empty when not known
 	RecordCreated time.Time               // Creation time of the record
 	RecordUpdated time.Time               // Time that the record was updated, if the k8s object was updated with different labels
 	SentAt        *time.Time              // Time that the record was sent to the cloudzero API, or null if not sent yet
+	StartSent     bool                    // Whether a sample of the record was ever sent, which is kept when the record is updated
+	DeletedAt     *time.Time              // Time that the k8s object was deleted, or null if it still exists
 	Size          int                     `gorm:"->;type:GENERATED ALWAYS AS (octet_length(name) + IFNULL(octet_length(namespace), 0) + IFNULL(octet_length(labels), 0) + IFNULL(octet_length(annotations), 0)) VIRTUAL;"` // Size of the record in bytes
 }
 
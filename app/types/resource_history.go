@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudzero/cloudzero-agent/app/config/insights-controller"
+)
+
+//go:generate mockgen -destination=mocks/resource_history_mock.go -package=mocks . ResourceHistoryStore
+
+// ResourceTagsVersion is a version of the labels and annotations of a
+// resource. It is valid from ValidFrom until ValidTo, or until now when
+// ValidTo is null. The versions are kept after the record of the resource is
+// removed, so the labels of a resource can be looked up after it was deleted.
+type ResourceTagsVersion struct {
+	ID           string                  `gorm:"primaryKey"`
+	Type         config.ResourceType     `gorm:"index:idx_resource_tags_versions_resource"`
+	Name         string                  `gorm:"index:idx_resource_tags_versions_resource"`
+	Namespace    *string                 `gorm:"index:idx_resource_tags_versions_resource"`
+	MetricLabels *config.MetricLabels    `gorm:"serializer:json"`
+	Labels       *config.MetricLabelTags `gorm:"serializer:json"`
+	Annotations  *config.MetricLabelTags `gorm:"serializer:json"`
+	ValidFrom    time.Time               // Time the labels were first seen
+	ValidTo      *time.Time              // Time the labels were replaced or the resource was deleted, or null if current
+	SentAt       *time.Time              // Time that the version was sent to the cloudzero API, or null if not sent yet
+	StartSent    bool                    // Whether the start of the version was sent, which is kept when the version is closed
+}
+
+// ResourceHistoryStore gives access to the versions of the labels and
+// annotations of the resources. The versions are written by the
+// ResourceStore whenever the labels, annotations or metric labels of a record
+// change.
+type ResourceHistoryStore interface {
+	// LabelsAt returns the version of a resource which was valid at the given
+	// time, or ErrNotFound.
+	LabelsAt(ctx context.Context, resourceType config.ResourceType, namespace *string, name string, at time.Time) (*ResourceTagsVersion, error)
+	// FindUnsentVersions returns the versions of the resources of the records
+	// which were not sent yet, oldest first.
+	FindUnsentVersions(ctx context.Context, records []*ResourceTags) ([]*ResourceTagsVersion, error)
+	// MarkVersionsSent records that the versions of a resource which started
+	// and, if closed, ended before the given time were sent. The start of the
+	// versions which ended after the given time is recorded as sent.
+	MarkVersionsSent(ctx context.Context, resourceType config.ResourceType, namespace *string, name string, at time.Time) error
+	// DeleteVersionsBefore removes the versions which ended before the given
+	// time, and returns how many were removed.
+	DeleteVersionsBefore(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: Copyright (c) 2016-2024, CloudZero, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+// ResourceQuantities are amounts of compute resources keyed by resource name,
+// e.g. `cpu`, `memory` or `nvidia.com/gpu`. CPU is in cores, memory and
+// storage in bytes, and every other resource in units.
+type ResourceQuantities = map[string]float64
+
+// ContainerResources holds the requests and limits of a container.
+type ContainerResources struct {
+	Name     string             `json:"name"`
+	Requests ResourceQuantities `json:"requests,omitempty"`
+	Limits   ResourceQuantities `json:"limits,omitempty"`
+}
+
+// ResourceSpec holds the resource specification of a pod or a node, which is
+// sent alongside its labels.
+type ResourceSpec struct {
+	// Containers are the containers of a pod.
+	Containers []ContainerResources `json:"containers,omitempty"`
+	// QOSClass is the QoS class of a pod.
+	QOSClass string `json:"qos_class,omitempty"`
+	// PriorityClass is the priority class of a pod.
+	PriorityClass string `json:"priority_class,omitempty"`
+	// NodeName is the node a pod is scheduled on.
+	NodeName string `json:"node_name,omitempty"`
+
+	// InstanceType is the instance type of a node.
+	InstanceType string `json:"instance_type,omitempty"`
+	// Zone is the zone of a node.
+	Zone string `json:"zone,omitempty"`
+	// Region is the region of a node.
+	Region string `json:"region,omitempty"`
+	// Capacity is the total amount of resources of a node.
+	Capacity ResourceQuantities `json:"capacity,omitempty"`
+	// Allocatable is the amount of resources of a node available to pods.
+	Allocatable ResourceQuantities `json:"allocatable,omitempty"`
+}
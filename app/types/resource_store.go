@@ -5,6 +5,7 @@ package types
 
 import (
 	"context"
+	"time"
 )
 
 //go:generate mockgen -destination=mocks/resource_store_mock.go -package=mocks . ResourceStore
@@ -17,4 +18,17 @@ type ResourceStore interface {
 	FindFirstBy(ctx context.Context, conds ...interface{}) (*ResourceTags, error)
 	// FindAllBy returns all resource tags that match the given conditions.
 	FindAllBy(ctx context.Context, conds ...interface{}) ([]*ResourceTags, error)
+	// FindPageBy returns up to limit resource tags that match the given
+	// conditions and have an ID after the given one, ordered by ID. The next
+	// page starts after the ID of the last resource tag of a page.
+	FindPageBy(ctx context.Context, after string, limit int, conds ...interface{}) ([]*ResourceTags, error)
+	// MarkSent records that a resource tag, last updated at recordUpdated,
+	// was sent. A resource tag which was updated since is left unsent, so its
+	// changes are sent with the next flush.
+	MarkSent(ctx context.Context, id string, recordUpdated, at time.Time) error
+	// DeleteSent removes the resource tag of a deleted resource, last updated
+	// at recordUpdated, once its end of life was sent. A resource tag which
+	// was updated since, such as a resource created again under the same
+	// name, is kept.
+	DeleteSent(ctx context.Context, id string, recordUpdated time.Time) error
 }
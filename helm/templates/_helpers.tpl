@@ -466,6 +466,24 @@ Name for the validating webhook configuration resource
 {{- printf "%s-webhook" (include "cloudzero-agent.insightsController.server.webhookFullname" .) }}
 {{- end }}
 
+{{/*
+Names of the validating webhook configurations created by the chart, as a YAML list
+*/}}
+{{- define "cloudzero-agent.validatingWebhookConfigNames" -}}
+{{- $names := list }}
+{{- range $configType, $configs := .Values.insightsController.webhooks.configurations }}
+{{- if or (index $.Values.insightsController.labels.resources $configType) (index $.Values.insightsController.annotations.resources $configType) }}
+{{- $names = append $names (printf "%s-%s" (include "cloudzero-agent.validatingWebhookConfigName" $) $configType) }}
+{{- end }}
+{{- end }}
+{{- if or .Values.insightsController.labels.enabled .Values.insightsController.annotations.enabled }}
+{{- range .Values.insightsController.customResources }}
+{{- $names = append $names (printf "%s-custom-%s" (include "cloudzero-agent.validatingWebhookConfigName" $) (.resource | default (printf "%ss" (lower .kind)))) }}
+{{- end }}
+{{- end }}
+{{- toYaml $names }}
+{{- end }}
+
 
 {{ define "cloudzero-agent.webhookConfigMapName" -}}
 {{ .Values.insightsController.ConfigMapNameOverride | default (printf "%s-webhook-configuration" .Release.Name) }}
@@ -598,3 +616,25 @@ image: "{{ .image.repository | default .defaults.repository }}:{{ .defaults.tag
 {{- end }}
 imagePullPolicy: "{{ .image.pullPolicy | default .defaults.pullPolicy }}"
 {{- end -}}
+
+{{/*
+Volume mount of the secret keying the hash transforms.
+*/}}
+{{- define "cloudzero-agent.hashSecretVolumeMount" -}}
+{{- if .Values.hashSecret.existingSecretName -}}
+- name: hash-secret
+  mountPath: {{ .Values.hashSecret.mountPath }}
+  readOnly: true
+{{- end }}
+{{- end }}
+
+{{/*
+Volume of the secret keying the hash transforms.
+*/}}
+{{- define "cloudzero-agent.hashSecretVolume" -}}
+{{- if .Values.hashSecret.existingSecretName -}}
+- name: hash-secret
+  secret:
+    secretName: {{ .Values.hashSecret.existingSecretName }}
+{{- end }}
+{{- end }}